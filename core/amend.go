@@ -0,0 +1,120 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// AmendCommitContent 重写历史，把指定 commit 中 path 文件的内容替换为 content 并强制推送。
+// allDescendants 为 true 时，该 commit 之后的所有 commit 中的这个文件也一并替换；
+// 否则后续 commit 保持各自原有的文件内容。
+// 此操作会重写历史记录。
+func AmendCommitContent(repoURL, sshKeyPEM string, commitHash, path string, content []byte, allDescendants bool) error {
+	return AmendCommitContentWithOptions(repoURL, sshKeyPEM, commitHash, path, content, allDescendants, nil)
+}
+
+// AmendCommitContentWithOptions 同 AmendCommitContent，可通过 opts 控制重写细节
+func AmendCommitContentWithOptions(repoURL, sshKeyPEM string, commitHash, path string, content []byte,
+	allDescendants bool, opts *RewriteOptions) error {
+	if path == "" {
+		return errors.New("path is empty")
+	}
+	if content == nil {
+		content = []byte{} // nil 在 editTree 中表示删除
+	}
+
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	targetHash := plumbing.NewHash(commitHash)
+	targetIndex := h.indexOf(targetHash)
+	if targetIndex == -1 {
+		return errors.New("commit not found in history")
+	}
+
+	var parent plumbing.Hash
+	if targetIndex+1 < len(h.commits) {
+		parent = h.commits[targetIndex+1].Hash
+	}
+	var editErr error
+	newHead, err := h.relink(parent, rootToHead(h.commits[:targetIndex+1]), opts, func(old, c *object.Commit) bool {
+		if editErr != nil || (old.Hash != targetHash && !allDescendants) {
+			return false
+		}
+		treeHash, err := editTree(h.repo.Storer, old.TreeHash, map[string][]byte{path: content})
+		if err != nil {
+			editErr = err
+			return false
+		}
+		c.TreeHash = treeHash
+		return treeHash != old.TreeHash
+	})
+	if editErr != nil {
+		return fmt.Errorf("edit tree: %w", editErr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return err
+	}
+
+	fmt.Printf("成功修改 commit %s 中的文件 %s，并重写历史\n", commitHash, path)
+	return nil
+}
+
+// AmendLastCommit 替换当前分支最新的 commit：newMessage 非空时替换提交信息，
+// files 非空时在原有内容上应用这些文件修改。只重写这一个 commit 并强制推送，不遍历整个历史。
+func AmendLastCommit(repoURL, sshKeyPEM string, newMessage string, files *FileBatch) error {
+	if newMessage == "" && files.Len() == 0 {
+		return errors.New("nothing to amend")
+	}
+
+	defer lockRepo(repoURL)()
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	tip, err := h.repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("head commit: %w", err)
+	}
+
+	amended := &object.Commit{
+		Author:       tip.Author,
+		Committer:    identityFor(repoURL).committer(),
+		Message:      tip.Message,
+		TreeHash:     tip.TreeHash,
+		ParentHashes: tip.ParentHashes,
+	}
+	if newMessage != "" {
+		amended.Message = withProvenance(newMessage)
+	}
+	if files.Len() > 0 {
+		amended.TreeHash, err = editTree(h.repo.Storer, tip.TreeHash, files.files)
+		if err != nil {
+			return fmt.Errorf("edit tree: %w", err)
+		}
+	}
+
+	newHead, err := h.storeCommit(amended)
+	if err != nil {
+		return err
+	}
+	h.markRewritten(tip.Hash, newHead)
+	if amended.TreeHash != tip.TreeHash {
+		h.markRetreed(tip.Hash)
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return err
+	}
+
+	fmt.Printf("成功修改最新 commit，新哈希 %s\n", newHead)
+	return nil
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ExportArchive 支持的归档格式
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// archiveFile 是归档中的一个文件，open 返回其内容
+type archiveFile struct {
+	path string
+	mode fs.FileMode
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// ExportArchive 把 commit（空字符串表示 HEAD）的文件树以 format（ArchiveTarGz 或 ArchiveZip）写入 outPath，
+// 用于“导出聊天记录/数据”。转存的大文件会还原为原内容，.mixgram/blobs/ 本身不写入归档；
+// 文件内容逐个流式写入，不在内存中拼出整个归档
+func ExportArchive(repoURL, sshKeyPEM string, commitHash, format string, outPath string) error {
+	if format != ArchiveTarGz && format != ArchiveZip {
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	target := head.Hash()
+	if commitHash != "" {
+		target = plumbing.NewHash(commitHash)
+	}
+	commit, err := h.repo.CommitObject(target)
+	if err != nil {
+		return fmt.Errorf("load commit: %w", err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("get tree: %w", err)
+	}
+	files, err := archiveFiles(tree)
+	if err != nil {
+		return err
+	}
+
+	tmp := outPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	modTime := commit.Committer.When
+	if format == ArchiveZip {
+		err = writeZip(f, files, modTime)
+	} else {
+		err = writeTarGz(f, files, modTime)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, outPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return nil
+}
+
+// archiveFiles 列出树中要写入归档的文件，转存的大文件替换为 overflowDir 中的内容
+func archiveFiles(tree *object.Tree) ([]archiveFile, error) {
+	var files []archiveFile
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		path, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("walk tree: %w", err)
+		}
+		if !entry.Mode.IsFile() || strings.HasPrefix(path, overflowDir+"/") {
+			continue
+		}
+		blob, err := tree.TreeEntryFile(&entry)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		mode, err := entry.Mode.ToOSFileMode()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		file := archiveFile{path: path, mode: mode, size: blob.Size, open: blob.Reader}
+
+		// 存根很小，只有小文件需要读出来判断
+		if blob.Size < 1024 && entry.Mode != filemode.Symlink {
+			content, err := blob.Contents()
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", path, err)
+			}
+			if stub, ok := parseOverflowStub([]byte(content)); ok {
+				data, err := tree.File(stub.Blob)
+				if err != nil {
+					return nil, fmt.Errorf("overflow blob for %s: %w", path, err)
+				}
+				file.size = data.Size
+				file.open = func() (io.ReadCloser, error) {
+					r, err := data.Reader()
+					if err != nil {
+						return nil, err
+					}
+					return &verifyingReader{r: r, stub: stub, sum: sha256.New()}, nil
+				}
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// verifyingReader 在读到结尾时校验转存大文件的大小和 sha256
+type verifyingReader struct {
+	r    io.ReadCloser
+	stub *overflowStub
+	sum  hash.Hash
+	n    int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.sum.Write(p[:n])
+	v.n += int64(n)
+	if err == io.EOF && (v.n != v.stub.Size || hex.EncodeToString(v.sum.Sum(nil)) != v.stub.SHA256) {
+		return n, fmt.Errorf("overflow blob %s: checksum mismatch", v.stub.Blob)
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
+
+func writeTarGz(w io.Writer, files []archiveFile, modTime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		if err := writeTarEntry(tw, f, modTime); err != nil {
+			return fmt.Errorf("write %s: %w", f.path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, f archiveFile, modTime time.Time) error {
+	r, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hdr := &tar.Header{Name: f.path, Mode: int64(f.mode.Perm()), Size: f.size, ModTime: modTime}
+	if f.mode&fs.ModeSymlink != 0 {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, string(target), 0
+		return tw.WriteHeader(hdr)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+func writeZip(w io.Writer, files []archiveFile, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.path, Method: zip.Deflate, Modified: modTime}
+		hdr.SetMode(f.mode)
+		r, err := f.open()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", f.path, err)
+		}
+		dst, err := zw.CreateHeader(hdr)
+		if err == nil {
+			_, err = io.Copy(dst, r)
+		}
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("write %s: %w", f.path, err)
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,452 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	// attachmentsDir 下 <ID>.json 为附件的清单，chunks/<sha256> 为按内容寻址的分块，相同的分块只存一份；
+	// sha256/<完整内容的 sha256> 记录该内容对应的附件 ID，相同内容的附件只存一份清单
+	attachmentsDir       = "attachments"
+	attachmentChunksDir  = attachmentsDir + "/chunks"
+	attachmentIndexDir   = attachmentsDir + "/sha256"
+	attachmentManifestV1 = 1
+)
+
+var (
+	// AttachmentChunkSize 是 UploadAttachment 分块的大小（字节）
+	AttachmentChunkSize int64 = 1 << 20
+	// AttachmentBatchSize 是 UploadAttachment 每次提交推送的分块总大小上限（字节），
+	// 已推送的分块会从内存中释放，上传占用的内存与附件大小无关
+	AttachmentBatchSize int64 = 16 << 20
+)
+
+// MessageTypeAttachment 是引用附件的消息类型，消息的 Body 为附件 ID（见 UploadAttachment）
+const MessageTypeAttachment = "attachment"
+
+// ErrAttachmentNotFound 表示附件清单不存在
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// AttachmentManifest 是分块存储的附件的清单
+type AttachmentManifest struct {
+	Version  int    `json:"version"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType,omitempty"`
+	Size     int64  `json:"size"`
+	// SHA256 是完整内容的哈希
+	SHA256    string `json:"sha256"`
+	ChunkSize int64  `json:"chunkSize"`
+	// Chunks 是各分块内容的 sha256，按顺序拼接即为完整内容
+	Chunks    []string `json:"chunks"`
+	CreatedAt int64    `json:"createdAt"`
+	// Deduplicated 仅出现在上传的返回值中：仓库中已有相同内容的附件，返回的是已有附件的清单，没有推送任何内容
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// UploadAttachment 从 r 读取附件内容，按 AttachmentChunkSize 分块写入 attachments/chunks/，
+// 每累计 AttachmentBatchSize 提交推送一次，最后提交清单 attachments/<ID>.json，返回清单。
+// 仓库中已有的分块不会重复推送；完整内容已作为附件存在时直接返回已有附件的清单（Deduplicated 为 true）。
+// 上传中途失败时已推送的分块会留在仓库中，但没有清单引用；需要断点续传时使用 StartAttachmentUpload
+func UploadAttachment(repoURL, sshKeyPEM string, r io.Reader, name, mimeType string) (*AttachmentManifest, error) {
+	m := newAttachmentManifest(name, mimeType)
+	if err := uploadAttachment(repoURL, sshKeyPEM, r, m, nil, nil); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UploadAttachmentBytes 同 UploadAttachment，内容来自内存
+func UploadAttachmentBytes(repoURL, sshKeyPEM string, content []byte, name, mimeType string) (*AttachmentManifest, error) {
+	return UploadAttachment(repoURL, sshKeyPEM, bytes.NewReader(content), name, mimeType)
+}
+
+// UploadAttachmentFile 同 UploadAttachment，内容来自本地文件 filePath，适合移动端上传大文件
+func UploadAttachmentFile(repoURL, sshKeyPEM string, filePath, name, mimeType string) (*AttachmentManifest, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return UploadAttachment(repoURL, sshKeyPEM, f, name, mimeType)
+}
+
+// DownloadAttachment 读取附件 id 的全部分块，校验后拼接为完整内容。大附件应使用 DownloadAttachmentToFile
+func DownloadAttachment(repoURL, sshKeyPEM string, id string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteAttachment(repoURL, sshKeyPEM, id, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteAttachment 把附件 id 的分块逐个校验后依次写入 w，不在内存中拼接完整内容。
+// 服务端支持时只下载 commit、tree、清单和分块（见 openPartialBranch），分块按 AttachmentBatchSize 分批取回。
+// 完整内容的校验在全部写入后进行，返回错误时 w 中已写入的内容应当丢弃
+func WriteAttachment(repoURL, sshKeyPEM string, id string, w io.Writer) error {
+	h, head, err := openPartialBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	m, err := h.attachmentManifest(head.Hash(), id)
+	if err != nil {
+		return err
+	}
+	if buf, ok := w.(*bytes.Buffer); ok {
+		buf.Grow(int(m.Size))
+	}
+	return m.stream(h, head.Hash(), func(chunk []byte) error {
+		_, err := w.Write(chunk)
+		return err
+	})
+}
+
+// AttachmentChunkHandler 依次接收附件的分块，返回错误会中止下载
+type AttachmentChunkHandler interface {
+	OnChunk(chunk []byte) error
+}
+
+// FetchAttachmentChunks 同 WriteAttachment，但每个分块回调一次 handler，便于通过 gomobile 把大附件分批交给 App
+func FetchAttachmentChunks(repoURL, sshKeyPEM string, id string, handler AttachmentChunkHandler) error {
+	return WriteAttachment(repoURL, sshKeyPEM, id, attachmentChunkWriter{handler})
+}
+
+// DownloadAttachmentToFile 把附件 id 写入本地文件 filePath：先写入 filePath.part，校验通过后再重命名，
+// 失败时不会留下不完整的文件
+func DownloadAttachmentToFile(repoURL, sshKeyPEM string, id string, filePath string) error {
+	tmp := filePath + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	err = WriteAttachment(repoURL, sshKeyPEM, id, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filePath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+type attachmentChunkWriter struct {
+	handler AttachmentChunkHandler
+}
+
+func (w attachmentChunkWriter) Write(p []byte) (int, error) {
+	if err := w.handler.OnChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// GetAttachmentManifestJSON 返回附件 id 的清单 JSON
+func GetAttachmentManifestJSON(repoURL, sshKeyPEM string, id string) (string, error) {
+	h, head, err := openPartialBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	m, err := h.attachmentManifest(head.Hash(), id)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func newAttachmentManifest(name, mimeType string) *AttachmentManifest {
+	return &AttachmentManifest{
+		Version:   attachmentManifestV1,
+		ID:        utils.RandomHexString(16),
+		Name:      name,
+		MimeType:  mimeType,
+		ChunkSize: AttachmentChunkSize,
+		CreatedAt: commitTime().UnixMilli(),
+	}
+}
+
+// uploadAttachment 按 m.ChunkSize 分块上传 r 的内容，填充 m 的大小、哈希和分块列表并提交清单；
+// 相同内容的附件已存在时把 m 替换为已有的清单。
+// pushed 是之前已推送过的分块（断点续传），r 开头的内容必须与其一致；
+// progress 不为 nil 时在每批推送后以目前已推送的全部分块调用
+func uploadAttachment(repoURL, sshKeyPEM string, r io.Reader, m *AttachmentManifest, pushed []string,
+	progress func(chunks []string) error) error {
+	if m.ChunkSize <= 0 {
+		return errors.New("attachment chunk size must be positive")
+	}
+	// 分块只需要与 HEAD 的树比较，新提交也只需要 tree，不下载仓库中已有的文件内容
+	h, head, err := openPartialBranch(repoURL, sshKeyPEM)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return err
+	}
+	up := &attachmentUpload{repoURL: repoURL, sshKeyPEM: sshKeyPEM, h: h, batch: map[string][]byte{}}
+	if head != nil {
+		up.parent = head.Hash()
+		c, err := h.repo.CommitObject(up.parent)
+		if err != nil {
+			return fmt.Errorf("head commit: %w", err)
+		}
+		if up.existing, err = c.Tree(); err != nil {
+			return fmt.Errorf("tree: %w", err)
+		}
+	}
+
+	m.Chunks, m.Size = []string{}, 0
+	whole := sha256.New()
+	buf := make([]byte, m.ChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := bytes.Clone(buf[:n])
+			whole.Write(chunk)
+			sum := sha256.Sum256(chunk)
+			name := hex.EncodeToString(sum[:])
+			i := len(m.Chunks)
+			m.Chunks = append(m.Chunks, name)
+			m.Size += int64(n)
+
+			if i < len(pushed) && pushed[i] != name {
+				return ErrUploadSourceChanged
+			}
+			chunkPath := path.Join(attachmentChunksDir, name)
+			if up.exists(chunkPath) {
+				continue
+			}
+			up.add(chunkPath, chunk)
+			up.added = true
+			if up.batchBytes >= AttachmentBatchSize {
+				if err := up.flush(fmt.Sprintf("upload attachment %s (%d bytes)", m.ID, m.Size)); err != nil {
+					return err
+				}
+				if progress != nil {
+					if err := progress(m.Chunks); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read attachment: %w", err)
+		}
+	}
+	if len(m.Chunks) < len(pushed) {
+		return ErrUploadSourceChanged
+	}
+	m.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	indexPath := path.Join(attachmentIndexDir, m.SHA256)
+	if !up.added {
+		// 全部分块都已在仓库中，可能是同一内容的附件已经存在
+		if existing, err := up.existingAttachment(indexPath); err != nil {
+			return err
+		} else if existing != nil && existing.Size == m.Size && existing.SHA256 == m.SHA256 {
+			*m = *existing
+			m.Deduplicated = true
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	up.add(m.manifestPath(), data)
+	up.add(indexPath, []byte(m.ID+"\n"))
+	return up.flush("attachment " + m.ID + " " + m.Name)
+}
+
+func (m *AttachmentManifest) manifestPath() string {
+	return path.Join(attachmentsDir, m.ID+".json")
+}
+
+// stream 按顺序读取并校验每个分块后交给 fn，最后校验完整内容的大小和哈希。
+// 本地没有的分块每次取回 AttachmentBatchSize 大小的一批，交给 fn 后即释放
+func (m *AttachmentManifest) stream(h *history, commit plumbing.Hash, fn func(chunk []byte) error) error {
+	batch := 1
+	if m.ChunkSize > 0 && AttachmentBatchSize > m.ChunkSize {
+		batch = int(AttachmentBatchSize / m.ChunkSize)
+	}
+	whole := sha256.New()
+	var size int64
+	for start := 0; start < len(m.Chunks); start += batch {
+		group := m.Chunks[start:min(start+batch, len(m.Chunks))]
+		paths := make([]string, len(group))
+		for j, sum := range group {
+			paths[j] = path.Join(attachmentChunksDir, sum)
+		}
+		fetched, err := h.fetchFiles(commit, paths...)
+		if err != nil {
+			return fmt.Errorf("attachment %s: %w", m.ID, err)
+		}
+		for j, sum := range group {
+			i := start + j
+			chunk, err := readRefFile(h.repo, commit, paths[j])
+			if err != nil {
+				return fmt.Errorf("attachment %s chunk %d: %w", m.ID, i, err)
+			}
+			if got := sha256.Sum256(chunk); hex.EncodeToString(got[:]) != sum {
+				return fmt.Errorf("attachment %s chunk %d: checksum mismatch", m.ID, i)
+			}
+			whole.Write(chunk)
+			size += int64(len(chunk))
+			if err := fn(chunk); err != nil {
+				return err
+			}
+		}
+		h.forgetBlobs(fetched)
+	}
+	if size != m.Size || hex.EncodeToString(whole.Sum(nil)) != m.SHA256 {
+		return fmt.Errorf("attachment %s: checksum mismatch", m.ID)
+	}
+	return nil
+}
+
+// attachmentManifest 取回（本地还没有时）并读取附件 id 的清单
+func (h *history) attachmentManifest(commit plumbing.Hash, id string) (*AttachmentManifest, error) {
+	if _, err := h.fetchFiles(commit, path.Join(attachmentsDir, id+".json")); err != nil {
+		return nil, err
+	}
+	return readAttachmentManifest(h, commit, id)
+}
+
+func readAttachmentManifest(h *history, commit plumbing.Hash, id string) (*AttachmentManifest, error) {
+	if id == "" || path.Base(id) != id {
+		return nil, fmt.Errorf("invalid attachment id %q", id)
+	}
+	content, err := readRefFile(h.repo, commit, path.Join(attachmentsDir, id+".json"))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m AttachmentManifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("parse attachment %s: %w", id, err)
+	}
+	return &m, nil
+}
+
+// attachmentUpload 把分块分批提交推送，每批推送后释放已推送分块占用的内存
+type attachmentUpload struct {
+	repoURL, sshKeyPEM string
+	// h 为 nil 表示远端是空仓库，第一批用于创建根提交
+	h      *history
+	parent plumbing.Hash
+	// existing 是开始上传时 HEAD 的树，用于判断分块是否已在仓库中
+	existing   *object.Tree
+	batch      map[string][]byte
+	batchBytes int64
+	// added 表示有分块需要推送
+	added bool
+}
+
+func (u *attachmentUpload) exists(path string) bool {
+	if u.existing == nil {
+		return false
+	}
+	_, err := u.existing.FindEntry(path)
+	return err == nil
+}
+
+// existingAttachment 通过内容索引查找开始上传时已存在的附件，不存在时返回 nil
+func (u *attachmentUpload) existingAttachment(indexPath string) (*AttachmentManifest, error) {
+	if u.existing == nil || !u.exists(indexPath) {
+		return nil, nil
+	}
+	if _, err := u.h.fetchFiles(u.parent, indexPath); err != nil {
+		return nil, err
+	}
+	id, err := readRefFile(u.h.repo, u.parent, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	m, err := u.h.attachmentManifest(u.parent, strings.TrimSpace(string(id)))
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return nil, nil
+	}
+	return m, err
+}
+
+func (u *attachmentUpload) add(path string, content []byte) {
+	if _, ok := u.batch[path]; !ok {
+		u.batchBytes += int64(len(content))
+	}
+	u.batch[path] = content
+}
+
+func (u *attachmentUpload) flush(commitMsg string) error {
+	if len(u.batch) == 0 {
+		return nil
+	}
+	if u.h == nil {
+		auth, err := utils.NewSSHAuthForURL(u.repoURL, u.sshKeyPEM)
+		if err != nil {
+			return err
+		}
+		if _, err := initRemote(u.repoURL, auth, initBranch(u.repoURL), u.batch, commitMsg); err != nil {
+			return err
+		}
+		// 之后的批次在刚创建的根提交上继续提交，只需要它的 tree
+		h, head, err := openPartialBranch(u.repoURL, u.sshKeyPEM)
+		if err != nil {
+			return err
+		}
+		u.h, u.parent = h, head.Hash()
+	} else {
+		newHead, err := u.h.commitAndPush(u.parent, commitMsg, u.batch)
+		if err != nil {
+			return err
+		}
+		u.parent = newHead
+	}
+
+	var pushed []plumbing.Hash
+	for _, content := range u.batch {
+		pushed = append(pushed, plumbing.ComputeHash(plumbing.BlobObject, content))
+	}
+	u.h.forgetBlobs(pushed)
+	u.batch, u.batchBytes = map[string][]byte{}, 0
+	return nil
+}
+
+// forgetBlobs 从内存仓库中删除已推送的 blob 以释放内存。
+// 之后的推送只需要树中的哈希，不再读取这些 blob 的内容
+func (h *history) forgetBlobs(hashes []plumbing.Hash) {
+	switch s := h.repo.Storer.(type) {
+	case *memory.Storage:
+		for _, hash := range hashes {
+			delete(s.Objects, hash)
+			delete(s.Blobs, hash)
+		}
+	case *spillStorage:
+		for _, hash := range hashes {
+			s.forget(hash)
+		}
+	}
+}
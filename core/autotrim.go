@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// AutoTrimIntervalSeconds 是同一仓库两次自动裁剪检查的最小间隔，检查需要完整克隆，不宜每次推送都执行
+var AutoTrimIntervalSeconds = 600
+
+// AutoTrimListener 接收自动裁剪的结果，只在裁剪了历史或裁剪失败时通知
+type AutoTrimListener interface {
+	// OnAutoTrim 的参数为 AutoTrimEvent 的 JSON
+	OnAutoTrim(eventJSON string)
+}
+
+// AutoTrimEvent 是一次自动裁剪的结果：清除的消息数量，或失败的原因
+type AutoTrimEvent struct {
+	RepoURL string `json:"repoUrl"`
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+type autoTrim struct {
+	sshKeyPEM string
+	running   bool
+	lastCheck time.Time
+}
+
+var (
+	autoTrimMu       sync.Mutex
+	autoTrims        = map[string]*autoTrim{}
+	autoTrimListener AutoTrimListener
+)
+
+// SetAutoTrim 开启或关闭仓库的自动裁剪。开启后每次成功推送新 commit，都会在后台按各频道 channel.json 中的
+// 保留策略（见 SetRetentionPolicy）清除过期的消息（同 EnforceRetention），
+// 同一仓库每 AutoTrimIntervalSeconds 秒最多检查一次。裁剪会重写历史记录
+func SetAutoTrim(repoURL, sshKeyPEM string, enabled bool) {
+	autoTrimMu.Lock()
+	defer autoTrimMu.Unlock()
+	if !enabled {
+		delete(autoTrims, repoURL)
+		return
+	}
+	if old := autoTrims[repoURL]; old != nil {
+		old.sshKeyPEM = sshKeyPEM
+		return
+	}
+	autoTrims[repoURL] = &autoTrim{sshKeyPEM: sshKeyPEM}
+}
+
+// SetAutoTrimListener 设置自动裁剪结果的接收者，传 nil 取消
+func SetAutoTrimListener(l AutoTrimListener) {
+	autoTrimMu.Lock()
+	defer autoTrimMu.Unlock()
+	autoTrimListener = l
+}
+
+// afterPush 在成功推送新 commit 后调用，按需在后台执行自动裁剪。
+// 推送方可能还持有仓库的共享锁，裁剪需要独占锁，所以不能同步执行
+func afterPush(repoURL string) {
+	autoTrimMu.Lock()
+	defer autoTrimMu.Unlock()
+	at := autoTrims[repoURL]
+	if at == nil || at.running || time.Since(at.lastCheck) < time.Duration(AutoTrimIntervalSeconds)*time.Second {
+		return
+	}
+	at.running = true
+	at.lastCheck = time.Now()
+	sshKeyPEM := at.sshKeyPEM
+	go func() {
+		removed, err := EnforceRetention(repoURL, sshKeyPEM)
+
+		autoTrimMu.Lock()
+		at.running = false
+		l := autoTrimListener
+		autoTrimMu.Unlock()
+		if l == nil || (removed == 0 && err == nil) {
+			return
+		}
+		event := AutoTrimEvent{RepoURL: repoURL, Removed: removed}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		data, _ := json.Marshal(event)
+		l.OnAutoTrim(string(data))
+	}()
+}
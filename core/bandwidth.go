@@ -0,0 +1,16 @@
+package core
+
+import "mixgram-core/internel/utils"
+
+// SetBandwidthLimit 设置 SSH 传输的上传/下载限速（字节/秒），0 表示不限速。
+// 用于后台同步时避免占满按流量计费的移动网络，对之后建立的连接生效
+func SetBandwidthLimit(uploadBytesPerSec, downloadBytesPerSec int64) {
+	utils.SetRateLimit(uploadBytesPerSec, downloadBytesPerSec)
+}
+
+// SetRepoBandwidthLimit 为该仓库后续的操作单独设置限速，覆盖 SetBandwidthLimit 的全局设置
+// （例如前台打开的会话不限速、后台同步的仓库限速）；两个参数都为 -1 时取消覆盖。
+// 启用连接池时连接由多个仓库共用，只使用全局限速
+func SetRepoBandwidthLimit(repoURL string, uploadBytesPerSec, downloadBytesPerSec int64) {
+	utils.SetRepoRateLimit(repoURL, uploadBytesPerSec, downloadBytesPerSec)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// bootstrapMeta 是启动包在 refs/mixgram/ 命名空间下的名称
+const bootstrapMeta = "bootstrap"
+
+// Bootstrap 是新设备首次打开时优先下载的启动包：包含最新的文件索引、最近的 commit 和作者资料，
+// 让界面可以立即渲染，完整历史在后台同步
+type Bootstrap struct {
+	HeadHash    string                    `json:"headHash"`
+	Branch      string                    `json:"branch"`
+	GeneratedAt int64                     `json:"generatedAt"`
+	Index       []BootstrapFile           `json:"index"`
+	Recent      []SimpleCommit            `json:"recent"`
+	Profiles    map[string]*AuthorProfile `json:"profiles,omitempty"`
+}
+
+// BootstrapFile 是 HEAD 中一个文件的索引项
+type BootstrapFile struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// BuildBootstrapJSON 克隆远端并生成启动包（包含最近 recent 条 commit）的 JSON
+func BuildBootstrapJSON(repoURL, sshKeyPEM string, recent int) (string, error) {
+	b, err := buildBootstrap(repoURL, sshKeyPEM, recent)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PublishBootstrap 生成启动包并以 gzip 压缩后写入远端的 refs/mixgram/bootstrap
+func PublishBootstrap(repoURL, sshKeyPEM string, recent int) error {
+	b, err := buildBootstrap(repoURL, sshKeyPEM, recent)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return fmt.Errorf("encode bootstrap: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress bootstrap: %w", err)
+	}
+	return WriteMeta(repoURL, sshKeyPEM, bootstrapMeta, buf.Bytes())
+}
+
+// FetchBootstrapJSON 只取回 refs/mixgram/bootstrap 并返回其中启动包的 JSON，不克隆数据分支。
+// 远端没有发布过启动包时返回 ErrMetaNotFound。
+func FetchBootstrapJSON(repoURL, sshKeyPEM string) (string, error) {
+	data, err := ReadMeta(repoURL, sshKeyPEM, bootstrapMeta)
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("decompress bootstrap: %w", err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("decompress bootstrap: %w", err)
+	}
+	return string(bytes.TrimSpace(raw)), nil
+}
+
+func buildBootstrap(repoURL, sshKeyPEM string, recent int) (*Bootstrap, error) {
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := h.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := headCommit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("get head tree: %w", err)
+	}
+
+	b := &Bootstrap{
+		HeadHash:    head.Hash().String(),
+		Branch:      head.Name().Short(),
+		GeneratedAt: time.Now().UnixMilli(),
+		Index:       []BootstrapFile{},
+		Recent:      []SimpleCommit{},
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		b.Index = append(b.Index, BootstrapFile{Path: f.Name, Hash: f.Hash.String(), Size: f.Size})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk tree: %w", err)
+	}
+
+	iter, err := h.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, fmt.Errorf("log: %w", err)
+	}
+	defer iter.Close()
+	for recent <= 0 || len(b.Recent) < recent {
+		c, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("iterate log: %w", err)
+		}
+		sc := toSimpleCommit(c)
+		if profile := resolveProfile(sc.Email); profile != nil {
+			if b.Profiles == nil {
+				b.Profiles = map[string]*AuthorProfile{}
+			}
+			b.Profiles[sc.Email] = profile
+		}
+		b.Recent = append(b.Recent, sc)
+	}
+	return b, nil
+}
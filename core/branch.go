@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	branchesMu   sync.Mutex
+	repoBranches = map[string]string{}
+)
+
+// SetRepoBranch 指定该仓库后续操作（读取、推送、重写历史、轮询）使用的分支（短名称）；
+// 空字符串表示使用远端默认分支（远端 HEAD 指向的分支）
+func SetRepoBranch(repoURL, branch string) {
+	branchesMu.Lock()
+	changed := repoBranches[repoURL] != branch
+	if branch == "" {
+		delete(repoBranches, repoURL)
+	} else {
+		repoBranches[repoURL] = branch
+	}
+	branchesMu.Unlock()
+
+	// 切换分支后上次看到的 head 不再可比，避免误报分歧
+	if changed {
+		SetLastSeenHead(repoURL, "")
+	}
+}
+
+// RepoBranch 返回通过 SetRepoBranch 指定的分支，未指定时返回空字符串
+func RepoBranch(repoURL string) string {
+	branchesMu.Lock()
+	defer branchesMu.Unlock()
+	return repoBranches[repoURL]
+}
+
+// ResolveDefaultBranch 只通过引用协商读取远端默认分支（短名称），空仓库返回空字符串
+func ResolveDefaultBranch(repoURL, sshKeyPEM string) (string, error) {
+	refs, err := ListRemoteRefs(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	return refs.DefaultBranch, nil
+}
+
+// cloneRepo 克隆远端仓库到内存，检出为该仓库指定的分支或远端默认分支；depth 为 0 表示完整克隆
+func cloneRepo(repoURL string, auth transport.AuthMethod, depth int) (*git.Repository, error) {
+	var repo *git.Repository
+	err := withRetry(repoURL, func(ctx context.Context) (err error) {
+		repo, err = cloneToMemory(ctx, repoURL, auth, RepoBranch(repoURL), depth)
+		return err
+	})
+	return repo, err
+}
+
+// initBranch 返回在空仓库中创建根提交时使用的分支名
+func initBranch(repoURL string) string {
+	if branch := RepoBranch(repoURL); branch != "" {
+		return branch
+	}
+	return DefaultBranchName
+}
+
+// selectedHead 返回指定分支在通告引用中的哈希；未指定分支时返回远端 HEAD
+func selectedHead(repoURL string, refs map[string]plumbing.Hash, head *plumbing.Hash) *plumbing.Hash {
+	branch := RepoBranch(repoURL)
+	if branch == "" {
+		return head
+	}
+	if h, ok := refs[plumbing.NewBranchReferenceName(branch).String()]; ok {
+		return &h
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+)
+
+// bundle 文件的签名行，导出使用 v2，导入同时接受 v3（只支持 sha1）
+const (
+	bundleSignatureV2 = "# v2 git bundle"
+	bundleSignatureV3 = "# v3 git bundle"
+)
+
+// ExportBundle 把仓库当前分支写入 git bundle 文件 outPath（与 git bundle create 的格式相同，可以用 git clone 或 git fetch 读取），
+// 用于离线备份或在无法直连的设备之间传递历史。
+// sinceHash 不为空时只导出该 commit 之后的历史，导入方的仓库需要已有该 commit。返回导出的 commit 数量
+func ExportBundle(repoURL, sshKeyPEM string, outPath string, sinceHash string) (int, error) {
+	defer shareRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return 0, err
+	}
+	if len(h.commits) == 0 {
+		return 0, errors.New("repository is empty")
+	}
+	head := h.commits[0].Hash
+
+	var prerequisites []plumbing.Hash
+	if sinceHash != "" {
+		since := plumbing.NewHash(sinceHash)
+		switch h.indexOf(since) {
+		case -1:
+			return 0, errors.New("commit not found in history")
+		case 0:
+			return 0, errors.New("no commits after sinceHash")
+		}
+		prerequisites = append(prerequisites, since)
+	}
+	objects, err := revlist.Objects(h.repo.Storer, []plumbing.Hash{head}, prerequisites)
+	if err != nil {
+		return 0, fmt.Errorf("list objects: %w", err)
+	}
+	commits := 0
+	for _, hash := range objects {
+		if _, err := h.repo.Storer.EncodedObject(plumbing.CommitObject, hash); err == nil {
+			commits++
+		}
+	}
+
+	tmp := outPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("create bundle: %w", err)
+	}
+	err = writeBundle(f, h, head, prerequisites, objects)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, outPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("write bundle: %w", err)
+	}
+	return commits, nil
+}
+
+// writeBundle 写入 bundle 头（前置 commit、分支和 HEAD）以及对象包
+func writeBundle(w io.Writer, h *history, head plumbing.Hash, prerequisites, objects []plumbing.Hash) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, bundleSignatureV2)
+	for _, p := range prerequisites {
+		fmt.Fprintf(bw, "-%s\n", p)
+	}
+	fmt.Fprintf(bw, "%s %s\n", head, h.refName)
+	fmt.Fprintf(bw, "%s %s\n", head, plumbing.HEAD)
+	fmt.Fprintln(bw)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := packfile.NewEncoder(w, h.repo.Storer, false).Encode(objects, 10)
+	return err
+}
+
+// ImportBundle 把 bundle 文件 inPath（ExportBundle 或 git bundle create 生成）中的历史应用到仓库当前分支并推送。
+// 使用 bundle 中同名分支的 head，没有同名分支时使用其 HEAD。
+// 只接受快进更新：bundle 中的历史已包含在仓库中时返回 MergeStatusUpToDate，两边分叉时返回 ErrMergeNotFastForward
+func ImportBundle(repoURL, sshKeyPEM string, inPath string) (*MergeResult, error) {
+	f, err := os.Open(inPath)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	prerequisites, refs, err := readBundleHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prerequisites {
+		if err := h.repo.Storer.HasEncodedObject(p); err != nil {
+			return nil, fmt.Errorf("bundle requires commit %s which the repository does not have", p)
+		}
+	}
+	newHead, ok := refs[h.refName]
+	if !ok {
+		if newHead, ok = refs[plumbing.HEAD]; !ok {
+			return nil, fmt.Errorf("bundle has no %s or HEAD", h.refName.Short())
+		}
+	}
+	if err := packfile.UpdateObjectStorage(h.repo.Storer, r); err != nil {
+		return nil, fmt.Errorf("read bundle objects: %w", err)
+	}
+
+	source, err := h.repo.CommitObject(newHead)
+	if err != nil {
+		return nil, fmt.Errorf("load bundle head: %w", err)
+	}
+	target, err := h.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("load head: %w", err)
+	}
+	if source.Hash == target.Hash {
+		return &MergeResult{Status: MergeStatusUpToDate, CommitHash: target.Hash.String()}, nil
+	}
+	if ok, err := source.IsAncestor(target); err != nil {
+		return nil, fmt.Errorf("check ancestry: %w", err)
+	} else if ok {
+		return &MergeResult{Status: MergeStatusUpToDate, CommitHash: target.Hash.String()}, nil
+	}
+	if ok, err := target.IsAncestor(source); err != nil {
+		return nil, fmt.Errorf("check ancestry: %w", err)
+	} else if !ok {
+		return nil, ErrMergeNotFastForward
+	}
+	if err := h.push(newHead, false); err != nil {
+		return nil, err
+	}
+	return &MergeResult{Status: MergeStatusFastForward, CommitHash: newHead.String()}, nil
+}
+
+// ImportBundleJSON 同 ImportBundle，以 JSON 返回 MergeResult
+func ImportBundleJSON(repoURL, sshKeyPEM string, inPath string) (string, error) {
+	result, err := ImportBundle(repoURL, sshKeyPEM, inPath)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// readBundleHeader 读取 bundle 头，返回前置 commit 和引用，r 随后指向对象包的开头
+func readBundleHeader(r *bufio.Reader) ([]plumbing.Hash, map[plumbing.ReferenceName]plumbing.Hash, error) {
+	var prerequisites []plumbing.Hash
+	refs := map[plumbing.ReferenceName]plumbing.Hash{}
+	for n := 0; ; n++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("read bundle header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case n == 0:
+			if line != bundleSignatureV2 && line != bundleSignatureV3 {
+				return nil, nil, errors.New("not a git bundle")
+			}
+		case line == "":
+			return prerequisites, refs, nil
+		case strings.HasPrefix(line, "@"):
+			// v3 的能力行，只接受 sha1 仓库
+			if name, value, _ := strings.Cut(line[1:], "="); name == "object-format" && value != "sha1" {
+				return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedObjectFormat, value)
+			}
+		case strings.HasPrefix(line, "-"):
+			hash, _, _ := strings.Cut(line[1:], " ")
+			prerequisites = append(prerequisites, plumbing.NewHash(hash))
+		default:
+			hash, name, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, nil, fmt.Errorf("malformed bundle ref %q", line)
+			}
+			refs[plumbing.ReferenceName(name)] = plumbing.NewHash(hash)
+		}
+	}
+}
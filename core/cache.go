@@ -0,0 +1,360 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+var (
+	cacheMu      sync.Mutex
+	cacheBackend StorageBackend
+	cacheLocks   = map[string]*sync.Mutex{}
+)
+
+// SetCacheDir 设置本地仓库缓存目录，传空字符串关闭缓存（默认）。
+// 开启后完整克隆改为先增量取回到缓存中的裸仓库，再从缓存载入内存，只有新增的对象需要经过网络。
+// 历史被改写后缓存中会留下不可达的对象，需要定期调用 MaintainCache。其他存储见 SetStorageBackend
+func SetCacheDir(dir string) error {
+	if dir == "" {
+		SetStorageBackend(nil)
+		return nil
+	}
+	b, err := NewDirStorage(dir)
+	if err != nil {
+		return err
+	}
+	SetStorageBackend(b)
+	return nil
+}
+
+// cacheFilesystem 返回仓库 repoURL 的缓存所在的文件系统，未开启缓存时返回 nil
+func cacheFilesystem(repoURL string) (billy.Filesystem, error) {
+	cacheMu.Lock()
+	b := cacheBackend
+	cacheMu.Unlock()
+	if b == nil {
+		return nil, nil
+	}
+	fs, err := b.Filesystem(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("cache storage: %w", err)
+	}
+	return fs, nil
+}
+
+// lockCache 独占仓库 repoURL 的缓存，返回解锁函数
+func lockCache(repoURL string) func() {
+	cacheMu.Lock()
+	l := cacheLocks[repoURL]
+	if l == nil {
+		l = &sync.Mutex{}
+		cacheLocks[repoURL] = l
+	}
+	cacheMu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// cloneToMemory 克隆仓库的 branch 分支（空字符串表示远端默认分支）到内存。
+// 开启缓存且是完整克隆时经由本地缓存取回，否则直接克隆
+func cloneToMemory(ctx context.Context, repoURL string, auth transport.AuthMethod, branch string, depth int) (*git.Repository, error) {
+	var fs billy.Filesystem
+	if depth == 0 {
+		var err error
+		if fs, err = cacheFilesystem(repoURL); err != nil {
+			return nil, err
+		}
+	}
+	if fs == nil {
+		repo, _, err := utils.CloneBranchToMemory(ctx, repoURL, auth, branch, depth)
+		return repo, err
+	}
+	defer lockCache(repoURL)()
+	cache, err := openCache(fs, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCache(cache)
+
+	remote, err := cache.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, ProxyOptions: utils.SSHProxyOptions(repoURL)})
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	refName, err := cloneTarget(refs, branch)
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	tracking := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, refName.Short())
+	// 首次克隆（或上次中断）时分批取回，中断后下一次尝试从已取回的对象继续
+	resumed, err := fetchResumable(ctx, cache, repoURL, auth, refName, refHash(refs, refName))
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	if !resumed {
+		if err := dropResumeRefs(cache); err != nil {
+			return nil, err
+		}
+		if err := fetchCache(ctx, cache, repoURL, auth, refName, tracking); err != nil {
+			return nil, err
+		}
+	}
+	head, err := cache.Reference(tracking, true)
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	return loadFromCache(cache, repoURL, refName, head.Hash())
+}
+
+// fetchCache 把 refName 增量取回到缓存的跟踪引用 tracking
+func fetchCache(ctx context.Context, cache *git.Repository, repoURL string, auth transport.AuthMethod, refName, tracking plumbing.ReferenceName) error {
+	err := cache.FetchContext(ctx, &git.FetchOptions{
+		Auth:         auth,
+		RefSpecs:     []ggconfig.RefSpec{ggconfig.RefSpec(fmt.Sprintf("+%s:%s", refName, tracking))},
+		Progress:     io.Discard,
+		Tags:         git.NoTags,
+		ProxyOptions: utils.SSHProxyOptions(repoURL),
+	})
+	if err := ignoreUpToDate(err); err != nil {
+		return fmt.Errorf("clone: %w", err)
+	}
+	return nil
+}
+
+// cloneTarget 在远端引用列表中确定要克隆的分支
+func cloneTarget(refs []*plumbing.Reference, branch string) (plumbing.ReferenceName, error) {
+	want := plumbing.ReferenceName("")
+	if branch != "" {
+		want = plumbing.NewBranchReferenceName(branch)
+	}
+	for _, ref := range refs {
+		if want == "" && ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			want = ref.Target()
+		}
+	}
+	for _, ref := range refs {
+		if want != "" && ref.Name() == want {
+			return want, nil
+		}
+	}
+	if want == "" {
+		return "", errors.New("remote HEAD is not on a branch")
+	}
+	return "", fmt.Errorf("couldn't find remote ref %q", want)
+}
+
+// refHash 返回 refs 中 name 指向的哈希
+func refHash(refs []*plumbing.Reference, name plumbing.ReferenceName) plumbing.Hash {
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref.Hash()
+		}
+	}
+	return plumbing.ZeroHash
+}
+
+// loadFromCache 把缓存中从 head 可达的对象复制到新的内存仓库，并检出 refName
+func loadFromCache(cache *git.Repository, repoURL string, refName plumbing.ReferenceName, head plumbing.Hash) (*git.Repository, error) {
+	hashes, err := revlist.Objects(cache.Storer, []plumbing.Hash{head}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("walk cache: %w", err)
+	}
+	mem := utils.NewStorage()
+	for _, hash := range hashes {
+		if err := copyObject(cache.Storer, mem, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	repo, err := git.Init(mem, memfs.New())
+	if err != nil {
+		return nil, fmt.Errorf("init: %w", err)
+	}
+	if _, err := repo.CreateRemote(&ggconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{repoURL}}); err != nil {
+		return nil, fmt.Errorf("add remote: %w", err)
+	}
+	tracking := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, refName.Short())
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewHashReference(refName, head),
+		plumbing.NewHashReference(tracking, head),
+		plumbing.NewSymbolicReference(plumbing.HEAD, refName),
+	} {
+		if err := mem.SetReference(ref); err != nil {
+			return nil, fmt.Errorf("set ref: %w", err)
+		}
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("worktree: %w", err)
+	}
+	if err := wt.Reset(&git.ResetOptions{Commit: head, Mode: git.HardReset}); err != nil {
+		return nil, fmt.Errorf("checkout: %w", err)
+	}
+	return repo, nil
+}
+
+// copyObject 把对象复制到内存中，缓存仓库关闭后仍可读取
+func copyObject(from, to storer.EncodedObjectStorer, hash plumbing.Hash) error {
+	src, err := from.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return fmt.Errorf("read cached object %s: %w", hash, err)
+	}
+	dst := to.NewEncodedObject()
+	dst.SetType(src.Type())
+	dst.SetSize(src.Size())
+	r, err := src.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := dst.Writer()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, err = to.SetEncodedObject(dst)
+	return err
+}
+
+// openCache 打开文件系统 fs 中的缓存裸仓库，不存在时创建
+func openCache(fs billy.Filesystem, repoURL string) (*git.Repository, error) {
+	// 保持包文件打开，加密存储等每次打开都要整体读取的文件系统不必为每个对象重新读取包文件；closeCache 时关闭
+	st := filesystem.NewStorageWithOptions(fs, cache.NewObjectLRUDefault(), filesystem.Options{KeepDescriptors: true})
+	repo, err := git.Open(st, nil)
+	if err == nil {
+		return repo, nil
+	}
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, fmt.Errorf("open cache: %w", err)
+	}
+	if repo, err = git.Init(st, nil); err != nil {
+		return nil, fmt.Errorf("init cache: %w", err)
+	}
+	if _, err := repo.CreateRemote(&ggconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{repoURL}}); err != nil {
+		return nil, fmt.Errorf("init cache: %w", err)
+	}
+	return repo, nil
+}
+
+func closeCache(repo *git.Repository) {
+	if c, ok := repo.Storer.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// CacheMaintenance 是 MaintainCache 的结果
+type CacheMaintenance struct {
+	// BytesBefore、BytesAfter 是维护前后缓存目录占用的字节数
+	BytesBefore int64 `json:"bytesBefore"`
+	BytesAfter  int64 `json:"bytesAfter"`
+	// PrunedObjects 是删除的不可达松散对象数量，不包括随旧包文件一起删除的对象
+	PrunedObjects int `json:"prunedObjects"`
+	// ResumePending 表示缓存中有未完成的可续传克隆，缓存中的 tree 引用了尚未取回的文件，本次没有整理
+	ResumePending bool `json:"resumePending,omitempty"`
+}
+
+// MaintainCache 整理仓库 repoURL 的本地缓存：删除不可达的松散对象，把可达对象重新打包为一个包文件
+// （旧包中被改写历史遗留的对象随之删除），并把引用合并到 packed-refs。
+// 没有开启缓存或该仓库还没有缓存时什么也不做；有未完成的可续传克隆时跳过整理，结果的 ResumePending 为 true
+func MaintainCache(repoURL string) (*CacheMaintenance, error) {
+	result := &CacheMaintenance{}
+	fs, err := cacheFilesystem(repoURL)
+	if err != nil || fs == nil {
+		return result, err
+	}
+	defer lockCache(repoURL)()
+	if _, err := fs.Stat("HEAD"); errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	result.BytesBefore = dirSize(fs)
+	cache, err := openCache(fs, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCache(cache)
+	if len(resumeRefs(cache)) > 0 {
+		// 遍历可达对象时会因缺少文件内容而失败，等克隆完成后再整理
+		result.ResumePending = true
+		result.BytesAfter = result.BytesBefore
+		return result, nil
+	}
+
+	err = cache.Prune(git.PruneOptions{Handler: func(hash plumbing.Hash) error {
+		result.PrunedObjects++
+		return cache.DeleteObject(hash)
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("prune: %w", err)
+	}
+	if err := cache.RepackObjects(&git.RepackConfig{}); err != nil {
+		return nil, fmt.Errorf("repack: %w", err)
+	}
+	// go-git 靠 packed-refs 的修改时间判断加锁是否成功，修改时间不稳定的文件系统（如 memfs）上会一直重试
+	if stableModTime(fs) {
+		if err := cache.Storer.PackRefs(); err != nil {
+			return nil, fmt.Errorf("pack refs: %w", err)
+		}
+	}
+	result.BytesAfter = dirSize(fs)
+	return result, nil
+}
+
+// MaintainCacheJSON 同 MaintainCache，以 JSON 返回 CacheMaintenance
+func MaintainCacheJSON(repoURL string) (string, error) {
+	result, err := MaintainCache(repoURL)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// stableModTime 判断 fs 对未修改的文件是否返回相同的修改时间
+func stableModTime(fs billy.Filesystem) bool {
+	a, err := fs.Stat("HEAD")
+	if err != nil {
+		return false
+	}
+	b, err := fs.Stat("HEAD")
+	return err == nil && a.ModTime().Equal(b.ModTime())
+}
+
+func dirSize(fs billy.Filesystem) int64 {
+	var total int64
+	util.Walk(fs, "", func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,489 @@
+package core
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/crypto/argon2"
+)
+
+// cacheKeyFile 是加密缓存目录中校验密钥的文件：magic | 口令派生用的 salt | 加密的 cacheKeyCheck
+const cacheKeyFile = "cache.key"
+
+// 加密缓存文件的头部，每个文件格式为 magic | nonce | 密文
+var (
+	cacheFileMagic = []byte("MGC1")
+	cacheKeyMagic  = []byte("MGCK1")
+	cacheKeyCheck  = []byte("mixgram cache key")
+)
+
+// ErrCacheKeyMismatch 表示加密缓存目录是用其他密钥或口令创建的
+var ErrCacheKeyMismatch = errors.New("cache key does not match")
+
+// SetEncryptedCacheDir 同 SetCacheDir，但缓存中的每个文件都用 key（32 字节，例如由系统密钥库保管）以 AES-256-GCM 加密，
+// 设备存储被导出时无法读出缓存的历史。文件名（对象哈希、引用名）不加密。
+// 目录第一次使用时记录密钥的校验信息，之后使用其他密钥返回 ErrCacheKeyMismatch；已有未加密缓存的目录不能直接改为加密
+func SetEncryptedCacheDir(dir string, key []byte) error {
+	if _, err := prepareCacheKey(dir, func([]byte) []byte { return key }); err != nil {
+		return err
+	}
+	return setEncryptedCache(dir, key)
+}
+
+// SetEncryptedCacheDirPassphrase 同 SetEncryptedCacheDir，密钥由口令经 argon2id 派生，salt 保存在缓存目录中
+func SetEncryptedCacheDirPassphrase(dir, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase is empty")
+	}
+	key, err := prepareCacheKey(dir, func(salt []byte) []byte {
+		return argon2.IDKey([]byte(passphrase), salt, bundleKDFTime, bundleKDFMemory, bundleKDFThreads, EncryptionKeySize)
+	})
+	if err != nil {
+		return err
+	}
+	return setEncryptedCache(dir, key)
+}
+
+func setEncryptedCache(dir string, key []byte) error {
+	inner, err := NewDirStorage(dir)
+	if err != nil {
+		return err
+	}
+	b, err := NewEncryptedStorage(inner, key)
+	if err != nil {
+		return err
+	}
+	SetStorageBackend(b)
+	return nil
+}
+
+// prepareCacheKey 读取（不存在时创建）dir 中的 cache.key，用 derive 从其中的 salt 得到密钥并校验，返回密钥
+func prepareCacheKey(dir string, derive func(salt []byte) []byte) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	path := filepath.Join(dir, cacheKeyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if !bytes.HasPrefix(data, cacheKeyMagic) || len(data) < len(cacheKeyMagic)+16 {
+			return nil, fmt.Errorf("invalid %s", cacheKeyFile)
+		}
+		salt := data[len(cacheKeyMagic) : len(cacheKeyMagic)+16]
+		key := derive(salt)
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		check, err := openCacheFile(aead, data[len(cacheKeyMagic)+16:])
+		if err != nil || !bytes.Equal(check, cacheKeyCheck) {
+			return nil, ErrCacheKeyMismatch
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read %s: %w", cacheKeyFile, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read cache dir: %w", err)
+	}
+	if len(entries) > 0 {
+		return nil, errors.New("cache dir already contains an unencrypted cache")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := derive(salt)
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealCacheFile(aead, cacheKeyCheck)
+	if err != nil {
+		return nil, err
+	}
+	data = append(append(append([]byte(nil), cacheKeyMagic...), salt...), sealed...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("write %s: %w", cacheKeyFile, err)
+	}
+	return key, nil
+}
+
+// encryptedStorage 把 inner 提供的文件系统包装为加密文件系统
+type encryptedStorage struct {
+	inner StorageBackend
+	aead  cipher.AEAD
+}
+
+// NewEncryptedStorage 返回在 inner 之上逐文件加密的 StorageBackend，key 为 32 字节的 AES-256 密钥。
+// 每个文件打开时整体解密到内存、关闭时整体加密写回，适合缓存这类按需载入内存的仓库
+func NewEncryptedStorage(inner StorageBackend, key []byte) (StorageBackend, error) {
+	if inner == nil {
+		return nil, errors.New("storage backend is nil")
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("cache key must be %d bytes", EncryptionKeySize)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStorage{inner: inner, aead: aead}, nil
+}
+
+func (s *encryptedStorage) Filesystem(repoURL string) (billy.Filesystem, error) {
+	fs, err := s.inner.Filesystem(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedFS(fs, s.aead), nil
+}
+
+func sealCacheFile(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), cacheFileMagic...), nonce...)
+	return aead.Seal(out, nonce, plain, cacheFileMagic), nil
+}
+
+func openCacheFile(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < cacheFileOverhead(aead) || !bytes.HasPrefix(data, cacheFileMagic) {
+		return nil, ErrNotEncrypted
+	}
+	nonce := data[len(cacheFileMagic) : len(cacheFileMagic)+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[len(cacheFileMagic)+aead.NonceSize():], cacheFileMagic)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plain, nil
+}
+
+// cacheFileOverhead 是加密文件比明文多出的字节数，Stat 据此返回明文大小
+func cacheFileOverhead(aead cipher.AEAD) int {
+	return len(cacheFileMagic) + aead.NonceSize() + aead.Overhead()
+}
+
+// encryptedFS 是逐文件加密的 billy.Filesystem。空文件视为空的明文。
+// go-git 写包文件时会同时打开临时文件读取已写入的部分，因此正在写入的文件在同一 encryptedFS 中共享内容
+type encryptedFS struct {
+	fs   billy.Filesystem
+	aead cipher.AEAD
+
+	mu      sync.Mutex
+	writing map[string]*encryptedBuffer
+}
+
+// encryptedBuffer 是一个文件解密后的内容，writers 是以写方式打开它的句柄数
+type encryptedBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	writers int
+}
+
+func newEncryptedFS(fs billy.Filesystem, aead cipher.AEAD) *encryptedFS {
+	return &encryptedFS{fs: fs, aead: aead, writing: map[string]*encryptedBuffer{}}
+}
+
+func (e *encryptedFS) Create(filename string) (billy.File, error) {
+	return e.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+func (e *encryptedFS) Open(filename string) (billy.File, error) {
+	return e.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (e *encryptedFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if buf := e.writing[filename]; buf != nil && !writable {
+		return &encryptedFile{fs: e, name: filename, buf: buf}, nil
+	}
+
+	// 追加和部分写入都需要先读出原内容，底层文件总以读写方式打开
+	innerFlag := flag &^ (os.O_WRONLY | os.O_APPEND)
+	if writable {
+		innerFlag |= os.O_RDWR
+	}
+	f, err := e.fs.OpenFile(filename, innerFlag, perm)
+	if err != nil {
+		return nil, err
+	}
+	buf := &encryptedBuffer{}
+	if flag&os.O_TRUNC == 0 {
+		data, err := io.ReadAll(f)
+		if err == nil && len(data) > 0 {
+			buf.data, err = openCacheFile(e.aead, data)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	file := &encryptedFile{fs: e, name: filename, buf: buf, inner: f, append: flag&os.O_APPEND != 0}
+	if !writable {
+		f.Close()
+		file.inner = nil
+		return file, nil
+	}
+	e.share(filename, buf)
+	return file, nil
+}
+
+// share 登记正在写入的文件，同名文件的只读句柄读到同一份内容
+func (e *encryptedFS) share(filename string, buf *encryptedBuffer) {
+	if prev := e.writing[filename]; prev != nil && prev != buf {
+		// 同一文件被再次以写方式打开时以最新的句柄为准
+		prev.writers = 0
+	}
+	buf.writers++
+	e.writing[filename] = buf
+}
+
+func (e *encryptedFS) release(filename string, buf *encryptedBuffer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	buf.writers--
+	if buf.writers <= 0 && e.writing[filename] == buf {
+		delete(e.writing, filename)
+	}
+}
+
+func (e *encryptedFS) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := e.fs.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	buf := &encryptedBuffer{}
+	e.share(f.Name(), buf)
+	return &encryptedFile{fs: e, name: f.Name(), buf: buf, inner: f}, nil
+}
+
+func (e *encryptedFS) Stat(filename string) (os.FileInfo, error) {
+	fi, err := e.fs.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return e.plainInfo(fi), nil
+}
+
+func (e *encryptedFS) Lstat(filename string) (os.FileInfo, error) {
+	fi, err := e.fs.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return e.plainInfo(fi), nil
+}
+
+func (e *encryptedFS) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := e.fs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range infos {
+		infos[i] = e.plainInfo(fi)
+	}
+	return infos, nil
+}
+
+// plainInfo 把普通文件的大小换算为明文大小
+func (e *encryptedFS) plainInfo(fi os.FileInfo) os.FileInfo {
+	if !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return fi
+	}
+	return plainFileInfo{FileInfo: fi, size: max(fi.Size()-int64(cacheFileOverhead(e.aead)), 0)}
+}
+
+func (e *encryptedFS) Rename(from, to string) error { return e.fs.Rename(from, to) }
+
+func (e *encryptedFS) Remove(filename string) error { return e.fs.Remove(filename) }
+
+func (e *encryptedFS) Join(elem ...string) string { return e.fs.Join(elem...) }
+
+func (e *encryptedFS) MkdirAll(filename string, perm os.FileMode) error {
+	return e.fs.MkdirAll(filename, perm)
+}
+
+func (e *encryptedFS) Symlink(target, link string) error { return e.fs.Symlink(target, link) }
+
+func (e *encryptedFS) Readlink(link string) (string, error) { return e.fs.Readlink(link) }
+
+func (e *encryptedFS) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := e.fs.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedFS(fs, e.aead), nil
+}
+
+func (e *encryptedFS) Root() string { return e.fs.Root() }
+
+func (e *encryptedFS) Capabilities() billy.Capability { return billy.Capabilities(e.fs) }
+
+type plainFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi plainFileInfo) Size() int64 { return fi.size }
+
+// encryptedFile 是 encryptedFS 中打开的文件，inner 为 nil 表示只读
+type encryptedFile struct {
+	fs     *encryptedFS
+	name   string
+	buf    *encryptedBuffer
+	inner  billy.File
+	pos    int64
+	append bool
+	dirty  bool
+	closed bool
+}
+
+func (f *encryptedFile) Name() string { return f.name }
+
+func (f *encryptedFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *encryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	f.buf.mu.Lock()
+	defer f.buf.mu.Unlock()
+	if off >= int64(len(f.buf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *encryptedFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.inner == nil {
+		return 0, os.ErrPermission
+	}
+	f.buf.mu.Lock()
+	defer f.buf.mu.Unlock()
+	if f.append {
+		f.pos = int64(len(f.buf.data))
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.buf.data)) {
+		f.buf.data = append(f.buf.data, make([]byte, end-int64(len(f.buf.data)))...)
+	}
+	copy(f.buf.data[f.pos:], p)
+	f.pos = end
+	f.dirty = true
+	return len(p), nil
+}
+
+func (f *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	f.buf.mu.Lock()
+	size := int64(len(f.buf.data))
+	f.buf.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *encryptedFile) Truncate(size int64) error {
+	if f.inner == nil {
+		return os.ErrPermission
+	}
+	f.buf.mu.Lock()
+	defer f.buf.mu.Unlock()
+	if size < int64(len(f.buf.data)) {
+		f.buf.data = f.buf.data[:size]
+	} else {
+		f.buf.data = append(f.buf.data, make([]byte, size-int64(len(f.buf.data)))...)
+	}
+	f.dirty = true
+	return nil
+}
+
+func (f *encryptedFile) Lock() error {
+	if f.inner == nil {
+		return nil
+	}
+	return f.inner.Lock()
+}
+
+func (f *encryptedFile) Unlock() error {
+	if f.inner == nil {
+		return nil
+	}
+	return f.inner.Unlock()
+}
+
+// Close 在内容有修改时把整个文件加密写回
+func (f *encryptedFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if f.inner == nil {
+		return nil
+	}
+	defer f.fs.release(f.name, f.buf)
+	err := f.flush()
+	if cerr := f.inner.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *encryptedFile) flush() error {
+	if !f.dirty {
+		return nil
+	}
+	f.buf.mu.Lock()
+	sealed, err := sealCacheFile(f.fs.aead, f.buf.data)
+	f.buf.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := f.inner.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.inner.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = f.inner.Write(sealed)
+	return err
+}
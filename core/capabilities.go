@@ -0,0 +1,111 @@
+package core
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RemoteCapabilities 描述远端服务器支持的协议能力，用于决定是否可以使用浅克隆、原子推送等特性
+type RemoteCapabilities struct {
+	Shallow     bool   `json:"shallow"`
+	Filter      bool   `json:"filter"`
+	Atomic      bool   `json:"atomic"`
+	PushOptions bool   `json:"pushOptions"`
+	DeleteRefs  bool   `json:"deleteRefs"`
+	SymrefHead  bool   `json:"symrefHead"`
+	Agent       string `json:"agent,omitempty"`
+	// ObjectFormat 是远端仓库的对象格式（sha1 或 sha256），服务端未通告时为 sha1
+	ObjectFormat string `json:"objectFormat"`
+	// PushProbed 为 false 表示无法探测推送端能力（例如只读密钥），此时推送相关能力一律视为不支持
+	PushProbed bool `json:"pushProbed"`
+}
+
+var (
+	capabilitiesMu sync.Mutex
+	capabilities   = map[string]*RemoteCapabilities{}
+)
+
+// ProbeRemote 通过引用协商探测远端支持的能力（不下载对象），结果会被缓存供后续操作降级使用
+func ProbeRemote(repoURL, sshKeyPEM string) (*RemoteCapabilities, error) {
+	p, err := getPoller(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.setKey(sshKeyPEM); err != nil {
+		return nil, err
+	}
+
+	caps := &RemoteCapabilities{}
+	if err := p.probe(transport.UploadPackServiceName, func(c *capability.List) {
+		caps.Shallow = c.Supports(capability.Shallow)
+		caps.Filter = c.Supports(capability.Filter)
+		caps.SymrefHead = c.Supports(capability.SymRef)
+		if agent := c.Get(capability.Agent); len(agent) > 0 {
+			caps.Agent = agent[0]
+		}
+		caps.ObjectFormat = ObjectFormatSHA1
+		if format := c.Get(capability.ObjectFormat); len(format) > 0 {
+			caps.ObjectFormat = format[0]
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if err := p.probe(transport.ReceivePackServiceName, func(c *capability.List) {
+		caps.Atomic = c.Supports(capability.Atomic)
+		caps.PushOptions = c.Supports(capability.PushOptions)
+		caps.DeleteRefs = c.Supports(capability.DeleteRefs)
+	}); err == nil {
+		caps.PushProbed = true
+	}
+
+	capabilitiesMu.Lock()
+	capabilities[repoURL] = caps
+	capabilitiesMu.Unlock()
+	return caps, nil
+}
+
+// ProbeRemoteJSON 同 ProbeRemote，以 JSON 返回
+func ProbeRemoteJSON(repoURL, sshKeyPEM string) (string, error) {
+	caps, err := ProbeRemote(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(caps)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// cachedCapabilities 返回已探测的能力，尚未探测时先探测一次；探测失败时返回 nil，调用方应按最保守的方式处理
+func cachedCapabilities(repoURL, sshKeyPEM string) *RemoteCapabilities {
+	capabilitiesMu.Lock()
+	caps, ok := capabilities[repoURL]
+	capabilitiesMu.Unlock()
+	if ok {
+		return caps
+	}
+	caps, err := ProbeRemote(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil
+	}
+	return caps
+}
+
+// probe 读取指定服务通告的能力，空仓库同样会通告能力
+func (p *poller) probe(service string, fn func(*capability.List)) error {
+	ar, err := p.advertisedRefs(service)
+	if err != nil {
+		return err
+	}
+	if ar != nil {
+		fn(ar.Capabilities)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// channelsDir 下每个频道一个目录：channel.json 保存频道信息，messages/ 的布局与仓库默认的消息目录相同。
+// 所有频道共用同一个分支，一次克隆即可读取全部频道
+const (
+	channelsDir     = "channels"
+	channelInfoFile = "channel.json"
+)
+
+var (
+	// ErrChannelNotFound 表示频道不存在
+	ErrChannelNotFound = errors.New("channel not found")
+	// ErrChannelExists 表示同名频道已存在
+	ErrChannelExists = errors.New("channel already exists")
+	// ErrChannelMoved 表示频道已迁移到其他仓库（见 MoveChannel），源仓库中的频道只读
+	ErrChannelMoved = errors.New("channel has been moved to another repository")
+)
+
+// Channel 是仓库中的一个独立会话
+type Channel struct {
+	// Name 是频道的目录名，只能包含小写字母、数字、"-"、"_" 和 "."
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	CreatedBy   string `json:"createdBy"`
+	// CreatedAt 是创建时间（Unix 毫秒）
+	CreatedAt int64 `json:"createdAt"`
+	// Retention 是频道的消息保留策略（见 SetRetentionPolicy），nil 表示不限制
+	Retention *ChannelRetention `json:"retention,omitempty"`
+	// MovedTo 指向频道迁移到的仓库，不为 nil 时频道只读
+	MovedTo *RotationPointer `json:"movedTo,omitempty"`
+}
+
+// CreateChannel 在仓库中创建频道 name 并提交推送，空仓库会先创建根提交
+func CreateChannel(repoURL, sshKeyPEM string, name, description string) (*Channel, error) {
+	if err := validateChannelName(name); err != nil {
+		return nil, err
+	}
+	ch := &Channel{
+		Name:        name,
+		Description: description,
+		CreatedBy:   identityFor(repoURL).Email,
+		CreatedAt:   commitTime().UnixMilli(),
+	}
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return nil, err
+	}
+	infoPath := path.Join(channelsDir, name, channelInfoFile)
+
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		files := NewFileBatch()
+		files.Put(infoPath, data)
+		if _, err := PushFiles(repoURL, sshKeyPEM, "create channel "+name, files); err != nil {
+			return nil, err
+		}
+		return ch, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readRefFile(h.repo, head.Hash(), infoPath); err == nil {
+		return nil, ErrChannelExists
+	} else if !errors.Is(err, object.ErrFileNotFound) {
+		return nil, err
+	}
+	if _, err := h.commitAndPush(head.Hash(), "create channel "+name, map[string][]byte{infoPath: data}); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// ListChannels 返回仓库中的全部频道（按名称排序）
+func ListChannels(repoURL, sshKeyPEM string) ([]Channel, error) {
+	result := []Channel{}
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return listChannels(h, head.Hash())
+}
+
+// listChannels 返回 commit 中的全部频道（按名称排序）
+func listChannels(h *history, commit plumbing.Hash) ([]Channel, error) {
+	result := []Channel{}
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	dir, err := tree.Tree(channelsDir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", channelsDir, err)
+	}
+
+	for _, e := range dir.Entries {
+		if e.Mode != filemode.Dir {
+			continue
+		}
+		ch, err := readChannel(h, commit, e.Name)
+		if errors.Is(err, ErrChannelNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *ch)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// ListChannelsJSON 同 ListChannels，以 JSON 数组返回
+func ListChannelsJSON(repoURL, sshKeyPEM string) (string, error) {
+	channels, err := ListChannels(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(channels)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SendChannelMessage 同 SendMessage，但消息写入频道 channel；频道不存在时返回 ErrChannelNotFound
+func SendChannelMessage(repoURL, sshKeyPEM string, channel string, sender, msgType, body string) (*Message, error) {
+	if err := validateChannelName(channel); err != nil {
+		return nil, err
+	}
+	return sendMessage(repoURL, sshKeyPEM, channel, "", sender, msgType, body)
+}
+
+// SendChannelMessageJSON 同 SendChannelMessage，以 JSON 返回写入的消息
+func SendChannelMessageJSON(repoURL, sshKeyPEM string, channel string, sender, msgType, body string) (string, error) {
+	msg, err := SendChannelMessage(repoURL, sshKeyPEM, channel, sender, msgType, body)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FetchChannelMessages 同 FetchMessages，但只读取频道 channel 的消息；频道不存在时返回 ErrChannelNotFound
+func FetchChannelMessages(repoURL, sshKeyPEM string, channel string, cursor string, max int) (*MessagePage, error) {
+	if err := validateChannelName(channel); err != nil {
+		return nil, err
+	}
+	return fetchMessages(repoURL, sshKeyPEM, channel, cursor, max)
+}
+
+// FetchChannelMessagesJSON 同 FetchChannelMessages，返回 MessagePage 的 JSON
+func FetchChannelMessagesJSON(repoURL, sshKeyPEM string, channel string, cursor string, max int) (string, error) {
+	page, err := FetchChannelMessages(repoURL, sshKeyPEM, channel, cursor, max)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// openChannel 克隆仓库并确认频道存在
+func openChannel(repoURL, sshKeyPEM string, channel string) (*history, *plumbing.Reference, error) {
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := readChannel(h, head.Hash(), channel); err != nil {
+		return nil, nil, err
+	}
+	return h, head, nil
+}
+
+// openWritableChannel 同 openChannel，频道已迁移时返回 ErrChannelMoved
+func openWritableChannel(repoURL, sshKeyPEM string, channel string) (*history, *plumbing.Reference, error) {
+	h, head, err := openChannel(repoURL, sshKeyPEM, channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := readChannel(h, head.Hash(), channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ch.writable(); err != nil {
+		return nil, nil, err
+	}
+	return h, head, nil
+}
+
+// writable 在频道已迁移时返回 ErrChannelMoved
+func (ch *Channel) writable() error {
+	if ch.MovedTo != nil {
+		return fmt.Errorf("%w: %s", ErrChannelMoved, ch.MovedTo.URL)
+	}
+	return nil
+}
+
+func readChannel(h *history, commit plumbing.Hash, name string) (*Channel, error) {
+	content, err := readRefFile(h.repo, commit, path.Join(channelsDir, name, channelInfoFile))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ch Channel
+	if err := json.Unmarshal(content, &ch); err != nil {
+		return nil, fmt.Errorf("parse channel %s: %w", name, err)
+	}
+	ch.Name = name
+	return &ch, nil
+}
+
+func channelMessagesDir(channel string) string {
+	return path.Join(channelsDir, channel, messagesDir)
+}
+
+// validateChannelName 检查频道名可以安全地用作目录名
+func validateChannelName(name string) error {
+	if name == "" || len(name) > 64 || name == "." || name == ".." {
+		return fmt.Errorf("invalid channel name %q", name)
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) >= 0 {
+		return fmt.Errorf("invalid channel name %q", name)
+	}
+	return nil
+}
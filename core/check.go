@@ -0,0 +1,197 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// CheckRemote 的检查步骤，按执行顺序排列
+const (
+	CheckStepDNS   = "dns"
+	CheckStepTCP   = "tcp"
+	CheckStepAuth  = "auth"
+	CheckStepRead  = "read"
+	CheckStepWrite = "write"
+)
+
+// checkTimeout 是 DNS 和 TCP 检查各自的超时时间
+const checkTimeout = 10 * time.Second
+
+// RemoteCheck 是 CheckRemote 的检查结果，某一步失败后其后的步骤不再执行，对应字段为 false
+type RemoteCheck struct {
+	DNS   bool `json:"dns"`
+	TCP   bool `json:"tcp"`
+	Auth  bool `json:"auth"`
+	Read  bool `json:"read"`
+	Write bool `json:"write"`
+	// EmptyRepo 表示远端是空仓库（可读但还没有任何分支）
+	EmptyRepo bool `json:"emptyRepo"`
+	// FailedStep 是失败的步骤（CheckStepDNS 等常量），全部通过时为空；Error 是其错误信息
+	FailedStep string `json:"failedStep,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// LeftoverRef 是写入检查创建后未能删除的临时 ref，需要手动清理
+	LeftoverRef string `json:"leftoverRef,omitempty"`
+	DurationMs  int64  `json:"durationMs"`
+}
+
+// CheckRemote 依次检查域名解析、TCP 连接、SSH 认证、读权限和写权限，供设置向导展示。
+// 写权限通过推送一个临时 ref（refs/mixgram/check-<随机串>，只含一个空提交）并立即删除来验证，不影响任何分支。
+// 检查结果本身通过返回值报告，只有参数无效时返回错误
+func CheckRemote(repoURL, sshKeyPEM string) (*RemoteCheck, error) {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	check := &RemoteCheck{}
+	defer func() { check.DurationMs = time.Since(start).Milliseconds() }()
+	fail := func(step string, err error) (*RemoteCheck, error) {
+		check.FailedStep = step
+		check.Error = err.Error()
+		return check, nil
+	}
+
+	if ep.Protocol == "file" {
+		check.DNS, check.TCP = true, true
+	} else {
+		// 设置了自定义 dialer（代理、隧道）时由 dialer 解析域名，本地解析失败不代表无法连接
+		if utils.SSHProxyOptions(repoURL).URL == "" {
+			if err := checkDNS(ep.Host); err != nil {
+				return fail(CheckStepDNS, err)
+			}
+		}
+		check.DNS = true
+		if err := checkTCP(ep); err != nil {
+			return fail(CheckStepTCP, err)
+		}
+		check.TCP = true
+	}
+
+	refs, err := ListRemoteRefs(repoURL, sshKeyPEM)
+	if err != nil {
+		if isAuthFailure(err) {
+			return fail(CheckStepAuth, err)
+		}
+		check.Auth = true
+		return fail(CheckStepRead, err)
+	}
+	check.Auth, check.Read = true, true
+	check.EmptyRepo = len(refs.Branches) == 0
+
+	ref, err := checkWrite(repoURL, auth)
+	check.LeftoverRef = ref
+	if err != nil {
+		return fail(CheckStepWrite, err)
+	}
+	check.Write = true
+	return check, nil
+}
+
+// CheckRemoteJSON 同 CheckRemote，以 JSON 返回 RemoteCheck
+func CheckRemoteJSON(repoURL, sshKeyPEM string) (string, error) {
+	check, err := CheckRemote(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(check)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func checkDNS(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	_, err := net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
+
+func checkTCP(ep *transport.Endpoint) error {
+	port := ep.Port
+	if port == 0 {
+		switch ep.Protocol {
+		case "ssh":
+			port = utils.DefaultSSHPort
+		case "http":
+			port = 80
+		default:
+			port = 443
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(ep.Host, strconv.Itoa(port))
+	var (
+		conn net.Conn
+		err  error
+	)
+	if ep.Protocol == "ssh" {
+		conn, err = utils.DialSSH(ctx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// isAuthFailure 判断错误是否是 SSH 认证失败
+func isAuthFailure(err error) bool {
+	if err == transport.ErrAuthenticationRequired || err == transport.ErrAuthorizationFailed {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unable to authenticate") || strings.Contains(msg, "handshake failed")
+}
+
+// checkWrite 推送一个指向空提交的临时 ref 再删除，返回删除失败时遗留的 ref 名称
+func checkWrite(repoURL string, auth transport.AuthMethod) (string, error) {
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return "", err
+	}
+	tree, err := editTree(repo.Storer, plumbing.ZeroHash, map[string][]byte{})
+	if err != nil {
+		return "", err
+	}
+	author, committer := identityFor(repoURL).signatures()
+	h := &history{repoURL: repoURL, auth: auth, repo: repo}
+	commit, err := h.storeCommit(&object.Commit{
+		Author:    author,
+		Committer: committer,
+		Message:   "access check",
+		TreeHash:  tree,
+	})
+	if err != nil {
+		return "", err
+	}
+	ref := plumbing.ReferenceName("refs/mixgram/check-" + utils.RandomHexString(12))
+	if err := pushRefs(repo, auth, false, ggconfig.RefSpec(fmt.Sprintf("%s:%s", commit, ref))); err != nil {
+		return "", err
+	}
+	if err := pushRefs(repo, auth, false, ggconfig.RefSpec(":"+ref)); err != nil {
+		return ref.String(), fmt.Errorf("delete %s: %w", ref, err)
+	}
+	return "", nil
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 生成 commit 时作者/提交者时间（Signature.When）的取值策略
+const (
+	// TimestampWallClock 使用设备的系统时间（默认）
+	TimestampWallClock = "wall"
+	// TimestampCorrected 使用系统时间加上 SetClockOffset 设置的偏移，用于校正设备时钟误差
+	TimestampCorrected = "corrected"
+	// TimestampMonotonic 在校正后的时间基础上保证本进程生成的时间严格递增（git 时间精度为秒，每次至少递增 1 秒）
+	TimestampMonotonic = "monotonic"
+	// TimestampProvided 由 SetClockProvider 设置的 ClockProvider 提供时间
+	TimestampProvided = "provided"
+)
+
+// ClockProvider 由调用方提供生成 commit 使用的时间
+type ClockProvider interface {
+	// NowMillis 返回 Unix 毫秒时间戳
+	NowMillis() int64
+}
+
+var (
+	clockMu       sync.Mutex
+	clockStrategy = TimestampWallClock
+	clockOffset   time.Duration
+	clockProvider ClockProvider
+	lastClock     time.Time
+)
+
+// SetTimestampStrategy 设置生成 commit 时使用的时间策略（TimestampWallClock 等常量）
+func SetTimestampStrategy(strategy string) error {
+	switch strategy {
+	case TimestampWallClock, TimestampCorrected, TimestampMonotonic, TimestampProvided:
+	default:
+		return fmt.Errorf("unknown timestamp strategy %q", strategy)
+	}
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	clockStrategy = strategy
+	return nil
+}
+
+// SetClockOffset 设置系统时间的校正量（毫秒），例如服务器时间减去本地时间；
+// 对 TimestampCorrected 和 TimestampMonotonic 生效
+func SetClockOffset(offsetMs int64) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	clockOffset = time.Duration(offsetMs) * time.Millisecond
+}
+
+// SetClockProvider 设置 TimestampProvided 策略使用的时间来源，传 nil 取消
+func SetClockProvider(p ClockProvider) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	clockProvider = p
+}
+
+// commitTime 按当前策略返回生成 commit 使用的时间
+func commitTime() time.Time {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+
+	switch clockStrategy {
+	case TimestampCorrected:
+		return time.Now().Add(clockOffset)
+	case TimestampMonotonic:
+		now := time.Now().Add(clockOffset).Truncate(time.Second)
+		if !now.After(lastClock) {
+			now = lastClock.Add(time.Second)
+		}
+		lastClock = now
+		return now
+	case TimestampProvided:
+		if clockProvider != nil {
+			return time.UnixMilli(clockProvider.NowMillis())
+		}
+	}
+	return time.Now()
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// 汇总周期
+const (
+	CompactDaily  = "day"
+	CompactWeekly = "week"
+)
+
+// rollupDir 是汇总 commit 中 changelog 文件所在的目录
+const rollupDir = ".mixgram/rollups"
+
+// CompactHistory 把最近 keepRecent 条之外的旧 commit 按周期（CompactDaily / CompactWeekly）
+// 合并为汇总 commit：汇总 commit 的内容为该周期最后一个 commit 的状态，
+// 并附带一个记录该周期内所有 commit 的 changelog 文件。只包含一个 commit 的周期保持不变。
+// 此操作会重写历史记录。
+func CompactHistory(repoURL, sshKeyPEM string, keepRecent int, period string) error {
+	return CompactHistoryWithOptions(repoURL, sshKeyPEM, keepRecent, period, nil)
+}
+
+// CompactHistoryWithOptions 同 CompactHistory，可通过 opts 控制重写细节
+func CompactHistoryWithOptions(repoURL, sshKeyPEM string, keepRecent int, period string, opts *RewriteOptions) error {
+	if period != CompactDaily && period != CompactWeekly {
+		return fmt.Errorf("unknown compaction period: %q", period)
+	}
+	if keepRecent < 0 {
+		keepRecent = 0
+	}
+
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	if len(h.commits) <= keepRecent {
+		fmt.Printf("commit 总数 %d <= %d，无需压缩\n", len(h.commits), keepRecent)
+		return nil
+	}
+
+	// 旧 commit (Root -> ...) 按周期切分为连续的分组
+	old := h.commits[keepRecent:]
+	var groups [][]*object.Commit
+	var lastLabel string
+	for i := len(old) - 1; i >= 0; i-- {
+		label := periodLabel(old[i].Author.When, period)
+		if len(groups) == 0 || label != lastLabel {
+			groups = append(groups, nil)
+			lastLabel = label
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], old[i])
+	}
+	if len(groups) == len(old) {
+		fmt.Println("没有可合并的周期，无需压缩")
+		return nil
+	}
+
+	id := identityFor(h.repoURL)
+	var currentParentHash plumbing.Hash
+	for _, group := range groups {
+		last := group[len(group)-1]
+		if len(group) == 1 {
+			currentParentHash, err = h.relink(currentParentHash, group, opts, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		label := periodLabel(last.Author.When, period)
+		treeHash, err := editTree(h.repo.Storer, last.TreeHash, map[string][]byte{
+			rollupDir + "/" + label + ".md": rollupChangelog(label, group),
+		})
+		if err != nil {
+			return fmt.Errorf("build rollup tree: %w", err)
+		}
+
+		parents := []plumbing.Hash{}
+		if !currentParentHash.IsZero() {
+			parents = []plumbing.Hash{currentParentHash}
+		}
+		currentParentHash, err = h.storeCommit(&object.Commit{
+			Author:       object.Signature{Name: id.Name, Email: id.Email, When: last.Author.When},
+			Committer:    id.committer(),
+			Message:      withProvenance(fmt.Sprintf("rollup %s: %d commits", label, len(group))),
+			TreeHash:     treeHash,
+			ParentHashes: parents,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 最近的 commit 原样接到汇总历史之后
+	currentParentHash, err = h.relink(currentParentHash, rootToHead(h.commits[:keepRecent]), opts, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := h.forcePush(currentParentHash); err != nil {
+		return err
+	}
+
+	fmt.Printf("成功压缩：%d 条旧 commit 合并为 %d 条\n", len(old), len(groups))
+	return nil
+}
+
+// periodLabel 返回时间所属周期的标签，如 2024-05-01 或 2024-W18（UTC）
+func periodLabel(t time.Time, period string) string {
+	t = t.UTC()
+	if period == CompactWeekly {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return t.Format("2006-01-02")
+}
+
+// rollupChangelog 生成汇总周期内各 commit 的摘要
+func rollupChangelog(label string, group []*object.Commit) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", label)
+	for _, c := range group {
+		subject, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+		fmt.Fprintf(&b, "- %s %s <%s> %s: %s\n",
+			c.Author.When.UTC().Format(time.RFC3339), c.Author.Name, c.Author.Email, c.Hash.String()[:7], subject)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"mixgram-core/internel/sshpool"
+	"mixgram-core/internel/utils"
+	"sync"
+	"time"
+
+	ggssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// connPool 是整个进程共用的连接池，而不是每个仓库一个：go-git 的克隆、推送和 upload-pack 会话
+// 都按 URL 的协议从全局的 client.InstallProtocol 注册表中查找 transport，无法为单个仓库指定 transport。
+// 连接按用户、主机和私钥指纹区分（见 sshpool.Identity），使用不同 deploy key 的仓库不会共用连接；
+// 同一私钥访问同一主机上的多个仓库时共用一个连接，每次操作各自新开 session，互不影响
+var (
+	connPoolMu sync.Mutex
+	connPool   *sshpool.Pool
+)
+
+// EnableConnectionPool 让后续的 SSH 操作复用到同一主机（相同用户和私钥）的连接，
+// 连接空闲 idleSeconds 秒后关闭（小于等于 0 时为 60 秒）。在高延迟网络上可省去每次操作的 TCP 和 SSH 握手。
+// 连接池对进程中的所有仓库生效（原因见 connPool）
+func EnableConnectionPool(idleSeconds int) {
+	if idleSeconds <= 0 {
+		idleSeconds = 60
+	}
+	connPoolMu.Lock()
+	defer connPoolMu.Unlock()
+	if connPool != nil {
+		connPool.CloseIdle()
+	}
+	connPool = sshpool.New(utils.DialSSH, time.Duration(idleSeconds)*time.Second)
+	utils.InstallProtocol("ssh", connPool)
+}
+
+// DisableConnectionPool 关闭连接池中的空闲连接，后续操作恢复为每次新建连接
+func DisableConnectionPool() {
+	connPoolMu.Lock()
+	defer connPoolMu.Unlock()
+	utils.InstallProtocol("ssh", ggssh.DefaultClient)
+	if connPool != nil {
+		connPool.CloseIdle()
+		connPool = nil
+	}
+}
+
+// CloseIdleConnections 立即关闭连接池中的空闲连接，例如 App 进入后台或网络切换时
+func CloseIdleConnections() {
+	connPoolMu.Lock()
+	defer connPoolMu.Unlock()
+	if connPool != nil {
+		connPool.CloseIdle()
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"sort"
+	"strings"
+
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// deviceBranchPrefix 是设备分支名的前缀，每台设备只推送自己的 device-<设备 ID> 分支
+const deviceBranchPrefix = "device-"
+
+// ReconcileResult 描述一次 Reconcile 的结果
+type ReconcileResult struct {
+	// MainBranch 是设备分支合并的目标分支，即远端默认分支
+	MainBranch string `json:"mainBranch"`
+	MainHead   string `json:"mainHead,omitempty"`
+	// Merged 是本次有新内容合并到主分支的设备分支
+	Merged []string `json:"merged"`
+	// Conflicts 是因文件冲突无法自动合并的设备分支及冲突的文件
+	Conflicts map[string][]string `json:"conflicts,omitempty"`
+	// Errors 是因其他原因合并失败的设备分支
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// EnableDeviceBranch 为该仓库开启设备分支模式：之后的读取和推送都使用 device-<deviceID> 分支（见 SetRepoBranch），
+// 各设备只以快进方式推送自己的分支，不会争用或强制推送共享分支。设备分支不存在时从远端默认分支创建。
+// 其他设备的内容需要通过 Reconcile 合并后才能看到
+func EnableDeviceBranch(repoURL, sshKeyPEM string, deviceID string) error {
+	branch, err := deviceBranchName(deviceID)
+	if err != nil {
+		return err
+	}
+	refs, err := ListRemoteRefs(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	if refs.DefaultBranch == "" {
+		return errors.New("remote has no default branch, push an initial commit first")
+	}
+	if !hasBranch(refs, branch) {
+		head := ""
+		for _, b := range refs.Branches {
+			if b.Name == refs.DefaultBranch {
+				head = b.Hash
+			}
+		}
+		if err := createBranch(repoURL, sshKeyPEM, branch, refs.DefaultBranch, head); err != nil {
+			return err
+		}
+	}
+	SetRepoBranch(repoURL, branch)
+	return nil
+}
+
+// DisableDeviceBranch 关闭设备分支模式，之后的操作重新使用远端默认分支；设备分支保留在远端
+func DisableDeviceBranch(repoURL string) {
+	if strings.HasPrefix(RepoBranch(repoURL), deviceBranchPrefix) {
+		SetRepoBranch(repoURL, "")
+	}
+}
+
+// Reconcile 把远端所有设备分支按 MergeRecursive 策略依次合并到远端默认分支。
+// 当前处于设备分支模式时，再把合并后的默认分支合并回本设备的分支，使本设备能看到其他设备的内容。
+// 单个设备分支冲突或失败不影响其他分支，结果中分别列出
+func Reconcile(repoURL, sshKeyPEM string) (*ReconcileResult, error) {
+	refs, err := ListRemoteRefs(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if refs.DefaultBranch == "" {
+		return nil, errors.New("remote has no default branch")
+	}
+	main := refs.DefaultBranch
+	result := &ReconcileResult{MainBranch: main, Merged: []string{}}
+
+	var devices []string
+	for _, b := range refs.Branches {
+		if strings.HasPrefix(b.Name, deviceBranchPrefix) {
+			devices = append(devices, b.Name)
+		}
+	}
+	sort.Strings(devices)
+	for _, branch := range devices {
+		merged, err := MergeBranch(repoURL, sshKeyPEM, branch, main, MergeRecursive)
+		var conflict *MergeConflictError
+		switch {
+		case errors.As(err, &conflict):
+			if result.Conflicts == nil {
+				result.Conflicts = map[string][]string{}
+			}
+			result.Conflicts[branch] = conflict.Paths
+		case err != nil:
+			if result.Errors == nil {
+				result.Errors = map[string]string{}
+			}
+			result.Errors[branch] = err.Error()
+		default:
+			result.MainHead = merged.CommitHash
+			if merged.Status != MergeStatusUpToDate {
+				result.Merged = append(result.Merged, branch)
+			}
+		}
+	}
+
+	if own := RepoBranch(repoURL); strings.HasPrefix(own, deviceBranchPrefix) && hasBranch(refs, own) {
+		if _, err := MergeBranch(repoURL, sshKeyPEM, main, own, MergeRecursive); err != nil {
+			return result, fmt.Errorf("update %s: %w", own, err)
+		}
+	}
+	return result, nil
+}
+
+// ReconcileJSON 同 Reconcile，以 JSON 返回 ReconcileResult
+func ReconcileJSON(repoURL, sshKeyPEM string) (string, error) {
+	result, err := Reconcile(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func deviceBranchName(deviceID string) (string, error) {
+	if deviceID == "" {
+		deviceID = DeviceID
+	}
+	if deviceID == "" || len(deviceID) > 64 || strings.IndexFunc(deviceID, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) >= 0 {
+		return "", fmt.Errorf("invalid device id %q", deviceID)
+	}
+	return deviceBranchPrefix + deviceID, nil
+}
+
+func hasBranch(refs *RemoteRefs, name string) bool {
+	for _, b := range refs.Branches {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// createBranch 在远端创建分支 branch，指向分支 from 当前的 commit head
+func createBranch(repoURL, sshKeyPEM string, branch, from, head string) error {
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return err
+	}
+	ref, err := fetchRef(repo, auth, plumbing.NewBranchReferenceName(from))
+	if err != nil {
+		return err
+	}
+	if ref == nil || ref.Hash().String() != head {
+		return fmt.Errorf("branch %s moved while creating %s, try again", from, branch)
+	}
+	return pushRefs(repo, auth, false, ggconfig.RefSpec(fmt.Sprintf("%s:%s", ref.Hash(), plumbing.NewBranchReferenceName(branch))))
+}
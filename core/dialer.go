@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"net"
+	"os"
+	"strconv"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/proxy"
+)
+
+// Dialer 由 App 建立到 SSH 服务器的 TCP 连接（例如通过 VpnService 保护的 socket 或隧道），
+// 返回已连接 socket 的文件描述符，其所有权转移给本库
+type Dialer interface {
+	Dial(host string, port int) (int, error)
+}
+
+// SetDialer 设置建立 SSH 连接使用的 Dialer，传 nil 恢复直连
+func SetDialer(d Dialer) {
+	if d == nil {
+		utils.SetSSHDialer(nil)
+		return
+	}
+	utils.SetSSHDialer(fdDialer{d})
+}
+
+// SetSOCKSProxy 让 SSH 连接经由 SOCKS5 代理（例如 Tor 的 127.0.0.1:9050 或可插拔传输的本地端口），
+// user 为空表示无需认证；address 为空恢复直连
+func SetSOCKSProxy(address, user, password string) error {
+	if address == "" {
+		utils.SetSSHDialer(nil)
+		return nil
+	}
+	var auth *proxy.Auth
+	if user != "" {
+		auth = &proxy.Auth{User: user, Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", address, auth, proxy.Direct)
+	if err != nil {
+		return fmt.Errorf("socks5 proxy: %w", err)
+	}
+	cd, ok := d.(utils.Dialer)
+	if !ok {
+		return errors.New("socks5 dialer does not support context")
+	}
+	utils.SetSSHDialer(cd)
+	return nil
+}
+
+// fdDialer 把 App 提供的文件描述符包装为 net.Conn
+type fdDialer struct {
+	d Dialer
+}
+
+func (f fdDialer) Dial(network, address string) (net.Conn, error) {
+	return f.DialContext(context.Background(), network, address)
+}
+
+func (f fdDialer) DialContext(ctx context.Context, _, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fd, err := f.d.Dial(host, port)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", address, err)
+	}
+	file := os.NewFile(uintptr(fd), address)
+	if file == nil {
+		return nil, fmt.Errorf("dial %s: invalid file descriptor %d", address, fd)
+	}
+	// FileConn 会复制描述符，原文件需要关闭
+	defer file.Close()
+	return net.FileConn(file)
+}
+
+// remoteProxy 返回访问 repo 的 origin 时使用的 ProxyOptions
+func remoteProxy(repo *git.Repository) transport.ProxyOptions {
+	return utils.SSHProxyOptions(originURL(repo))
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// 文件的变更类型
+const (
+	FileAdded    = "added"
+	FileDeleted  = "deleted"
+	FileModified = "modified"
+	FileRenamed  = "renamed"
+)
+
+// FileDiffStat 是单个文件的变更统计
+type FileDiffStat struct {
+	Path string `json:"path"`
+	// OldPath 仅在重命名时不为空
+	OldPath   string `json:"oldPath,omitempty"`
+	Status    string `json:"status"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	// Binary 为 true 时不统计行数（包括转存的大文件）
+	Binary bool `json:"binary"`
+}
+
+// DiffStat 是一个 commit 相对于其第一个父 commit（根提交相对于空树）的变更统计
+type DiffStat struct {
+	Hash           string         `json:"hash"`
+	Files          []FileDiffStat `json:"files"`
+	FilesChanged   int            `json:"filesChanged"`
+	TotalAdditions int            `json:"totalAdditions"`
+	TotalDeletions int            `json:"totalDeletions"`
+}
+
+// DiffStatJSON 返回 commit 的逐文件变更统计（DiffStat 的 JSON），用于 commit 详情页展示。
+// 大文件转存目录中的内部文件不会单独列出，其对应的文件显示为二进制变更
+func DiffStatJSON(repoURL, sshKeyPEM string, commitHash string) (string, error) {
+	h, _, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	stat, err := diffStat(h.repo.Storer, plumbing.NewHash(commitHash))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(stat)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func diffStat(s storer.EncodedObjectStorer, hash plumbing.Hash) (*DiffStat, error) {
+	c, err := object.GetCommit(s, hash)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %w", hash, err)
+	}
+	to, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	var from *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("parent: %w", err)
+		}
+		if from, err = parent.Tree(); err != nil {
+			return nil, fmt.Errorf("parent tree: %w", err)
+		}
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), from, to, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("diff tree: %w", err)
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, fmt.Errorf("patch: %w", err)
+	}
+
+	stat := &DiffStat{Hash: hash.String(), Files: []FileDiffStat{}}
+	for _, fp := range patch.FilePatches() {
+		oldFile, newFile := fp.Files()
+		fs := FileDiffStat{Binary: fp.IsBinary()}
+		switch {
+		case oldFile == nil:
+			fs.Path, fs.Status = newFile.Path(), FileAdded
+		case newFile == nil:
+			fs.Path, fs.Status = oldFile.Path(), FileDeleted
+		case oldFile.Path() != newFile.Path():
+			fs.Path, fs.OldPath, fs.Status = newFile.Path(), oldFile.Path(), FileRenamed
+		default:
+			fs.Path, fs.Status = newFile.Path(), FileModified
+		}
+		if strings.HasPrefix(fs.Path, overflowDir+"/") {
+			continue
+		}
+		if isOverflowStub(s, oldFile) || isOverflowStub(s, newFile) {
+			fs.Binary = true
+		}
+		if !fs.Binary {
+			fs.Additions, fs.Deletions = countLines(fp.Chunks())
+		}
+
+		stat.Files = append(stat.Files, fs)
+		stat.TotalAdditions += fs.Additions
+		stat.TotalDeletions += fs.Deletions
+	}
+	stat.FilesChanged = len(stat.Files)
+	return stat, nil
+}
+
+// isOverflowStub 判断文件内容是否是大文件转存的占位文件
+func isOverflowStub(s storer.EncodedObjectStorer, f fdiff.File) bool {
+	if f == nil {
+		return false
+	}
+	blob, err := object.GetBlob(s, f.Hash())
+	if err != nil || blob.Size > 4096 {
+		return false
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return false
+	}
+	_, ok := parseOverflowStub(content)
+	return ok
+}
+
+func countLines(chunks []fdiff.Chunk) (additions, deletions int) {
+	for _, chunk := range chunks {
+		content := chunk.Content()
+		lines := strings.Count(content, "\n")
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			lines++
+		}
+		switch chunk.Type() {
+		case fdiff.Add:
+			additions += lines
+		case fdiff.Delete:
+			deletions += lines
+		}
+	}
+	return additions, deletions
+}
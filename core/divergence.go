@@ -0,0 +1,96 @@
+package core
+
+import (
+	"encoding/json"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// DivergenceListener 在发现远端历史被外部改写（强制推送）时收到通知
+type DivergenceListener interface {
+	// OnDivergence 的参数为 DivergenceEvent 的 JSON
+	OnDivergence(eventJSON string)
+}
+
+// DivergenceEvent 描述一次远端历史分歧：上次看到的 head 已不在远端历史中
+type DivergenceEvent struct {
+	RepoURL string `json:"repoUrl"`
+	Branch  string `json:"branch"`
+	OldHead string `json:"oldHead"`
+	NewHead string `json:"newHead"`
+}
+
+var (
+	headsMu            sync.Mutex
+	lastSeenHeads      = map[string]string{}
+	divergenceListener DivergenceListener
+)
+
+// SetDivergenceListener 设置分歧通知的接收者，传 nil 取消
+func SetDivergenceListener(l DivergenceListener) {
+	headsMu.Lock()
+	defer headsMu.Unlock()
+	divergenceListener = l
+}
+
+// LastSeenHead 返回该仓库上次看到的远端 head，没有记录时返回空字符串
+func LastSeenHead(repoURL string) string {
+	headsMu.Lock()
+	defer headsMu.Unlock()
+	return lastSeenHeads[repoURL]
+}
+
+// SetLastSeenHead 设置该仓库上次看到的远端 head，供 App 重启后恢复持久化的状态
+func SetLastSeenHead(repoURL, hash string) {
+	headsMu.Lock()
+	defer headsMu.Unlock()
+	if hash == "" {
+		delete(lastSeenHeads, repoURL)
+		return
+	}
+	lastSeenHeads[repoURL] = hash
+}
+
+// observeHead 在取回远端数据后调用：若上次看到的 head 已不是当前 head 的祖先，
+// 则通知 DivergenceListener，然后记录新的 head
+func observeHead(repo *git.Repository, repoURL string, head *plumbing.Reference) {
+	headsMu.Lock()
+	old := lastSeenHeads[repoURL]
+	lastSeenHeads[repoURL] = head.Hash().String()
+	listener := divergenceListener
+	headsMu.Unlock()
+
+	if old == "" || old == head.Hash().String() || listener == nil {
+		return
+	}
+	if containsCommit(repo, head.Hash(), plumbing.NewHash(old)) {
+		return
+	}
+
+	data, err := json.Marshal(DivergenceEvent{
+		RepoURL: repoURL,
+		Branch:  head.Name().Short(),
+		OldHead: old,
+		NewHead: head.Hash().String(),
+	})
+	if err != nil {
+		return
+	}
+	listener.OnDivergence(string(data))
+}
+
+// containsCommit 判断 target 是否在 head 的历史中
+func containsCommit(repo *git.Repository, head, target plumbing.Hash) bool {
+	targetCommit, err := repo.CommitObject(target)
+	if err != nil {
+		return false
+	}
+	headCommit, err := repo.CommitObject(head)
+	if err != nil {
+		return false
+	}
+	ok, err := targetCommit.IsAncestor(headCommit)
+	return err == nil && ok
+}
@@ -0,0 +1,284 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// editsDir 下保存消息的编辑记录：<消息 ID>/<13 位毫秒时间戳>-<编辑 ID>.json。
+// 编辑只追加新文件，不修改原消息，也不需要强制推送；读取消息时使用最新一条编辑记录的内容
+const editsDir = "edits"
+
+// MessageEdit 是一条消息的一次编辑
+type MessageEdit struct {
+	ID        string `json:"id"`
+	MessageID string `json:"messageId"`
+	Editor    string `json:"editor"`
+	// Timestamp 是编辑时间（Unix 毫秒）
+	Timestamp int64  `json:"timestamp"`
+	Body      string `json:"body"`
+}
+
+// EditMessage 把消息 messageID 的内容改为 newBody，返回编辑后的消息；消息不存在时返回 ErrMessageNotFound。
+// hard 为 false 时追加一条编辑记录，原内容保留在编辑历史中（见 FetchMessageEdits）；
+// hard 为 true 时重写全部历史，把每个 commit 中的原消息替换为新内容并删除其编辑记录，然后强制推送。
+// 此操作会重写历史记录，远端禁止强制推送时只能使用软编辑
+func EditMessage(repoURL, sshKeyPEM string, messageID, newBody string, hard bool) (*Message, error) {
+	return editMessage(repoURL, sshKeyPEM, "", messageID, newBody, hard)
+}
+
+// EditMessageJSON 同 EditMessage，以 JSON 返回编辑后的消息
+func EditMessageJSON(repoURL, sshKeyPEM string, messageID, newBody string, hard bool) (string, error) {
+	return editMessageJSON(repoURL, sshKeyPEM, "", messageID, newBody, hard)
+}
+
+// EditChannelMessageJSON 同 EditMessageJSON，但消息在频道 channel 中
+func EditChannelMessageJSON(repoURL, sshKeyPEM string, channel, messageID, newBody string, hard bool) (string, error) {
+	if err := validateChannelName(channel); err != nil {
+		return "", err
+	}
+	return editMessageJSON(repoURL, sshKeyPEM, channel, messageID, newBody, hard)
+}
+
+// FetchMessageEdits 返回消息 messageID 的编辑记录，按时间从旧到新排列，硬编辑过的消息没有编辑记录
+func FetchMessageEdits(repoURL, sshKeyPEM string, messageID string) ([]MessageEdit, error) {
+	return fetchMessageEdits(repoURL, sshKeyPEM, "", messageID)
+}
+
+// FetchMessageEditsJSON 同 FetchMessageEdits，以 JSON 数组返回
+func FetchMessageEditsJSON(repoURL, sshKeyPEM string, messageID string) (string, error) {
+	edits, err := FetchMessageEdits(repoURL, sshKeyPEM, messageID)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(edits)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func editMessageJSON(repoURL, sshKeyPEM string, channel, messageID, newBody string, hard bool) (string, error) {
+	msg, err := editMessage(repoURL, sshKeyPEM, channel, messageID, newBody, hard)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func editMessage(repoURL, sshKeyPEM string, channel, messageID, newBody string, hard bool) (*Message, error) {
+	if hard {
+		return hardEditMessage(repoURL, sshKeyPEM, channel, messageID, newBody)
+	}
+	return softEditMessage(repoURL, sshKeyPEM, channel, messageID, newBody)
+}
+
+// softEditMessage 在最新的远端头上追加一条编辑记录
+func softEditMessage(repoURL, sshKeyPEM string, channel, messageID, newBody string) (*Message, error) {
+	h, head, err := openWritableStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg, _, err := findMessage(h, head.Hash(), messageStoreDir(channel), messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	edit := &MessageEdit{
+		ID:        utils.RandomHexString(16),
+		MessageID: msg.ID,
+		Editor:    identityFor(repoURL).Email,
+		Timestamp: commitTime().UnixMilli(),
+		Body:      newBody,
+	}
+	data, err := json.Marshal(edit)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{edit.path(channel): data}
+	if _, err := h.commitAndPush(head.Hash(), "edit message "+msg.ID, files); err != nil {
+		return nil, err
+	}
+	msg.apply(edit)
+	return msg, nil
+}
+
+// hardEditMessage 重写全部历史，把原消息文件替换为新内容并删除消息的编辑记录
+func hardEditMessage(repoURL, sshKeyPEM string, channel, messageID, newBody string) (*Message, error) {
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	head := h.commits[0].Hash
+	if channel != "" {
+		ch, err := readChannel(h, head, channel)
+		if err != nil {
+			return nil, err
+		}
+		if err := ch.writable(); err != nil {
+			return nil, err
+		}
+	}
+	msg, msgPath, err := findMessage(h, head, messageStoreDir(channel), messageID)
+	if err != nil {
+		return nil, err
+	}
+	msg.Body = newBody
+	msg.EditedAt = commitTime().UnixMilli()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := prepareFiles(repoURL, map[string][]byte{msgPath: data})
+	if err != nil {
+		return nil, err
+	}
+	changes[path.Join(editStoreDir(channel), msg.ID)] = nil
+
+	var editErr error
+	newHead, err := h.relink(plumbing.ZeroHash, rootToHead(h.commits), nil, func(old, c *object.Commit) bool {
+		if editErr != nil {
+			return false
+		}
+		tree, err := old.Tree()
+		if err != nil {
+			editErr = err
+			return false
+		}
+		if _, err := tree.FindEntry(msgPath); err != nil {
+			return false
+		}
+		treeHash, err := editTree(h.repo.Storer, old.TreeHash, changes)
+		if err != nil {
+			editErr = err
+			return false
+		}
+		c.TreeHash = treeHash
+		return true
+	})
+	if editErr != nil {
+		return nil, fmt.Errorf("edit message: %w", editErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func fetchMessageEdits(repoURL, sshKeyPEM string, channel, messageID string) ([]MessageEdit, error) {
+	h, head, err := openMessageStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := findMessage(h, head.Hash(), messageStoreDir(channel), messageID); err != nil {
+		return nil, err
+	}
+	paths, err := editPaths(h, head.Hash(), channel, messageID)
+	if err != nil {
+		return nil, err
+	}
+	edits := make([]MessageEdit, 0, len(paths))
+	for _, p := range paths {
+		edit, err := readEdit(h, head.Hash(), p)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, *edit)
+	}
+	return edits, nil
+}
+
+// apply 使用编辑记录的内容
+func (m *Message) apply(edit *MessageEdit) {
+	m.Body = edit.Body
+	m.EditedAt = edit.Timestamp
+}
+
+// applyLatestEdit 把消息 m 的内容替换为其最新一条编辑记录，没有编辑记录时保持不变
+func applyLatestEdit(h *history, commit plumbing.Hash, channel string, m *Message) error {
+	paths, err := editPaths(h, commit, channel, m.ID)
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	edit, err := readEdit(h, commit, paths[len(paths)-1])
+	if err != nil {
+		return err
+	}
+	m.apply(edit)
+	return nil
+}
+
+// path 返回编辑记录在频道 channel 中的文件路径
+func (e *MessageEdit) path(channel string) string {
+	return path.Join(editStoreDir(channel), e.MessageID, fmt.Sprintf("%013d-%s.json", e.Timestamp, e.ID))
+}
+
+// editPaths 返回消息 messageID 的编辑记录路径，按时间从旧到新排列
+func editPaths(h *history, commit plumbing.Hash, channel, messageID string) ([]string, error) {
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	dir := path.Join(editStoreDir(channel), messageID)
+	sub, err := tree.Tree(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dir, err)
+	}
+	var paths []string
+	for _, e := range sub.Entries {
+		if e.Mode.IsFile() && strings.HasSuffix(e.Name, ".json") {
+			paths = append(paths, path.Join(dir, e.Name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func readEdit(h *history, commit plumbing.Hash, editPath string) (*MessageEdit, error) {
+	content, err := readCommitFile(h.repoURL, h.repo, commit, editPath)
+	if err != nil {
+		return nil, err
+	}
+	var e MessageEdit
+	if err := json.Unmarshal(content, &e); err != nil {
+		return nil, fmt.Errorf("parse edit %s: %w", editPath, err)
+	}
+	return &e, nil
+}
+
+func editStoreDir(channel string) string {
+	if channel == "" {
+		return editsDir
+	}
+	return path.Join(channelsDir, channel, editsDir)
+}
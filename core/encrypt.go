@@ -0,0 +1,180 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// EncryptionKeySize 是仓库对称密钥的长度（AES-256）
+const EncryptionKeySize = 32
+
+// 加密文件的头部：magic + 模式字节
+var encryptedMagic = []byte("MGENC")
+
+const (
+	// encModeSymmetric 使用仓库的对称密钥加密
+	encModeSymmetric byte = 1
+)
+
+var (
+	// ErrNoEncryptionKey 表示仓库没有设置加密密钥
+	ErrNoEncryptionKey = errors.New("no encryption key for repository")
+	// ErrNotEncrypted 表示文件不是本库加密的内容
+	ErrNotEncrypted = errors.New("file is not encrypted")
+	// ErrDecryptFailed 表示密钥错误或密文被篡改
+	ErrDecryptFailed = errors.New("decrypt failed")
+)
+
+var (
+	encKeysMu sync.Mutex
+	encKeys   = map[string][]byte{}
+)
+
+// GenerateEncryptionKey 生成一个随机的仓库对称密钥
+func GenerateEncryptionKey() ([]byte, error) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SetRepoEncryptionKey 设置该仓库加密文件使用的对称密钥（32 字节），传 nil 清除。
+// 密钥只保存在内存中，需要持久化时使用 StoreRepoEncryptionKey
+func SetRepoEncryptionKey(repoURL string, key []byte) error {
+	encKeysMu.Lock()
+	defer encKeysMu.Unlock()
+	if key == nil {
+		delete(encKeys, repoURL)
+		return nil
+	}
+	if len(key) != EncryptionKeySize {
+		return fmt.Errorf("encryption key must be %d bytes", EncryptionKeySize)
+	}
+	encKeys[repoURL] = bytes.Clone(key)
+	return nil
+}
+
+// repoEncryptionKey 返回仓库的对称密钥，内存中没有时读取 StoreRepoEncryptionKey 保存的密钥
+func repoEncryptionKey(repoURL string) ([]byte, error) {
+	encKeysMu.Lock()
+	key, ok := encKeys[repoURL]
+	encKeysMu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := currentKeyStore().Get(repoKeyAlias("channel", repoURL))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoEncryptionKey, err)
+	}
+	if key == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	if err := SetRepoEncryptionKey(repoURL, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PushCommitEncrypted 同 PushFiles，但文件内容先用仓库的对称密钥以 AES-256-GCM 加密，托管方只能看到密文。
+// 路径和提交信息不加密；删除操作不受影响
+func PushCommitEncrypted(repoURL, sshKeyPEM string, commitMsg string, files *FileBatch) (string, error) {
+	if files.Len() == 0 {
+		return "", errors.New("no files to push")
+	}
+	key, err := repoEncryptionKey(repoURL)
+	if err != nil {
+		return "", err
+	}
+	encrypted := NewFileBatch()
+	for path, content := range files.files {
+		if content == nil {
+			encrypted.Delete(path)
+			continue
+		}
+		sealed, err := sealSymmetric(key, path, content)
+		if err != nil {
+			return "", err
+		}
+		encrypted.Put(path, sealed)
+	}
+	return PushFiles(repoURL, sshKeyPEM, commitMsg, encrypted)
+}
+
+// GetFileAtCommitDecrypted 同 GetFileAtCommit，并用仓库的对称密钥解密文件内容
+func GetFileAtCommitDecrypted(repoURL, sshKeyPEM string, commitHash, path string) ([]byte, error) {
+	key, err := repoEncryptionKey(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	content, err := GetFileAtCommit(repoURL, sshKeyPEM, commitHash, path)
+	if err != nil {
+		return nil, err
+	}
+	return openSymmetric(key, path, content)
+}
+
+// sealSymmetric 加密 content，格式为 magic | 模式 | nonce | 密文。
+// 路径作为附加数据参与认证，密文被移动到其他路径后无法解密
+func sealSymmetric(key []byte, path string, content []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := append(bytes.Clone(encryptedMagic), encModeSymmetric)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, content, encryptionAAD(header, path)), nil
+}
+
+func openSymmetric(key []byte, path string, data []byte) ([]byte, error) {
+	header, body, err := splitEncrypted(data)
+	if err != nil {
+		return nil, err
+	}
+	if header[len(header)-1] != encModeSymmetric {
+		return nil, fmt.Errorf("%w: unexpected encryption mode %d", ErrDecryptFailed, header[len(header)-1])
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	nonce, ciphertext := body[:aead.NonceSize()], body[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, encryptionAAD(header, path))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plain, nil
+}
+
+// splitEncrypted 拆出加密文件的头部（magic + 模式）和其余部分
+func splitEncrypted(data []byte) (header, body []byte, err error) {
+	n := len(encryptedMagic) + 1
+	if len(data) < n || !bytes.HasPrefix(data, encryptedMagic) {
+		return nil, nil, ErrNotEncrypted
+	}
+	return data[:n], data[n:], nil
+}
+
+func encryptionAAD(header []byte, path string) []byte {
+	return append(bytes.Clone(header), path...)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"sort"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// remoteGroup 是一个仓库的只读备用远端（例如镜像），读取时主仓库无法连接或被限流则依次改用备用远端
+type remoteGroup struct {
+	fallbacks []RemoteConfig
+	byLatency bool
+}
+
+var (
+	remoteGroupsMu sync.Mutex
+	remoteGroups   = map[string]*remoteGroup{}
+	// remoteLatency 记录每个远端最近的克隆耗时（平滑后），用于按延迟排序
+	remoteLatency = map[string]time.Duration{}
+)
+
+// SetRemoteGroup 为仓库 repoURL 设置读取时使用的备用远端，remotesJSON 是 RemoteConfig 的 JSON 数组，传空数组取消。
+// FetchCommits、FetchMessages 等读取操作在主仓库无法连接或被限流时按顺序改用备用远端；
+// byLatency 为 true 时按最近测得的克隆耗时从快到慢尝试（包括主仓库）。写入始终只使用主仓库
+func SetRemoteGroup(repoURL string, remotesJSON string, byLatency bool) error {
+	var fallbacks []RemoteConfig
+	if err := json.Unmarshal([]byte(remotesJSON), &fallbacks); err != nil {
+		return fmt.Errorf("parse remotes: %w", err)
+	}
+	for _, r := range fallbacks {
+		if r.URL == "" || r.URL == repoURL {
+			return fmt.Errorf("invalid fallback remote %q", r.URL)
+		}
+	}
+	remoteGroupsMu.Lock()
+	defer remoteGroupsMu.Unlock()
+	if len(fallbacks) == 0 {
+		delete(remoteGroups, repoURL)
+		return nil
+	}
+	remoteGroups[repoURL] = &remoteGroup{fallbacks: fallbacks, byLatency: byLatency}
+	return nil
+}
+
+// readCandidates 返回读取 repoURL 时依次尝试的远端，第一个未设置私钥的远端使用 sshKeyPEM
+func readCandidates(repoURL, sshKeyPEM string) []RemoteConfig {
+	remoteGroupsMu.Lock()
+	defer remoteGroupsMu.Unlock()
+	candidates := []RemoteConfig{{URL: repoURL, SSHKeyPEM: sshKeyPEM}}
+	group := remoteGroups[repoURL]
+	if group == nil {
+		return candidates
+	}
+	for _, r := range group.fallbacks {
+		if r.SSHKeyPEM == "" {
+			r.SSHKeyPEM = sshKeyPEM
+		}
+		candidates = append(candidates, r)
+	}
+	if group.byLatency {
+		// 没有测量过的远端排在最前，以便测得其延迟
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return remoteLatency[candidates[i].URL] < remoteLatency[candidates[j].URL]
+		})
+	}
+	return candidates
+}
+
+// readClone 按 readCandidates 的顺序克隆仓库 repoURL 用于读取，返回实际使用的远端 URL。
+// 只有无法连接或被限流时才换下一个远端，其他错误（例如认证失败、空仓库）直接返回
+func readClone(repoURL, sshKeyPEM string, depth int) (*git.Repository, string, error) {
+	branch := RepoBranch(repoURL)
+	return readRemotes(repoURL, sshKeyPEM, func(ctx context.Context, url string, auth transport.AuthMethod) (*git.Repository, error) {
+		return cloneToMemory(ctx, url, auth, branch, depth)
+	})
+}
+
+// readRemotes 按 readCandidates 的顺序用 clone 取回仓库 repoURL，切换远端的规则同 readClone
+func readRemotes(repoURL, sshKeyPEM string, clone func(ctx context.Context, url string, auth transport.AuthMethod) (*git.Repository, error)) (*git.Repository, string, error) {
+	var lastErr error
+	for _, remote := range readCandidates(repoURL, sshKeyPEM) {
+		auth, err := utils.NewSSHAuthForURL(remote.URL, remote.SSHKeyPEM)
+		if err != nil {
+			return nil, "", err
+		}
+		start := time.Now()
+		var repo *git.Repository
+		err = withRetry(remote.URL, func(ctx context.Context) (err error) {
+			repo, err = clone(ctx, remote.URL, auth)
+			return err
+		})
+		if err == nil {
+			recordLatency(remote.URL, time.Since(start))
+			return repo, remote.URL, nil
+		}
+		if !isOffline(err) && !isRateLimited(err) {
+			return nil, "", err
+		}
+		recordLatency(remote.URL, time.Hour)
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+// readBranch 同 openBranch，但按 readClone 在备用远端间切换。返回的 history 只用于读取
+func readBranch(repoURL, sshKeyPEM string) (*history, *plumbing.Reference, error) {
+	repo, served, err := readClone(repoURL, sshKeyPEM, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("clone repo: %w", err)
+	}
+	headRef, err := repo.Head()
+	if err != nil {
+		return nil, nil, fmt.Errorf("head: %w", err)
+	}
+	// 备用远端可能落后于主仓库，不记录为主仓库上次看到的 head
+	if served == repoURL {
+		observeHead(repo, repoURL, headRef)
+	}
+	if !headRef.Name().IsBranch() {
+		return nil, nil, fmt.Errorf("HEAD is not on a branch: %s", headRef.Name().String())
+	}
+	return &history{repoURL: repoURL, repo: repo, refName: headRef.Name()}, headRef, nil
+}
+
+// readMessageStore 同 openMessageStore，但按 readClone 在备用远端间切换
+func readMessageStore(repoURL, sshKeyPEM string, channel string) (*history, *plumbing.Reference, error) {
+	h, head, err := readBranch(repoURL, sshKeyPEM)
+	if channel == "" {
+		return h, head, err
+	}
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := readChannel(h, head.Hash(), channel); err != nil {
+		return nil, nil, err
+	}
+	return h, head, nil
+}
+
+// recordLatency 以指数平滑记录远端的克隆耗时
+func recordLatency(url string, d time.Duration) {
+	remoteGroupsMu.Lock()
+	defer remoteGroupsMu.Unlock()
+	if old, ok := remoteLatency[url]; ok {
+		d = (old*3 + d) / 4
+	}
+	remoteLatency[url] = d
+}
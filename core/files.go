@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// FileBatch 是一组待写入的文件修改。gomobile 无法直接传递 map，调用方通过它构造文件列表。
+type FileBatch struct {
+	files map[string][]byte
+}
+
+// NewFileBatch 创建一个空的 FileBatch
+func NewFileBatch() *FileBatch {
+	return &FileBatch{files: map[string][]byte{}}
+}
+
+// Put 写入（或覆盖）path 的内容
+func (b *FileBatch) Put(path string, content []byte) {
+	if content == nil {
+		content = []byte{} // nil 在 editTree 中表示删除
+	}
+	b.files[path] = content
+}
+
+// Delete 删除 path（文件或整个目录）
+func (b *FileBatch) Delete(path string) {
+	b.files[path] = nil
+}
+
+// Len 返回修改的数量
+func (b *FileBatch) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.files)
+}
+
+// PushFiles 在当前分支最新 commit 的基础上应用 files 中的修改，提交并推送（非强制推送），
+// 返回新 commit 的哈希。超过 InlineSizeLimit 的文件会自动转存为独立的 blob，原路径只保留引用。
+func PushFiles(repoURL, sshKeyPEM string, commitMsg string, files *FileBatch) (string, error) {
+	if files.Len() == 0 {
+		return "", errors.New("no files to push")
+	}
+	if err := validateFiles(repoURL, commitMsg, files.files); err != nil {
+		return "", err
+	}
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+		if err != nil {
+			return "", err
+		}
+		return initRemote(repoURL, auth, initBranch(repoURL), files.files, commitMsg)
+	}
+	if err != nil {
+		return "", err
+	}
+	newHead, err := h.commitAndPush(head.Hash(), commitMsg, files.files)
+	if err != nil {
+		return "", err
+	}
+	return newHead.String(), nil
+}
+
+// commitAndPush 在 parent 的基础上应用 files 中的修改（nil 表示删除），提交并以快进方式推送，返回新 commit。
+// files 是用户写入的内容，提交前经过 prepareFiles 转换。
+// 远端分支已有新提交时变基后重新推送（见 PushRebaseAttempts），修改的文件在远端也被修改过时返回 *PushConflictError
+func (h *history) commitAndPush(parent plumbing.Hash, commitMsg string, files map[string][]byte) (plumbing.Hash, error) {
+	files, err := prepareFiles(h.repoURL, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	newHead, err := h.commitFiles(parent, commitMsg, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := h.push(newHead, false); err != nil {
+		return h.rebaseAndPush(parent, commitMsg, files, false, err)
+	}
+	return newHead, nil
+}
+
+// prepareFiles 把文件修改转换为要提交的内容：用户内容先经过 PushHook，再转存超过 InlineSizeLimit 的文件；
+// 内部元数据（见 hookExempt）原样提交
+func prepareFiles(repoURL string, files map[string][]byte) (map[string][]byte, error) {
+	out := make(map[string][]byte, len(files))
+	user := make(map[string][]byte, len(files))
+	for path, content := range files {
+		if hookExempt(path) {
+			out[path] = content
+		} else {
+			user[path] = content
+		}
+	}
+	user, err := runPushHooks(repoURL, user)
+	if err != nil {
+		return nil, err
+	}
+	for path, content := range overflowFiles(user) {
+		out[path] = content
+	}
+	return out, nil
+}
+
+// commitFiles 在 parent 的基础上应用 files 中的修改并提交，只写入内存中的仓库，不推送。
+// files 是已转换好的内容（见 prepareFiles）
+func (h *history) commitFiles(parent plumbing.Hash, commitMsg string, files map[string][]byte) (plumbing.Hash, error) {
+	parentCommit, err := h.repo.CommitObject(parent)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("head commit: %w", err)
+	}
+
+	treeHash, err := editTree(h.repo.Storer, parentCommit.TreeHash, files)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("edit tree: %w", err)
+	}
+
+	author, committer := identityFor(h.repoURL).signatures()
+	return h.storeCommit(&object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      withProvenance(commitMsg),
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{parent},
+	})
+}
+
+// GetFileAtCommit 读取某个 commit（空字符串表示 HEAD）中 path 的内容，转存的大文件会被透明地还原，并经过 FetchHook
+func GetFileAtCommit(repoURL, sshKeyPEM string, commitHash, path string) ([]byte, error) {
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	target := head.Hash()
+	if commitHash != "" {
+		target = plumbing.NewHash(commitHash)
+	}
+	return readCommitFile(h.repoURL, h.repo, target, path)
+}
+
+// readCommitFile 读取 commit 中的用户内容：还原转存的大文件，再经过 FetchHook
+func readCommitFile(repoURL string, repo *git.Repository, commitHash plumbing.Hash, path string) ([]byte, error) {
+	content, err := readStoredFile(repo, commitHash, path)
+	if err != nil {
+		return nil, err
+	}
+	return runFetchHooks(repoURL, path, content)
+}
+
+// readStoredFile 读取 commit 中的文件并还原转存的大文件，不经过钩子
+func readStoredFile(repo *git.Repository, commitHash plumbing.Hash, path string) ([]byte, error) {
+	content, err := readRefFile(repo, commitHash, path)
+	if err != nil {
+		return nil, err
+	}
+	stub, ok := parseOverflowStub(content)
+	if !ok {
+		return content, nil
+	}
+	blob, err := readRefFile(repo, commitHash, stub.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("overflow blob for %s: %w", path, err)
+	}
+	if err := stub.verify(blob); err != nil {
+		return nil, fmt.Errorf("overflow blob for %s: %w", path, err)
+	}
+	return blob, nil
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,20 +14,66 @@ import (
 	git "github.com/go-git/go-git/v5"
 	ggconfig "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
-var (
-	UserName  = "MixGram"
-	UserEmail = "admin@mixgram.org"
+// 推送结果的状态
+const (
+	// PushStatusPushed 表示远端分支已更新
+	PushStatusPushed = "pushed"
+	// PushStatusCreated 表示远端原本是空仓库，本次推送创建了分支
+	PushStatusCreated = "created"
+	// PushStatusUpToDate 表示远端已是最新，没有更新任何 ref
+	PushStatusUpToDate = "up-to-date"
+	// PushStatusQueued 表示离线时提交已写入 outbox（见 SetOfflineQueue），尚未推送
+	PushStatusQueued = "queued"
 )
 
-// PushCommit 用 ssh 私钥字符串向远端仓库提交并推送一个 commit。
-func PushCommit(repoURL, sshKeyPEM string, commitMsg string) error {
+// PushResult 描述一次推送的结果
+type PushResult struct {
+	CommitHash string `json:"commitHash"`
+	Branch     string `json:"branch"`
+	Status     string `json:"status"`
+	// RemoteMessages 是远端在推送过程中返回的消息（"remote: ..."）
+	RemoteMessages string `json:"remoteMessages,omitempty"`
+	// ObjectCount / ObjectBytes 是本次发送的对象数量和未压缩的总大小
+	ObjectCount int   `json:"objectCount"`
+	ObjectBytes int64 `json:"objectBytes"`
+	DurationMs  int64 `json:"durationMs"`
+}
+
+// PushCommit 用 ssh 私钥字符串向远端仓库提交并推送一个 commit，返回新 commit 和推送的结果。
+// 远端分支已有新提交时会变基到新的远端头上重新推送（见 PushRebaseAttempts）。
+// 开启离线队列（见 SetOfflineQueue）时，无法连接远端的提交写入 outbox，Status 为 PushStatusQueued
+func PushCommit(repoURL, sshKeyPEM string, commitMsg string) (*PushResult, error) {
+	if err := validateCommit(repoURL, commitMsg); err != nil {
+		return nil, err
+	}
+	var result *PushResult
+	entry := &OutboxEntry{Kind: outboxKindCommit, RepoURL: repoURL, Message: commitMsg}
+	queued, err := sendOrQueue(repoURL, sshKeyPEM, entry, func() error {
+		var err error
+		result, err = pushCommit(repoURL, sshKeyPEM, commitMsg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if queued {
+		return &PushResult{Status: PushStatusQueued}, nil
+	}
+	return result, nil
+}
+
+func pushCommit(repoURL, sshKeyPEM string, commitMsg string) (*PushResult, error) {
+	start := time.Now()
+
 	// 1) 准备 auth
-	auth, err := utils.NewSSHAuth(sshKeyPEM)
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	files := map[string][]byte{
 		"README.MD": []byte(utils.RandomHexString(32)),
@@ -33,25 +81,40 @@ func PushCommit(repoURL, sshKeyPEM string, commitMsg string) error {
 
 	// 2) 克隆到内存 (完整克隆, depth=0)
 	// 修正：我们不再需要 clone 返回的 fs，用 _ 忽略
-	repo, _, err := utils.CloneToMemory(repoURL, auth)
+	repo, err := cloneRepo(repoURL, auth, 0)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		// 空仓库没有 HEAD，直接创建根提交
+		branch := initBranch(repoURL)
+		hash, err := initRemote(repoURL, auth, branch, files, commitMsg)
+		if err != nil {
+			return nil, err
+		}
+		return &PushResult{
+			CommitHash: hash,
+			Branch:     branch,
+			Status:     PushStatusCreated,
+			DurationMs: time.Since(start).Milliseconds(),
+		}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("clone repo: %w", err)
+		return nil, fmt.Errorf("clone repo: %w", err)
 	}
 
 	// 3) 工作区（worktree）
 	wt, err := repo.Worktree()
 	if err != nil {
-		return fmt.Errorf("worktree: %w", err)
+		return nil, fmt.Errorf("worktree: %w", err)
 	}
 
 	// 3.5) 获取当前分支引用
 	headRef, err := repo.Head()
 	if err != nil {
-		return fmt.Errorf("head: %w", err)
+		return nil, fmt.Errorf("head: %w", err)
 	}
+	observeHead(repo, repoURL, headRef)
 	refName := headRef.Name()
 	if !refName.IsBranch() {
-		return fmt.Errorf("HEAD is not on a branch: %s", refName.String())
+		return nil, fmt.Errorf("HEAD is not on a branch: %s", refName.String())
 	}
 
 	// 4) 写入/修改文件到内存 fs
@@ -60,63 +123,191 @@ func PushCommit(repoURL, sshKeyPEM string, commitMsg string) error {
 		f, err := wt.Filesystem.Create(path)
 		if err != nil {
 			// 如果父目录不存在，Create 会在需要时创建目录。若失败则返回。
-			return fmt.Errorf("create file %s: %w", path, err)
+			return nil, fmt.Errorf("create file %s: %w", path, err)
 		}
 		_, _ = f.Write(content)
 		_ = f.Close()
 		// git add
 		_, err = wt.Add(path)
 		if err != nil {
-			return fmt.Errorf("add %s: %w", path, err)
+			return nil, fmt.Errorf("add %s: %w", path, err)
 		}
 	}
 
 	// 5) commit
-	_, err = wt.Commit(commitMsg, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  UserName,
-			Email: UserEmail,
-			When:  time.Now(),
-		},
+	author, committer := identityFor(repoURL).signatures()
+	commitHash, err := wt.Commit(withProvenance(commitMsg), &git.CommitOptions{
+		Author:    &author,
+		Committer: &committer,
 	})
 	if err != nil {
-		return fmt.Errorf("commit: %w", err)
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	result := &PushResult{CommitHash: commitHash.String(), Branch: refName.Short(), Status: PushStatusPushed}
+	result.ObjectCount, result.ObjectBytes, err = pushedObjects(repo, commitHash, headRef.Hash())
+	if err != nil {
+		return nil, err
 	}
 
 	// 6) push to origin
+	var remoteMessages bytes.Buffer
 	pushOpts := &git.PushOptions{
 		Auth: auth,
 		RefSpecs: []ggconfig.RefSpec{
 			// 优化：明确推送当前分支，而不是 "refs/heads/*"
 			ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName)),
 		},
-		Progress: os.Stdout,
+		Progress:     io.MultiWriter(os.Stdout, &remoteMessages),
+		ProxyOptions: utils.SSHProxyOptions(repoURL),
+	}
+	unlock := shareRepo(repoURL)
+	err = withRetry(repoURL, func(ctx context.Context) error {
+		remoteMessages.Reset()
+		return repo.PushContext(ctx, pushOpts)
+	})
+	unlock()
+	if isPushConflict(err) {
+		// 其他设备先推送了：变基到新的远端头上重试。README.MD 只是随机标记，直接覆盖远端的内容
+		h := &history{repoURL: repoURL, auth: auth, repo: repo, refName: refName}
+		newHead, err := h.rebaseAndPush(headRef.Hash(), commitMsg, files, true, fmt.Errorf("push: %w", err))
+		if err != nil {
+			return nil, err
+		}
+		result.CommitHash = newHead.String()
+		result.RemoteMessages = h.remoteMessages
+		if c, err := repo.CommitObject(newHead); err == nil && c.NumParents() > 0 {
+			result.ObjectCount, result.ObjectBytes, _ = pushedObjects(repo, newHead, c.ParentHashes[0])
+		}
+		result.DurationMs = time.Since(start).Milliseconds()
+		return result, nil
+	}
+	if err != nil {
+		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return nil, fmt.Errorf("push: %w", err)
+		}
+		result.Status = PushStatusUpToDate
+		result.ObjectCount, result.ObjectBytes = 0, 0
+	} else {
+		SetLastSeenHead(repoURL, commitHash.String())
+		afterPush(repoURL)
+	}
+	result.RemoteMessages = remoteMessages.String()
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result, nil
+}
+
+// pushedObjects 统计 head 相对于其父提交 base 新增的对象数量和未压缩的总大小，即推送需要发送的对象：
+// head 本身，以及 head 的树中与 base 的树不同的子树和文件。只比较两棵树中变化的部分，不遍历 base 的历史
+func pushedObjects(repo *git.Repository, head, base plumbing.Hash) (int, int64, error) {
+	c, err := repo.CommitObject(head)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load commit %s: %w", head, err)
+	}
+	var baseTree plumbing.Hash
+	if !base.IsZero() {
+		parent, err := repo.CommitObject(base)
+		if err != nil {
+			return 0, 0, fmt.Errorf("load commit %s: %w", base, err)
+		}
+		baseTree = parent.TreeHash
+	}
+	hashes := []plumbing.Hash{head}
+	seen := map[plumbing.Hash]bool{}
+	if hashes, err = newTreeObjects(repo, c.TreeHash, baseTree, seen, hashes); err != nil {
+		return 0, 0, err
 	}
-	if err := repo.Push(pushOpts); err != nil {
-		if errors.Is(err, git.NoErrAlreadyUpToDate) {
-			return nil
+	var total int64
+	for _, h := range hashes {
+		size, err := repo.Storer.EncodedObjectSize(h)
+		if err != nil {
+			return 0, 0, fmt.Errorf("object size %s: %w", h, err)
 		}
-		return fmt.Errorf("push: %w", err)
+		total += size
 	}
+	return len(hashes), total, nil
+}
 
-	return nil
+// newTreeObjects 把树 tree 中与 old（零值表示空树）不同的对象追加到 hashes，内容相同的子树不再展开
+func newTreeObjects(repo *git.Repository, tree, old plumbing.Hash, seen map[plumbing.Hash]bool, hashes []plumbing.Hash) ([]plumbing.Hash, error) {
+	if tree == old || seen[tree] {
+		return hashes, nil
+	}
+	seen[tree] = true
+	hashes = append(hashes, tree)
+	t, err := object.GetTree(repo.Storer, tree)
+	if err != nil {
+		return nil, fmt.Errorf("get tree %s: %w", tree, err)
+	}
+	before := map[string]object.TreeEntry{}
+	if !old.IsZero() {
+		o, err := object.GetTree(repo.Storer, old)
+		if err != nil {
+			return nil, fmt.Errorf("get tree %s: %w", old, err)
+		}
+		for _, e := range o.Entries {
+			before[e.Name] = e
+		}
+	}
+	for _, e := range t.Entries {
+		prev, existed := before[e.Name]
+		if existed && prev.Hash == e.Hash {
+			continue
+		}
+		switch {
+		case e.Mode == filemode.Dir:
+			var prevTree plumbing.Hash
+			if existed && prev.Mode == filemode.Dir {
+				prevTree = prev.Hash
+			}
+			if hashes, err = newTreeObjects(repo, e.Hash, prevTree, seen, hashes); err != nil {
+				return nil, err
+			}
+		case e.Mode == filemode.Submodule:
+			// 子模块指向其他仓库的 commit，不随推送发送
+		case !seen[e.Hash]:
+			seen[e.Hash] = true
+			hashes = append(hashes, e.Hash)
+		}
+	}
+	return hashes, nil
 }
 
 // SimpleCommit 描述一个简化的 commit 信息
 type SimpleCommit struct {
-	Hash    string `json:"hash"`
-	Author  string `json:"author"`
-	Email   string `json:"email"`
-	Message string `json:"message"`
-	Date    int64  `json:"date"`
+	Hash       string `json:"hash"`
+	Author     string `json:"author"`
+	Email      string `json:"email"`
+	Message    string `json:"message"`
+	Date       int64  `json:"date"`
+	DeviceID   string `json:"deviceId,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+	// Profile 是 ProfileResolver 解析出的作者资料，未设置解析器时为空
+	Profile *AuthorProfile `json:"profile,omitempty"`
 }
 
+// CommitPage 是一页 commit 查询结果，JSON 接口都以它作为外层结构返回
+type CommitPage struct {
+	Items []SimpleCommit `json:"items"`
+	// Truncated 表示因 max 限制还有更早的 commit 未返回
+	Truncated bool `json:"truncated"`
+	// NextCursor 用于获取下一页，Truncated 为 false 时为空
+	NextCursor string `json:"nextCursor,omitempty"`
+	HeadHash   string `json:"headHash"`
+}
+
+// FetchCommitsJSON 返回最近 max 条 commit 的 CommitPage JSON
 func FetchCommitsJSON(repoURL, sshKeyPEM string, max int) (string, error) {
-	commits, err := FetchCommits(repoURL, sshKeyPEM, max)
+	return FetchCommitsPageJSON(repoURL, sshKeyPEM, "", max)
+}
+
+// FetchCommitsPageJSON 从 cursor 开始（空字符串表示从 HEAD 开始）返回 max 条 commit 的 CommitPage JSON
+func FetchCommitsPageJSON(repoURL, sshKeyPEM string, cursor string, max int) (string, error) {
+	page, err := FetchCommitsPage(repoURL, sshKeyPEM, cursor, max)
 	if err != nil {
 		return "", err
 	}
-	data, err := json.Marshal(commits)
+	data, err := json.Marshal(page)
 	if err != nil {
 		return "", err
 	}
@@ -125,402 +316,307 @@ func FetchCommitsJSON(repoURL, sshKeyPEM string, max int) (string, error) {
 
 // FetchCommits 克隆远端并列出最近的 N 条 commit（返回 commit 信息数组）
 func FetchCommits(repoURL, sshKeyPEM string, max int) ([]SimpleCommit, error) {
-	auth, err := utils.NewSSHAuth(sshKeyPEM)
+	page, err := FetchCommitsPage(repoURL, sshKeyPEM, "", max)
 	if err != nil {
 		return nil, err
 	}
+	return page.Items, nil
+}
 
-	// 修正：我们不需要 fs，所以用 _ 忽略
-	repo, _, err := utils.CloneToMemory(repoURL, auth)
+// FetchCommitsPage 从 cursor 开始（空字符串表示从 HEAD 开始）列出 max 条 commit，max <= 0 表示全部
+func FetchCommitsPage(repoURL, sshKeyPEM string, cursor string, max int) (*CommitPage, error) {
+	page := &CommitPage{Items: []SimpleCommit{}}
+	head, next, err := walkCommits(repoURL, sshKeyPEM, cursor, max, func(c SimpleCommit) error {
+		page.Items = append(page.Items, c)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	page.HeadHash = head
+	page.NextCursor = next
+	page.Truncated = next != ""
+	return page, nil
+}
+
+// walkCommits 克隆远端并从 cursor（空字符串表示 HEAD）开始依次把 N 条 commit 交给 fn（max <= 0 表示全部），
+// 返回远端 HEAD 以及下一页的起点（没有更多 commit 时为空）
+func walkCommits(repoURL, sshKeyPEM string, cursor string, max int, fn func(SimpleCommit) error) (head, next string, err error) {
+	// 只需要 commit 元数据，不下载文件内容；从 HEAD 开始时最多需要 max+1 条（多一条用于确定下一页的起点）。
+	// 主仓库不可用时改用备用远端（见 SetRemoteGroup）
+	depth := 0
+	if cursor == "" && max > 0 {
+		depth = max + 1
+	}
+	repo, served, err := readMetadata(repoURL, sshKeyPEM, depth)
+	if err != nil {
+		return "", "", err
+	}
 
 	// 获取 HEAD 引用
 	ref, err := repo.Head()
 	if err != nil {
-		return nil, fmt.Errorf("head: %w", err)
+		return "", "", fmt.Errorf("head: %w", err)
 	}
+	// 浅克隆中可能没有上次看到的 head，无法判断是否分叉
+	if shallow, _ := repo.Storer.Shallow(); served == repoURL && len(shallow) == 0 {
+		observeHead(repo, repoURL, ref)
+	}
+
+	from := ref.Hash()
+	if cursor != "" {
+		from = plumbing.NewHash(cursor)
+		if _, err := repo.CommitObject(from); err != nil {
+			return "", "", fmt.Errorf("cursor %s: %w", cursor, err)
+		}
+	}
+
+	if next, err = logCommits(repo, from, max, fn); err != nil {
+		return "", "", err
+	}
+	return ref.Hash().String(), next, nil
+}
 
-	cIter, err := repo.Log(&git.LogOptions{From: ref.Hash()})
+// logCommits 从 from 开始按时间倒序把最多 max 条 commit（0 表示不限）交给 fn，fn 返回 io.EOF 时提前结束；
+// 因 max 停止时返回下一页的起点
+func logCommits(repo *git.Repository, from plumbing.Hash, max int, fn func(SimpleCommit) error) (next string, err error) {
+	cIter, err := repo.Log(&git.LogOptions{From: from})
 	if err != nil {
-		return nil, fmt.Errorf("log: %w", err)
+		return "", fmt.Errorf("log: %w", err)
 	}
 	defer cIter.Close()
 
-	results := make([]SimpleCommit, 0, max)
 	count := 0
 	err = cIter.ForEach(func(c *object.Commit) error {
 		if max > 0 && count >= max {
+			next = c.Hash.String()
 			return io.EOF // 结束遍历
 		}
-		results = append(results, SimpleCommit{
-			Hash:    c.Hash.String(),
-			Author:  c.Author.Name,
-			Email:   c.Author.Email,
-			Message: c.Message,
-			Date:    c.Author.When.UnixMilli(),
-		})
 		count++
-		return nil
+		sc := toSimpleCommit(c)
+		sc.Profile = resolveProfile(sc.Email)
+		return fn(sc)
 	})
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("iterate log: %w", err)
+		return "", fmt.Errorf("iterate log: %w", err)
+	}
+	return next, nil
+}
+
+// toSimpleCommit 把 go-git 的 commit 转换为 SimpleCommit
+func toSimpleCommit(c *object.Commit) SimpleCommit {
+	message, deviceID, appVersion := parseProvenance(c.Message)
+	return SimpleCommit{
+		Hash:       c.Hash.String(),
+		Author:     c.Author.Name,
+		Email:      c.Author.Email,
+		Message:    message,
+		Date:       c.Author.When.UnixMilli(),
+		DeviceID:   deviceID,
+		AppVersion: appVersion,
 	}
-	return results, nil
 }
 
 // TrimOldCommits 重写远端仓库历史，只保留最近的 keep 条 commit
 func TrimOldCommits(repoURL, sshKeyPEM string, keep int) error {
-	auth, err := utils.NewSSHAuth(sshKeyPEM)
-	if err != nil {
-		return err
-	}
+	return TrimOldCommitsWithOptions(repoURL, sshKeyPEM, keep, nil)
+}
 
-	// 修正：我们不需要 fs，所以用 _ 忽略
-	repo, _, err := utils.CloneToMemory(repoURL, auth)
-	if err != nil {
-		return err
+// TrimOldCommitsWithOptions 同 TrimOldCommits，可通过 opts 控制重写细节
+func TrimOldCommitsWithOptions(repoURL, sshKeyPEM string, keep int, opts *RewriteOptions) error {
+	if keep < 1 {
+		return errors.New("keep must be at least 1")
 	}
 
-	headRef, err := repo.Head()
-	if err != nil {
-		return fmt.Errorf("head: %w", err)
+	defer lockRepo(repoURL)()
+	var h *history
+	var err error
+	if opts == nil || !opts.TrimSummary {
+		// 不需要摘要时只浅克隆保留窗口（多取一条用于判断是否有更早的历史），避免在大仓库上下载完整历史
+		if caps := cachedCapabilities(repoURL, sshKeyPEM); caps != nil && caps.Shallow {
+			h, err = loadRecentHistory(repoURL, sshKeyPEM, keep+1)
+		}
 	}
-	refName := headRef.Name()
-	if !refName.IsBranch() {
-		return fmt.Errorf("HEAD is not on a branch: %s", refName.String())
+	if h == nil && err == nil {
+		h, err = loadHistory(repoURL, sshKeyPEM)
 	}
-
-	iter, err := repo.Log(&git.LogOptions{From: headRef.Hash()})
 	if err != nil {
-		return fmt.Errorf("log: %w", err)
+		return err
 	}
-	defer iter.Close()
 
-	var commits []*object.Commit
-	_ = iter.ForEach(func(c *object.Commit) error {
-		commits = append(commits, c)
-		return nil
-	})
-
-	if len(commits) <= keep {
-		fmt.Printf("commit 总数 %d <= %d，无需裁剪\n", len(commits), keep)
+	if len(h.commits) <= keep {
+		fmt.Printf("commit 总数 %d <= %d，无需裁剪\n", len(h.commits), keep)
 		return nil
 	}
+	return h.trim(keep, opts)
+}
 
-	// -----------------------------------------------------------------
-	// 核心修改逻辑：重写历史
-	// -----------------------------------------------------------------
-	newRootAncestor := commits[keep-1]
-	tree, err := newRootAncestor.Tree()
+// trim 把最近 keep 条 commit 重写为独立的历史并强制推送
+func (h *history) trim(keep int, opts *RewriteOptions) error {
+	newHead, err := h.trimmedHead(keep, opts)
 	if err != nil {
-		return fmt.Errorf("get tree for new root: %w", err)
+		return err
 	}
-
-	storer := repo.Storer
-	newRootCommit := &object.Commit{
-		Author:       newRootAncestor.Author,
-		Committer:    object.Signature{Name: UserName, Email: UserEmail, When: time.Now()},
-		Message:      newRootAncestor.Message,
-		TreeHash:     tree.Hash,
-		ParentHashes: []plumbing.Hash{},
+	if err := h.forcePush(newHead); err != nil {
+		return err
 	}
 
-	obj := storer.NewEncodedObject()
-	if err := newRootCommit.Encode(obj); err != nil {
-		return fmt.Errorf("encode new root commit: %w", err)
-	}
-	newRootHash, err := storer.SetEncodedObject(obj)
-	if err != nil {
-		return fmt.Errorf("store new root commit: %w", err)
+	if h.shallow {
+		fmt.Printf("成功裁剪：保留最近 %d 条 commit\n", keep)
+		return nil
 	}
+	fmt.Printf("成功裁剪：保留最近 %d 条 commit，共删除 %d 条\n", keep, len(h.commits)-keep)
+	return nil
+}
 
-	currentParentHash := newRootHash
-
-	for i := keep - 2; i >= 0; i-- {
-		oldCommit := commits[i]
-		oldTree, err := oldCommit.Tree()
-		if err != nil {
-			return fmt.Errorf("get tree for commit %s: %w", oldCommit.Hash.String(), err)
-		}
-
-		newCommit := &object.Commit{
-			Author:       oldCommit.Author,
-			Committer:    object.Signature{Name: UserName, Email: UserEmail, When: time.Now()},
-			Message:      oldCommit.Message,
-			TreeHash:     oldTree.Hash,
-			ParentHashes: []plumbing.Hash{currentParentHash},
-		}
-
-		obj := storer.NewEncodedObject()
-		if err := newCommit.Encode(obj); err != nil {
-			return fmt.Errorf("encode rebased commit: %w", err)
+// trimmedHead 在内存中把最近 keep 条 commit 重写为独立的历史，返回新的 head，不修改分支
+func (h *history) trimmedHead(keep int, opts *RewriteOptions) (plumbing.Hash, error) {
+	// 最旧的保留 commit 成为新的根提交
+	var modify func(old, c *object.Commit) bool
+	var editErr error
+	if opts != nil && opts.TrimSummary {
+		summary := trimSummary(h, h.commits[keep:])
+		newRoot := h.commits[keep-1].Hash
+		modify = func(old, c *object.Commit) bool {
+			if old.Hash != newRoot {
+				return false
+			}
+			c.TreeHash, editErr = editTree(h.repo.Storer, old.TreeHash, map[string][]byte{trimSummaryPath: summary})
+			return editErr == nil
 		}
-		newCommitHash, err := storer.SetEncodedObject(obj)
-		if err != nil {
-			return fmt.Errorf("store rebased commit: %w", err)
-		}
-		currentParentHash = newCommitHash
 	}
-
-	finalHeadHash := currentParentHash
-	mainRef := plumbing.NewHashReference(refName, finalHeadHash)
-	if err := repo.Storer.SetReference(mainRef); err != nil {
-		return fmt.Errorf("set ref: %w", err)
+	newHead, err := h.relink(plumbing.ZeroHash, rootToHead(h.commits[:keep]), opts, modify)
+	if editErr != nil {
+		return plumbing.ZeroHash, fmt.Errorf("write trim summary: %w", editErr)
 	}
-
-	err = repo.Push(&git.PushOptions{
-		Auth:  auth,
-		Force: true,
-		RefSpecs: []ggconfig.RefSpec{
-			ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName)),
-		},
-		Progress: io.Discard,
-	})
 	if err != nil {
-		return fmt.Errorf("push: %w", err)
+		return plumbing.ZeroHash, err
 	}
-
-	fmt.Printf("成功裁剪：保留最近 %d 条 commit，共删除 %d 条\n", keep, len(commits)-keep)
-	return nil
+	return newHead, nil
 }
 
 // DeleteCommit 通过哈希值删除远端仓库历史中的一个 commit，并强制推送。
 // 此操作会重写历史记录。
 func DeleteCommit(repoURL, sshKeyPEM string, commitHash string) error {
-	auth, err := utils.NewSSHAuth(sshKeyPEM)
-	if err != nil {
-		return err
-	}
-
-	// 克隆到内存 (完整克隆, depth=0)
-	repo, _, err := utils.CloneToMemory(repoURL, auth)
-	if err != nil {
-		return fmt.Errorf("clone repo: %w", err)
-	}
+	return DeleteCommitRange(repoURL, sshKeyPEM, commitHash, commitHash)
+}
 
-	// 获取当前分支引用
-	headRef, err := repo.Head()
-	if err != nil {
-		return fmt.Errorf("head: %w", err)
-	}
-	refName := headRef.Name()
-	if !refName.IsBranch() {
-		return fmt.Errorf("HEAD is not on a branch: %s", refName.String())
-	}
+// DeleteCommitRange 删除远端仓库历史中 fromHash 到 toHash（闭区间）之间的所有 commit，
+// 把区间两侧的历史重新连接后强制推送。两个哈希的先后顺序不限。
+// 此操作会重写历史记录。
+func DeleteCommitRange(repoURL, sshKeyPEM string, fromHash, toHash string) error {
+	return DeleteCommitRangeWithOptions(repoURL, sshKeyPEM, fromHash, toHash, nil)
+}
 
-	// 遍历日志，收集所有 commit 并找到目标索引
-	iter, err := repo.Log(&git.LogOptions{From: headRef.Hash()})
+// DeleteCommitRangeWithOptions 同 DeleteCommitRange，可通过 opts 控制重写细节
+func DeleteCommitRangeWithOptions(repoURL, sshKeyPEM string, fromHash, toHash string, opts *RewriteOptions) error {
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
 	if err != nil {
-		return fmt.Errorf("log: %w", err)
+		return err
 	}
-	defer iter.Close()
 
-	var commits []*object.Commit // HEAD -> ... -> Root
-	var targetIndex = -1
-	targetHash := plumbing.NewHash(commitHash)
-
-	_ = iter.ForEach(func(c *object.Commit) error {
-		if c.Hash == targetHash {
-			targetIndex = len(commits)
-		}
-		commits = append(commits, c)
-		return nil
-	})
-
-	if targetIndex == -1 {
+	fromIndex := h.indexOf(plumbing.NewHash(fromHash))
+	toIndex := h.indexOf(plumbing.NewHash(toHash))
+	if fromIndex == -1 || toIndex == -1 {
 		return errors.New("commit not found in history")
 	}
-	if len(commits) == 1 {
-		return errors.New("cannot delete the only commit in the repository")
+	if fromIndex > toIndex {
+		fromIndex, toIndex = toIndex, fromIndex
 	}
-
-	// 准备新的 commit 列表 (Root -> ... -> New HEAD)，跳过被删除的目标
-	var newCommits []*object.Commit
-	for i := len(commits) - 1; i >= 0; i-- {
-		if i != targetIndex {
-			newCommits = append(newCommits, commits[i])
-		}
-	}
-
-	// 核心修改逻辑：重建历史链条
-	storer := repo.Storer
-	var currentParentHash plumbing.Hash
-
-	for i, oldCommit := range newCommits {
-		oldTree, err := oldCommit.Tree()
-		if err != nil {
-			return fmt.Errorf("get tree for commit %s: %w", oldCommit.Hash.String(), err)
-		}
-
-		parentHashes := []plumbing.Hash{}
-		if i > 0 { // 非根提交
-			parentHashes = []plumbing.Hash{currentParentHash}
-		}
-
-		// 创建新的 commit 对象 (保留原作者信息，用 MixGram 作为 Committer)
-		newCommit := &object.Commit{
-			Author:       oldCommit.Author,
-			Committer:    object.Signature{Name: UserName, Email: UserEmail, When: time.Now()}, // 使用新的 Committer 和时间
-			Message:      oldCommit.Message,
-			TreeHash:     oldTree.Hash,
-			ParentHashes: parentHashes,
-		}
-
-		obj := storer.NewEncodedObject()
-		if err := newCommit.Encode(obj); err != nil {
-			return fmt.Errorf("encode rebased commit: %w", err)
-		}
-		currentParentHash, err = storer.SetEncodedObject(obj)
-		if err != nil {
-			return fmt.Errorf("store rebased commit: %w", err)
-		}
+	if toIndex-fromIndex+1 == len(h.commits) {
+		return errors.New("cannot delete every commit in the repository")
 	}
 
-	// 设置新的引用
-	finalHeadHash := currentParentHash
-	mainRef := plumbing.NewHashReference(refName, finalHeadHash)
-	if err := repo.Storer.SetReference(mainRef); err != nil {
-		return fmt.Errorf("set ref: %w", err)
+	// 区间之前（更旧）的 commit 保持不变，区间之后的 commit 接到区间前一个 commit 上
+	var parent plumbing.Hash
+	if toIndex+1 < len(h.commits) {
+		parent = h.commits[toIndex+1].Hash
 	}
-
-	// 强制推送
-	err = repo.Push(&git.PushOptions{
-		Auth:  auth,
-		Force: true,
-		RefSpecs: []ggconfig.RefSpec{
-			ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName)),
-		},
-		Progress: io.Discard,
-	})
+	newHead, err := h.relink(parent, rootToHead(h.commits[:fromIndex]), opts, nil)
 	if err != nil {
-		return fmt.Errorf("push: %w", err)
+		return err
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return err
 	}
 
-	fmt.Printf("成功删除 commit %s，并重写历史\n", commitHash)
+	fmt.Printf("成功删除 %d 个 commit，并重写历史\n", toIndex-fromIndex+1)
 	return nil
 }
 
 // ModifyCommit 通过哈希值修改远端仓库历史中一个 commit 的提交信息，并强制推送。
 // 此操作会重写历史记录。
 func ModifyCommit(repoURL, sshKeyPEM string, commitHash string, newCommitMsg string) error {
-	auth, err := utils.NewSSHAuth(sshKeyPEM)
-	if err != nil {
-		return err
-	}
+	return ModifyCommitWithOptions(repoURL, sshKeyPEM, commitHash, newCommitMsg, nil)
+}
 
-	// 克隆到内存 (完整克隆, depth=0)
-	repo, _, err := utils.CloneToMemory(repoURL, auth)
-	if err != nil {
-		return fmt.Errorf("clone repo: %w", err)
-	}
+// ModifyCommitWithOptions 同 ModifyCommit，可通过 opts 控制重写细节
+func ModifyCommitWithOptions(repoURL, sshKeyPEM string, commitHash string, newCommitMsg string, opts *RewriteOptions) error {
+	return EditCommit(repoURL, sshKeyPEM, commitHash, &CommitEdit{Message: newCommitMsg}, opts)
+}
 
-	// 获取当前分支引用
-	headRef, err := repo.Head()
-	if err != nil {
-		return fmt.Errorf("head: %w", err)
-	}
-	refName := headRef.Name()
-	if !refName.IsBranch() {
-		return fmt.Errorf("HEAD is not on a branch: %s", refName.String())
+// CommitEdit 描述对一个历史 commit 的修改，零值字段表示保持不变
+type CommitEdit struct {
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	// AuthorDate 为新的作者时间（毫秒时间戳），0 表示保持不变
+	AuthorDate int64
+}
+
+// EditCommit 通过哈希值修改远端仓库历史中一个 commit 的提交信息和/或作者信息，并强制推送。
+// 此操作会重写历史记录。
+func EditCommit(repoURL, sshKeyPEM string, commitHash string, edit *CommitEdit, opts *RewriteOptions) error {
+	if edit == nil {
+		return errors.New("nothing to edit")
 	}
 
-	// 遍历日志，收集所有 commit
-	iter, err := repo.Log(&git.LogOptions{From: headRef.Hash()})
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
 	if err != nil {
-		return fmt.Errorf("log: %w", err)
+		return err
 	}
-	defer iter.Close()
 
-	var commits []*object.Commit // HEAD -> ... -> Root
 	targetHash := plumbing.NewHash(commitHash)
-	foundTarget := false
-
-	_ = iter.ForEach(func(c *object.Commit) error {
-		if c.Hash == targetHash {
-			foundTarget = true
-		}
-		commits = append(commits, c)
-		return nil
-	})
-
-	if !foundTarget {
+	targetIndex := h.indexOf(targetHash)
+	if targetIndex == -1 {
 		return errors.New("commit not found in history")
 	}
 
-	// 反转列表 (Root -> ... -> HEAD)
-	var rootToHead []*object.Commit
-	for i := len(commits) - 1; i >= 0; i-- {
-		rootToHead = append(rootToHead, commits[i])
+	// 目标之前（更旧）的 commit 不受影响，从目标开始重建历史链条
+	var parent plumbing.Hash
+	if targetIndex+1 < len(h.commits) {
+		parent = h.commits[targetIndex+1].Hash
 	}
-
-	// 核心修改逻辑：重建历史链条
-	storer := repo.Storer
-	var currentParentHash plumbing.Hash
-
-	for i, oldCommit := range rootToHead {
-		oldTree, err := oldCommit.Tree()
-		if err != nil {
-			return fmt.Errorf("get tree for commit %s: %w", oldCommit.Hash.String(), err)
+	newHead, err := h.relink(parent, rootToHead(h.commits[:targetIndex+1]), opts, func(old, c *object.Commit) bool {
+		if old.Hash != targetHash {
+			return false
 		}
-
-		var parentHashes []plumbing.Hash
-		if i > 0 { // 非根提交
-			parentHashes = []plumbing.Hash{currentParentHash}
+		// 注意：为了保持 git rebase 的惯例，未指定的作者信息 (Author) 保持不变，
+		// 但更新提交者信息 (Committer) 和时间。
+		if edit.Message != "" {
+			c.Message = withProvenance(edit.Message)
 		}
-
-		message := oldCommit.Message
-		author := oldCommit.Author
-		//when := oldCommit.Author.When
-
-		// 检查是否是目标 commit，如果是，则修改 message，更新 Committer 时间
-		if oldCommit.Hash == targetHash {
-			message = newCommitMsg
-			// 注意：为了保持 git rebase 的惯例，我们保留原作者信息 (Author)，
-			// 但更新提交者信息 (Committer) 和时间。
+		if edit.AuthorName != "" {
+			c.Author.Name = edit.AuthorName
 		}
-
-		// 创建新的 commit 对象
-		newCommit := &object.Commit{
-			Author:       author,
-			Committer:    object.Signature{Name: UserName, Email: UserEmail, When: time.Now()}, // 使用新的 Committer 和时间
-			Message:      message,
-			TreeHash:     oldTree.Hash,
-			ParentHashes: parentHashes,
+		if edit.AuthorEmail != "" {
+			c.Author.Email = edit.AuthorEmail
 		}
-
-		obj := storer.NewEncodedObject()
-		if err := newCommit.Encode(obj); err != nil {
-			return fmt.Errorf("encode rebased commit: %w", err)
+		if edit.AuthorDate != 0 {
+			c.Author.When = time.UnixMilli(edit.AuthorDate)
 		}
-		currentParentHash, err = storer.SetEncodedObject(obj)
-		if err != nil {
-			return fmt.Errorf("store rebased commit: %w", err)
-		}
-	}
-
-	// 设置新的引用
-	finalHeadHash := currentParentHash
-	mainRef := plumbing.NewHashReference(refName, finalHeadHash)
-	if err := repo.Storer.SetReference(mainRef); err != nil {
-		return fmt.Errorf("set ref: %w", err)
-	}
-
-	// 强制推送
-	err = repo.Push(&git.PushOptions{
-		Auth:  auth,
-		Force: true,
-		RefSpecs: []ggconfig.RefSpec{
-			ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName)),
-		},
-		Progress: io.Discard,
+		return true
 	})
 	if err != nil {
-		return fmt.Errorf("push: %w", err)
+		return err
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return err
 	}
 
-	fmt.Printf("成功修改 commit %s 的信息，并重写历史\n", commitHash)
+	fmt.Printf("成功修改 commit %s，并重写历史\n", commitHash)
 	return nil
 }
 
@@ -0,0 +1,292 @@
+package core
+
+import (
+	"fmt"
+	"mixgram-core/internel/utils"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RewriteOptions 控制历史重写的细节，传 nil 使用默认行为
+type RewriteOptions struct {
+	// PreserveCommitter 为 true 时，非目标 commit 保留原有的 committer 身份和时间，
+	// 父 commit 没有变化的 commit 保持原哈希不变；默认使用仓库的身份（见 SetRepoIdentity）和当前时间作为 committer
+	PreserveCommitter bool
+	// TrimSummary 仅用于 TrimOldCommits：在新的根提交中写入被裁剪历史的摘要文件
+	// （作者、时间范围、删除的 commit 数量），保留被删除历史的记录
+	TrimSummary bool
+}
+
+// history 是一次历史重写所需的上下文：内存中的仓库、当前分支以及分支上的全部 commit
+type history struct {
+	repoURL string
+	auth    transport.AuthMethod
+	repo    *git.Repository
+	refName plumbing.ReferenceName
+	commits []*object.Commit // HEAD -> ... -> Root
+	// rewritten 记录重写过程中旧 commit 到新 commit 的对应关系，用于迁移 notes
+	rewritten map[plumbing.Hash]plumbing.Hash
+	// retreed 记录本次重写有意修改了树的旧 commit，强制推送前的校验（见 verifyRewrite）不检查它们
+	retreed map[plumbing.Hash]bool
+	// shallow 为 true 时仓库是浅克隆，commits 只包含最近的一段历史
+	shallow bool
+	// remoteMessages 是最近一次 push 时远端返回的消息（"remote: ..."）
+	remoteMessages string
+}
+
+// loadHistory 完整克隆远端仓库到内存，并收集当前分支上的所有 commit
+func loadHistory(repoURL, sshKeyPEM string) (*history, error) {
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := h.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, fmt.Errorf("log: %w", err)
+	}
+	defer iter.Close()
+
+	if err := iter.ForEach(func(c *object.Commit) error {
+		h.commits = append(h.commits, c)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("iterate log: %w", err)
+	}
+	return h, nil
+}
+
+// loadRecentHistory 浅克隆远端仓库，只收集当前分支最近的 depth 条 commit（沿第一父提交）
+func loadRecentHistory(repoURL, sshKeyPEM string, depth int) (*history, error) {
+	h, head, err := cloneBranch(repoURL, sshKeyPEM, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := h.repo.CommitObject(head.Hash())
+	for err == nil && len(h.commits) < depth {
+		h.commits = append(h.commits, c)
+		if c.NumParents() == 0 {
+			break
+		}
+		c, err = c.Parent(0)
+	}
+	if err != nil && len(h.commits) < depth {
+		return nil, fmt.Errorf("walk shallow history: %w", err)
+	}
+	return h, nil
+}
+
+// openBranch 完整克隆远端仓库到内存并定位当前分支，不遍历历史（返回的 commits 为空）
+func openBranch(repoURL, sshKeyPEM string) (*history, *plumbing.Reference, error) {
+	return cloneBranch(repoURL, sshKeyPEM, 0)
+}
+
+// cloneBranch 克隆远端仓库到内存并定位当前分支，depth 为 0 表示完整克隆。
+// 浅克隆缺少较早的历史，无法判断远端是否被改写，因此不做分歧检测
+func cloneBranch(repoURL, sshKeyPEM string, depth int) (*history, *plumbing.Reference, error) {
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err := cloneRepo(repoURL, auth, depth)
+	if err != nil {
+		return nil, nil, fmt.Errorf("clone repo: %w", err)
+	}
+
+	headRef, err := repo.Head()
+	if err != nil {
+		return nil, nil, fmt.Errorf("head: %w", err)
+	}
+	if depth == 0 {
+		observeHead(repo, repoURL, headRef)
+	}
+	refName := headRef.Name()
+	if !refName.IsBranch() {
+		return nil, nil, fmt.Errorf("HEAD is not on a branch: %s", refName.String())
+	}
+
+	return &history{repoURL: repoURL, auth: auth, repo: repo, refName: refName, shallow: depth > 0}, headRef, nil
+}
+
+// indexOf 返回 hash 在 commits 中的索引，不存在时返回 -1
+func (h *history) indexOf(hash plumbing.Hash) int {
+	for i, c := range h.commits {
+		if c.Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// rootToHead 把 HEAD -> Root 顺序的 commit 列表反转为 Root -> HEAD 顺序
+func rootToHead(commits []*object.Commit) []*object.Commit {
+	result := make([]*object.Commit, 0, len(commits))
+	for i := len(commits) - 1; i >= 0; i-- {
+		result = append(result, commits[i])
+	}
+	return result
+}
+
+// relink 把 chain（Root -> HEAD 顺序）依次接到 parent 之后重建 commit 链条，返回新的 HEAD。
+// parent 为零值时 chain 的第一个 commit 成为根提交。
+// modify 可修改新 commit 的信息、作者或树，返回 true 表示该 commit 是本次重写的目标；
+// modify 为 nil 时所有 commit 都只更新父 commit。
+func (h *history) relink(parent plumbing.Hash, chain []*object.Commit, opts *RewriteOptions,
+	modify func(old, c *object.Commit) bool) (plumbing.Hash, error) {
+	preserve := opts != nil && opts.PreserveCommitter
+
+	for _, old := range chain {
+		parents := []plumbing.Hash{}
+		if !parent.IsZero() {
+			parents = []plumbing.Hash{parent}
+		}
+
+		c := &object.Commit{
+			Author:       old.Author,
+			Committer:    old.Committer,
+			Message:      old.Message,
+			TreeHash:     old.TreeHash,
+			ParentHashes: parents,
+		}
+		touched := modify != nil && modify(old, c)
+		if touched && c.TreeHash != old.TreeHash {
+			h.markRetreed(old.Hash)
+		}
+
+		if preserve && !touched && sameHashes(old.ParentHashes, parents) {
+			// 内容与父 commit 均未变化，直接沿用原 commit
+			parent = old.Hash
+			continue
+		}
+		if touched || !preserve {
+			c.Committer = identityFor(h.repoURL).committer()
+		}
+
+		hash, err := h.storeCommit(c)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("rewrite commit %s: %w", old.Hash.String(), err)
+		}
+		if hash != old.Hash {
+			h.markRewritten(old.Hash, hash)
+		}
+		parent = hash
+	}
+	return parent, nil
+}
+
+// markRewritten 记录 old 被重写为 rewritten
+func (h *history) markRewritten(old, rewritten plumbing.Hash) {
+	if h.rewritten == nil {
+		h.rewritten = map[plumbing.Hash]plumbing.Hash{}
+	}
+	h.rewritten[old] = rewritten
+}
+
+// markRetreed 记录 old 的树被有意修改
+func (h *history) markRetreed(old plumbing.Hash) {
+	if h.retreed == nil {
+		h.retreed = map[plumbing.Hash]bool{}
+	}
+	h.retreed[old] = true
+}
+
+func sameHashes(a, b []plumbing.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// storeCommit 编码并写入一个 commit 对象
+func (h *history) storeCommit(c *object.Commit) (plumbing.Hash, error) {
+	obj := h.repo.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("encode commit: %w", err)
+	}
+	hash, err := h.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store commit: %w", err)
+	}
+	return hash, nil
+}
+
+// forcePush 把当前分支指向 newHead 并强制推送到远端
+func (h *history) forcePush(newHead plumbing.Hash) error {
+	return h.push(newHead, true)
+}
+
+// push 把当前分支指向 newHead 并推送到远端，force 为 false 时远端只接受快进更新。
+// 重写过的 commit 上的 notes 会迁移到新 commit，并与分支在同一次推送中原子地更新。
+func (h *history) push(newHead plumbing.Hash, force bool) (err error) {
+	if !force {
+		// 强制推送的调用方已通过 lockRepo 独占仓库
+		defer shareRepo(h.repoURL)()
+	} else {
+		defer func(start time.Time) { observeRewrite(start, len(h.rewritten), err) }(time.Now())
+		if len(h.rewritten) > 0 {
+			if err := h.verifyRewrite(); err != nil {
+				return err
+			}
+		}
+		if err := h.runRewriteHooks(newHead); err != nil {
+			return err
+		}
+	}
+	var journalRefs []journalRef
+	if force {
+		if old, err := h.repo.Storer.Reference(h.refName); err == nil {
+			journalRefs = append(journalRefs, journalRef{Name: h.refName.String(), Old: old.Hash().String(), New: newHead.String()})
+		}
+	}
+	ref := plumbing.NewHashReference(h.refName, newHead)
+	if err := h.repo.Storer.SetReference(ref); err != nil {
+		return fmt.Errorf("set ref: %w", err)
+	}
+
+	spec := fmt.Sprintf("%s:%s", h.refName, h.refName)
+	if force {
+		spec = "+" + spec
+	}
+	specs := []ggconfig.RefSpec{ggconfig.RefSpec(spec)}
+	if len(h.rewritten) > 0 {
+		noteSpecs, err := h.remapNotes()
+		if err != nil {
+			return err
+		}
+		specs = append(specs, noteSpecs...)
+		if force {
+			journalRefs = append(journalRefs, h.notesJournalRefs(noteSpecs)...)
+		}
+	}
+
+	var entry *journalEntry
+	if force {
+		// 强制推送前写入预写日志，App 在推送途中被杀死时由 ResumePendingOperations 补完或放弃
+		var err error
+		if entry, err = beginRewritePush(h, journalRefs); err != nil {
+			return err
+		}
+	}
+	h.remoteMessages, err = pushRefsProgress(h.repo, h.auth, false, specs...)
+	entry.finish()
+	if err != nil {
+		return err
+	}
+	SetLastSeenHead(h.repoURL, newHead.String())
+	if !force {
+		afterPush(h.repoURL)
+	}
+	return nil
+}
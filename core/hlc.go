@@ -0,0 +1,172 @@
+package core
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// 消息的混合逻辑时钟（HLC）写在 Message.Clock 中，格式为 <13 位毫秒物理时间>.<5 位计数>.<节点 ID>，
+// 字符串的字典序即时钟的先后。每条新消息的时钟都大于本设备生成或看到过的全部时钟，
+// 因此即使设备的系统时间有偏差，回复也总是排在被回复的消息之后，且各设备得到相同的全序
+
+// clockObserveWindow 是发送消息前读取的最新消息条数，其中最大的时钟会推进本地时钟
+const clockObserveWindow = 32
+
+// maxClockCounter 是同一物理时间内的最大计数，超过后物理时间进 1 毫秒
+const maxClockCounter = 99999
+
+var (
+	hlcMu       sync.Mutex
+	hlcPhysical int64
+	hlcCounter  int64
+	hlcNode     string
+)
+
+// hybridClock 是解析后的时钟
+type hybridClock struct {
+	physical int64
+	counter  int64
+	node     string
+}
+
+// SortMessages 把消息按时钟排成各设备一致的全序（从旧到新）；没有时钟的旧消息以 Timestamp 作为物理时间，
+// 时钟相同时按 ID 排序
+func SortMessages(msgs []Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return compareMessages(&msgs[i], &msgs[j]) < 0
+	})
+}
+
+// SortMessagesJSON 同 SortMessages，参数和返回值都是消息的 JSON 数组
+func SortMessagesJSON(messagesJSON string) (string, error) {
+	var msgs []Message
+	if err := json.Unmarshal([]byte(messagesJSON), &msgs); err != nil {
+		return "", fmt.Errorf("parse messages: %w", err)
+	}
+	SortMessages(msgs)
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ObserveMessageClock 用其他来源（例如推送通知）收到的消息时钟推进本地时钟，格式错误的时钟会被忽略
+func ObserveMessageClock(clock string) {
+	if c, ok := parseClock(clock); ok {
+		observeClock(c)
+	}
+}
+
+// compareMessages 按时钟、ID 比较两条消息
+func compareMessages(a, b *Message) int {
+	if c := a.clock().compare(b.clock()); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// clock 返回消息的时钟，没有或无法解析时以 Timestamp 代替
+func (m *Message) clock() hybridClock {
+	if c, ok := parseClock(m.Clock); ok {
+		return c
+	}
+	return hybridClock{physical: m.Timestamp}
+}
+
+func (c hybridClock) compare(o hybridClock) int {
+	switch {
+	case c.physical != o.physical:
+		return cmp.Compare(c.physical, o.physical)
+	case c.counter != o.counter:
+		return cmp.Compare(c.counter, o.counter)
+	}
+	return strings.Compare(c.node, o.node)
+}
+
+func (c hybridClock) String() string {
+	return fmt.Sprintf("%013d.%05d.%s", c.physical, c.counter, c.node)
+}
+
+func parseClock(s string) (hybridClock, bool) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) != 3 {
+		return hybridClock{}, false
+	}
+	physical, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return hybridClock{}, false
+	}
+	counter, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return hybridClock{}, false
+	}
+	return hybridClock{physical: physical, counter: counter, node: parts[2]}, true
+}
+
+// tickClock 返回一个大于本地已生成和已看到的全部时钟的新时钟
+func tickClock() hybridClock {
+	now := commitTime().UnixMilli()
+	hlcMu.Lock()
+	defer hlcMu.Unlock()
+	if now > hlcPhysical {
+		hlcPhysical, hlcCounter = now, 0
+	} else if hlcCounter++; hlcCounter > maxClockCounter {
+		hlcPhysical, hlcCounter = hlcPhysical+1, 0
+	}
+	return hybridClock{physical: hlcPhysical, counter: hlcCounter, node: clockNode()}
+}
+
+// observeClock 让本地时钟不小于 c
+func observeClock(c hybridClock) {
+	hlcMu.Lock()
+	defer hlcMu.Unlock()
+	if c.physical > hlcPhysical || c.physical == hlcPhysical && c.counter > hlcCounter {
+		hlcPhysical, hlcCounter = c.physical, c.counter
+	}
+}
+
+// clockNode 返回时钟中的节点 ID：设置了 DeviceID 时使用 DeviceID，否则为本进程随机生成的 ID
+func clockNode() string {
+	if DeviceID != "" {
+		return DeviceID
+	}
+	if hlcNode == "" {
+		hlcNode = utils.RandomHexString(8)
+	}
+	return hlcNode
+}
+
+// stampClock 读取消息目录中最新的若干条消息推进本地时钟；msg 的时钟不大于其中最大的时钟或 floor 时重新分配
+func stampClock(h *history, commit plumbing.Hash, dir string, msg *Message, floor hybridClock) error {
+	paths, err := messagePaths(h, commit, dir)
+	if err != nil {
+		return err
+	}
+	if len(paths) > clockObserveWindow {
+		paths = paths[:clockObserveWindow]
+	}
+	latest := floor
+	for _, p := range paths {
+		m, err := readMessage(h, commit, p)
+		if err != nil {
+			return err
+		}
+		if c := m.clock(); c.compare(latest) > 0 {
+			latest = c
+		}
+	}
+	observeClock(latest)
+	if c, ok := parseClock(msg.Clock); !ok || c.compare(latest) <= 0 {
+		msg.Clock = tickClock().String()
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// 钩子的阶段
+const (
+	HookBeforePush    = "before-push"
+	HookAfterFetch    = "after-fetch"
+	HookBeforeRewrite = "before-rewrite"
+)
+
+// ErrHookRejected 表示钩子拒绝了本次操作，仓库和远端都没有被修改；
+// 具体原因使用 errors.As 取出 *HookError
+var ErrHookRejected = errors.New("operation rejected by hook")
+
+// HookError 是钩子返回错误时的错误，errors.Is(err, ErrHookRejected) 为 true
+type HookError struct {
+	Stage string `json:"stage"`
+	Hook  string `json:"hook"`
+	// Path 是正在处理的文件，BeforeRewrite 为空
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *HookError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s hook %s on %s: %s", ErrHookRejected, e.Stage, e.Hook, e.Path, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s hook %s: %s", ErrHookRejected, e.Stage, e.Hook, e.Reason)
+}
+
+func (e *HookError) Is(target error) bool {
+	return target == ErrHookRejected
+}
+
+// PushHook 在提交之前转换用户写入的文件内容（PushFiles、消息、编辑记录等），
+// 本库内部的元数据（见 hookExempt）不经过钩子
+type PushHook interface {
+	// BeforePush 对每个写入的文件调用（删除不调用），返回要写入仓库的内容；返回错误取消整个推送
+	BeforePush(repoURL, path string, content []byte) ([]byte, error)
+}
+
+// FetchHook 在读出用户内容之后转换文件内容，与 PushHook 对应
+type FetchHook interface {
+	// AfterFetch 的 content 已还原转存的大文件，返回交给调用方的内容；返回错误则读取失败
+	AfterFetch(repoURL, path string, content []byte) ([]byte, error)
+}
+
+// RewriteHook 在重写后的历史强制推送之前调用，可以否决这次重写
+type RewriteHook interface {
+	// BeforeRewrite 的参数为 RewriteEvent 的 JSON，返回错误取消推送，远端保持不变
+	BeforeRewrite(eventJSON string) error
+}
+
+// RewriteEvent 描述一次即将强制推送的历史重写
+type RewriteEvent struct {
+	RepoURL string `json:"repoUrl"`
+	Ref     string `json:"ref"`
+	// OldHead 是重写前的分支头，NewHead 是要强制推送的新分支头
+	OldHead string `json:"oldHead"`
+	NewHead string `json:"newHead"`
+	// Rewritten 是被重写的 commit 数量
+	Rewritten int `json:"rewritten"`
+}
+
+// registry 是按添加顺序排列的具名扩展，同名的会被替换
+type registry[T any] struct {
+	mu    sync.RWMutex
+	names []string
+	items []T
+}
+
+func (r *registry[T]) add(name string, item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, n := range r.names {
+		if n == name {
+			r.items[i] = item
+			return
+		}
+	}
+	r.names = append(r.names, name)
+	r.items = append(r.items, item)
+}
+
+func (r *registry[T]) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, n := range r.names {
+		if n == name {
+			r.names = append(r.names[:i], r.names[i+1:]...)
+			r.items = append(r.items[:i], r.items[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *registry[T]) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names, r.items = nil, nil
+}
+
+// snapshot 返回当前的名称和扩展，调用扩展时不持有锁
+func (r *registry[T]) snapshot() ([]string, []T) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.names...), append([]T(nil), r.items...)
+}
+
+var (
+	pushHooks    registry[PushHook]
+	fetchHooks   registry[FetchHook]
+	rewriteHooks registry[RewriteHook]
+)
+
+// AddPushHook 添加名为 name 的 PushHook。多个钩子按添加的顺序依次转换内容
+func AddPushHook(name string, h PushHook) error {
+	if err := checkExtension(name, h == nil); err != nil {
+		return err
+	}
+	pushHooks.add(name, h)
+	return nil
+}
+
+// AddFetchHook 添加名为 name 的 FetchHook。多个钩子按添加的逆序依次转换内容，
+// 与同样顺序添加的 PushHook 对应（例如先压缩再加密的文件先解密再解压）
+func AddFetchHook(name string, h FetchHook) error {
+	if err := checkExtension(name, h == nil); err != nil {
+		return err
+	}
+	fetchHooks.add(name, h)
+	return nil
+}
+
+// AddRewriteHook 添加名为 name 的 RewriteHook，按添加的顺序调用，任一钩子返回错误即取消
+func AddRewriteHook(name string, h RewriteHook) error {
+	if err := checkExtension(name, h == nil); err != nil {
+		return err
+	}
+	rewriteHooks.add(name, h)
+	return nil
+}
+
+// RemoveHook 移除全部阶段中名为 name 的钩子
+func RemoveHook(name string) {
+	pushHooks.remove(name)
+	fetchHooks.remove(name)
+	rewriteHooks.remove(name)
+}
+
+// ClearHooks 移除全部钩子
+func ClearHooks() {
+	pushHooks.clear()
+	fetchHooks.clear()
+	rewriteHooks.clear()
+}
+
+func checkExtension(name string, isNil bool) error {
+	if name == "" {
+		return errors.New("name is empty")
+	}
+	if isNil {
+		return errors.New("hook is nil")
+	}
+	return nil
+}
+
+// hookExempt 判断 path 是否是本库内部的元数据：.mixgram/ 下的成员、密钥、转存等记录，频道的 channel.json，
+// 话题索引，以及按内容哈希寻址的附件。这些文件由本库直接读取，钩子只作用于其余的用户内容
+func hookExempt(p string) bool {
+	if strings.HasPrefix(p, ".mixgram/") || strings.HasPrefix(p, attachmentsDir+"/") || strings.HasPrefix(p, threadsDir+"/") {
+		return true
+	}
+	parts := strings.Split(p, "/")
+	if len(parts) < 3 || parts[0] != channelsDir {
+		return false
+	}
+	return (len(parts) == 3 && parts[2] == channelInfoFile) || parts[2] == threadsDir
+}
+
+// runPushHooks 返回经过 PushHook 转换后的文件修改，不修改 files；没有钩子时直接返回 files
+func runPushHooks(repoURL string, files map[string][]byte) (map[string][]byte, error) {
+	names, hooks := pushHooks.snapshot()
+	if len(hooks) == 0 {
+		return files, nil
+	}
+	out := make(map[string][]byte, len(files))
+	for path, content := range files {
+		if content == nil {
+			out[path] = nil
+			continue
+		}
+		for i, hook := range hooks {
+			var err error
+			if content, err = hook.BeforePush(repoURL, path, content); err != nil {
+				return nil, &HookError{Stage: HookBeforePush, Hook: names[i], Path: path, Reason: err.Error()}
+			}
+			if content == nil {
+				content = []byte{} // nil 在 editTree 中表示删除
+			}
+		}
+		out[path] = content
+	}
+	return out, nil
+}
+
+// runFetchHooks 按添加的逆序用 FetchHook 转换读出的文件内容
+func runFetchHooks(repoURL, path string, content []byte) ([]byte, error) {
+	if hookExempt(path) {
+		return content, nil
+	}
+	names, hooks := fetchHooks.snapshot()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		var err error
+		if content, err = hooks[i].AfterFetch(repoURL, path, content); err != nil {
+			return nil, &HookError{Stage: HookAfterFetch, Hook: names[i], Path: path, Reason: err.Error()}
+		}
+	}
+	return content, nil
+}
+
+// runRewriteHooks 在强制推送 newHead 之前调用 RewriteHook
+func (h *history) runRewriteHooks(newHead plumbing.Hash) error {
+	names, hooks := rewriteHooks.snapshot()
+	if len(hooks) == 0 {
+		return nil
+	}
+	event := RewriteEvent{RepoURL: h.repoURL, Ref: h.refName.String(), NewHead: newHead.String(), Rewritten: len(h.rewritten)}
+	if old, err := h.repo.Storer.Reference(h.refName); err == nil {
+		event.OldHead = old.Hash().String()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for i, hook := range hooks {
+		if err := hook.BeforeRewrite(string(data)); err != nil {
+			return &HookError{Stage: HookBeforeRewrite, Hook: names[i], Reason: err.Error()}
+		}
+	}
+	return nil
+}
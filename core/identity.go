@@ -0,0 +1,94 @@
+package core
+
+import (
+	"errors"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Identity 是本库生成的 commit 使用的作者和提交者身份
+type Identity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// CommitterName / CommitterEmail 为空时提交者与作者相同
+	CommitterName  string `json:"committerName,omitempty"`
+	CommitterEmail string `json:"committerEmail,omitempty"`
+}
+
+var (
+	identityMu      sync.Mutex
+	defaultIdentity = Identity{Name: "MixGram", Email: "admin@mixgram.org"}
+	repoIdentities  = map[string]Identity{}
+)
+
+// SetDefaultIdentity 设置没有通过 SetRepoIdentity 单独指定身份的仓库使用的身份
+func SetDefaultIdentity(id *Identity) error {
+	if id == nil || id.Name == "" || id.Email == "" {
+		return errors.New("identity name and email are required")
+	}
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	defaultIdentity = *id
+	return nil
+}
+
+// SetRepoIdentity 指定该仓库后续生成的 commit 使用的身份，传 nil 恢复使用默认身份。
+// 同一进程中不同仓库可以使用不同的身份
+func SetRepoIdentity(repoURL string, id *Identity) error {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	if id == nil {
+		delete(repoIdentities, repoURL)
+		return nil
+	}
+	if id.Name == "" || id.Email == "" {
+		return errors.New("identity name and email are required")
+	}
+	repoIdentities[repoURL] = *id
+	return nil
+}
+
+// RepoIdentity 返回该仓库实际使用的身份
+func RepoIdentity(repoURL string) *Identity {
+	id := identityFor(repoURL)
+	return &id
+}
+
+func identityFor(repoURL string) Identity {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	if id, ok := repoIdentities[repoURL]; ok {
+		return id
+	}
+	return defaultIdentity
+}
+
+// signatures 返回使用当前时间的作者和提交者签名
+func (id Identity) signatures() (author, committer object.Signature) {
+	author = object.Signature{Name: id.Name, Email: id.Email, When: commitTime()}
+	committer = author
+	if id.CommitterName != "" {
+		committer.Name = id.CommitterName
+	}
+	if id.CommitterEmail != "" {
+		committer.Email = id.CommitterEmail
+	}
+	return author, committer
+}
+
+// committer 返回使用当前时间的提交者签名
+func (id Identity) committer() object.Signature {
+	_, committer := id.signatures()
+	return committer
+}
+
+// originURL 返回 repo 的 origin 地址，没有时返回空字符串
+func originURL(repo *git.Repository) string {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// DefaultBranchName 是初始化空仓库时使用的分支名
+var DefaultBranchName = "main"
+
+// ErrRemoteNotEmpty 表示要初始化的远端仓库已经有内容
+var ErrRemoteNotEmpty = errors.New("remote repository is not empty")
+
+// InitRemoteRepo 为一个刚创建的空远端仓库生成根提交（包含 initialFiles，可为 nil）并推送到 branchName
+// （空字符串表示 DefaultBranchName），返回根提交的哈希。远端已有内容时返回 ErrRemoteNotEmpty。
+func InitRemoteRepo(repoURL, sshKeyPEM string, branchName string, initialFiles *FileBatch) (string, error) {
+	if branchName == "" {
+		branchName = DefaultBranchName
+	}
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	files := map[string][]byte{}
+	if initialFiles != nil {
+		files = initialFiles.files
+	}
+	return initRemote(repoURL, auth, branchName, files, "Initial commit")
+}
+
+// initRemote 在空远端仓库上创建根提交并推送
+func initRemote(repoURL string, auth transport.AuthMethod, branchName string, files map[string][]byte, message string) (string, error) {
+	return initRemoteWithMeta(repoURL, auth, branchName, files, message, nil)
+}
+
+// initRemoteWithMeta 同 initRemote，并在同一次（原子）推送中写入 meta 中的元数据（名称 -> 内容）
+func initRemoteWithMeta(repoURL string, auth transport.AuthMethod, branchName string, files map[string][]byte,
+	message string, meta map[string][]byte) (string, error) {
+	refName := plumbing.NewBranchReferenceName(branchName)
+	if err := refName.Validate(); err != nil {
+		return "", fmt.Errorf("invalid branch name %q: %w", branchName, err)
+	}
+	files, err := prepareFiles(repoURL, files)
+	if err != nil {
+		return "", err
+	}
+
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", err
+	}
+	err = withRetry(repoURL, func(ctx context.Context) error {
+		_, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, ProxyOptions: utils.SSHProxyOptions(repoURL)})
+		return err
+	})
+	if err == nil {
+		return "", ErrRemoteNotEmpty
+	}
+	if !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return "", fmt.Errorf("list remote: %w", err)
+	}
+
+	treeHash, err := editTree(repo.Storer, plumbing.ZeroHash, files)
+	if err != nil {
+		return "", fmt.Errorf("build tree: %w", err)
+	}
+	author, committer := identityFor(repoURL).signatures()
+	obj := repo.Storer.NewEncodedObject()
+	err = (&object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      withProvenance(message),
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{},
+	}).Encode(obj)
+	if err != nil {
+		return "", fmt.Errorf("encode commit: %w", err)
+	}
+	root, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return "", fmt.Errorf("store commit: %w", err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(refName, root)); err != nil {
+		return "", fmt.Errorf("set ref: %w", err)
+	}
+
+	specs := []ggconfig.RefSpec{ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName))}
+	for name, data := range meta {
+		metaRef, err := metaRefName(name)
+		if err != nil {
+			return "", err
+		}
+		if _, err := commitRefFiles(repo, metaRef, plumbing.ZeroHash, map[string][]byte{metaFile: data}, "update "+name); err != nil {
+			return "", err
+		}
+		specs = append(specs, ggconfig.RefSpec(fmt.Sprintf("%s:%s", metaRef, metaRef)))
+	}
+
+	unlock := shareRepo(repoURL)
+	err = pushRefs(repo, auth, false, specs...)
+	unlock()
+	if err != nil {
+		return "", err
+	}
+	SetLastSeenHead(repoURL, root.String())
+	return root.String(), nil
+}
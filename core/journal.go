@@ -0,0 +1,332 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+)
+
+// 中断操作的恢复结果
+const (
+	// RecoveryCompleted 表示操作已完成（重启前已生效，或恢复时补完了剩余的推送）
+	RecoveryCompleted = "completed"
+	// RecoveryRolledBack 表示操作被放弃：远端已被其他设备修改，或缺少补完所需的数据；远端保持现状
+	RecoveryRolledBack = "rolled-back"
+	// RecoveryFailed 表示这次恢复失败（例如网络不可用），记录保留，下次再试
+	RecoveryFailed = "failed"
+)
+
+// journalKindRewrite 是重写历史后的强制推送
+const journalKindRewrite = "rewrite"
+
+// journalRef 是一次推送中一个 ref 的更新
+type journalRef struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// journalEntry 是预写日志中一个未完成的多步操作，推送所需的新对象另存为同名的 .pack 文件
+type journalEntry struct {
+	ID        string       `json:"id"`
+	Kind      string       `json:"kind"`
+	RepoURL   string       `json:"repoUrl"`
+	Refs      []journalRef `json:"refs"`
+	CreatedAt int64        `json:"createdAt"`
+}
+
+// RecoveredOperation 是 ResumePendingOperations 处理的一个中断操作
+type RecoveredOperation struct {
+	ID string `json:"id"`
+	// Kind 为 "rewrite"（重写历史后的强制推送）或 "upload"（附件上传）
+	Kind    string `json:"kind"`
+	RepoURL string `json:"repoUrl"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+var journalMu sync.Mutex
+
+// ResumePendingOperations 检查上次运行中被中断的多步操作并完成或回滚，App 启动时在 SetDataDir 之后调用，
+// 返回 RecoveredOperation 的 JSON 数组。
+// 重写历史后的强制推送：远端已是推送后的状态则记为完成；远端仍是推送前的状态（或只更新了一部分 ref）时用日志中保存的对象补完推送；
+// 远端已被其他设备修改时放弃。未完成的附件上传同 ResumePendingUploads 继续上传
+func ResumePendingOperations(keys KeyProvider) (string, error) {
+	journalMu.Lock()
+	entries, err := readJournal()
+	journalMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	results := []RecoveredOperation{}
+	for _, e := range entries {
+		op := RecoveredOperation{ID: e.ID, Kind: e.Kind, RepoURL: e.RepoURL}
+		key, err := keys.SSHKey(e.RepoURL)
+		if err == nil {
+			op.Outcome, err = recoverRewrite(e, key)
+		}
+		if err != nil {
+			op.Outcome, op.Error = RecoveryFailed, err.Error()
+		} else {
+			journalMu.Lock()
+			err = removeJournalEntry(e.ID)
+			journalMu.Unlock()
+			if err != nil {
+				return "", err
+			}
+		}
+		results = append(results, op)
+	}
+
+	uploadsMu.Lock()
+	uploads, err := readPendingUploads()
+	uploadsMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	for _, u := range uploads {
+		op := RecoveredOperation{ID: u.ID, Kind: "upload", RepoURL: u.RepoURL, Outcome: RecoveryCompleted}
+		key, err := keys.SSHKey(u.RepoURL)
+		if err == nil {
+			_, err = ResumeAttachmentUpload(u.RepoURL, key, u.ID)
+		}
+		if err != nil {
+			op.Outcome, op.Error = RecoveryFailed, err.Error()
+		}
+		results = append(results, op)
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// beginRewritePush 在强制推送重写后的历史之前写入日志：各 ref 的新旧值，以及远端可能没有的新对象。
+// 未设置 SetDataDir 时不记录，返回 nil
+func beginRewritePush(h *history, refs []journalRef) (*journalEntry, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, nil
+	}
+	entry := &journalEntry{
+		ID:        utils.RandomHexString(16),
+		Kind:      journalKindRewrite,
+		RepoURL:   h.repoURL,
+		Refs:      refs,
+		CreatedAt: commitTime().UnixMilli(),
+	}
+	var tips, ignore []plumbing.Hash
+	for _, r := range refs {
+		tips = append(tips, plumbing.NewHash(r.New))
+		if old := plumbing.NewHash(r.Old); !old.IsZero() {
+			ignore = append(ignore, old)
+		}
+	}
+	objects, err := revlist.Objects(h.repo.Storer, tips, ignore)
+	if err != nil {
+		return nil, fmt.Errorf("journal objects: %w", err)
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	f, err := os.Create(filepath.Join(dir, entry.ID+".pack"))
+	if err != nil {
+		return nil, fmt.Errorf("write journal: %w", err)
+	}
+	_, err = packfile.NewEncoder(f, h.repo.Storer, false).Encode(objects, 10)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write journal: %w", err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	// 记录最后写入，只有对象完整保存后的记录才会被恢复
+	if err := writeFileAtomic(filepath.Join(dir, entry.ID+".json"), data); err != nil {
+		return nil, fmt.Errorf("write journal: %w", err)
+	}
+	return entry, nil
+}
+
+// notesJournalRefs 返回 remapNotes 生成的 notes 更新：新值是迁移 notes 的 commit，旧值是它的父 commit
+func (h *history) notesJournalRefs(specs []ggconfig.RefSpec) []journalRef {
+	var refs []journalRef
+	for _, spec := range specs {
+		name := spec.Dst("")
+		ref, err := h.repo.Storer.Reference(name)
+		if err != nil {
+			continue
+		}
+		r := journalRef{Name: name.String(), Old: plumbing.ZeroHash.String(), New: ref.Hash().String()}
+		if c, err := h.repo.CommitObject(ref.Hash()); err == nil && c.NumParents() > 0 {
+			r.Old = c.ParentHashes[0].String()
+		}
+		refs = append(refs, r)
+	}
+	return refs
+}
+
+// finish 在推送返回后（无论成功与否）删除日志，调用方已知道结果，不需要恢复
+func (e *journalEntry) finish() {
+	if e == nil {
+		return
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	_ = removeJournalEntry(e.ID)
+}
+
+// recoverRewrite 比较远端各 ref 与日志，返回恢复结果
+func recoverRewrite(e *journalEntry, sshKeyPEM string) (string, error) {
+	defer lockRepo(e.RepoURL)()
+	auth, err := utils.NewSSHAuthForURL(e.RepoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	repo, err := utils.OpenRemote(e.RepoURL)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", err
+	}
+	var advertised []*plumbing.Reference
+	err = withRetry(e.RepoURL, func(ctx context.Context) (err error) {
+		advertised, err = remote.ListContext(ctx, &git.ListOptions{Auth: auth, ProxyOptions: utils.SSHProxyOptions(e.RepoURL)})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("list remote: %w", err)
+	}
+	current := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range advertised {
+		current[ref.Name()] = ref.Hash()
+	}
+
+	var pending []journalRef
+	for _, r := range e.Refs {
+		switch current[plumbing.ReferenceName(r.Name)] {
+		case plumbing.NewHash(r.New):
+		case plumbing.NewHash(r.Old):
+			pending = append(pending, r)
+		default:
+			return RecoveryRolledBack, nil
+		}
+	}
+	if len(pending) == 0 {
+		return RecoveryCompleted, nil
+	}
+
+	// 日志只保存了旧历史中没有的对象，先取回各 ref 在远端的旧值
+	for _, r := range pending {
+		if plumbing.NewHash(r.Old).IsZero() {
+			continue
+		}
+		if err := fetchRefSpec(repo, auth, ggconfig.RefSpec(fmt.Sprintf("+%s:%s", r.Name, r.Name))); err != nil {
+			return "", err
+		}
+	}
+	dir, err := journalDir()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(filepath.Join(dir, e.ID+".pack"))
+	if errors.Is(err, os.ErrNotExist) {
+		return RecoveryRolledBack, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := packfile.UpdateObjectStorage(repo.Storer, f); err != nil {
+		return "", fmt.Errorf("load journal objects: %w", err)
+	}
+	var specs []ggconfig.RefSpec
+	for _, r := range pending {
+		name := plumbing.ReferenceName(r.Name)
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, plumbing.NewHash(r.New))); err != nil {
+			return "", fmt.Errorf("set ref: %w", err)
+		}
+		specs = append(specs, ggconfig.RefSpec(fmt.Sprintf("+%s:%s", name, name)))
+	}
+	if err := pushRefs(repo, auth, false, specs...); err != nil {
+		return "", err
+	}
+	return RecoveryCompleted, nil
+}
+
+func journalDir() (string, error) {
+	return dataPath("journal")
+}
+
+// readJournal 按创建时间读取全部日志记录，调用方需持有 journalMu
+func readJournal() ([]*journalEntry, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, err
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	var entries []*journalEntry
+	for _, n := range names {
+		if n.IsDir() || !strings.HasSuffix(n.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, n.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read journal: %w", err)
+		}
+		var e journalEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("decode journal %s: %w", n.Name(), err)
+		}
+		entries = append(entries, &e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt < entries[j].CreatedAt })
+	return entries, nil
+}
+
+// removeJournalEntry 删除日志记录及其对象，调用方需持有 journalMu
+func removeJournalEntry(id string) error {
+	dir, err := journalDir()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{id + ".json", id + ".pack"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再重命名
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,353 @@
+package core
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// 导出密钥包时 argon2id 的参数
+const (
+	bundleKDF        = "argon2id"
+	bundleKDFTime    = 3
+	bundleKDFMemory  = 64 * 1024 // KiB
+	bundleKDFThreads = 4
+
+	// 导入时接受的 argon2id 参数上限，导入在手机上进行，内存不能超过 App 可用的范围
+	bundleKDFMaxTime    = 16
+	bundleKDFMaxMemory  = 256 * 1024 // KiB
+	bundleKDFMaxThreads = 16
+)
+
+var (
+	// ErrKeyIdentityNotFound 表示 keyring 中没有该身份
+	ErrKeyIdentityNotFound = errors.New("key identity not found")
+	// ErrWrongPassphrase 表示密钥包的口令错误或内容被篡改
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted bundle")
+)
+
+// KeyIdentity 是 keyring 中一个身份的公开信息：用于签名的 Ed25519 公钥和用于加密的 X25519 公钥（base64）
+type KeyIdentity struct {
+	// ID 是签名公钥 sha256 的前 16 个十六进制字符
+	ID                  string `json:"id"`
+	Label               string `json:"label"`
+	SigningPublicKey    string `json:"signingPublicKey"`
+	EncryptionPublicKey string `json:"encryptionPublicKey"`
+	CreatedAt           int64  `json:"createdAt"`
+}
+
+// keyringEntry 是 keyring 中保存的完整身份，包含私钥
+type keyringEntry struct {
+	KeyIdentity
+	SigningPrivateKey    string `json:"signingPrivateKey"`
+	EncryptionPrivateKey string `json:"encryptionPrivateKey"`
+}
+
+// keyBundle 是用口令加密的导出格式
+type keyBundle struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"`
+	Threads    uint8  `json:"threads"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+var keyringMu sync.Mutex
+
+// GenerateKeyIdentity 生成一个新的身份（签名和加密密钥对）并保存到 keyring（KeyStore，默认为 SetDataDir 下的 keyring 目录）。
+// 默认的文件存储中私钥以明文保存在 App 的私有目录中，需要在设备之间迁移时使用 ExportKeyIdentity
+func GenerateKeyIdentity(label string) (*KeyIdentity, error) {
+	signPub, signPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	encPriv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	entry := &keyringEntry{
+		KeyIdentity: KeyIdentity{
+			ID:                  keyIdentityID(signPub),
+			Label:               label,
+			SigningPublicKey:    base64.StdEncoding.EncodeToString(signPub),
+			EncryptionPublicKey: base64.StdEncoding.EncodeToString(encPriv.PublicKey().Bytes()),
+			CreatedAt:           time.Now().UnixMilli(),
+		},
+		SigningPrivateKey:    base64.StdEncoding.EncodeToString(signPriv.Seed()),
+		EncryptionPrivateKey: base64.StdEncoding.EncodeToString(encPriv.Bytes()),
+	}
+
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	if err := saveKeyringEntry(entry); err != nil {
+		return nil, err
+	}
+	identity := entry.KeyIdentity
+	return &identity, nil
+}
+
+// ListKeyIdentitiesJSON 返回 keyring 中所有身份的公开信息（KeyIdentity 数组的 JSON），按创建时间排序
+func ListKeyIdentitiesJSON() (string, error) {
+	keyringMu.Lock()
+	entries, err := readKeyring()
+	keyringMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	result := make([]KeyIdentity, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.KeyIdentity)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DeleteKeyIdentity 从 keyring 中删除身份
+func DeleteKeyIdentity(id string) error {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	alias, err := keyringAlias(id)
+	if err != nil {
+		return err
+	}
+	ks := currentKeyStore()
+	data, err := ks.Get(alias)
+	if err != nil {
+		return fmt.Errorf("read key identity: %w", err)
+	}
+	if data == nil {
+		return ErrKeyIdentityNotFound
+	}
+	if err := ks.Delete(alias); err != nil {
+		return fmt.Errorf("delete key identity: %w", err)
+	}
+	return nil
+}
+
+// ExportKeyIdentity 把身份（含私钥）导出为用 passphrase 加密的密钥包（JSON），
+// 口令经 argon2id 派生为 AES-256-GCM 密钥
+func ExportKeyIdentity(id, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("passphrase is required")
+	}
+	entry, err := loadKeyringEntry(id)
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	bundle := keyBundle{Version: 1, KDF: bundleKDF, Time: bundleKDFTime, Memory: bundleKDFMemory, Threads: bundleKDFThreads}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(bundle.deriveKey(passphrase, salt))
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	bundle.Salt = base64.StdEncoding.EncodeToString(salt)
+	bundle.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	bundle.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plain, nil))
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ImportKeyIdentity 用 passphrase 解开 ExportKeyIdentity 导出的密钥包并保存到 keyring，已存在的同一身份会被覆盖
+func ImportKeyIdentity(bundleJSON, passphrase string) (*KeyIdentity, error) {
+	var bundle keyBundle
+	if err := json.Unmarshal([]byte(bundleJSON), &bundle); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	if bundle.Version != 1 || bundle.KDF != bundleKDF {
+		return nil, fmt.Errorf("unsupported bundle version %d (%s)", bundle.Version, bundle.KDF)
+	}
+	// 拒绝异常的 KDF 参数，避免恶意密钥包耗尽内存或 CPU
+	if bundle.Time == 0 || bundle.Time > bundleKDFMaxTime || bundle.Memory > bundleKDFMaxMemory ||
+		bundle.Threads == 0 || bundle.Threads > bundleKDFMaxThreads {
+		return nil, errors.New("unsupported bundle kdf parameters")
+	}
+	salt, err1 := base64.StdEncoding.DecodeString(bundle.Salt)
+	nonce, err2 := base64.StdEncoding.DecodeString(bundle.Nonce)
+	ciphertext, err3 := base64.StdEncoding.DecodeString(bundle.Ciphertext)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+
+	aead, err := newGCM(bundle.deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	var entry keyringEntry
+	if err := json.Unmarshal(plain, &entry); err != nil {
+		return nil, fmt.Errorf("parse identity: %w", err)
+	}
+	if err := entry.validate(); err != nil {
+		return nil, err
+	}
+
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	if err := saveKeyringEntry(&entry); err != nil {
+		return nil, err
+	}
+	identity := entry.KeyIdentity
+	return &identity, nil
+}
+
+func (b *keyBundle) deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, b.Time, b.Memory, b.Threads, EncryptionKeySize)
+}
+
+// signingKey 返回身份的 Ed25519 私钥
+func (e *keyringEntry) signingKey() (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(e.SigningPrivateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key for %s", e.ID)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// encryptionKey 返回身份的 X25519 私钥
+func (e *keyringEntry) encryptionKey() (*ecdh.PrivateKey, error) {
+	return parseX25519Private(e.EncryptionPrivateKey)
+}
+
+// validate 检查私钥与公开信息是否一致
+func (e *keyringEntry) validate() error {
+	sign, err := e.signingKey()
+	if err != nil {
+		return err
+	}
+	enc, err := e.encryptionKey()
+	if err != nil {
+		return err
+	}
+	signPub := sign.Public().(ed25519.PublicKey)
+	if e.ID != keyIdentityID(signPub) ||
+		e.SigningPublicKey != base64.StdEncoding.EncodeToString(signPub) ||
+		e.EncryptionPublicKey != base64.StdEncoding.EncodeToString(enc.PublicKey().Bytes()) {
+		return fmt.Errorf("key identity %s does not match its private keys", e.ID)
+	}
+	return nil
+}
+
+func keyIdentityID(signPub ed25519.PublicKey) string {
+	sum := sha256.Sum256(signPub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// keyringAlias 返回身份在 KeyStore 中的别名
+func keyringAlias(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid key identity id %q", id)
+	}
+	return "keyring/" + id + ".json", nil
+}
+
+// loadKeyringEntry 读取 keyring 中的身份（含私钥）
+func loadKeyringEntry(id string) (*keyringEntry, error) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	alias, err := keyringAlias(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := currentKeyStore().Get(alias)
+	if err != nil {
+		return nil, fmt.Errorf("read key identity: %w", err)
+	}
+	if data == nil {
+		return nil, ErrKeyIdentityNotFound
+	}
+	var entry keyringEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("parse key identity %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// saveKeyringEntry 写入身份，调用方需持有 keyringMu
+func saveKeyringEntry(entry *keyringEntry) error {
+	alias, err := keyringAlias(entry.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := currentKeyStore().Put(alias, data); err != nil {
+		return fmt.Errorf("write key identity: %w", err)
+	}
+	return nil
+}
+
+// readKeyring 读取全部身份，调用方需持有 keyringMu
+func readKeyring() ([]*keyringEntry, error) {
+	ks := currentKeyStore()
+	aliases, err := listKeys(ks, "keyring/")
+	if err != nil {
+		return nil, fmt.Errorf("read keyring: %w", err)
+	}
+	var entries []*keyringEntry
+	for _, alias := range aliases {
+		if !strings.HasSuffix(alias, ".json") {
+			continue
+		}
+		data, err := ks.Get(alias)
+		if err != nil {
+			return nil, fmt.Errorf("read keyring: %w", err)
+		}
+		if data == nil {
+			continue
+		}
+		var entry keyringEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", alias, err)
+		}
+		entries = append(entries, &entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CreatedAt != entries[j].CreatedAt {
+			return entries[i].CreatedAt < entries[j].CreatedAt
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// KeyRotationResult 是 RotateChannelKey 的结果
+type KeyRotationResult struct {
+	// CommitHash 是写入新密钥的 commit
+	CommitHash string `json:"commitHash"`
+	// Epoch 是新密钥的轮次
+	Epoch int `json:"epoch"`
+	// Skipped 是无法用任何旧频道密钥解密、因而没有重新加密的文件路径（按字典序），
+	// 这些文件仍是原来的密文，App 可以提示用户检查
+	Skipped []string `json:"skipped"`
+}
+
+// RotateChannelKey 以身份 selfID 轮换仓库的频道密钥：生成新密钥并封装给当前所有成员，
+// 再把当前文件中用旧频道密钥加密的内容改用新密钥重新加密，新密钥同时设置为该仓库的加密密钥（KeyStore 中保存了旧密钥时一并替换）。
+// rewriteHistory 为 true 时还会重写全部历史，重新加密旧 commit 中的文件并强制推送（此操作会重写历史记录）；
+// 否则旧 commit 中的密文保持不变，持有旧密钥的人仍能读取这些历史版本。
+// 移除成员后应调用本函数，使被移除的成员无法再读取现有内容
+func RotateChannelKey(repoURL, sshKeyPEM string, selfID string, rewriteHistory bool) (*KeyRotationResult, error) {
+	self, err := loadKeyringEntry(selfID)
+	if err != nil {
+		return nil, err
+	}
+	var h *history
+	var head plumbing.Hash
+	if rewriteHistory {
+		defer lockRepo(repoURL)()
+		if h, err = loadHistory(repoURL, sshKeyPEM); err != nil {
+			return nil, err
+		}
+		head = h.commits[0].Hash
+	} else {
+		var ref *plumbing.Reference
+		if h, ref, err = openBranch(repoURL, sshKeyPEM); err != nil {
+			return nil, err
+		}
+		head = ref.Hash()
+	}
+
+	state, err := readMembership(h, head)
+	if err != nil {
+		return nil, err
+	}
+	oldKeys, err := state.epochKeys(h, head, self)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := GenerateEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	rekey := &channelRekey{oldKeys: oldKeys, newKey: newKey, done: map[rekeyItem][]byte{}, skipped: map[string]bool{}}
+
+	parent := head
+	files := map[string][]byte{}
+	if rewriteHistory {
+		var editErr error
+		parent, err = h.relink(plumbing.ZeroHash, rootToHead(h.commits), nil, func(old, c *object.Commit) bool {
+			if editErr != nil {
+				return false
+			}
+			changes, err := rekey.changes(old)
+			if err != nil {
+				editErr = err
+				return false
+			}
+			if len(changes) == 0 {
+				return false
+			}
+			treeHash, err := editTree(h.repo.Storer, old.TreeHash, changes)
+			if err != nil {
+				editErr = err
+				return false
+			}
+			c.TreeHash = treeHash
+			return true
+		})
+		if editErr != nil {
+			return nil, fmt.Errorf("re-encrypt history: %w", editErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		c, err := h.repo.CommitObject(head)
+		if err != nil {
+			return nil, fmt.Errorf("head commit: %w", err)
+		}
+		if files, err = rekey.changes(c); err != nil {
+			return nil, fmt.Errorf("re-encrypt files: %w", err)
+		}
+	}
+
+	if err := state.rotate(files, newKey, self); err != nil {
+		return nil, err
+	}
+	newHead, err := h.commitFiles(parent, "rotate channel key", files)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.push(newHead, rewriteHistory); err != nil {
+		return nil, err
+	}
+	result := &KeyRotationResult{CommitHash: newHead.String(), Epoch: state.epoch, Skipped: []string{}}
+	for p := range rekey.skipped {
+		result.Skipped = append(result.Skipped, p)
+	}
+	sort.Strings(result.Skipped)
+	if err := replaceRepoEncryptionKey(repoURL, newKey); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RotateChannelKeyJSON 同 RotateChannelKey，以 JSON 返回 KeyRotationResult
+func RotateChannelKeyJSON(repoURL, sshKeyPEM string, selfID string, rewriteHistory bool) (string, error) {
+	result, err := RotateChannelKey(repoURL, sshKeyPEM, selfID, rewriteHistory)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// channelRekey 把用旧频道密钥加密（encModeSymmetric）的文件改用新密钥加密。
+// 发给指定接收者的文件（encModeRecipients）和 .mixgram/ 下的内部文件不受影响
+type channelRekey struct {
+	oldKeys [][]byte
+	newKey  []byte
+	// done 缓存每个 (路径, blob) 重新加密后的内容，nil 表示无需修改；
+	// 历史中没有变化的文件只重新加密一次，重写后在各个 commit 中仍是同一个 blob
+	done map[rekeyItem][]byte
+	// skipped 记录无法用任何旧密钥解密的路径
+	skipped map[string]bool
+}
+
+type rekeyItem struct {
+	path string
+	blob plumbing.Hash
+}
+
+// changes 返回 commit 中需要重新加密的文件修改，超出内联大小的文件已转存，
+// 不再被引用的旧转存 blob 会被删除
+func (r *channelRekey) changes(c *object.Commit) (map[string][]byte, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+
+	sealed := map[string][]byte{}
+	// replaced 是引用已被替换的转存 blob，kept 是仍被未修改的存根引用的转存 blob
+	replaced, kept := map[string]bool{}, map[string]bool{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if strings.HasPrefix(f.Name, ".mixgram/") {
+			return nil
+		}
+		content, err := blobContent(f)
+		if err != nil {
+			return err
+		}
+		stub, isStub := parseOverflowStub(content)
+		item := rekeyItem{path: f.Name, blob: f.Hash}
+		if isStub {
+			// 转存的文件以实际内容所在的 blob 作为缓存键
+			blobFile, err := tree.File(stub.Blob)
+			if err != nil {
+				return fmt.Errorf("overflow blob for %s: %w", f.Name, err)
+			}
+			item.blob = blobFile.Hash
+			if _, ok := r.done[item]; !ok {
+				if content, err = blobContent(blobFile); err != nil {
+					return err
+				}
+				if err := stub.verify(content); err != nil {
+					return fmt.Errorf("overflow blob for %s: %w", f.Name, err)
+				}
+			}
+		}
+
+		result, ok := r.done[item]
+		if !ok {
+			if result, err = r.reseal(f.Name, content); err != nil {
+				return err
+			}
+			r.done[item] = result
+		}
+		switch {
+		case result != nil:
+			sealed[f.Name] = result
+			if isStub {
+				replaced[stub.Blob] = true
+			}
+		case isStub:
+			kept[stub.Blob] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := overflowFiles(sealed)
+	for blob := range replaced {
+		if _, ok := files[blob]; !ok && !kept[blob] {
+			files[blob] = nil
+		}
+	}
+	return files, nil
+}
+
+// reseal 用旧密钥解密 content 并用新密钥重新加密，不是频道密钥加密的文件返回 nil
+func (r *channelRekey) reseal(path string, content []byte) ([]byte, error) {
+	header, _, err := splitEncrypted(content)
+	if err != nil || header[len(header)-1] != encModeSymmetric {
+		return nil, nil
+	}
+	for _, key := range r.oldKeys {
+		plain, err := openSymmetric(key, path, content)
+		if err != nil {
+			continue
+		}
+		return sealSymmetric(r.newKey, path, plain)
+	}
+	r.skipped[path] = true
+	return nil, nil
+}
+
+func blobContent(f *object.File) ([]byte, error) {
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyStore 按别名保存本库使用的密钥材料：keyring 中的身份（"keyring/<id>.json"）、
+// StoreSSHKey 保存的 SSH 私钥（"ssh/..."）和 StoreRepoEncryptionKey 保存的频道密钥（"channel/..."）。
+// 别名由 "/" 分隔的若干段组成，每段只含字母、数字、"."、"_" 和 "-"。
+// 默认使用 SetDataDir 下的文件，Android App 可以用硬件 Keystore 包装的存储实现
+type KeyStore interface {
+	// Get 返回别名的内容，不存在时返回 nil 和 nil 错误
+	Get(alias string) ([]byte, error)
+	// Put 写入（或覆盖）别名的内容
+	Put(alias string, data []byte) error
+	// Delete 删除别名，不存在时不返回错误
+	Delete(alias string) error
+	// List 返回以 prefix 开头的全部别名（字符串数组的 JSON）
+	List(prefix string) (string, error)
+}
+
+var (
+	keyStoreMu sync.Mutex
+	keyStore   KeyStore = fileKeyStore{}
+)
+
+// SetKeyStore 设置保存密钥材料的 KeyStore，传 nil 恢复默认的文件存储。
+// 切换存储不会迁移已有的密钥
+func SetKeyStore(ks KeyStore) {
+	keyStoreMu.Lock()
+	defer keyStoreMu.Unlock()
+	if ks == nil {
+		ks = fileKeyStore{}
+	}
+	keyStore = ks
+}
+
+func currentKeyStore() KeyStore {
+	keyStoreMu.Lock()
+	defer keyStoreMu.Unlock()
+	return keyStore
+}
+
+// listKeys 返回 KeyStore 中以 prefix 开头的别名，按字典序排列
+func listKeys(ks KeyStore, prefix string) ([]string, error) {
+	data, err := ks.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	var aliases []string
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &aliases); err != nil {
+			return nil, fmt.Errorf("list keys: %w", err)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases, nil
+}
+
+func validateKeyAlias(alias string) error {
+	if alias == "" {
+		return errors.New("key alias is empty")
+	}
+	for _, part := range strings.Split(alias, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.TrimFunc(part, func(r rune) bool {
+			return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+		}) != "" {
+			return fmt.Errorf("invalid key alias %q", alias)
+		}
+	}
+	return nil
+}
+
+// repoKeyAlias 返回仓库 repoURL 在 KeyStore 中以 kind 为前缀的别名
+func repoKeyAlias(kind, repoURL string) string {
+	sum := sha256.Sum256([]byte(repoURL))
+	return kind + "/" + hex.EncodeToString(sum[:16])
+}
+
+// StoreSSHKey 把仓库 repoURL 的 SSH 私钥保存到 KeyStore，传空字符串删除。
+// 保存后后台任务可以使用 StoredSSHKeys 作为 KeyProvider
+func StoreSSHKey(repoURL, sshKeyPEM string) error {
+	ks := currentKeyStore()
+	alias := repoKeyAlias("ssh", repoURL)
+	if sshKeyPEM == "" {
+		return ks.Delete(alias)
+	}
+	return ks.Put(alias, []byte(sshKeyPEM))
+}
+
+// StoredSSHKeys 返回从 KeyStore 读取 StoreSSHKey 保存的私钥的 KeyProvider
+func StoredSSHKeys() KeyProvider {
+	return storedSSHKeys{}
+}
+
+type storedSSHKeys struct{}
+
+func (storedSSHKeys) SSHKey(repoURL string) (string, error) {
+	data, err := currentKeyStore().Get(repoKeyAlias("ssh", repoURL))
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return "", fmt.Errorf("no ssh key stored for %s", repoURL)
+	}
+	return string(data), nil
+}
+
+// StoreRepoEncryptionKey 同 SetRepoEncryptionKey，并把密钥保存到 KeyStore，之后的会话无需再次设置；传 nil 同时删除保存的密钥
+func StoreRepoEncryptionKey(repoURL string, key []byte) error {
+	if err := SetRepoEncryptionKey(repoURL, key); err != nil {
+		return err
+	}
+	ks := currentKeyStore()
+	alias := repoKeyAlias("channel", repoURL)
+	if key == nil {
+		return ks.Delete(alias)
+	}
+	return ks.Put(alias, key)
+}
+
+// replaceRepoEncryptionKey 替换仓库的对称密钥（传 nil 表示吊销）：KeyStore 中已保存了该仓库的密钥时同时更新保存的密钥，
+// 否则只修改内存中的密钥。成员变动和密钥轮换都经由它更新密钥，避免之后从 KeyStore 读回已失效的旧密钥
+func replaceRepoEncryptionKey(repoURL string, key []byte) error {
+	stored, err := currentKeyStore().Get(repoKeyAlias("channel", repoURL))
+	if err != nil {
+		return err
+	}
+	if stored != nil {
+		return StoreRepoEncryptionKey(repoURL, key)
+	}
+	return SetRepoEncryptionKey(repoURL, key)
+}
+
+// fileKeyStore 把别名保存为 SetDataDir 下的同名文件
+type fileKeyStore struct{}
+
+func (fileKeyStore) path(alias string) (string, error) {
+	if err := validateKeyAlias(alias); err != nil {
+		return "", err
+	}
+	dir, file := filepath.Split(filepath.FromSlash(alias))
+	base, err := dataPath(filepath.Clean(dir))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, file), nil
+}
+
+func (s fileKeyStore) Get(alias string) ([]byte, error) {
+	path, err := s.path(alias)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", alias, err)
+	}
+	return data, nil
+}
+
+func (s fileKeyStore) Put(alias string, data []byte) error {
+	path, err := s.path(alias)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", alias, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write %s: %w", alias, err)
+	}
+	return nil
+}
+
+func (s fileKeyStore) Delete(alias string) error {
+	path, err := s.path(alias)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete %s: %w", alias, err)
+	}
+	return nil
+}
+
+// List 只列出 prefix 最后一个 "/" 之前的目录中的文件，不含子目录
+func (fileKeyStore) List(prefix string) (string, error) {
+	dir, name := "", prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, name = prefix[:i], prefix[i+1:]
+	}
+	base, err := dataPath(filepath.FromSlash(dir))
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", dir, err)
+	}
+	aliases := []string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), name) || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		alias := e.Name()
+		if dir != "" {
+			alias = dir + "/" + alias
+		}
+		if validateKeyAlias(alias) == nil {
+			aliases = append(aliases, alias)
+		}
+	}
+	data, err := json.Marshal(aliases)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
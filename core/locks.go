@@ -0,0 +1,65 @@
+package core
+
+import "sync"
+
+// 同一进程内对同一仓库的并发操作通过读写锁协调：
+// 重写历史并强制推送的操作持有写锁，从克隆到推送完成期间独占该仓库；
+// 其他推送（快进更新分支、notes）只在推送时持有读锁，可以互相并发。
+// 重写期间普通推送会等待，重写完成后按快进规则被远端拒绝，而不会被强制推送覆盖
+
+var (
+	repoLocksMu sync.Mutex
+	repoLocks   = map[string]*sync.RWMutex{}
+
+	networkMu  sync.Mutex
+	networkSem chan struct{}
+)
+
+// SetMaxConcurrentNetworkOps 设置同时进行的网络操作（克隆、取回、推送、引用协商）的上限，0 表示不限制（默认）。
+// 已在等待的操作仍按旧的上限执行
+func SetMaxConcurrentNetworkOps(n int) {
+	networkMu.Lock()
+	defer networkMu.Unlock()
+	if n <= 0 {
+		networkSem = nil
+		return
+	}
+	networkSem = make(chan struct{}, n)
+}
+
+// acquireNetwork 占用一个网络操作名额，返回释放函数
+func acquireNetwork() func() {
+	networkMu.Lock()
+	sem := networkSem
+	networkMu.Unlock()
+	if sem == nil {
+		return func() {}
+	}
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+func repoLock(repoURL string) *sync.RWMutex {
+	repoLocksMu.Lock()
+	defer repoLocksMu.Unlock()
+	l, ok := repoLocks[repoURL]
+	if !ok {
+		l = &sync.RWMutex{}
+		repoLocks[repoURL] = l
+	}
+	return l
+}
+
+// lockRepo 独占仓库，用于重写历史的操作，返回释放函数。持有期间不能再调用会推送该仓库的非强制推送
+func lockRepo(repoURL string) func() {
+	l := repoLock(repoURL)
+	l.Lock()
+	return l.Unlock
+}
+
+// shareRepo 在非强制推送期间共享仓库，等待正在进行的历史重写完成，返回释放函数
+func shareRepo(repoURL string) func() {
+	l := repoLock(repoURL)
+	l.RLock()
+	return l.RUnlock
+}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// mailDateLayout 是 RFC 5322 的日期格式
+const mailDateLayout = "Mon, 02 Jan 2006 15:04:05 -0700"
+
+// ExportMbox 把频道 channel（空字符串表示仓库默认的 messages/）的消息按从旧到新的顺序以 mbox（mboxrd）格式写入 w，
+// 每条消息一封邮件，附件消息（MessageTypeAttachment）的附件内容作为 MIME 附件。
+// 已软删除的消息不导出，编辑过的消息导出最后的内容；max > 0 时只导出最近的 max 条。返回导出的邮件数量
+func ExportMbox(repoURL, sshKeyPEM string, channel string, max int, w io.Writer) (int, error) {
+	h, head, msgs, err := exportMessages(repoURL, sshKeyPEM, channel, max)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, m := range msgs {
+		fmt.Fprintf(bw, "From %s %s\n", mboxSender(m.Sender), time.UnixMilli(m.Timestamp).UTC().Format("Mon Jan _2 15:04:05 2006"))
+		mw := &mailWriter{w: bw, mbox: true}
+		if err := renderMail(mw, h, head, channel, m); err != nil {
+			return i, err
+		}
+		if err := mw.Close(); err != nil {
+			return i, fmt.Errorf("write mbox: %w", err)
+		}
+		// 每封邮件后留一个空行，再开始下一个 "From " 分隔行
+		bw.WriteString("\n")
+		if err := bw.Flush(); err != nil {
+			return i, fmt.Errorf("write mbox: %w", err)
+		}
+	}
+	return len(msgs), nil
+}
+
+// ExportMaildir 同 ExportMbox，但把每封邮件写入 maildir 目录 dir（不存在时创建 tmp、new、cur 子目录）。
+// 文件名由消息 ID 确定，重复导出同一频道会覆盖已有的邮件而不是产生重复
+func ExportMaildir(repoURL, sshKeyPEM string, channel string, max int, dir string) (int, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return 0, fmt.Errorf("create maildir: %w", err)
+		}
+	}
+	h, head, msgs, err := exportMessages(repoURL, sshKeyPEM, channel, max)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range msgs {
+		name := fmt.Sprintf("%d.%s.mixgram", m.Timestamp/1000, m.ID)
+		tmp := filepath.Join(dir, "tmp", name)
+		if err := writeMailFile(tmp, h, head, channel, m); err != nil {
+			return i, err
+		}
+		// ":2,S" 表示已读
+		if err := os.Rename(tmp, filepath.Join(dir, "cur", name+":2,S")); err != nil {
+			return i, fmt.Errorf("write maildir: %w", err)
+		}
+	}
+	return len(msgs), nil
+}
+
+// writeMailFile 把消息渲染为邮件写入文件 name，失败时删除写了一半的文件
+func writeMailFile(name string, h *history, commit plumbing.Hash, channel string, m *Message) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("write maildir: %w", err)
+	}
+	bw := bufio.NewWriter(f)
+	mw := &mailWriter{w: bw}
+	err = renderMail(mw, h, commit, channel, m)
+	if err == nil {
+		err = mw.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("write maildir: %w", cerr)
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+// exportMessages 克隆远端并返回频道 channel 最近的 max 条消息（从旧到新，已应用最后一次编辑，不含软删除的消息）
+func exportMessages(repoURL, sshKeyPEM string, channel string, max int) (*history, plumbing.Hash, []*Message, error) {
+	h, head, err := openMessageStore(repoURL, sshKeyPEM, channel)
+	if err != nil {
+		return nil, plumbing.ZeroHash, nil, err
+	}
+	paths, err := messagePaths(h, head.Hash(), messageStoreDir(channel))
+	if err != nil {
+		return nil, plumbing.ZeroHash, nil, err
+	}
+	deleted, err := tombstoned(h, head.Hash(), channel)
+	if err != nil {
+		return nil, plumbing.ZeroHash, nil, err
+	}
+
+	var msgs []*Message
+	for _, p := range paths {
+		if max > 0 && len(msgs) >= max {
+			break
+		}
+		m, err := readMessage(h, head.Hash(), p)
+		if err != nil {
+			return nil, plumbing.ZeroHash, nil, err
+		}
+		// ID 用于 Message-ID 和 maildir 文件名，不是本库生成的十六进制 ID 的消息不导出
+		if deleted[m.ID] || !isMailID(m.ID) {
+			continue
+		}
+		if err := applyLatestEdit(h, head.Hash(), channel, m); err != nil {
+			return nil, plumbing.ZeroHash, nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	// messagePaths 从新到旧排列
+	slices.Reverse(msgs)
+	return h, head.Hash(), msgs, nil
+}
+
+// renderMail 把消息渲染为一封 RFC 5322 邮件（CRLF 换行）写入 w，回复和话题对应 In-Reply-To 和 References；
+// 附件按分块边读取边编码写出，不在内存中保存完整内容。
+// 消息的字段来自仓库中任何成员写入的内容，写入邮件头前去掉控制字符或编码，不能注入额外的邮件头
+func renderMail(w io.Writer, h *history, commit plumbing.Hash, channel string, m *Message) error {
+	body := m.Body
+	var attachment *AttachmentManifest
+	if m.Type == MessageTypeAttachment {
+		var err error
+		attachment, err = readAttachmentManifest(h, commit, m.Body)
+		if errors.Is(err, ErrAttachmentNotFound) {
+			// 附件已被清理，只导出消息本身
+			attachment = nil
+		} else if err != nil {
+			return err
+		} else {
+			body = stripControl(attachment.Name)
+		}
+	}
+	subject, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+
+	header := func(key, value string) {
+		fmt.Fprintf(w, "%s: %s\r\n", key, value)
+	}
+	header("From", (&mail.Address{Address: stripControl(m.Sender)}).String())
+	header("Date", time.UnixMilli(m.Timestamp).Format(mailDateLayout))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Message-ID", mailMessageID(m.ID))
+	if isMailID(m.ReplyTo) {
+		header("In-Reply-To", mailMessageID(m.ReplyTo))
+	}
+	if isMailID(m.ThreadID) {
+		header("References", mailMessageID(m.ThreadID))
+	}
+	if channel != "" {
+		header("X-MixGram-Channel", mime.QEncoding.Encode("utf-8", channel))
+	}
+	header("X-MixGram-Type", mime.QEncoding.Encode("utf-8", m.Type))
+	if m.EditedAt > 0 {
+		header("X-MixGram-Edited", time.UnixMilli(m.EditedAt).Format(mailDateLayout))
+	}
+	header("MIME-Version", "1.0")
+
+	if attachment == nil {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		io.WriteString(w, "\r\n")
+		return writeQuotedPrintable(w, body)
+	}
+
+	mw := multipart.NewWriter(w)
+	header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	io.WriteString(w, "\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeQuotedPrintable(part, body); err != nil {
+		return err
+	}
+	name := stripControl(attachment.Name)
+	contentType := mime.FormatMediaType(attachment.MimeType, nil)
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+	})
+	if err != nil {
+		return err
+	}
+	lines := &base64Lines{w: part}
+	enc := base64.NewEncoder(base64.StdEncoding, lines)
+	if err := attachment.stream(h, commit, func(chunk []byte) error {
+		_, err := enc.Write(chunk)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := lines.Close(); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// mailMessageID 返回消息 id 对应的 Message-ID
+func mailMessageID(id string) string {
+	return "<" + id + "@mixgram>"
+}
+
+// isMailID 判断 id 是否为可以直接写入邮件头和文件名的十六进制 ID
+func isMailID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// stripControl 去掉 s 中的控制字符（包括 CR、LF）
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// mboxSender 返回 mbox 分隔行中的发送者，不能包含空白或控制字符
+func mboxSender(sender string) string {
+	if sender == "" || strings.IndexFunc(sender, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "MAILER-DAEMON"
+	}
+	return sender
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// base64Lines 在写入的 base64 内容中每 76 个字符插入一个 CRLF
+type base64Lines struct {
+	w   io.Writer
+	col int
+}
+
+func (l *base64Lines) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(76-l.col, len(p))
+		if _, err := l.w.Write(p[:k]); err != nil {
+			return 0, err
+		}
+		l.col += k
+		p = p[k:]
+		if l.col == 76 {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return 0, err
+			}
+			l.col = 0
+		}
+	}
+	return n, nil
+}
+
+// Close 结束最后不满 76 个字符的一行
+func (l *base64Lines) Close() error {
+	if l.col == 0 {
+		return nil
+	}
+	l.col = 0
+	_, err := io.WriteString(l.w, "\r\n")
+	return err
+}
+
+// mailWriter 把 renderMail 写出的 CRLF 换行转换为 LF，mbox 为 true 时按 mboxrd 规则转义 "From " 行，
+// 只缓存当前不完整的一行
+type mailWriter struct {
+	w    io.Writer
+	mbox bool
+	line []byte
+}
+
+func (w *mailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.line = append(w.line, p...)
+			break
+		}
+		w.line = append(w.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := w.writeLine(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (w *mailWriter) writeLine() error {
+	line := w.line
+	w.line = w.line[:0]
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		line = append(line[:len(line)-2], '\n')
+	}
+	if w.mbox && isMboxFromLine(line) {
+		if _, err := w.w.Write([]byte{'>'}); err != nil {
+			return err
+		}
+	}
+	_, err := w.w.Write(line)
+	return err
+}
+
+// Close 写出最后不完整的一行并补上换行
+func (w *mailWriter) Close() error {
+	if len(w.line) == 0 {
+		return nil
+	}
+	w.line = append(w.line, '\n')
+	return w.writeLine()
+}
+
+// isMboxFromLine 判断一行是否需要按 mboxrd 规则转义（">*From "）
+func isMboxFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
@@ -0,0 +1,609 @@
+package core
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	// membersDir 下每个成员一个文件（成员 ID.json），内容为签名的 KeyIdentity 公开信息（见 signedRecord）
+	membersDir = ".mixgram/members"
+	// channelKeysDir 下按轮次（epoch）保存频道密钥，每个成员一份用其加密公钥封装的密钥，
+	// 以及签名的轮次记录 epoch.json（见 epochRecord）；current 文件记录当前轮次
+	channelKeysDir     = ".mixgram/keys"
+	channelKeysCurrent = channelKeysDir + "/current"
+	epochRecordName    = "epoch.json"
+)
+
+var (
+	// ErrNotMember 表示该身份不是仓库成员，或当前轮次的频道密钥没有为其封装
+	ErrNotMember = errors.New("not a member of this repository")
+	// ErrAlreadyMember 表示成员已存在
+	ErrAlreadyMember = errors.New("already a member")
+	// ErrInvalidMembership 表示成员文件或轮次记录的签名无效，或签名者不是成员，仓库中的成员信息可能被篡改
+	ErrInvalidMembership = errors.New("invalid membership record")
+)
+
+// AddMember 以 keyring 中的身份 selfID 把成员（memberJSON 为对方的 KeyIdentity 公开信息）加入仓库：
+// 写入由 selfID 签名的成员文件，并把当前的频道密钥封装给对方。仓库还没有频道密钥时生成第一轮密钥，selfID 同时成为成员
+func AddMember(repoURL, sshKeyPEM string, selfID string, memberJSON string) error {
+	var member KeyIdentity
+	if err := json.Unmarshal([]byte(memberJSON), &member); err != nil {
+		return fmt.Errorf("parse member: %w", err)
+	}
+	if err := member.validatePublic(); err != nil {
+		return err
+	}
+	self, err := loadKeyringEntry(selfID)
+	if err != nil {
+		return err
+	}
+
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return addFirstMembers(repoURL, sshKeyPEM, self, &member)
+	}
+	if err != nil {
+		return err
+	}
+	state, err := readMembership(h, head.Hash())
+	if err != nil {
+		return err
+	}
+	if _, ok := state.members[member.ID]; ok {
+		return ErrAlreadyMember
+	}
+
+	files := map[string][]byte{}
+	if state.epoch == 0 {
+		// 还没有频道密钥：生成第一轮密钥
+		key, err := GenerateEncryptionKey()
+		if err != nil {
+			return err
+		}
+		if _, ok := state.members[self.ID]; !ok {
+			state.members[self.ID] = self.KeyIdentity
+			if err := state.writeMember(files, &self.KeyIdentity, self); err != nil {
+				return err
+			}
+		}
+		state.members[member.ID] = member
+		if err := state.rotate(files, key, self); err != nil {
+			return err
+		}
+	} else {
+		key, err := state.channelKey(h, head.Hash(), self)
+		if err != nil {
+			return err
+		}
+		state.members[member.ID] = member
+		if err := state.sealFor(files, &member, key); err != nil {
+			return err
+		}
+		if err := state.writeEpoch(files, state.epoch, self); err != nil {
+			return err
+		}
+	}
+	if err := state.writeMember(files, &member, self); err != nil {
+		return err
+	}
+	_, err = h.commitAndPush(head.Hash(), "add member "+member.ID, files)
+	return err
+}
+
+// RemoveMember 以身份 selfID 把成员 memberID 移出仓库，并轮换频道密钥：新密钥只封装给剩余的成员，
+// 被移除的成员无法解密之后加密的内容，新密钥同时设置为该仓库的加密密钥（KeyStore 中保存了旧密钥时一并替换）。
+// 被移除的成员签名的成员文件和轮次记录改由 selfID 重新签名，因此成员不能移除自己。
+// 已有的文件仍是旧密钥加密的，需要再调用 RotateChannelKey 重新加密
+func RemoveMember(repoURL, sshKeyPEM string, selfID string, memberID string) error {
+	self, err := loadKeyringEntry(selfID)
+	if err != nil {
+		return err
+	}
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	state, err := readMembership(h, head.Hash())
+	if err != nil {
+		return err
+	}
+	if _, ok := state.members[memberID]; !ok {
+		return ErrNotMember
+	}
+	if memberID == self.ID {
+		return fmt.Errorf("member %s cannot remove itself", memberID)
+	}
+	// 只有持有当前密钥的成员才能轮换
+	if _, err := state.channelKey(h, head.Hash(), self); err != nil {
+		return err
+	}
+
+	files := map[string][]byte{}
+	if err := state.remove(files, memberID, self); err != nil {
+		return err
+	}
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		return err
+	}
+	if err := state.rotate(files, key, self); err != nil {
+		return err
+	}
+	if _, err := h.commitAndPush(head.Hash(), "remove member "+memberID, files); err != nil {
+		return err
+	}
+	// 内存中和 KeyStore 保存的旧密钥都已失效，换成新密钥
+	return replaceRepoEncryptionKey(repoURL, key)
+}
+
+// LoadChannelKey 用 keyring 中的身份 selfID 解开仓库当前轮次的频道密钥，
+// 并把它设置为该仓库的加密密钥（供 PushCommitEncrypted 等使用；KeyStore 中保存了该仓库的密钥时一并更新），返回密钥
+func LoadChannelKey(repoURL, sshKeyPEM string, selfID string) ([]byte, error) {
+	self, err := loadKeyringEntry(selfID)
+	if err != nil {
+		return nil, err
+	}
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	state, err := readMembership(h, head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	key, err := state.channelKey(h, head.Hash(), self)
+	if err != nil {
+		return nil, err
+	}
+	if err := replaceRepoEncryptionKey(repoURL, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ListMembersJSON 返回仓库成员的 KeyIdentity 数组 JSON（按 ID 排序）
+func ListMembersJSON(repoURL, sshKeyPEM string) (string, error) {
+	result := []KeyIdentity{}
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return "", err
+	}
+	if err == nil {
+		state, err := readMembership(h, head.Hash())
+		if err != nil {
+			return "", err
+		}
+		for _, m := range state.members {
+			result = append(result, m)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// addFirstMembers 在空仓库上创建根提交，写入第一轮频道密钥和两个成员
+func addFirstMembers(repoURL, sshKeyPEM string, self *keyringEntry, member *KeyIdentity) error {
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	state := newMembership()
+	state.members[self.ID] = self.KeyIdentity
+	state.members[member.ID] = *member
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{}
+	if err := state.rotate(files, key, self); err != nil {
+		return err
+	}
+	if err := state.writeMember(files, &self.KeyIdentity, self); err != nil {
+		return err
+	}
+	if err := state.writeMember(files, member, self); err != nil {
+		return err
+	}
+	_, err = initRemote(repoURL, auth, initBranch(repoURL), files, "add member "+member.ID)
+	return err
+}
+
+// membership 是某个 commit 中的成员和频道密钥轮次。
+// 成员文件和轮次记录都由一个成员签名，签名链的起点是唯一一个自签名的成员（创建者，或移除创建者的成员）
+type membership struct {
+	members map[string]KeyIdentity
+	epoch   int
+	// sealed 是各轮次为每个成员封装的密钥的 sha256（轮次 -> 成员 ID -> 十六进制）
+	sealed map[int]map[string]string
+	// records 是成员文件和轮次记录（路径 -> 记录），移除成员时用来重新签名
+	records map[string]*signedRecord
+}
+
+// signedRecord 是成员文件和轮次记录的内容：数据、签名者的成员 ID 和签名者对路径与数据的 Ed25519 签名
+type signedRecord struct {
+	Data      json.RawMessage `json:"data"`
+	SignedBy  string          `json:"signedBy"`
+	Signature string          `json:"signature"`
+}
+
+// epochRecord 是一个轮次的记录：为每个成员封装的密钥的 sha256
+type epochRecord struct {
+	Epoch int               `json:"epoch"`
+	Keys  map[string]string `json:"keys"`
+}
+
+func newMembership() *membership {
+	return &membership{members: map[string]KeyIdentity{}, sealed: map[int]map[string]string{}, records: map[string]*signedRecord{}}
+}
+
+// readMembership 读取 commit 中的成员和轮次记录并校验签名，任何记录无效时返回 ErrInvalidMembership
+func readMembership(h *history, commit plumbing.Hash) (*membership, error) {
+	state := newMembership()
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+
+	dir, err := tree.Tree(membersDir)
+	if err != nil && !errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, fmt.Errorf("read %s: %w", membersDir, err)
+	}
+	if dir != nil {
+		for _, e := range dir.Entries {
+			if !e.Mode.IsFile() || !strings.HasSuffix(e.Name, ".json") {
+				continue
+			}
+			p := path.Join(membersDir, e.Name)
+			r, err := readSignedRecord(h, commit, p)
+			if err != nil {
+				return nil, err
+			}
+			var m KeyIdentity
+			if err := json.Unmarshal(r.Data, &m); err != nil {
+				return nil, fmt.Errorf("parse member %s: %w", e.Name, err)
+			}
+			if p != memberPath(m.ID) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidMembership, p)
+			}
+			if err := m.validatePublic(); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidMembership, err)
+			}
+			state.members[m.ID] = m
+			state.records[p] = r
+		}
+	}
+	if err := state.verifyMembers(); err != nil {
+		return nil, err
+	}
+
+	current, err := readRefFile(h.repo, commit, channelKeysCurrent)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if state.epoch, err = strconv.Atoi(strings.TrimSpace(string(current))); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", channelKeysCurrent, err)
+	}
+	for epoch := 1; epoch <= state.epoch; epoch++ {
+		p := epochRecordPath(epoch)
+		r, err := readSignedRecord(h, commit, p)
+		if err != nil {
+			return nil, err
+		}
+		var rec epochRecord
+		if err := json.Unmarshal(r.Data, &rec); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", p, err)
+		}
+		if rec.Epoch != epoch {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMembership, p)
+		}
+		if err := state.verify(p, r); err != nil {
+			return nil, err
+		}
+		state.sealed[epoch] = rec.Keys
+		state.records[p] = r
+	}
+	return state, nil
+}
+
+func readSignedRecord(h *history, commit plumbing.Hash, p string) (*signedRecord, error) {
+	content, err := readRefFile(h.repo, commit, p)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %s is missing", ErrInvalidMembership, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r signedRecord
+	if err := json.Unmarshal(content, &r); err != nil {
+		return nil, fmt.Errorf("%w: parse %s: %v", ErrInvalidMembership, p, err)
+	}
+	return &r, nil
+}
+
+// verifyMembers 从唯一的自签名成员出发，沿签名链校验全部成员文件
+func (m *membership) verifyMembers() error {
+	if len(m.members) == 0 {
+		return nil
+	}
+	trusted := map[string]bool{}
+	for id := range m.members {
+		if m.records[memberPath(id)].SignedBy != id {
+			continue
+		}
+		if len(trusted) > 0 {
+			return fmt.Errorf("%w: more than one self-signed member", ErrInvalidMembership)
+		}
+		if err := m.verify(memberPath(id), m.records[memberPath(id)]); err != nil {
+			return err
+		}
+		trusted[id] = true
+	}
+	for progress := true; progress; {
+		progress = false
+		for id := range m.members {
+			r := m.records[memberPath(id)]
+			if trusted[id] || !trusted[r.SignedBy] {
+				continue
+			}
+			if err := m.verify(memberPath(id), r); err != nil {
+				return err
+			}
+			trusted[id] = true
+			progress = true
+		}
+	}
+	if len(trusted) != len(m.members) {
+		return fmt.Errorf("%w: member records are not signed by a member", ErrInvalidMembership)
+	}
+	return nil
+}
+
+// verify 校验路径 p 的记录是由成员 r.SignedBy 签名的
+func (m *membership) verify(p string, r *signedRecord) error {
+	signer, ok := m.members[r.SignedBy]
+	if !ok {
+		return fmt.Errorf("%w: %s is signed by non-member %q", ErrInvalidMembership, p, r.SignedBy)
+	}
+	pub, err := base64.StdEncoding.DecodeString(signer.SigningPublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: invalid signing key of %s", ErrInvalidMembership, signer.ID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil || !ed25519.Verify(pub, recordPayload(p, r.Data), sig) {
+		return fmt.Errorf("%w: bad signature on %s", ErrInvalidMembership, p)
+	}
+	return nil
+}
+
+// sign 以成员 signer 签名路径 p 的记录 data，写入 files
+func (m *membership) sign(files map[string][]byte, p string, data []byte, signer *keyringEntry) error {
+	if _, ok := m.members[signer.ID]; !ok {
+		return ErrNotMember
+	}
+	priv, err := signer.signingKey()
+	if err != nil {
+		return err
+	}
+	r := &signedRecord{
+		Data:      data,
+		SignedBy:  signer.ID,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, recordPayload(p, data))),
+	}
+	content, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	files[p] = content
+	m.records[p] = r
+	return nil
+}
+
+// remove 删除成员 removed 的文件，并把它签名的记录改由 signer 重新签名；
+// removed 是自签名的起点时，signer 改为自签名
+func (m *membership) remove(files map[string][]byte, removed string, signer *keyringEntry) error {
+	p := memberPath(removed)
+	anchor := m.records[p] != nil && m.records[p].SignedBy == removed
+	delete(m.members, removed)
+	delete(m.records, p)
+	files[p] = nil
+	for p, r := range m.records {
+		if r.SignedBy == removed {
+			if err := m.sign(files, p, r.Data, signer); err != nil {
+				return err
+			}
+		}
+	}
+	if self := m.records[memberPath(signer.ID)]; anchor && self != nil && self.SignedBy != signer.ID {
+		return m.sign(files, memberPath(signer.ID), self.Data, signer)
+	}
+	return nil
+}
+
+// recordPayload 是记录签名的内容，包含路径，记录不能被移到其他路径使用
+func recordPayload(p string, data []byte) []byte {
+	return []byte("mixgram membership record\n" + p + "\n" + string(data))
+}
+
+// channelKey 用 self 的加密私钥解开当前轮次的频道密钥
+func (m *membership) channelKey(h *history, commit plumbing.Hash, self *keyringEntry) ([]byte, error) {
+	if m.epoch == 0 {
+		return nil, ErrNotMember
+	}
+	blobPath := m.keyPath(self.ID)
+	sealed, err := readRefFile(h.repo, commit, blobPath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkSealed(m.epoch, self.ID, sealed); err != nil {
+		return nil, err
+	}
+	priv, err := self.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return openForRecipient(priv, blobPath, sealed)
+}
+
+// checkSealed 校验封装的密钥与轮次记录中的 sha256 一致
+func (m *membership) checkSealed(epoch int, memberID string, sealed []byte) error {
+	sum := sha256.Sum256(sealed)
+	if m.sealed[epoch][memberID] != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("%w: channel key of epoch %d for %s", ErrInvalidMembership, epoch, memberID)
+	}
+	return nil
+}
+
+// rotate 进入下一轮次，把 key 封装给当前所有成员，由 signer 签名轮次记录，修改写入 files
+func (m *membership) rotate(files map[string][]byte, key []byte, signer *keyringEntry) error {
+	m.epoch++
+	m.sealed[m.epoch] = map[string]string{}
+	for _, member := range m.members {
+		if err := m.sealFor(files, &member, key); err != nil {
+			return err
+		}
+	}
+	if err := m.writeEpoch(files, m.epoch, signer); err != nil {
+		return err
+	}
+	files[channelKeysCurrent] = []byte(strconv.Itoa(m.epoch) + "\n")
+	return nil
+}
+
+// sealFor 把当前轮次的密钥封装给 member，并记录到当前轮次，之后需要用 writeEpoch 重新签名轮次记录
+func (m *membership) sealFor(files map[string][]byte, member *KeyIdentity, key []byte) error {
+	pub, err := member.encryptionPublicKey()
+	if err != nil {
+		return err
+	}
+	blobPath := m.keyPath(member.ID)
+	sealed, err := sealForRecipients([]*ecdh.PublicKey{pub}, blobPath, key)
+	if err != nil {
+		return err
+	}
+	files[blobPath] = sealed
+	sum := sha256.Sum256(sealed)
+	m.sealed[m.epoch][member.ID] = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// writeEpoch 由 signer 签名轮次 epoch 的记录
+func (m *membership) writeEpoch(files map[string][]byte, epoch int, signer *keyringEntry) error {
+	data, err := json.Marshal(&epochRecord{Epoch: epoch, Keys: m.sealed[epoch]})
+	if err != nil {
+		return err
+	}
+	return m.sign(files, epochRecordPath(epoch), data, signer)
+}
+
+// writeMember 由 signer 签名 member 的成员文件
+func (m *membership) writeMember(files map[string][]byte, member *KeyIdentity, signer *keyringEntry) error {
+	data, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	return m.sign(files, memberPath(member.ID), data, signer)
+}
+
+// epochKeys 用 self 的加密私钥解开从当前轮次到第一轮的全部频道密钥（新的在前），
+// self 加入之前的轮次没有为其封装，会被跳过；当前轮次没有为 self 封装时返回 ErrNotMember
+func (m *membership) epochKeys(h *history, commit plumbing.Hash, self *keyringEntry) ([][]byte, error) {
+	current, err := m.channelKey(h, commit, self)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := self.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	keys := [][]byte{current}
+	for epoch := m.epoch - 1; epoch > 0; epoch-- {
+		blobPath := epochKeyPath(epoch, self.ID)
+		sealed, err := readRefFile(h.repo, commit, blobPath)
+		if errors.Is(err, object.ErrFileNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := m.checkSealed(epoch, self.ID, sealed); err != nil {
+			return nil, err
+		}
+		key, err := openForRecipient(priv, blobPath, sealed)
+		if err != nil {
+			return nil, fmt.Errorf("channel key of epoch %d: %w", epoch, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *membership) keyPath(memberID string) string {
+	return epochKeyPath(m.epoch, memberID)
+}
+
+func epochKeyPath(epoch int, memberID string) string {
+	return path.Join(channelKeysDir, strconv.Itoa(epoch), memberID)
+}
+
+func epochRecordPath(epoch int) string {
+	return path.Join(channelKeysDir, strconv.Itoa(epoch), epochRecordName)
+}
+
+func memberPath(memberID string) string {
+	return path.Join(membersDir, memberID+".json")
+}
+
+// validatePublic 检查公开信息中的 ID 与签名公钥一致、加密公钥有效
+func (k *KeyIdentity) validatePublic() error {
+	signPub, err := base64.StdEncoding.DecodeString(k.SigningPublicKey)
+	if err != nil || len(signPub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid signing public key for member %q", k.ID)
+	}
+	if k.ID != keyIdentityID(signPub) {
+		return fmt.Errorf("member id %q does not match its signing key", k.ID)
+	}
+	_, err = k.encryptionPublicKey()
+	return err
+}
+
+func (k *KeyIdentity) encryptionPublicKey() (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(k.EncryptionPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption public key for member %q", k.ID)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption public key for member %q", k.ID)
+	}
+	return pub, nil
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"sort"
+	"strings"
+
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// MergeBranch 的合并策略
+const (
+	// MergeFastForward 只在目标分支是源分支的祖先时把目标分支快进到源分支，否则返回 ErrMergeNotFastForward
+	MergeFastForward = "fast-forward"
+	// MergeOurs 创建合并提交但完全保留目标分支的内容，只把源分支的历史记为已合并
+	MergeOurs = "ours"
+	// MergeRecursive 能快进时快进，否则以两个分支的共同祖先为基础按文件三方合并，
+	// 两边把同一个文件改成不同内容时返回 *MergeConflictError
+	MergeRecursive = "recursive"
+)
+
+// 合并结果的状态
+const (
+	MergeStatusUpToDate    = "up-to-date"
+	MergeStatusFastForward = "fast-forward"
+	MergeStatusMerged      = "merged"
+)
+
+// ErrMergeNotFastForward 表示使用 MergeFastForward 策略时两个分支已经分叉
+var ErrMergeNotFastForward = errors.New("branches have diverged, cannot fast-forward")
+
+// MergeConflictError 表示两个分支把同一个文件改成了不同的内容，无法自动合并
+type MergeConflictError struct {
+	Paths []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return "merge conflict: " + strings.Join(e.Paths, ", ")
+}
+
+// MergeResult 描述一次分支合并的结果
+type MergeResult struct {
+	Status string `json:"status"`
+	// CommitHash 是目标分支合并后指向的 commit
+	CommitHash string `json:"commitHash"`
+}
+
+// MergeBranch 把远端分支 sourceBranch 合并到 targetBranch（均为短名称）并推送 targetBranch，
+// strategy 为 MergeFastForward、MergeOurs 或 MergeRecursive（空字符串等同于 MergeRecursive）。
+// 目标分支以快进方式更新，不会重写历史
+func MergeBranch(repoURL, sshKeyPEM string, sourceBranch, targetBranch, strategy string) (*MergeResult, error) {
+	if strategy == "" {
+		strategy = MergeRecursive
+	}
+	if strategy != MergeFastForward && strategy != MergeOurs && strategy != MergeRecursive {
+		return nil, fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+	if sourceBranch == "" || targetBranch == "" || sourceBranch == targetBranch {
+		return nil, errors.New("source and target must be two different branches")
+	}
+
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	h := &history{repoURL: repoURL, auth: auth, repo: repo, refName: plumbing.NewBranchReferenceName(targetBranch)}
+	source, err := fetchBranchCommit(h, sourceBranch)
+	if err != nil {
+		return nil, err
+	}
+	target, err := fetchBranchCommit(h, targetBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.Hash == target.Hash {
+		return &MergeResult{Status: MergeStatusUpToDate, CommitHash: target.Hash.String()}, nil
+	}
+	if ok, err := source.IsAncestor(target); err != nil {
+		return nil, fmt.Errorf("check ancestry: %w", err)
+	} else if ok {
+		return &MergeResult{Status: MergeStatusUpToDate, CommitHash: target.Hash.String()}, nil
+	}
+	canFastForward, err := target.IsAncestor(source)
+	if err != nil {
+		return nil, fmt.Errorf("check ancestry: %w", err)
+	}
+
+	result := &MergeResult{Status: MergeStatusMerged}
+	newHead := source.Hash
+	switch {
+	case strategy == MergeOurs:
+		newHead, err = h.mergeCommit(target, source, target.TreeHash, sourceBranch, targetBranch)
+	case canFastForward:
+		result.Status = MergeStatusFastForward
+	case strategy == MergeFastForward:
+		return nil, ErrMergeNotFastForward
+	default:
+		var tree plumbing.Hash
+		if tree, err = h.mergeTrees(target, source); err == nil {
+			newHead, err = h.mergeCommit(target, source, tree, sourceBranch, targetBranch)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 目标分支不一定是该仓库当前使用的分支，不记录为上次看到的 head
+	unlock := shareRepo(repoURL)
+	err = pushRefs(repo, auth, false, ggconfig.RefSpec(fmt.Sprintf("%s:%s", newHead, h.refName)))
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	result.CommitHash = newHead.String()
+	return result, nil
+}
+
+// fetchBranchCommit 取回远端分支 branch 及其全部历史
+func fetchBranchCommit(h *history, branch string) (*object.Commit, error) {
+	ref, err := fetchRef(h.repo, h.auth, plumbing.NewBranchReferenceName(branch))
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("branch %s not found", branch)
+	}
+	c, err := h.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", branch, err)
+	}
+	return c, nil
+}
+
+// mergeCommit 创建以 target、source 为父提交、树为 tree 的合并提交
+func (h *history) mergeCommit(target, source *object.Commit, tree plumbing.Hash, sourceBranch, targetBranch string) (plumbing.Hash, error) {
+	author, committer := identityFor(h.repoURL).signatures()
+	return h.storeCommit(&object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      withProvenance(fmt.Sprintf("Merge branch '%s' into %s", sourceBranch, targetBranch)),
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{target.Hash, source.Hash},
+	})
+}
+
+// mergeTrees 以共同祖先为基础按文件三方合并两个 commit 的树，返回合并后的树
+func (h *history) mergeTrees(ours, theirs *object.Commit) (plumbing.Hash, error) {
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("merge base: %w", err)
+	}
+	base := map[string]treeFile{}
+	if len(bases) > 0 {
+		if base, err = flattenTree(bases[0]); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	mine, err := flattenTree(ours)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	other, err := flattenTree(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	paths := map[string]bool{}
+	for p := range mine {
+		paths[p] = true
+	}
+	for p := range other {
+		paths[p] = true
+	}
+	changes := map[string][]byte{}
+	modes := map[string]filemode.FileMode{}
+	var conflicts []string
+	for p := range paths {
+		b, m, o := base[p], mine[p], other[p]
+		if m == o || o == b {
+			continue // 两边相同，或只有我们修改了
+		}
+		if m != b {
+			conflicts = append(conflicts, p)
+			continue
+		}
+		// 只有对方修改了：采用对方的版本
+		if o.hash.IsZero() {
+			changes[p] = nil
+			continue
+		}
+		content, err := blobBytes(h, o.hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		changes[p], modes[p] = content, o.mode
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return plumbing.ZeroHash, &MergeConflictError{Paths: conflicts}
+	}
+	return editTreeModes(h.repo.Storer, ours.TreeHash, changes, modes)
+}
+
+// treeFile 是树中一个文件的内容哈希和模式，零值表示文件不存在
+type treeFile struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+// flattenTree 返回 commit 中全部文件的路径到 treeFile 的映射
+func flattenTree(c *object.Commit) (map[string]treeFile, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	files := map[string]treeFile{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name] = treeFile{hash: f.Hash, mode: f.Mode}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+	return files, nil
+}
+
+func blobBytes(h *history, hash plumbing.Hash) ([]byte, error) {
+	blob, err := h.repo.BlobObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("load blob %s: %w", hash, err)
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,304 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"os"
+	"path"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// MessageImportBatchSize 是 ImportMessages 每个 commit 写入的最多消息数量
+var MessageImportBatchSize = 500
+
+// 消息归档中的记录类型
+const (
+	archiveKindChannel = "channel"
+	archiveKindMessage = "message"
+)
+
+// MessageExportOptions 控制 ExportMessages 导出的内容
+type MessageExportOptions struct {
+	// Channels 是要导出的频道，空字符串表示仓库默认的 messages/；为空时导出默认消息和全部频道
+	Channels []string `json:"channels,omitempty"`
+	// Decrypt 为 true 时用仓库的对称密钥（见 SetRepoEncryptionKey）解密加密的消息文件；
+	// 否则加密的消息原样导出，只能导入到使用同一密钥的仓库
+	Decrypt bool `json:"decrypt,omitempty"`
+}
+
+// MessageArchiveRecord 是消息归档（NDJSON）中的一行：频道信息或一条消息。
+// 频道的记录总在其消息之前，消息按时间从旧到新排列
+type MessageArchiveRecord struct {
+	// Kind 为 "channel" 或 "message"
+	Kind string `json:"kind"`
+	// Channel 是所在频道，空字符串表示仓库默认的 messages/
+	Channel string   `json:"channel,omitempty"`
+	Info    *Channel `json:"info,omitempty"`
+	// Message 是已应用最后一次编辑的消息；未解密的加密消息为空，其原路径和密文见 Path、Sealed
+	Message *Message `json:"message,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Sealed  []byte   `json:"sealed,omitempty"`
+}
+
+// ExportMessages 把仓库当前的消息逐条以 NDJSON（每行一个 MessageArchiveRecord）写入 outPath，
+// optionsJSON 为 MessageExportOptions 的 JSON（空字符串使用默认值）。
+// 已软删除的消息不导出，编辑过的消息导出最后的内容；只导出本仓库，不沿轮换链读取前驱仓库。返回导出的消息数量
+func ExportMessages(repoURL, sshKeyPEM string, outPath string, optionsJSON string) (int, error) {
+	var opts MessageExportOptions
+	if optionsJSON != "" {
+		if err := json.Unmarshal([]byte(optionsJSON), &opts); err != nil {
+			return 0, fmt.Errorf("decode options: %w", err)
+		}
+	}
+	var key []byte
+	if opts.Decrypt {
+		var err error
+		if key, err = repoEncryptionKey(repoURL); err != nil {
+			return 0, err
+		}
+	}
+
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return 0, err
+	}
+	channels := opts.Channels
+	if len(channels) == 0 {
+		all, err := listChannels(h, head.Hash())
+		if err != nil {
+			return 0, err
+		}
+		channels = []string{""}
+		for _, ch := range all {
+			channels = append(channels, ch.Name)
+		}
+	}
+
+	tmp := outPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("create archive: %w", err)
+	}
+	bw := bufio.NewWriter(f)
+	count, err := writeMessageArchive(json.NewEncoder(bw), h, head.Hash(), channels, key)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, outPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return count, nil
+}
+
+// writeMessageArchive 依次写出各频道的信息和消息，key 不为 nil 时解密加密的消息
+func writeMessageArchive(enc *json.Encoder, h *history, commit plumbing.Hash, channels []string, key []byte) (int, error) {
+	count := 0
+	for _, channel := range channels {
+		if channel != "" {
+			info, err := readChannel(h, commit, channel)
+			if err != nil {
+				return count, fmt.Errorf("channel %s: %w", channel, err)
+			}
+			if err := enc.Encode(&MessageArchiveRecord{Kind: archiveKindChannel, Channel: channel, Info: info}); err != nil {
+				return count, fmt.Errorf("write archive: %w", err)
+			}
+		}
+		paths, err := messagePaths(h, commit, messageStoreDir(channel))
+		if err != nil {
+			return count, err
+		}
+		deleted, err := tombstoned(h, commit, channel)
+		if err != nil {
+			return count, err
+		}
+		// messagePaths 从新到旧排列，归档按从旧到新写出，导入时先写话题的根消息
+		for i := len(paths) - 1; i >= 0; i-- {
+			rec, err := archiveMessage(h, commit, channel, paths[i], key)
+			if err != nil {
+				return count, err
+			}
+			if rec.Message != nil {
+				if deleted[rec.Message.ID] {
+					continue
+				}
+				if err := applyLatestEdit(h, commit, channel, rec.Message); err != nil {
+					return count, err
+				}
+			}
+			if err := enc.Encode(rec); err != nil {
+				return count, fmt.Errorf("write archive: %w", err)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// archiveMessage 读取一个消息文件，加密的文件在 key 为 nil 时原样保留
+func archiveMessage(h *history, commit plumbing.Hash, channel, msgPath string, key []byte) (*MessageArchiveRecord, error) {
+	content, err := readCommitFile(h.repoURL, h.repo, commit, msgPath)
+	if err != nil {
+		return nil, err
+	}
+	rec := &MessageArchiveRecord{Kind: archiveKindMessage, Channel: channel}
+	if bytes.HasPrefix(content, encryptedMagic) {
+		if key == nil {
+			rec.Path, rec.Sealed = msgPath, content
+			return rec, nil
+		}
+		if content, err = openSymmetric(key, msgPath, content); err != nil {
+			return nil, fmt.Errorf("decrypt %s: %w", msgPath, err)
+		}
+	}
+	var m Message
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("parse message %s: %w", msgPath, err)
+	}
+	rec.Message = &m
+	return rec, nil
+}
+
+// ImportMessages 把 ExportMessages 生成的归档 inPath 写入空仓库 repoURL 并推送，返回导入的消息数量。
+// 消息保留原有的 ID、发送者和时间，话题索引随之重建；每 MessageImportBatchSize 条消息一个 commit。
+// 远端已有内容时返回 ErrRemoteNotEmpty
+func ImportMessages(repoURL, sshKeyPEM string, inPath string) (int, error) {
+	f, err := os.Open(inPath)
+	if err != nil {
+		return 0, fmt.Errorf("open archive: %w", err)
+	}
+	defer f.Close()
+
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return 0, err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return 0, err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return 0, err
+	}
+	err = withRetry(repoURL, func(ctx context.Context) error {
+		_, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, ProxyOptions: utils.SSHProxyOptions(repoURL)})
+		return err
+	})
+	if err == nil {
+		return 0, ErrRemoteNotEmpty
+	}
+	if !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return 0, fmt.Errorf("list remote: %w", err)
+	}
+
+	refName := plumbing.NewBranchReferenceName(initBranch(repoURL))
+	var head plumbing.Hash
+	files := map[string][]byte{}
+	count, batch := 0, 0
+	flush := func() error {
+		if len(files) == 0 {
+			return nil
+		}
+		msg := fmt.Sprintf("import %d messages", batch)
+		if batch == 0 {
+			msg = "import channels"
+		}
+		changes, err := prepareFiles(repoURL, files)
+		if err != nil {
+			return err
+		}
+		head, err = commitRefFiles(repo, refName, head, changes, msg)
+		files, batch = map[string][]byte{}, 0
+		return err
+	}
+	// 话题根消息的路径，按频道和消息 ID 记录
+	roots := map[string]string{}
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var rec MessageArchiveRecord
+		if err := dec.Decode(&rec); err != nil {
+			return count, fmt.Errorf("read archive: %w", err)
+		}
+		if rec.Channel != "" {
+			if err := validateChannelName(rec.Channel); err != nil {
+				return count, err
+			}
+		}
+		dir := messageStoreDir(rec.Channel)
+		switch {
+		case rec.Kind == archiveKindChannel && rec.Info != nil:
+			data, err := json.Marshal(rec.Info)
+			if err != nil {
+				return count, err
+			}
+			files[path.Join(channelsDir, rec.Channel, channelInfoFile)] = data
+			continue
+		case rec.Kind != archiveKindMessage:
+			return count, fmt.Errorf("unknown archive record %q", rec.Kind)
+		case rec.Message != nil:
+			m := rec.Message
+			m.Pending = false
+			data, err := json.Marshal(m)
+			if err != nil {
+				return count, err
+			}
+			msgPath := m.path(dir)
+			files[msgPath] = data
+			roots[rec.Channel+"\n"+m.ID] = msgPath
+			if m.ThreadID != "" {
+				files[threadIndexPath(rec.Channel, m.ThreadID, msgPath)] = []byte(msgPath)
+				if root, ok := roots[rec.Channel+"\n"+m.ThreadID]; ok {
+					files[threadIndexPath(rec.Channel, m.ThreadID, root)] = []byte(root)
+				}
+			}
+		case rec.Sealed != nil:
+			// 密文以路径作为附加数据，只能写回原路径
+			if !strings.HasPrefix(rec.Path, dir+"/") || path.Clean(rec.Path) != rec.Path {
+				return count, fmt.Errorf("invalid message path %q", rec.Path)
+			}
+			files[rec.Path] = rec.Sealed
+		default:
+			return count, errors.New("message record has no content")
+		}
+		count++
+		batch++
+		if batch >= MessageImportBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	if head.IsZero() {
+		return 0, errors.New("archive is empty")
+	}
+
+	unlock := shareRepo(repoURL)
+	err = pushRefs(repo, auth, false, ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName)))
+	unlock()
+	if err != nil {
+		return count, err
+	}
+	SetLastSeenHead(repoURL, head.String())
+	return count, nil
+}
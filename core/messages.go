@@ -0,0 +1,365 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// messagesDir 下按 UTC 日期分目录保存消息，每条消息一个文件：<日期>/<13 位毫秒时间戳>-<ID>.json，
+// 文件名的字典序即消息的时间顺序
+const messagesDir = "messages"
+
+// Message 是一条结构化消息
+type Message struct {
+	ID     string `json:"id"`
+	Sender string `json:"sender"`
+	// Timestamp 是发送时间（Unix 毫秒），按 SetTimestampStrategy 的策略取值
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Body      string `json:"body"`
+	// ReplyTo 是被回复的消息 ID；ThreadID 是所在话题的根消息 ID，根消息本身没有 ThreadID
+	ReplyTo  string `json:"replyTo,omitempty"`
+	ThreadID string `json:"threadId,omitempty"`
+	// EditedAt 是最后一次编辑的时间（Unix 毫秒），未编辑过时为 0
+	EditedAt int64 `json:"editedAt,omitempty"`
+	// Clock 是消息的混合逻辑时钟，用于在设备时间不准时也能得到一致的顺序（见 SortMessages）
+	Clock string `json:"clock,omitempty"`
+	// Pending 只出现在发送结果中，表示消息已写入 outbox，尚未推送
+	Pending bool `json:"pending,omitempty"`
+}
+
+// MessagePage 是一页消息查询结果，按时间从新到旧排列
+type MessagePage struct {
+	Items []Message `json:"items"`
+	// Truncated 表示因 max 限制还有更早的消息未返回
+	Truncated bool `json:"truncated"`
+	// NextCursor 用于获取下一页，Truncated 为 false 时为空
+	NextCursor string `json:"nextCursor,omitempty"`
+	HeadHash   string `json:"headHash"`
+}
+
+// SendMessage 把一条消息写入 messages/ 并提交推送，返回写入的消息。
+// sender 为空时使用该仓库身份（见 SetRepoIdentity）的邮箱
+func SendMessage(repoURL, sshKeyPEM string, sender, msgType, body string) (*Message, error) {
+	return sendMessage(repoURL, sshKeyPEM, "", "", sender, msgType, body)
+}
+
+// SendMessageJSON 同 SendMessage，以 JSON 返回写入的消息
+func SendMessageJSON(repoURL, sshKeyPEM string, sender, msgType, body string) (string, error) {
+	msg, err := SendMessage(repoURL, sshKeyPEM, sender, msgType, body)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FetchMessages 从 cursor 开始（空字符串表示从最新的消息开始）按时间从新到旧返回 max 条消息，max <= 0 表示全部。
+// 已软删除（见 DeleteMessageSoft）的消息不会返回。分页按发送设备的时间排序，需要各设备一致的顺序时再用 SortMessages 排序
+func FetchMessages(repoURL, sshKeyPEM string, cursor string, max int) (*MessagePage, error) {
+	return fetchMessages(repoURL, sshKeyPEM, "", cursor, max)
+}
+
+// FetchMessagesJSON 同 FetchMessages，返回 MessagePage 的 JSON
+func FetchMessagesJSON(repoURL, sshKeyPEM string, cursor string, max int) (string, error) {
+	page, err := FetchMessages(repoURL, sshKeyPEM, cursor, max)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// sendMessage 把消息写入频道 channel 的消息目录并提交推送，channel 为空表示仓库默认的 messages/。
+// replyTo 不为空时消息是对该消息的回复，并加入其所在话题的索引。
+// 开启离线队列（见 SetOfflineQueue）时，无法连接远端的消息写入 outbox，返回的消息 Pending 为 true
+func sendMessage(repoURL, sshKeyPEM string, channel, replyTo string, sender, msgType, body string) (*Message, error) {
+	msg, err := newMessage(repoURL, channel, replyTo, sender, msgType, body, commitTime().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	entry := &OutboxEntry{Kind: outboxKindMessage, RepoURL: repoURL, Channel: channel, Msg: msg}
+	queued, err := sendOrQueue(repoURL, sshKeyPEM, entry, func() error {
+		return deliverMessage(repoURL, sshKeyPEM, channel, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.Pending = queued
+	return msg, nil
+}
+
+// newMessage 生成一条时间为 timestamp（Unix 毫秒）的消息并校验，sender 为空时使用该仓库身份的邮箱
+func newMessage(repoURL, channel, replyTo string, sender, msgType, body string, timestamp int64) (*Message, error) {
+	if msgType == "" {
+		return nil, errors.New("message type is empty")
+	}
+	if sender == "" {
+		sender = identityFor(repoURL).Email
+	}
+	msg := &Message{
+		ID:        utils.RandomHexString(16),
+		Sender:    sender,
+		Timestamp: timestamp,
+		Type:      msgType,
+		Body:      body,
+		ReplyTo:   replyTo,
+		Clock:     tickClock().String(),
+	}
+	if err := validateMessage(repoURL, channel, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// deliverMessage 把已生成 ID 和时间的消息 msg 提交推送，回复消息的 ThreadID 在此时确定
+func deliverMessage(repoURL, sshKeyPEM string, channel string, msg *Message) error {
+	dir := messageStoreDir(channel)
+	msgPath := msg.path(dir)
+	commitMsg := "message " + msg.ID
+	if channel != "" {
+		commitMsg += " in " + channel
+	}
+
+	// 频道必须已经存在，避免消息写入拼错名字的频道
+	h, head, err := openWritableStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) && msg.ReplyTo == "" {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+		if err != nil {
+			return err
+		}
+		_, err = initRemote(repoURL, auth, initBranch(repoURL), map[string][]byte{msgPath: data}, commitMsg)
+		return err
+	}
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if next, err := readRotationPointer(h, head.Hash(), successorPath); err != nil {
+		return err
+	} else if next != nil {
+		return fmt.Errorf("%w: %s", ErrRepoRotated, next.URL)
+	}
+
+	files := map[string][]byte{}
+	var floor hybridClock
+	if msg.ReplyTo != "" {
+		parent, parentPath, err := findMessage(h, head.Hash(), dir, msg.ReplyTo)
+		if err != nil {
+			return err
+		}
+		floor = parent.clock()
+		msg.ThreadID = parent.threadID()
+		if parent.ThreadID == "" {
+			// 第一条回复时把根消息也加入话题索引
+			files[threadIndexPath(channel, msg.ThreadID, parentPath)] = []byte(parentPath)
+		}
+		files[threadIndexPath(channel, msg.ThreadID, msgPath)] = []byte(msgPath)
+	}
+	if err := stampClock(h, head.Hash(), dir, msg, floor); err != nil {
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	files[msgPath] = data
+	_, err = h.commitAndPush(head.Hash(), commitMsg, files)
+	return err
+}
+
+// openMessageStore 克隆仓库，channel 不为空时确认频道存在
+func openMessageStore(repoURL, sshKeyPEM string, channel string) (*history, *plumbing.Reference, error) {
+	if channel == "" {
+		return openBranch(repoURL, sshKeyPEM)
+	}
+	return openChannel(repoURL, sshKeyPEM, channel)
+}
+
+// openWritableStore 同 openMessageStore，频道已迁移时返回 ErrChannelMoved
+func openWritableStore(repoURL, sshKeyPEM string, channel string) (*history, *plumbing.Reference, error) {
+	if channel == "" {
+		return openBranch(repoURL, sshKeyPEM)
+	}
+	return openWritableChannel(repoURL, sshKeyPEM, channel)
+}
+
+// messageStoreDir 返回频道 channel 的消息目录，channel 为空表示仓库默认的 messages/
+func messageStoreDir(channel string) string {
+	if channel == "" {
+		return messagesDir
+	}
+	return channelMessagesDir(channel)
+}
+
+// fetchMessages 分页读取频道 channel 的消息，channel 为空表示仓库默认的 messages/。
+// 仓库是轮换得到的后继仓库时（见 RotateRepo），读完本仓库的消息后沿轮换链继续读取前驱仓库
+func fetchMessages(repoURL, sshKeyPEM string, channel string, cursor string, max int) (*MessagePage, error) {
+	page := &MessagePage{Items: []Message{}}
+	url := repoURL
+	if chained, path, ok := parseChainCursor(cursor); ok {
+		url, cursor = chained, path
+	}
+	for hops := 0; ; hops++ {
+		key, err := chainKey(url, sshKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		limit := max
+		if max > 0 {
+			limit = max - len(page.Items)
+		}
+		part, prev, err := fetchStoreMessages(url, key, channel, cursor, limit)
+		if url != repoURL && errors.Is(err, ErrChannelNotFound) {
+			// 频道在轮换之后才创建，前驱仓库中没有
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hops == 0 {
+			page.HeadHash = part.HeadHash
+		}
+		page.Items = append(page.Items, part.Items...)
+		if part.Truncated {
+			page.Truncated = true
+			page.NextCursor = part.NextCursor
+			if url != repoURL {
+				page.NextCursor = chainCursor(url, part.NextCursor)
+			}
+			return page, nil
+		}
+		if prev == nil || hops+1 >= maxRotationHops {
+			return page, nil
+		}
+		if max > 0 && len(page.Items) >= max {
+			page.Truncated = true
+			page.NextCursor = chainCursor(prev.URL, "")
+			return page, nil
+		}
+		url, cursor = prev.URL, ""
+	}
+}
+
+// fetchStoreMessages 分页读取一个仓库中的消息，同时返回其前驱仓库的轮换指针（没有时为 nil）
+func fetchStoreMessages(repoURL, sshKeyPEM string, channel string, cursor string, max int) (*MessagePage, *RotationPointer, error) {
+	page := &MessagePage{Items: []Message{}}
+	h, head, err := readMessageStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return page, nil, nil
+	}
+	dir := messageStoreDir(channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	page.HeadHash = head.Hash().String()
+	prev, err := readRotationPointer(h, head.Hash(), predecessorPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths, err := messagePaths(h, head.Hash(), dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	deleted, err := tombstoned(h, head.Hash(), channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := 0
+	if cursor != "" {
+		// 游标是下一条消息的路径，游标指向的消息被删除后从其之前的消息继续
+		start = sort.Search(len(paths), func(i int) bool { return paths[i] <= cursor })
+	}
+	for i := start; i < len(paths); i++ {
+		if max > 0 && len(page.Items) >= max {
+			page.NextCursor = paths[i]
+			page.Truncated = true
+			break
+		}
+		msg, err := readMessage(h, head.Hash(), paths[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		observeClock(msg.clock())
+		if deleted[msg.ID] {
+			continue
+		}
+		if err := applyLatestEdit(h, head.Hash(), channel, msg); err != nil {
+			return nil, nil, err
+		}
+		page.Items = append(page.Items, *msg)
+	}
+	return page, prev, nil
+}
+
+// path 返回消息在消息目录 dir 下的文件路径，由发送时间和 ID 唯一确定
+func (m *Message) path(dir string) string {
+	t := time.UnixMilli(m.Timestamp).UTC()
+	return path.Join(dir, t.Format("2006-01-02"), fmt.Sprintf("%013d-%s.json", m.Timestamp, m.ID))
+}
+
+// messagePathTime 从消息文件名（<13 位毫秒时间戳>-<ID>.json）中取出发送时间
+func messagePathTime(p string) (int64, bool) {
+	ts, _, found := strings.Cut(path.Base(p), "-")
+	if !found {
+		return 0, false
+	}
+	millis, err := strconv.ParseInt(ts, 10, 64)
+	return millis, err == nil
+}
+
+// messagePaths 返回 commit 中消息目录 dir 下全部消息文件的路径，按时间从新到旧排列
+func messagePaths(h *history, commit plumbing.Hash, dir string) ([]string, error) {
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	sub, err := tree.Tree(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dir, err)
+	}
+
+	var paths []string
+	err = sub.Files().ForEach(func(f *object.File) error {
+		if strings.HasSuffix(f.Name, ".json") {
+			paths = append(paths, path.Join(dir, f.Name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths, nil
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// metaRefPrefix 是本库管理的元数据（已读标记、锁、审计记录等）所在的 ref 命名空间，
+// 与数据分支分开存放，不会污染数据分支的历史
+const metaRefPrefix = "refs/mixgram/"
+
+// metaFile 是元数据 commit 中保存内容的文件名
+const metaFile = "data"
+
+// ErrMetaNotFound 表示远端不存在该元数据
+var ErrMetaNotFound = errors.New("metadata not found")
+
+// WriteMeta 把 data 写入远端的 refs/mixgram/<name>。每次写入都是一个以上次内容为父的新 commit，
+// 因此保留了元数据的修改历史；并发写入导致非快进时返回错误。
+func WriteMeta(repoURL, sshKeyPEM string, name string, data []byte) error {
+	refName, err := metaRefName(name)
+	if err != nil {
+		return err
+	}
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return err
+	}
+
+	current, err := fetchRef(repo, auth, refName)
+	if err != nil {
+		return err
+	}
+	var parent plumbing.Hash
+	if current != nil {
+		parent = current.Hash()
+	}
+
+	_, err = commitRefFiles(repo, refName, parent, map[string][]byte{metaFile: data}, "update "+name)
+	if err != nil {
+		return err
+	}
+	return pushRefs(repo, auth, false, ggconfig.RefSpec(fmt.Sprintf("%s:%s", refName, refName)))
+}
+
+// ReadMeta 读取远端 refs/mixgram/<name> 的内容，不存在时返回 ErrMetaNotFound
+func ReadMeta(repoURL, sshKeyPEM string, name string) ([]byte, error) {
+	refName, err := metaRefName(name)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := fetchRef(repo, auth, refName)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, ErrMetaNotFound
+	}
+	return readRefFile(repo, ref.Hash(), metaFile)
+}
+
+// DeleteMeta 删除远端的 refs/mixgram/<name>
+func DeleteMeta(repoURL, sshKeyPEM string, name string) error {
+	refName, err := metaRefName(name)
+	if err != nil {
+		return err
+	}
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return err
+	}
+	return pushRefs(repo, auth, false, ggconfig.RefSpec(":"+refName.String()))
+}
+
+// ListMeta 列出远端所有元数据的名称
+func ListMeta(repoURL, sshKeyPEM string) ([]string, error) {
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	var refs []*plumbing.Reference
+	err = withRetry(repoURL, func(ctx context.Context) (err error) {
+		refs, err = remote.ListContext(ctx, &git.ListOptions{Auth: auth, ProxyOptions: utils.SSHProxyOptions(repoURL)})
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("list remote: %w", err)
+	}
+
+	names := []string{}
+	for _, ref := range refs {
+		if name, ok := strings.CutPrefix(ref.Name().String(), metaRefPrefix); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListMetaJSON 同 ListMeta，以 JSON 数组返回
+func ListMetaJSON(repoURL, sshKeyPEM string) (string, error) {
+	names, err := ListMeta(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(names)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func metaRefName(name string) (plumbing.ReferenceName, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", errors.New("metadata name is empty")
+	}
+	refName := plumbing.ReferenceName(metaRefPrefix + name)
+	if err := refName.Validate(); err != nil {
+		return "", fmt.Errorf("invalid metadata name %q: %w", name, err)
+	}
+	return refName, nil
+}
+
+// fetchRef 从 origin 取回单个 ref 及其对象，远端不存在该 ref 时返回 nil
+func fetchRef(repo *git.Repository, auth transport.AuthMethod, name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if err := fetchRefSpec(repo, auth, ggconfig.RefSpec(fmt.Sprintf("+%s:%s", name, name))); err != nil {
+		return nil, err
+	}
+	ref, err := repo.Reference(name, true)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ref, nil
+}
+
+// fetchRefSpec 按 refspec 从 origin 取回 ref，远端没有匹配的 ref 时不视为错误
+func fetchRefSpec(repo *git.Repository, auth transport.AuthMethod, spec ggconfig.RefSpec) error {
+	err := withRetry(originURL(repo), func(ctx context.Context) error {
+		return ignoreUpToDate(repo.FetchContext(ctx, &git.FetchOptions{
+			RemoteName:   git.DefaultRemoteName,
+			Auth:         auth,
+			RefSpecs:     []ggconfig.RefSpec{spec},
+			Progress:     io.Discard,
+			ProxyOptions: remoteProxy(repo),
+		}))
+	})
+	if err != nil && !errors.Is(err, git.NoMatchingRefSpecError{}) && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return fmt.Errorf("fetch %s: %w", spec, err)
+	}
+	return nil
+}
+
+// commitRefFiles 在 parent（可为零值）的树上修改文件，生成新的 commit 并把本地的 refName 指向它
+func commitRefFiles(repo *git.Repository, refName plumbing.ReferenceName, parent plumbing.Hash,
+	files map[string][]byte, message string) (plumbing.Hash, error) {
+	var baseTree plumbing.Hash
+	parents := []plumbing.Hash{}
+	if !parent.IsZero() {
+		c, err := repo.CommitObject(parent)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("load %s: %w", refName, err)
+		}
+		baseTree = c.TreeHash
+		parents = []plumbing.Hash{parent}
+	}
+
+	treeHash, err := editTree(repo.Storer, baseTree, files)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("edit tree: %w", err)
+	}
+
+	author, committer := identityFor(originURL(repo)).signatures()
+	obj := repo.Storer.NewEncodedObject()
+	err = (&object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      withProvenance(message),
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}).Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("encode commit: %w", err)
+	}
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store commit: %w", err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(refName, hash)); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("set ref: %w", err)
+	}
+	return hash, nil
+}
+
+// readRefFile 读取某个 commit 树中的文件内容
+func readRefFile(repo *git.Repository, commitHash plumbing.Hash, path string) ([]byte, error) {
+	c, err := repo.CommitObject(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.File(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return []byte(content), nil
+}
+
+// pushRefs 把若干 refspec 推送到 origin，远端已是最新时不视为错误。
+// 同时更新多个 ref 时请求原子推送（服务端不支持 atomic 时 go-git 会自动退化为普通推送），
+// 使观察者不会看到只更新了一部分的状态。强制推送被远端拒绝时返回 *ForcePushRejectedError
+func pushRefs(repo *git.Repository, auth transport.AuthMethod, force bool, specs ...ggconfig.RefSpec) error {
+	_, err := pushRefsProgress(repo, auth, force, specs...)
+	return err
+}
+
+// pushRefsProgress 同 pushRefs，并返回远端在推送过程中返回的消息
+func pushRefsProgress(repo *git.Repository, auth transport.AuthMethod, force bool, specs ...ggconfig.RefSpec) (string, error) {
+	var progress bytes.Buffer
+	err := withRetry(originURL(repo), func(ctx context.Context) error {
+		progress.Reset()
+		return ignoreUpToDate(repo.PushContext(ctx, &git.PushOptions{
+			RemoteName:   git.DefaultRemoteName,
+			Auth:         auth,
+			Force:        force,
+			Atomic:       len(specs) > 1,
+			RefSpecs:     specs,
+			Progress:     &progress,
+			ProxyOptions: remoteProxy(repo),
+		}))
+	})
+	if err != nil {
+		if isForcePush(force, specs) {
+			if rejected := forcePushRejection(err, progress.String()); rejected != nil {
+				return progress.String(), rejected
+			}
+		}
+		return progress.String(), fmt.Errorf("push: %w", err)
+	}
+	return progress.String(), nil
+}
+
+// ignoreUpToDate 把 NoErrAlreadyUpToDate 视为成功
+func ignoreUpToDate(err error) error {
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsSink 接收本库的运行指标，App 或服务端可以把它接入 Prometheus、统计 SDK 等，本库不依赖任何指标库。
+// 方法在执行操作的 goroutine 中同步调用，实现应当尽快返回并允许并发调用。
+// tagsJSON 是标签的 JSON 对象（键和值都是字符串），指标名和标签见 Metric* 常量
+type MetricsSink interface {
+	// Counter 把计数器 name 增加 delta
+	Counter(name string, delta int64, tagsJSON string)
+	// Gauge 把仪表 name 设为 value
+	Gauge(name string, value float64, tagsJSON string)
+	// Timing 记录一次耗时（毫秒）
+	Timing(name string, millis int64, tagsJSON string)
+}
+
+// 指标名。网络操作的标签 op 为 MetricOpClone 等，result 为 "ok" 或 "error"
+const (
+	// MetricOperations 计数网络操作和历史改写，标签 op、result；每次重试单独计数
+	MetricOperations = "mixgram_operations_total"
+	// MetricOperationDuration 是操作耗时，标签 op、result
+	MetricOperationDuration = "mixgram_operation_duration_ms"
+	// MetricBytesSent、MetricBytesReceived 是操作传输的字节数（同 TransferStats），标签 op
+	MetricBytesSent     = "mixgram_bytes_sent_total"
+	MetricBytesReceived = "mixgram_bytes_received_total"
+	// MetricObjectsSent、MetricObjectsReceived 是操作传输的对象数，标签 op
+	MetricObjectsSent     = "mixgram_objects_sent_total"
+	MetricObjectsReceived = "mixgram_objects_received_total"
+	// MetricRewrittenCommits 计数历史改写中被替换的 commit，标签 result
+	MetricRewrittenCommits = "mixgram_rewritten_commits_total"
+	// MetricNetworkInFlight 是正在进行的网络操作数（仪表，无标签）
+	MetricNetworkInFlight = "mixgram_network_operations_in_flight"
+)
+
+// 标签 op 的取值
+const (
+	// MetricOpPoll 只读取了远端引用
+	MetricOpPoll = "poll"
+	// MetricOpClone 在没有本地历史的情况下取回对象
+	MetricOpClone = "clone"
+	// MetricOpFetch 在已有历史上增量取回
+	MetricOpFetch = "fetch"
+	// MetricOpPush 推送（包括改写历史时的强制推送）
+	MetricOpPush = "push"
+	// MetricOpRewrite 改写历史，从校验改写结果到强制推送完成
+	MetricOpRewrite = "rewrite"
+	// MetricOpConnect 还没有建立任何会话就失败的网络操作
+	MetricOpConnect = "connect"
+)
+
+var (
+	metricsMu   sync.Mutex
+	metricsSink MetricsSink
+	inFlight    atomic.Int64
+)
+
+// SetMetricsSink 设置指标的接收者，传 nil 取消
+func SetMetricsSink(s MetricsSink) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricsSink = s
+}
+
+func currentMetricsSink() MetricsSink {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	return metricsSink
+}
+
+func metricTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeOperation 上报一次操作的次数和耗时
+func observeOperation(op string, d time.Duration, err error) {
+	sink := currentMetricsSink()
+	if sink == nil {
+		return
+	}
+	tags := metricTags(map[string]string{"op": op, "result": metricResult(err)})
+	sink.Counter(MetricOperations, 1, tags)
+	sink.Timing(MetricOperationDuration, d.Milliseconds(), tags)
+}
+
+// observeTransfer 上报一次网络操作的传输量，为 0 的项不上报
+func observeTransfer(op string, t TransferTotals) {
+	sink := currentMetricsSink()
+	if sink == nil {
+		return
+	}
+	tags := metricTags(map[string]string{"op": op})
+	for _, m := range []struct {
+		name  string
+		value int64
+	}{
+		{MetricBytesSent, t.BytesSent},
+		{MetricBytesReceived, t.BytesReceived},
+		{MetricObjectsSent, t.ObjectsSent},
+		{MetricObjectsReceived, t.ObjectsReceived},
+	} {
+		if m.value > 0 {
+			sink.Counter(m.name, m.value, tags)
+		}
+	}
+}
+
+// trackInFlight 把正在进行的网络操作数加 delta 并上报
+func trackInFlight(delta int64) {
+	n := inFlight.Add(delta)
+	if sink := currentMetricsSink(); sink != nil {
+		sink.Gauge(MetricNetworkInFlight, float64(n), "{}")
+	}
+}
+
+// observeRewrite 上报一次历史改写，rewritten 是被替换的 commit 数
+func observeRewrite(start time.Time, rewritten int, err error) {
+	observeOperation(MetricOpRewrite, time.Since(start), err)
+	if sink := currentMetricsSink(); sink != nil && rewritten > 0 {
+		sink.Counter(MetricRewrittenCommits, int64(rewritten), metricTags(map[string]string{"result": metricResult(err)}))
+	}
+}
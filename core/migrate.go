@@ -0,0 +1,500 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"path"
+	"sort"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// migrateRemote 是迁移时目标仓库在内存仓库中的远端名称，origin 指向源仓库
+const migrateRemote = "destination"
+
+// migrateRefSpecs 是迁移复制的引用：分支、标签、notes 和 refs/mixgram/ 下的元数据。
+// 不使用 refs/*，提供方的只读引用（如 refs/pull/）推送到其他提供方会被拒绝
+var migrateRefSpecs = []ggconfig.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+	"+refs/notes/*:refs/notes/*",
+	"+refs/mixgram/*:refs/mixgram/*",
+}
+
+// 迁移的阶段
+const (
+	MigrationStageFetch = "fetch"
+	MigrationStageTrim  = "trim"
+	MigrationStagePush  = "push"
+)
+
+// MigrationListener 接收 MigrateRepo 的进度
+type MigrationListener interface {
+	// OnMigrationProgress 的参数为 MigrationProgress 的 JSON
+	OnMigrationProgress(progressJSON string)
+}
+
+// MigrationProgress 是迁移的进度：fetch 阶段按引用命名空间计数，push 阶段按引用计数
+type MigrationProgress struct {
+	SrcURL string `json:"srcUrl"`
+	DstURL string `json:"dstUrl"`
+	Stage  string `json:"stage"`
+	// Ref 是刚处理完的命名空间或引用
+	Ref   string `json:"ref,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// MigrationResult 是 MigrateRepo 的结果
+type MigrationResult struct {
+	DefaultBranch string `json:"defaultBranch"`
+	// Refs 是复制的引用数量，其中 UpToDate 个在目标仓库中已是最新（例如续做中断的迁移）
+	Refs     int `json:"refs"`
+	UpToDate int `json:"upToDate"`
+	// Trimmed 是按保留策略从默认分支删除的 commit 数量
+	Trimmed int `json:"trimmed"`
+}
+
+var (
+	migrationMu       sync.Mutex
+	migrationListener MigrationListener
+)
+
+// SetMigrationListener 设置迁移进度的接收者，传 nil 取消
+func SetMigrationListener(l MigrationListener) {
+	migrationMu.Lock()
+	defer migrationMu.Unlock()
+	migrationListener = l
+}
+
+// MigrateRepo 把源仓库的全部分支、标签、notes 和元数据复制到目标仓库，用于迁移到其他提供方。
+// policy 不为 nil 时在复制前按保留策略裁剪默认分支（只影响目标仓库，源仓库保持不变，notes 随之迁移）；
+// 其他分支和标签原样复制，仍会带上它们引用的完整历史。
+// 目标仓库应为空仓库或一次中断的迁移的目标，已有引用与源仓库分叉时推送失败。默认分支最先推送
+func MigrateRepo(srcURL, srcSSHKeyPEM, dstURL, dstSSHKeyPEM string, policy *RetentionPolicy) (*MigrationResult, error) {
+	if srcURL == dstURL {
+		return nil, errors.New("source and destination are the same repository")
+	}
+	if dstSSHKeyPEM == "" {
+		dstSSHKeyPEM = srcSSHKeyPEM
+	}
+	srcAuth, err := utils.NewSSHAuthForURL(srcURL, srcSSHKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	dstAuth, err := utils.NewSSHAuthForURL(dstURL, dstSSHKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	branch := RepoBranch(srcURL)
+	if branch == "" {
+		if branch, err = ResolveDefaultBranch(srcURL, srcSSHKeyPEM); err != nil {
+			return nil, err
+		}
+	}
+	repo, err := utils.OpenRemote(srcURL)
+	if err != nil {
+		return nil, err
+	}
+	progress := MigrationProgress{SrcURL: srcURL, DstURL: dstURL, Stage: MigrationStageFetch, Total: len(migrateRefSpecs)}
+	for i, spec := range migrateRefSpecs {
+		if err := fetchRefSpec(repo, srcAuth, spec); err != nil {
+			return nil, err
+		}
+		progress.Ref, progress.Done = spec.Src(), i+1
+		reportMigration(progress)
+	}
+
+	result := &MigrationResult{DefaultBranch: branch}
+	if policy != nil && branch != "" {
+		h := &history{repoURL: dstURL, auth: srcAuth, repo: repo, refName: plumbing.NewBranchReferenceName(branch)}
+		if result.Trimmed, err = h.trimForMigration(policy); err != nil {
+			return nil, err
+		}
+		reportMigration(MigrationProgress{SrcURL: srcURL, DstURL: dstURL, Stage: MigrationStageTrim, Ref: h.refName.String(), Done: 1, Total: 1})
+	}
+
+	names, err := migrationRefs(repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repo.CreateRemote(&ggconfig.RemoteConfig{Name: migrateRemote, URLs: []string{dstURL}}); err != nil {
+		return nil, fmt.Errorf("add remote: %w", err)
+	}
+	defer shareRepo(dstURL)()
+	progress = MigrationProgress{SrcURL: srcURL, DstURL: dstURL, Stage: MigrationStagePush, Total: len(names)}
+	for i, name := range names {
+		upToDate, err := pushMigrationRef(repo, dstURL, dstAuth, name)
+		if err != nil {
+			return nil, fmt.Errorf("migrate %s: %w", name, err)
+		}
+		result.Refs++
+		if upToDate {
+			result.UpToDate++
+		}
+		progress.Ref, progress.Done = name.String(), i+1
+		reportMigration(progress)
+	}
+	return result, nil
+}
+
+// MigrateRepoJSON 同 MigrateRepo，policyJSON 为 RetentionPolicy 的 JSON（空字符串表示不裁剪），返回 MigrationResult 的 JSON
+func MigrateRepoJSON(srcURL, srcSSHKeyPEM, dstURL, dstSSHKeyPEM string, policyJSON string) (string, error) {
+	var policy *RetentionPolicy
+	if policyJSON != "" {
+		policy = &RetentionPolicy{}
+		if err := json.Unmarshal([]byte(policyJSON), policy); err != nil {
+			return "", fmt.Errorf("decode policy: %w", err)
+		}
+	}
+	result, err := MigrateRepo(srcURL, srcSSHKeyPEM, dstURL, dstSSHKeyPEM, policy)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// trimForMigration 在内存中按策略裁剪 h.refName 并迁移 notes，不推送，返回删除的 commit 数量
+func (h *history) trimForMigration(policy *RetentionPolicy) (int, error) {
+	head, err := h.repo.Storer.Reference(h.refName)
+	if err != nil {
+		return 0, fmt.Errorf("branch %s: %w", h.refName.Short(), err)
+	}
+	iter, err := h.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return 0, fmt.Errorf("log: %w", err)
+	}
+	err = iter.ForEach(func(c *object.Commit) error {
+		h.commits = append(h.commits, c)
+		return nil
+	})
+	iter.Close()
+	if err != nil {
+		return 0, fmt.Errorf("iterate log: %w", err)
+	}
+	keep, err := h.retainedCount(policy)
+	if err != nil || keep >= len(h.commits) {
+		return 0, err
+	}
+
+	// 保留原 committer，相同的策略总是得到相同的哈希，中断的迁移可以续做
+	newHead, err := h.trimmedHead(keep, &RewriteOptions{PreserveCommitter: true, TrimSummary: policy.WriteSummary})
+	if err != nil {
+		return 0, err
+	}
+	if err := h.repo.Storer.SetReference(plumbing.NewHashReference(h.refName, newHead)); err != nil {
+		return 0, fmt.Errorf("set ref: %w", err)
+	}
+	// remapNotes 直接更新本地的 notes 引用，随后与其他引用一起推送
+	if _, err := h.remapNotes(); err != nil {
+		return 0, err
+	}
+	return len(h.commits) - keep, nil
+}
+
+// migrationRefs 返回要推送的引用，默认分支排在最前面（多数提供方把第一个推送的分支设为默认分支）
+func migrationRefs(repo *git.Repository, branch string) ([]plumbing.ReferenceName, error) {
+	iter, err := repo.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var names []plumbing.ReferenceName
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		for _, spec := range migrateRefSpecs {
+			if spec.Match(ref.Name()) {
+				names = append(names, ref.Name())
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	first := plumbing.NewBranchReferenceName(branch)
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == first) != (names[j] == first) {
+			return names[i] == first
+		}
+		return names[i] < names[j]
+	})
+	return names, nil
+}
+
+// pushMigrationRef 把引用 name 推送到目标仓库（只接受快进），返回目标仓库是否已是最新
+func pushMigrationRef(repo *git.Repository, dstURL string, auth transport.AuthMethod, name plumbing.ReferenceName) (bool, error) {
+	var upToDate bool
+	err := withRetry(dstURL, func(ctx context.Context) error {
+		err := repo.PushContext(ctx, &git.PushOptions{
+			RemoteName:   migrateRemote,
+			Auth:         auth,
+			RefSpecs:     []ggconfig.RefSpec{ggconfig.RefSpec(fmt.Sprintf("%s:%s", name, name))},
+			Progress:     io.Discard,
+			ProxyOptions: utils.SSHProxyOptions(dstURL),
+		})
+		upToDate = errors.Is(err, git.NoErrAlreadyUpToDate)
+		return ignoreUpToDate(err)
+	})
+	return upToDate, err
+}
+
+func reportMigration(p MigrationProgress) {
+	migrationMu.Lock()
+	l := migrationListener
+	migrationMu.Unlock()
+	if l == nil {
+		return
+	}
+	data, _ := json.Marshal(p)
+	l.OnMigrationProgress(string(data))
+}
+
+// ChannelMoveResult 是 MoveChannel 的结果
+type ChannelMoveResult struct {
+	SrcURL  string `json:"srcUrl"`
+	DstURL  string `json:"dstUrl"`
+	Channel string `json:"channel"`
+	// Files 是复制的频道文件数量，Attachments 是随消息复制的附件数量
+	Files       int `json:"files"`
+	Attachments int `json:"attachments"`
+	// SrcCommit 是源仓库中标记频道已迁移的 commit，DstCommit 是目标仓库中写入频道的 commit
+	SrcCommit string `json:"srcCommit"`
+	DstCommit string `json:"dstCommit"`
+}
+
+// MoveChannel 把频道 channel 从 srcURL 迁移到 dstURL。先在源仓库的 channel.json 中记录迁移目标，
+// 之后向源仓库的该频道写入返回 ErrChannelMoved；再把频道目录下的全部文件、消息引用的附件（见 MessageTypeAttachment）
+// 和频道信息在一次提交中写入目标仓库，两边的 ListChannels 随之更新，CurrentChannelRepo 可沿迁移记录找到新仓库。
+// 目标仓库可以为空；dstSSHKeyPEM 为空时使用 srcSSHKeyPEM。中断后用相同参数重新调用会继续完成迁移
+func MoveChannel(srcURL, srcSSHKeyPEM string, channel string, dstURL, dstSSHKeyPEM string) (*ChannelMoveResult, error) {
+	if srcURL == dstURL {
+		return nil, errors.New("source and destination are the same repository")
+	}
+	if err := validateChannelName(channel); err != nil {
+		return nil, err
+	}
+	if dstSSHKeyPEM == "" {
+		dstSSHKeyPEM = srcSSHKeyPEM
+	}
+	result := &ChannelMoveResult{SrcURL: srcURL, DstURL: dstURL, Channel: channel}
+
+	// 目标仓库已有同名频道时不能标记源仓库，除非是续做已经写入目标仓库的迁移
+	dh, dhead, err := openBranch(dstURL, dstSSHKeyPEM)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, err
+	}
+	dstHas := false
+	if dh != nil {
+		if _, err := readChannel(dh, dhead.Hash(), channel); err == nil {
+			dstHas = true
+		} else if !errors.Is(err, ErrChannelNotFound) {
+			return nil, err
+		}
+	}
+
+	h, head, err := openChannel(srcURL, srcSSHKeyPEM, channel)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := readChannel(h, head.Hash(), channel)
+	if err != nil {
+		return nil, err
+	}
+	srcHead := head.Hash()
+	switch {
+	case ch.MovedTo != nil && ch.MovedTo.URL != dstURL:
+		return nil, ch.writable()
+	case ch.MovedTo == nil && dstHas:
+		return nil, fmt.Errorf("%w in %s", ErrChannelExists, dstURL)
+	case ch.MovedTo == nil:
+		ch.MovedTo = &RotationPointer{URL: dstURL, Head: srcHead.String(), RotatedAt: commitTime().UnixMilli()}
+		data, err := json.Marshal(ch)
+		if err != nil {
+			return nil, err
+		}
+		infoPath := path.Join(channelsDir, channel, channelInfoFile)
+		if srcHead, err = h.commitAndPush(srcHead, "move channel "+channel+" to "+dstURL, map[string][]byte{infoPath: data}); err != nil {
+			return nil, err
+		}
+	}
+	result.SrcCommit = srcHead.String()
+	reportMigration(MigrationProgress{SrcURL: srcURL, DstURL: dstURL, Stage: MigrationStageFetch, Ref: channel, Done: 1, Total: 1})
+
+	if dstHas {
+		result.DstCommit = dhead.Hash().String()
+		reportMigration(MigrationProgress{SrcURL: srcURL, DstURL: dstURL, Stage: MigrationStagePush, Ref: channel, Done: 1, Total: 1})
+		return result, nil
+	}
+	files, err := h.channelFiles(srcHead, channel, result)
+	if err != nil {
+		return nil, err
+	}
+	ch.MovedTo = nil
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return nil, err
+	}
+	files[path.Join(channelsDir, channel, channelInfoFile)] = data
+
+	commitMsg := "move channel " + channel + " from " + srcURL
+	if dh == nil {
+		auth, err := utils.NewSSHAuthForURL(dstURL, dstSSHKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		if result.DstCommit, err = initRemote(dstURL, auth, initBranch(dstURL), files, commitMsg); err != nil {
+			return nil, err
+		}
+	} else {
+		newHead, err := dh.commitAndPush(dhead.Hash(), commitMsg, files)
+		if err != nil {
+			return nil, err
+		}
+		result.DstCommit = newHead.String()
+	}
+	reportMigration(MigrationProgress{SrcURL: srcURL, DstURL: dstURL, Stage: MigrationStagePush, Ref: channel, Done: 1, Total: 1})
+	return result, nil
+}
+
+// MoveChannelJSON 同 MoveChannel，返回 ChannelMoveResult 的 JSON
+func MoveChannelJSON(srcURL, srcSSHKeyPEM string, channel string, dstURL, dstSSHKeyPEM string) (string, error) {
+	result, err := MoveChannel(srcURL, srcSSHKeyPEM, channel, dstURL, dstSSHKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// CurrentChannelRepo 沿频道的迁移记录返回当前存放频道 channel 的仓库（未迁移时返回 repoURL 本身），
+// 其他仓库的私钥来自 SetRotationKeys
+func CurrentChannelRepo(repoURL, sshKeyPEM string, channel string) (string, error) {
+	url := repoURL
+	for range maxRotationHops {
+		key, err := chainKey(url, sshKeyPEM)
+		if err != nil {
+			return "", err
+		}
+		h, head, err := readBranch(url, key)
+		if err != nil {
+			return "", err
+		}
+		ch, err := readChannel(h, head.Hash(), channel)
+		if err != nil {
+			return "", err
+		}
+		if ch.MovedTo == nil {
+			return url, nil
+		}
+		url = ch.MovedTo.URL
+	}
+	return "", errors.New("channel move chain too long")
+}
+
+// channelFiles 返回 commit 中频道目录下的全部文件（用户内容已还原转存并经过 FetchHook，在目标仓库提交时重新转换），
+// 以及频道消息引用的附件的清单、分块和内容索引，不含 channel.json
+func (h *history) channelFiles(commit plumbing.Hash, channel string, result *ChannelMoveResult) (map[string][]byte, error) {
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	dir := path.Join(channelsDir, channel)
+	sub, err := tree.Tree(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dir, err)
+	}
+	files := map[string][]byte{}
+	err = sub.Files().ForEach(func(f *object.File) error {
+		if f.Name == channelInfoFile {
+			return nil
+		}
+		p := path.Join(dir, f.Name)
+		content, err := readCommitFile(h.repoURL, h.repo, commit, p)
+		if err != nil {
+			return err
+		}
+		files[p] = content
+		result.Files++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := messagePaths(h, commit, channelMessagesDir(channel))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		msg, err := readMessage(h, commit, p)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Type != MessageTypeAttachment {
+			continue
+		}
+		manifestPath := path.Join(attachmentsDir, msg.Body+".json")
+		if _, ok := files[manifestPath]; ok {
+			continue
+		}
+		m, err := readAttachmentManifest(h, commit, msg.Body)
+		if errors.Is(err, ErrAttachmentNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 内容索引只用于上传时去重，缺失时不影响附件本身
+		indexPath := path.Join(attachmentIndexDir, m.SHA256)
+		if content, err := readRefFile(h.repo, commit, indexPath); err == nil {
+			files[indexPath] = content
+		} else if !errors.Is(err, object.ErrFileNotFound) {
+			return nil, err
+		}
+		for _, p := range append([]string{manifestPath}, chunkPaths(m)...) {
+			if _, ok := files[p]; ok {
+				continue
+			}
+			content, err := readRefFile(h.repo, commit, p)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %s: %w", m.ID, err)
+			}
+			files[p] = content
+		}
+		result.Attachments++
+	}
+	return files, nil
+}
+
+// chunkPaths 返回附件各分块的路径
+func chunkPaths(m *AttachmentManifest) []string {
+	paths := make([]string, len(m.Chunks))
+	for i, sum := range m.Chunks {
+		paths[i] = path.Join(attachmentChunksDir, sum)
+	}
+	return paths
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+
+	"github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// 镜像推送的状态
+const (
+	// MirrorStatusRepaired 表示镜像与主仓库已经分叉，按 repair 参数强制推送为主仓库的内容
+	MirrorStatusRepaired = "repaired"
+	// MirrorStatusFailed 表示推送到该镜像失败，见 Error
+	MirrorStatusFailed = "failed"
+)
+
+// RemoteConfig 是一个远端仓库及访问它使用的 SSH 私钥
+type RemoteConfig struct {
+	URL string `json:"url"`
+	// SSHKeyPEM 为空时使用第一个远端的私钥
+	SSHKeyPEM string `json:"sshKeyPem,omitempty"`
+}
+
+// MirrorStatus 是推送到一个镜像的结果，Status 为 PushStatusPushed、PushStatusUpToDate、MirrorStatusRepaired 或 MirrorStatusFailed
+type MirrorStatus struct {
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// MirrorPushResult 是 PushCommitMirrored 的结果
+type MirrorPushResult struct {
+	// Primary 是在第一个远端上提交推送的结果
+	Primary *PushResult    `json:"primary"`
+	Mirrors []MirrorStatus `json:"mirrors"`
+}
+
+// PushCommitMirrored 同 PushCommit，但只在 remotes[0] 上提交一次，然后把得到的分支推送到其余远端作为镜像。
+// 落后的镜像会直接快进到主仓库的分支；与主仓库分叉的镜像在 repair 为 true 时强制推送修复，否则记为失败。
+// 主仓库推送失败时返回错误，镜像失败只记录在结果中
+func PushCommitMirrored(remotes []RemoteConfig, commitMsg string, repair bool) (*MirrorPushResult, error) {
+	if len(remotes) == 0 {
+		return nil, errors.New("no remotes")
+	}
+	primary := remotes[0]
+	if err := validateCommit(primary.URL, commitMsg); err != nil {
+		return nil, err
+	}
+	pushed, err := pushCommit(primary.URL, primary.SSHKeyPEM, commitMsg)
+	if err != nil {
+		return nil, err
+	}
+	result := &MirrorPushResult{Primary: pushed, Mirrors: []MirrorStatus{}}
+	if len(remotes) == 1 {
+		return result, nil
+	}
+
+	// 从主仓库取回刚推送的分支，作为推送到镜像的对象来源
+	auth, err := utils.NewSSHAuthForURL(primary.URL, primary.SSHKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := utils.OpenRemote(primary.URL)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := fetchRef(repo, auth, plumbing.NewBranchReferenceName(pushed.Branch))
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("branch %s not found on %s", pushed.Branch, primary.URL)
+	}
+
+	for i, mirror := range remotes[1:] {
+		key := mirror.SSHKeyPEM
+		if key == "" {
+			key = primary.SSHKeyPEM
+		}
+		status, err := pushMirror(repo, fmt.Sprintf("mirror-%d", i), mirror.URL, key, ref, repair)
+		if err != nil {
+			status = MirrorStatusFailed
+		}
+		ms := MirrorStatus{URL: mirror.URL, Status: status}
+		if err != nil {
+			ms.Error = err.Error()
+		}
+		result.Mirrors = append(result.Mirrors, ms)
+	}
+	return result, nil
+}
+
+// PushCommitMirroredJSON 同 PushCommitMirrored，remotesJSON 是 RemoteConfig 的 JSON 数组，返回 MirrorPushResult 的 JSON
+func PushCommitMirroredJSON(remotesJSON string, commitMsg string, repair bool) (string, error) {
+	var remotes []RemoteConfig
+	if err := json.Unmarshal([]byte(remotesJSON), &remotes); err != nil {
+		return "", fmt.Errorf("parse remotes: %w", err)
+	}
+	result, err := PushCommitMirrored(remotes, commitMsg, repair)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// pushMirror 把 repo 中的分支 ref 推送到镜像 url，镜像已分叉且 repair 为 true 时强制推送
+func pushMirror(repo *git.Repository, name, url, sshKeyPEM string, ref *plumbing.Reference, repair bool) (string, error) {
+	auth, err := utils.NewSSHAuthForURL(url, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	if _, err := repo.CreateRemote(&ggconfig.RemoteConfig{Name: name, URLs: []string{url}}); err != nil {
+		return "", fmt.Errorf("add remote: %w", err)
+	}
+	push := func(force bool) error {
+		spec := ggconfig.RefSpec(fmt.Sprintf("%s:%s", ref.Hash(), ref.Name()))
+		if force {
+			spec = "+" + spec
+		}
+		return withRetry(url, func(ctx context.Context) error {
+			return repo.PushContext(ctx, &git.PushOptions{
+				RemoteName:   name,
+				Auth:         auth,
+				RefSpecs:     []ggconfig.RefSpec{spec},
+				Progress:     io.Discard,
+				ProxyOptions: utils.SSHProxyOptions(url),
+			})
+		})
+	}
+
+	unlock := shareRepo(url)
+	defer unlock()
+	err = push(false)
+	switch {
+	case err == nil:
+		return PushStatusPushed, nil
+	case errors.Is(err, git.NoErrAlreadyUpToDate):
+		return PushStatusUpToDate, nil
+	case !repair || !isPushConflict(err):
+		return "", fmt.Errorf("push: %w", err)
+	}
+	if err := push(true); err != nil {
+		if rejected := forcePushRejection(err, ""); rejected != nil {
+			return "", rejected
+		}
+		return "", fmt.Errorf("repair: %w", err)
+	}
+	return MirrorStatusRepaired, nil
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// undoEntry 记录被删除的 commit 对一个路径的修改：want 是其写入的内容（零值表示删除了该路径），
+// restore 是修改前的内容（nil 表示原本不存在）
+type undoEntry struct {
+	want    plumbing.Hash
+	restore []byte
+}
+
+// DeleteCommitsByAuthor 在一次重写中删除历史中作者邮箱为 email（不区分大小写）的全部 commit 并强制推送，
+// 用于清理被盗用身份发送的垃圾消息。
+// 与 DeleteCommit 不同，被删除的 commit 写入的文件也会从之后的历史中移除；之后又被其他 commit 修改过的文件保留修改后的内容。
+// 返回删除的 commit 数量。此操作会重写历史记录。
+func DeleteCommitsByAuthor(repoURL, sshKeyPEM string, email string) (int, error) {
+	return DeleteCommitsByAuthorInRange(repoURL, sshKeyPEM, email, 0, 0)
+}
+
+// DeleteCommitsByAuthorInRange 同 DeleteCommitsByAuthor，只删除作者时间在 [fromMillis, toMillis]（Unix 毫秒）内的 commit，
+// 0 表示该侧不限制
+func DeleteCommitsByAuthorInRange(repoURL, sshKeyPEM string, email string, fromMillis, toMillis int64) (int, error) {
+	if email == "" {
+		return 0, errors.New("email is empty")
+	}
+	match := func(c *object.Commit) bool {
+		at := c.Author.When.UnixMilli()
+		return strings.EqualFold(c.Author.Email, email) &&
+			(fromMillis == 0 || at >= fromMillis) && (toMillis == 0 || at <= toMillis)
+	}
+
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return 0, err
+	}
+	chain := rootToHead(h.commits)
+	first := -1
+	var kept []*object.Commit
+	for i, c := range chain {
+		if match(c) {
+			if first < 0 {
+				first = i
+			}
+		} else if first >= 0 {
+			kept = append(kept, c)
+		}
+	}
+	if first < 0 {
+		return 0, nil
+	}
+	removed := len(chain) - first - len(kept)
+	if removed == len(h.commits) {
+		return 0, errors.New("cannot delete every commit in the repository")
+	}
+
+	// 按从旧到新的顺序逐段重放：遇到要删除的 commit 记下它的修改，之后保留的 commit 撤销其中仍未被改动的部分
+	undo := map[string]*undoEntry{}
+	var editErr error
+	modify := func(old, c *object.Commit) bool {
+		files, err := h.undoFiles(old.TreeHash, undo)
+		if err == nil && len(files) > 0 {
+			c.TreeHash, err = editTree(h.repo.Storer, old.TreeHash, files)
+		}
+		if err != nil {
+			editErr = err
+			return false
+		}
+		return c.TreeHash != old.TreeHash
+	}
+	var parent plumbing.Hash
+	if first > 0 {
+		parent = chain[first-1].Hash
+	}
+	segment := []*object.Commit{}
+	for _, c := range chain[first:] {
+		if !match(c) {
+			segment = append(segment, c)
+			continue
+		}
+		if parent, err = h.relink(parent, segment, nil, modify); err != nil {
+			return 0, err
+		}
+		if editErr != nil {
+			return 0, fmt.Errorf("remove content: %w", editErr)
+		}
+		segment = segment[:0]
+		if err := h.recordUndo(c, undo); err != nil {
+			return 0, err
+		}
+	}
+	if parent, err = h.relink(parent, segment, nil, modify); err != nil {
+		return 0, err
+	}
+	if editErr != nil {
+		return 0, fmt.Errorf("remove content: %w", editErr)
+	}
+	if err := h.forcePush(parent); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("成功删除 %d 个 commit，并重写历史\n", removed)
+	return removed, nil
+}
+
+// recordUndo 记录要删除的 commit c 相对于其第一个父 commit 的修改
+func (h *history) recordUndo(c *object.Commit, undo map[string]*undoEntry) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return fmt.Errorf("get tree for commit %s: %w", c.Hash, err)
+	}
+	var parentTree *object.Tree // 根提交的父树视为空树
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return fmt.Errorf("load parent: %w", err)
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return fmt.Errorf("get parent tree: %w", err)
+		}
+	}
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	for _, change := range changes {
+		path, from, to := change.To.Name, change.From.TreeEntry.Hash, change.To.TreeEntry.Hash
+		if path == "" {
+			path = change.From.Name
+		}
+		if prev := undo[path]; prev != nil && prev.want == from {
+			// 连续被要删除的 commit 修改，恢复到第一次修改之前的内容
+			prev.want = to
+			continue
+		}
+		entry := &undoEntry{want: to}
+		if change.From.Name != "" {
+			file, err := parentTree.TreeEntryFile(&change.From.TreeEntry)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", path, err)
+			}
+			content, err := file.Contents()
+			if err != nil {
+				return fmt.Errorf("read %s: %w", path, err)
+			}
+			entry.restore = []byte(content)
+		}
+		undo[path] = entry
+	}
+	return nil
+}
+
+// undoFiles 返回在树 treeHash 上撤销 undo 所需的文件修改。
+// 路径已被保留的 commit 改成其他内容时不再撤销，并从 undo 中移除
+func (h *history) undoFiles(treeHash plumbing.Hash, undo map[string]*undoEntry) (map[string][]byte, error) {
+	if len(undo) == 0 {
+		return nil, nil
+	}
+	tree, err := object.GetTree(h.repo.Storer, treeHash)
+	if err != nil {
+		return nil, fmt.Errorf("get tree %s: %w", treeHash, err)
+	}
+	files := map[string][]byte{}
+	for path, u := range undo {
+		var current plumbing.Hash
+		entry, err := tree.FindEntry(path)
+		if err == nil {
+			current = entry.Hash
+		} else if !errors.Is(err, object.ErrEntryNotFound) && !errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, fmt.Errorf("find %s: %w", path, err)
+		}
+		if current != u.want {
+			delete(undo, path)
+			continue
+		}
+		files[path] = u.restore
+	}
+	return files, nil
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// MultiFetchConcurrency 是 FetchCommitsMulti 同时克隆的仓库数量上限
+var MultiFetchConcurrency = 4
+
+// FeedCommit 是聚合 feed 中的一个 commit，RepoURL 标明来源仓库
+type FeedCommit struct {
+	SimpleCommit
+	RepoURL string `json:"repoUrl"`
+}
+
+// RepoFetchError 是聚合 feed 中一个仓库的取回错误
+type RepoFetchError struct {
+	RepoURL string `json:"repoUrl"`
+	Error   string `json:"error"`
+}
+
+// CommitFeed 是多个仓库按时间合并后的 commit feed
+type CommitFeed struct {
+	// Items 按作者时间从新到旧排列
+	Items []FeedCommit `json:"items"`
+	// Heads 是每个仓库本次看到的远端 head，可用于之后的增量检查（HasNewCommits）
+	Heads map[string]string `json:"heads"`
+	// Errors 是取回失败的仓库，其他仓库的结果不受影响
+	Errors []RepoFetchError `json:"errors,omitempty"`
+}
+
+// FetchCommitsMulti 并发取回 repoURLsJSON（仓库地址的 JSON 数组）中每个仓库最近的 max 条 commit，
+// 按时间合并为一个 feed 并截取最新的 max 条（max <= 0 表示全部），返回 CommitFeed 的 JSON。
+// 每个仓库的私钥由 keys 提供，最多同时克隆 MultiFetchConcurrency 个仓库；
+// 单个仓库失败时记录在 Errors 中，不影响其他仓库
+func FetchCommitsMulti(repoURLsJSON string, keys KeyProvider, max int) (string, error) {
+	var repoURLs []string
+	if err := json.Unmarshal([]byte(repoURLsJSON), &repoURLs); err != nil {
+		return "", fmt.Errorf("parse repo urls: %w", err)
+	}
+	data, err := json.Marshal(fetchCommitsMulti(repoURLs, keys, max))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func fetchCommitsMulti(repoURLs []string, keys KeyProvider, max int) *CommitFeed {
+	workers := MultiFetchConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	seen := map[string]bool{}
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, u := range repoURLs {
+			if !seen[u] {
+				seen[u] = true
+				jobs <- u
+			}
+		}
+	}()
+
+	feed := &CommitFeed{Items: []FeedCommit{}, Heads: map[string]string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for repoURL := range jobs {
+				commits, head, err := fetchFeedCommits(repoURL, keys, max)
+				mu.Lock()
+				if err != nil {
+					feed.Errors = append(feed.Errors, RepoFetchError{RepoURL: repoURL, Error: err.Error()})
+				} else {
+					feed.Items = append(feed.Items, commits...)
+					feed.Heads[repoURL] = head
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(feed.Items, func(i, j int) bool {
+		a, b := feed.Items[i], feed.Items[j]
+		if a.Date != b.Date {
+			return a.Date > b.Date
+		}
+		return a.RepoURL < b.RepoURL
+	})
+	if max > 0 && len(feed.Items) > max {
+		feed.Items = feed.Items[:max]
+	}
+	sort.Slice(feed.Errors, func(i, j int) bool { return feed.Errors[i].RepoURL < feed.Errors[j].RepoURL })
+	return feed
+}
+
+// fetchFeedCommits 取回一个仓库最近的 max 条 commit（从新到旧）以及远端 head，空仓库没有 commit
+func fetchFeedCommits(repoURL string, keys KeyProvider, max int) ([]FeedCommit, string, error) {
+	key, err := keys.SSHKey(repoURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("ssh key for %s: %w", repoURL, err)
+	}
+	var commits []FeedCommit
+	head, _, err := walkCommits(repoURL, key, "", max, func(c SimpleCommit) error {
+		commits = append(commits, FeedCommit{SimpleCommit: c, RepoURL: repoURL})
+		return nil
+	})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return commits, head, nil
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// defaultNotesNamespace 是 git 默认的 notes 命名空间（refs/notes/commits）
+const defaultNotesNamespace = "commits"
+
+var (
+	// ErrNoteNotFound 表示该 commit 没有 note
+	ErrNoteNotFound = errors.New("note not found")
+	// ErrNoteExists 表示该 commit 已经有 note
+	ErrNoteExists = errors.New("note already exists")
+)
+
+// AddNote 给 commit 添加一条 note 并推送到 refs/notes/<namespace>（空字符串表示 commits），
+// 已存在时返回 ErrNoteExists。note 不改变 commit 本身，因此不需要重写历史。
+func AddNote(repoURL, sshKeyPEM string, namespace, commitHash, note string) error {
+	return editNotes(repoURL, sshKeyPEM, namespace, "add note", func(n *notesRef) error {
+		if _, err := n.read(commitHash); err == nil {
+			return ErrNoteExists
+		}
+		n.changes[commitHash] = []byte(note)
+		return nil
+	})
+}
+
+// UpdateNote 设置（覆盖）commit 的 note 并推送，不存在时直接创建
+func UpdateNote(repoURL, sshKeyPEM string, namespace, commitHash, note string) error {
+	return editNotes(repoURL, sshKeyPEM, namespace, "update note", func(n *notesRef) error {
+		n.changes[commitHash] = []byte(note)
+		return nil
+	})
+}
+
+// RemoveNote 删除 commit 的 note 并推送，不存在时返回 ErrNoteNotFound
+func RemoveNote(repoURL, sshKeyPEM string, namespace, commitHash string) error {
+	return editNotes(repoURL, sshKeyPEM, namespace, "remove note", func(n *notesRef) error {
+		if _, err := n.read(commitHash); err != nil {
+			return err
+		}
+		n.changes[commitHash] = nil
+		return nil
+	})
+}
+
+// ReadNote 读取 commit 的 note，不存在时返回 ErrNoteNotFound
+func ReadNote(repoURL, sshKeyPEM string, namespace, commitHash string) (string, error) {
+	n, err := openNotes(repoURL, sshKeyPEM, namespace)
+	if err != nil {
+		return "", err
+	}
+	content, err := n.read(commitHash)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// ReadNotesJSON 读取命名空间下的全部 note，返回 commit 哈希到 note 内容的 JSON 对象
+func ReadNotesJSON(repoURL, sshKeyPEM string, namespace string) (string, error) {
+	n, err := openNotes(repoURL, sshKeyPEM, namespace)
+	if err != nil {
+		return "", err
+	}
+	notes, err := n.all()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(notes)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// notesRef 是从远端取回的一个 notes ref
+type notesRef struct {
+	auth    transport.AuthMethod
+	repo    *git.Repository
+	refName plumbing.ReferenceName
+	head    plumbing.Hash     // notes ref 当前指向的 commit，零值表示尚无 note
+	changes map[string][]byte // 待写入的修改，key 为 commit 哈希，nil 表示删除
+}
+
+func notesRefName(namespace string) (plumbing.ReferenceName, error) {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		namespace = defaultNotesNamespace
+	}
+	refName := plumbing.ReferenceName("refs/notes/" + namespace)
+	if err := refName.Validate(); err != nil {
+		return "", fmt.Errorf("invalid notes namespace %q: %w", namespace, err)
+	}
+	return refName, nil
+}
+
+// openNotes 只取回 notes ref，不克隆数据分支
+func openNotes(repoURL, sshKeyPEM string, namespace string) (*notesRef, error) {
+	refName, err := notesRefName(namespace)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := fetchRef(repo, auth, refName)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &notesRef{auth: auth, repo: repo, refName: refName, changes: map[string][]byte{}}
+	if ref != nil {
+		n.head = ref.Hash()
+	}
+	return n, nil
+}
+
+// editNotes 取回 notes ref，由 edit 填写修改后提交并推送
+func editNotes(repoURL, sshKeyPEM string, namespace, message string, edit func(*notesRef) error) error {
+	n, err := openNotes(repoURL, sshKeyPEM, namespace)
+	if err != nil {
+		return err
+	}
+	if err := edit(n); err != nil {
+		return err
+	}
+	if err := n.commit(message); err != nil {
+		return err
+	}
+	// 重写历史时会迁移 notes，不能与之并发
+	defer shareRepo(repoURL)()
+	return pushRefs(n.repo, n.auth, false, n.refSpec())
+}
+
+// commit 把 changes 写入 notes ref 的新 commit
+func (n *notesRef) commit(message string) error {
+	n.removeFanout()
+	head, err := commitRefFiles(n.repo, n.refName, n.head, n.changes, message)
+	if err != nil {
+		return err
+	}
+	n.head = head
+	n.changes = map[string][]byte{}
+	return nil
+}
+
+func (n *notesRef) refSpec() ggconfig.RefSpec {
+	return ggconfig.RefSpec(fmt.Sprintf("%s:%s", n.refName, n.refName))
+}
+
+// read 读取 commit 的 note，兼容 git 的 fanout 目录布局（ab/cdef...）
+func (n *notesRef) read(commitHash string) ([]byte, error) {
+	if n.head.IsZero() {
+		return nil, ErrNoteNotFound
+	}
+	for _, path := range notePaths(commitHash) {
+		content, err := readRefFile(n.repo, n.head, path)
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, object.ErrFileNotFound) {
+			return nil, err
+		}
+	}
+	return nil, ErrNoteNotFound
+}
+
+// removeFanout 写入前删除 fanout 布局下的同名 note，保证一个 commit 只有一份 note（统一写为平铺布局）
+func (n *notesRef) removeFanout() {
+	if n.head.IsZero() {
+		return
+	}
+	for commitHash := range n.changes {
+		for _, path := range notePaths(commitHash)[1:] {
+			if _, err := readRefFile(n.repo, n.head, path); err == nil {
+				n.changes[path] = nil
+			}
+		}
+	}
+}
+
+// all 返回全部 note
+func (n *notesRef) all() (map[string]string, error) {
+	notes := map[string]string{}
+	if n.head.IsZero() {
+		return notes, nil
+	}
+	c, err := n.repo.CommitObject(n.head)
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.Files()
+	if err != nil {
+		return nil, err
+	}
+	err = files.ForEach(func(f *object.File) error {
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		notes[strings.ReplaceAll(f.Name, "/", "")] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read notes: %w", err)
+	}
+	return notes, nil
+}
+
+// notePaths 返回 note 可能所在的路径：平铺布局以及 git 的两级 fanout 布局
+func notePaths(commitHash string) []string {
+	paths := []string{commitHash}
+	if len(commitHash) > 2 {
+		paths = append(paths, commitHash[:2]+"/"+commitHash[2:])
+	}
+	if len(commitHash) > 4 {
+		paths = append(paths, commitHash[:2]+"/"+commitHash[2:4]+"/"+commitHash[4:])
+	}
+	return paths
+}
+
+// remapNotes 取回远端全部 notes，把重写前 commit 上的 note 迁移到重写后的 commit 上，
+// 返回需要与分支一起推送的 notes refspec
+func (h *history) remapNotes() ([]ggconfig.RefSpec, error) {
+	if err := fetchRefSpec(h.repo, h.auth, ggconfig.RefSpec("+refs/notes/*:refs/notes/*")); err != nil {
+		return nil, err
+	}
+	refs, err := h.repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	var specs []ggconfig.RefSpec
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !strings.HasPrefix(ref.Name().String(), "refs/notes/") || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		n := &notesRef{auth: h.auth, repo: h.repo, refName: ref.Name(), head: ref.Hash(), changes: map[string][]byte{}}
+		for old, rewritten := range h.rewritten {
+			content, err := n.read(old.String())
+			if errors.Is(err, ErrNoteNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n.changes[old.String()] = nil
+			n.changes[rewritten.String()] = content
+		}
+		if len(n.changes) == 0 {
+			return nil
+		}
+		if err := n.commit("remap notes after history rewrite"); err != nil {
+			return err
+		}
+		specs = append(specs, n.refSpec())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("remap notes: %w", err)
+	}
+	return specs, nil
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+// 仓库的对象格式（哈希算法）
+const (
+	ObjectFormatSHA1   = "sha1"
+	ObjectFormatSHA256 = "sha256"
+)
+
+// ErrUnsupportedObjectFormat 表示远端仓库的对象格式不受支持
+var ErrUnsupportedObjectFormat = errors.New("unsupported object format")
+
+// ObjectFormatError 描述远端与本地不一致的对象格式，errors.Is(err, ErrUnsupportedObjectFormat) 为 true
+type ObjectFormatError struct {
+	Remote    string
+	Supported string
+}
+
+func (e *ObjectFormatError) Error() string {
+	return fmt.Sprintf("remote repository uses %s objects, only %s is supported", e.Remote, e.Supported)
+}
+
+func (e *ObjectFormatError) Unwrap() error {
+	return ErrUnsupportedObjectFormat
+}
+
+// SupportedObjectFormat 返回本库支持的对象格式。go-git v5 的 sha256 构建标签只改变本地哈希算法，
+// 引用通告和 pack 协议仍按 sha1 解析，因此目前只支持 sha1 仓库
+func SupportedObjectFormat() string {
+	return ObjectFormatSHA1
+}
+
+// objectFormatError 把因哈希长度不符而无法解析引用通告的错误转换为 ObjectFormatError，其他错误原样返回
+func objectFormatError(err error) error {
+	var unexpected *packp.ErrUnexpectedData
+	if err == nil || !errors.As(err, &unexpected) {
+		return err
+	}
+	remote := advertisedObjectFormat(unexpected.Data)
+	if remote == "" || remote == SupportedObjectFormat() {
+		return err
+	}
+	return &ObjectFormatError{Remote: remote, Supported: SupportedObjectFormat()}
+}
+
+// advertisedObjectFormat 从无法解析的引用通告行中找出 object-format 能力（git 2.28 起总会通告），找不到时返回空字符串
+func advertisedObjectFormat(line []byte) string {
+	fields := bytes.FieldsFunc(line, func(r rune) bool { return r == 0 || r == ' ' || r == '\n' })
+	for _, f := range fields {
+		if format, ok := bytes.CutPrefix(f, []byte(capability.ObjectFormat+"=")); ok {
+			return string(format)
+		}
+	}
+	return ""
+}
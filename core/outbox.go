@@ -0,0 +1,507 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyProvider 为后台任务（定时发送等）按仓库提供 SSH 私钥，私钥本身不会被写入本地存储
+type KeyProvider interface {
+	SSHKey(repoURL string) (string, error)
+}
+
+// outbox 条目的类型，空字符串表示 PushFiles
+const (
+	outboxKindMessage = "message"
+	outboxKindCommit  = "commit"
+)
+
+// OutboxEntry 是 outbox 中一条待发送的提交
+type OutboxEntry struct {
+	ID string `json:"id"`
+	// Kind 为空表示 SchedulePush 写入的文件提交，"message" 为离线时的 SendMessage，"commit" 为离线时的 PushCommit
+	Kind    string            `json:"kind,omitempty"`
+	RepoURL string            `json:"repoUrl"`
+	Message string            `json:"message"`
+	Files   map[string][]byte `json:"files,omitempty"`
+	// Channel / Msg 只用于 "message" 条目，Msg 的 ID 和时间在写入 outbox 时已确定
+	Channel   string   `json:"channel,omitempty"`
+	Msg       *Message `json:"msg,omitempty"`
+	SendAt    int64    `json:"sendAt"`
+	CreatedAt int64    `json:"createdAt"`
+	Attempts  int      `json:"attempts"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+var (
+	dataMu  sync.Mutex
+	dataDir string
+
+	outboxMu     sync.Mutex
+	offlineQueue bool
+	// flushMu 串行化 outbox 的发送，保证同一条目不会被两次推送；推送期间只持有 flushMu，不持有 outboxMu
+	flushMu sync.Mutex
+
+	schedulerMu   sync.Mutex
+	schedulerStop chan struct{}
+)
+
+// SetDataDir 设置本库的本地存储目录（outbox 等），App 应传入自己的私有目录
+func SetDataDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	dataDir = dir
+	return nil
+}
+
+// dataPath 返回本地存储目录下的子目录，未调用 SetDataDir 时返回错误
+func dataPath(name string) (string, error) {
+	dataMu.Lock()
+	dir := dataDir
+	dataMu.Unlock()
+	if dir == "" {
+		return "", errors.New("data dir not set, call SetDataDir first")
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return "", fmt.Errorf("create %s: %w", name, err)
+	}
+	return path, nil
+}
+
+// SchedulePush 把一次 PushFiles 持久化到 outbox，在 sendAt（毫秒时间戳）之后由 FlushOutbox 或后台调度器发送；
+// 如果到时处于离线状态，会在之后第一次成功连接时发送。返回该条目的 ID。
+func SchedulePush(repoURL string, commitMsg string, files *FileBatch, sendAt int64) (string, error) {
+	if files.Len() == 0 {
+		return "", errors.New("no files to push")
+	}
+	if err := validateFiles(repoURL, commitMsg, files.files); err != nil {
+		return "", err
+	}
+	entry := &OutboxEntry{
+		RepoURL: repoURL,
+		Message: commitMsg,
+		Files:   files.files,
+		SendAt:  sendAt,
+	}
+
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	if err := enqueueOutbox(entry); err != nil {
+		return "", err
+	}
+	return entry.ID, nil
+}
+
+// ScheduleMessage 把一条消息持久化到 outbox，在 sendAt（毫秒时间戳）之后发送到频道 channel，channel 为空表示仓库默认的 messages/。
+// 消息时间取 sendAt（已过去时取当前时间），sender 为空时使用该仓库身份的邮箱。返回该条目的 ID
+func ScheduleMessage(repoURL string, channel string, sender, msgType, body string, sendAt int64) (string, error) {
+	timestamp := commitTime().UnixMilli()
+	if sendAt > timestamp {
+		timestamp = sendAt
+	}
+	msg, err := newMessage(repoURL, channel, "", sender, msgType, body, timestamp)
+	if err != nil {
+		return "", err
+	}
+	entry := &OutboxEntry{
+		Kind:    outboxKindMessage,
+		RepoURL: repoURL,
+		Channel: channel,
+		Msg:     msg,
+		SendAt:  sendAt,
+	}
+
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	if err := enqueueOutbox(entry); err != nil {
+		return "", err
+	}
+	return entry.ID, nil
+}
+
+// SetOfflineQueue 开启后，SendMessage（包括频道消息和回复）与 PushCommit 因网络不可用失败时不返回错误，
+// 而是写入 outbox：消息的 Pending 为 true，PushCommit 的 Status 为 PushStatusQueued。
+// 同一仓库在 outbox 中还有已到时间但未发送的条目时，新的调用也会排在其后，以保持发送顺序。
+// 这些条目由 FlushOutbox、后台调度器（StartScheduler）或 NotifyConnectivityRestored 发送
+func SetOfflineQueue(enabled bool) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	offlineQueue = enabled
+}
+
+// NotifyConnectivityRestored 在 App 检测到网络恢复时调用，在后台发送 outbox 中已到时间的条目
+func NotifyConnectivityRestored(keys KeyProvider) {
+	go func() {
+		_, _ = FlushOutbox(keys)
+	}()
+}
+
+// CancelScheduled 从 outbox 中删除尚未发送的条目，正在推送中的条目取消后仍可能已经送达
+func CancelScheduled(id string) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := readOutbox()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.entry.ID == id {
+			return os.Remove(e.path)
+		}
+	}
+	return fmt.Errorf("outbox entry %s not found", id)
+}
+
+// ListScheduledJSON 以 JSON 数组返回 outbox 中所有待发送的条目（不含文件内容）
+func ListScheduledJSON() (string, error) {
+	outboxMu.Lock()
+	entries, err := readOutbox()
+	outboxMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	list := make([]OutboxEntry, 0, len(entries))
+	for _, e := range entries {
+		summary := *e.entry
+		summary.Files = nil
+		list = append(list, summary)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FlushOutbox 按顺序发送所有已到时间的条目，返回成功发送的数量。
+// 推送因远端分支已被其他设备更新而被拒绝时，会在新的远端头上重新应用后再推送。
+// 某个仓库的条目因网络不可用发送失败时，该仓库之后的条目本轮不再发送，以保持顺序；
+// 因其他原因（例如频道已迁移、校验不通过）失败的条目重试也不会成功，移到本地存储目录的 outbox-quarantine/ 下，不阻塞之后的条目。
+func FlushOutbox(keys KeyProvider) (int, error) {
+	return flushOutbox(keys, "")
+}
+
+// flushOutbox 发送 repoURL（为空表示全部仓库）已到时间的条目。
+// outboxMu 只在读取和更新条目文件时持有，推送期间其他调用仍可写入或取消条目
+func flushOutbox(keys KeyProvider, repoURL string) (int, error) {
+	sent, _, err := flushOutboxEntries(keys, repoURL)
+	return sent, err
+}
+
+// flushOutboxEntries 同 flushOutbox，同时返回本轮发送失败的条目（ID -> 错误）
+func flushOutboxEntries(keys KeyProvider, repoURL string) (int, map[string]error, error) {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	outboxMu.Lock()
+	entries, err := readOutbox()
+	outboxMu.Unlock()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	blocked := map[string]bool{}
+	failed := map[string]error{}
+	sent := 0
+	var firstErr error
+	for _, e := range entries {
+		if repoURL != "" && e.entry.RepoURL != repoURL {
+			continue
+		}
+		if e.entry.SendAt > now || blocked[e.entry.RepoURL] {
+			continue
+		}
+		sendErr := sendOutboxEntry(keys, e.entry)
+		outboxMu.Lock()
+		err := settleOutboxEntry(e, sendErr)
+		outboxMu.Unlock()
+		if err != nil {
+			return sent, failed, err
+		}
+		if sendErr != nil {
+			failed[e.entry.ID] = sendErr
+			if outboxRetryable(sendErr) {
+				blocked[e.entry.RepoURL] = true
+			}
+			if firstErr == nil {
+				firstErr = sendErr
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, failed, firstErr
+}
+
+// settleOutboxEntry 在推送后删除已发送的条目，或记录失败原因：因网络不可用失败的条目等待下次发送，
+// 其他失败的条目移入 outbox-quarantine/。推送期间已被 CancelScheduled 删除的条目不再写回。调用方需持有 outboxMu
+func settleOutboxEntry(e outboxFile, sendErr error) error {
+	if _, err := os.Stat(e.path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if sendErr == nil {
+		if err := os.Remove(e.path); err != nil {
+			return fmt.Errorf("remove sent entry: %w", err)
+		}
+		return nil
+	}
+	e.entry.Attempts++
+	e.entry.LastError = sendErr.Error()
+	_ = writeOutboxEntry(e.path, e.entry)
+	if !outboxRetryable(sendErr) {
+		return quarantineOutboxEntry(e.path)
+	}
+	return nil
+}
+
+// StartScheduler 启动后台调度器，每隔 intervalSeconds 秒调用一次 FlushOutbox，重复调用会替换之前的调度器
+func StartScheduler(keys KeyProvider, intervalSeconds int) {
+	if intervalSeconds <= 0 {
+		intervalSeconds = 30
+	}
+	StopScheduler()
+
+	stop := make(chan struct{})
+	schedulerMu.Lock()
+	schedulerStop = stop
+	schedulerMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(intervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			_, _ = FlushOutbox(keys)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// StopScheduler 停止后台调度器
+func StopScheduler() {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	if schedulerStop != nil {
+		close(schedulerStop)
+		schedulerStop = nil
+	}
+}
+
+// errOutboxKey 表示 KeyProvider 没能提供条目所需的私钥，条目保留在 outbox 中等待下次发送
+var errOutboxKey = errors.New("ssh key")
+
+// outboxRetryable 判断条目的发送错误是否可能在重试时消失（网络不可用或暂时取不到私钥）
+func outboxRetryable(err error) bool {
+	return isOffline(err) || errors.Is(err, errOutboxKey)
+}
+
+func sendOutboxEntry(keys KeyProvider, entry *OutboxEntry) error {
+	key, err := keys.SSHKey(entry.RepoURL)
+	if err != nil {
+		return fmt.Errorf("%w for %s: %w", errOutboxKey, entry.RepoURL, err)
+	}
+	switch entry.Kind {
+	case "":
+		_, err := PushFiles(entry.RepoURL, key, entry.Message, &FileBatch{files: entry.Files})
+		return err
+	case outboxKindMessage:
+		if entry.Msg == nil {
+			return fmt.Errorf("outbox entry %s has no message", entry.ID)
+		}
+		return deliverMessage(entry.RepoURL, key, entry.Channel, entry.Msg)
+	case outboxKindCommit:
+		_, err := pushCommit(entry.RepoURL, key, entry.Message)
+		return err
+	default:
+		return fmt.Errorf("unknown outbox entry kind %q", entry.Kind)
+	}
+}
+
+// sendOrQueue 执行 send。开启离线队列时，如果 repoURL 在 outbox 中还有未发送的条目，
+// 把 entry 排在其后并尝试用 sshKeyPEM 发送该仓库的条目；如果 send 因网络不可用失败，把 entry 写入 outbox。
+// queued 为 true 表示 entry 因网络不可用仍在 outbox 中等待发送；entry 本身因其他原因发送失败时返回该错误
+// （条目已移入 outbox-quarantine/）
+func sendOrQueue(repoURL, sshKeyPEM string, entry *OutboxEntry, send func() error) (queued bool, err error) {
+	outboxMu.Lock()
+	enabled := offlineQueue
+	pending := false
+	if enabled {
+		pending, err = hasOutboxEntries(repoURL)
+	}
+	outboxMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if !pending {
+		err := send()
+		if !enabled || err == nil || !isOffline(err) {
+			return false, err
+		}
+	}
+
+	outboxMu.Lock()
+	err = enqueueOutbox(entry)
+	outboxMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if !pending {
+		return true, nil
+	}
+	// 排在前面的条目发送失败的原因已记录在其 LastError 中，无法重试的已移入 outbox-quarantine/，不再阻塞 entry
+	_, failed, err := flushOutboxEntries(repoKey{repoURL: repoURL, key: sshKeyPEM}, repoURL)
+	if failed == nil {
+		return false, err
+	}
+	if sendErr := failed[entry.ID]; sendErr != nil && !outboxRetryable(sendErr) {
+		return false, sendErr
+	}
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	return hasOutboxEntry(entry.ID)
+}
+
+// repoKey 只为一个仓库提供私钥
+type repoKey struct {
+	repoURL string
+	key     string
+}
+
+func (k repoKey) SSHKey(repoURL string) (string, error) {
+	if repoURL != k.repoURL {
+		return "", fmt.Errorf("no key for %s", repoURL)
+	}
+	return k.key, nil
+}
+
+// enqueueOutbox 为 entry 分配 ID 并写入 outbox，SendAt 为 0 表示立即发送，调用方需持有 outboxMu
+func enqueueOutbox(entry *OutboxEntry) error {
+	dir, err := dataPath("outbox")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	entry.ID = utils.RandomHexString(16)
+	entry.CreatedAt = now.UnixMilli()
+	if entry.SendAt == 0 {
+		entry.SendAt = entry.CreatedAt
+	}
+	// 文件名以创建时间开头，保证按提交顺序发送
+	name := fmt.Sprintf("%020d-%s.json", now.UnixNano(), entry.ID)
+	return writeOutboxEntry(filepath.Join(dir, name), entry)
+}
+
+// hasOutboxEntries 判断 repoURL 在 outbox 中是否有已到时间但未发送的条目，调用方需持有 outboxMu
+func hasOutboxEntries(repoURL string) (bool, error) {
+	entries, err := readOutbox()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UnixMilli()
+	for _, e := range entries {
+		if e.entry.RepoURL == repoURL && e.entry.SendAt <= now {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasOutboxEntry 判断条目 id 是否仍在 outbox 中，调用方需持有 outboxMu
+func hasOutboxEntry(id string) (bool, error) {
+	entries, err := readOutbox()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.entry.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type outboxFile struct {
+	path  string
+	entry *OutboxEntry
+}
+
+// readOutbox 按文件名顺序读取所有条目，调用方需持有 outboxMu
+func readOutbox() ([]outboxFile, error) {
+	dir, err := dataPath("outbox")
+	if err != nil {
+		return nil, err
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+
+	var result []outboxFile
+	for _, n := range names {
+		if n.IsDir() || !strings.HasSuffix(n.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, n.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read outbox entry: %w", err)
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			// 无法解析的条目（写入时损坏或来自不兼容的版本）移出 outbox，不影响其他条目的发送
+			if err := quarantineOutboxEntry(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		result = append(result, outboxFile{path: path, entry: &entry})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].path < result[j].path })
+	return result, nil
+}
+
+// quarantineOutboxEntry 把条目文件移到本地存储目录的 outbox-quarantine/ 下，保留原文件名以便排查
+func quarantineOutboxEntry(path string) error {
+	dir, err := dataPath("outbox-quarantine")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		return fmt.Errorf("quarantine outbox entry: %w", err)
+	}
+	return nil
+}
+
+// writeOutboxEntry 先写临时文件再重命名，避免进程被杀时留下半个文件
+func writeOutboxEntry(path string, entry *OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write outbox entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write outbox entry: %w", err)
+	}
+	return nil
+}
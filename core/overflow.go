@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// overflowDir 是超出内联大小的文件内容的存放目录，文件名为内容的 sha256，相同内容只存一份
+const overflowDir = ".mixgram/blobs"
+
+// overflowMagic 是引用存根的前缀，用于在读取时识别存根
+var overflowMagic = []byte(`{"mixgramOverflow":`)
+
+// InlineSizeLimit 是 PushFiles 内联写入单个文件的最大字节数，<= 0 表示不限制。
+// 超过该大小的文件会转存到 .mixgram/blobs/，原路径只保留一个引用存根，读取时透明还原。
+var InlineSizeLimit int64 = 256 << 10
+
+// overflowStub 是写在原路径上的引用存根
+type overflowStub struct {
+	Version int    `json:"mixgramOverflow"`
+	Blob    string `json:"blob"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// overflowFiles 把超出内联大小的文件替换为存根，并把内容写到 overflowDir 下
+func overflowFiles(files map[string][]byte) map[string][]byte {
+	limit := InlineSizeLimit
+	if limit <= 0 {
+		return files
+	}
+
+	result := make(map[string][]byte, len(files))
+	for path, content := range files {
+		if content == nil || int64(len(content)) <= limit {
+			result[path] = content
+			continue
+		}
+		sum := sha256.Sum256(content)
+		stub := overflowStub{
+			Version: 1,
+			Blob:    overflowDir + "/" + hex.EncodeToString(sum[:]),
+			Size:    int64(len(content)),
+			SHA256:  hex.EncodeToString(sum[:]),
+		}
+		data, _ := json.Marshal(stub)
+		result[path] = data
+		result[stub.Blob] = content
+	}
+	return result
+}
+
+// parseOverflowStub 判断文件内容是否为引用存根
+func parseOverflowStub(content []byte) (*overflowStub, bool) {
+	if !bytes.HasPrefix(content, overflowMagic) {
+		return nil, false
+	}
+	var stub overflowStub
+	if err := json.Unmarshal(content, &stub); err != nil || stub.Blob == "" {
+		return nil, false
+	}
+	return &stub, true
+}
+
+func (s *overflowStub) verify(content []byte) error {
+	sum := sha256.Sum256(content)
+	if int64(len(content)) != s.Size || hex.EncodeToString(sum[:]) != s.SHA256 {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
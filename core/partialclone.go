@@ -0,0 +1,240 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+)
+
+// readMetadata 同 readClone，但只用于读取 commit 元数据（消息、作者、时间）：
+// 服务端支持 filter 时以 filter=blob:none 只取回 commit 和 tree，不下载任何文件内容；
+// 否则在 depth > 0 且服务端支持浅克隆时只浅克隆 depth 条，都不支持时完整克隆。
+// 返回的仓库可能缺少 blob，不能用来读取文件或检出
+func readMetadata(repoURL, sshKeyPEM string, depth int) (*git.Repository, string, error) {
+	branch := RepoBranch(repoURL)
+	return readRemotes(repoURL, sshKeyPEM, func(ctx context.Context, url string, auth transport.AuthMethod) (*git.Repository, error) {
+		return cloneMetadata(ctx, url, auth, branch, depth)
+	})
+}
+
+// cloneMetadata 从 repoURL 取回 branch 分支（空字符串表示远端默认分支）的 commit 和 tree 到内存，
+// 服务端不支持 filter 时按 readMetadata 的规则退回普通克隆
+func cloneMetadata(ctx context.Context, repoURL string, auth transport.AuthMethod, branch string, depth int) (repo *git.Repository, err error) {
+	err = uploadPack(ctx, repoURL, auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+		if ar == nil || !ar.Capabilities.Supports(capability.Filter) {
+			if ar == nil || !ar.Capabilities.Supports(capability.Shallow) {
+				depth = 0
+			}
+			return nil
+		}
+		repo, err = fetchWithoutBlobs(ctx, s, ar, repoURL, branch)
+		return err
+	})
+	if err != nil || repo != nil {
+		return repo, err
+	}
+	// 空仓库同样交给普通克隆，返回与 readClone 相同的错误
+	return cloneToMemory(ctx, repoURL, auth, branch, depth)
+}
+
+// openPartialBranch 同 openBranch，但服务端支持 filter 且允许按哈希取回对象时只取回 commit 和 tree，
+// 读取文件前需用 fetchBlobs 取回对应的 blob；新提交只需要 tree，可以直接在其上提交推送。
+// 服务端不支持时退回 openBranch 的完整克隆
+func openPartialBranch(repoURL, sshKeyPEM string) (*history, *plumbing.Reference, error) {
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	branch := RepoBranch(repoURL)
+	var repo *git.Repository
+	err = withRetry(repoURL, func(ctx context.Context) error {
+		return uploadPack(ctx, repoURL, auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) (err error) {
+			if ar == nil {
+				return transport.ErrEmptyRemoteRepository
+			}
+			if !ar.Capabilities.Supports(capability.Filter) || !ar.Capabilities.Supports(capability.AllowReachableSHA1InWant) {
+				return nil
+			}
+			repo, err = fetchWithoutBlobs(ctx, s, ar, repoURL, branch)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("clone repo: %w", err)
+	}
+	if repo == nil {
+		return openBranch(repoURL, sshKeyPEM)
+	}
+
+	headRef, err := repo.Head()
+	if err != nil {
+		return nil, nil, fmt.Errorf("head: %w", err)
+	}
+	observeHead(repo, repoURL, headRef)
+	if !headRef.Name().IsBranch() {
+		return nil, nil, fmt.Errorf("HEAD is not on a branch: %s", headRef.Name().String())
+	}
+	return &history{repoURL: repoURL, auth: auth, repo: repo, refName: headRef.Name()}, headRef, nil
+}
+
+// fetchBlobs 取回 hashes 中本地还没有的 blob，返回实际取回的 blob（去掉重复），用于 openPartialBranch 返回的仓库
+func (h *history) fetchBlobs(hashes []plumbing.Hash) ([]plumbing.Hash, error) {
+	var wants []plumbing.Hash
+	seen := map[plumbing.Hash]bool{}
+	for _, hash := range hashes {
+		if seen[hash] || h.repo.Storer.HasEncodedObject(hash) == nil {
+			continue
+		}
+		seen[hash] = true
+		wants = append(wants, hash)
+	}
+	if len(wants) == 0 {
+		return nil, nil
+	}
+	err := withRetry(h.repoURL, func(ctx context.Context) error {
+		return uploadPack(ctx, h.repoURL, h.auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+			if ar == nil {
+				return transport.ErrEmptyRemoteRepository
+			}
+			return fetchObjects(ctx, s, ar.Capabilities, wants, nil, "", h.repo.Storer)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wants, nil
+}
+
+// fetchFiles 取回 commit 中 paths 对应的、本地还没有的 blob，不存在的路径被忽略，返回实际取回的 blob
+func (h *history) fetchFiles(commit plumbing.Hash, paths ...string) ([]plumbing.Hash, error) {
+	tree, err := headTree(h.repo, commit)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]plumbing.Hash, 0, len(paths))
+	for _, p := range paths {
+		if e, err := tree.FindEntry(p); err == nil {
+			hashes = append(hashes, e.Hash)
+		}
+	}
+	return h.fetchBlobs(hashes)
+}
+
+// uploadPack 打开 repoURL 的 upload-pack 会话，读取通告的引用和能力后交给 fn，空仓库时 ar 为 nil。
+// 一个会话只能取回一次对象
+func uploadPack(ctx context.Context, repoURL string, auth transport.AuthMethod, fn func(s transport.UploadPackSession, ar *packp.AdvRefs) error) (err error) {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return fmt.Errorf("parse url: %w", err)
+	}
+	ep.Proxy = utils.SSHProxyOptions(repoURL)
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return fmt.Errorf("transport: %w", err)
+	}
+	s, err := c.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return fmt.Errorf("open %s session: %w", transport.UploadPackServiceName, err)
+	}
+	defer func() {
+		if cerr := s.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	ar, err := s.AdvertisedReferencesContext(ctx)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return fn(s, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("advertised refs: %w", err)
+	}
+	return fn(s, ar)
+}
+
+// fetchWithoutBlobs 在会话 s 上以 filter=blob:none 取回 branch 分支到新的内存仓库，并设置分支引用，不检出工作区
+func fetchWithoutBlobs(ctx context.Context, s transport.UploadPackSession, ar *packp.AdvRefs, repoURL, branch string) (*git.Repository, error) {
+	refs, err := ar.AllReferences()
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	list := make([]*plumbing.Reference, 0, len(refs))
+	for _, ref := range refs {
+		list = append(list, ref)
+	}
+	refName, err := cloneTarget(list, branch)
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	head, err := refs.Reference(refName)
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+
+	repo, err := utils.OpenRemote(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := fetchObjects(ctx, s, ar.Capabilities, []plumbing.Hash{head.Hash()}, nil, packp.FilterBlobNone(), repo.Storer); err != nil {
+		return nil, err
+	}
+
+	tracking := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, refName.Short())
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewHashReference(refName, head.Hash()),
+		plumbing.NewHashReference(tracking, head.Hash()),
+		plumbing.NewSymbolicReference(plumbing.HEAD, refName),
+	} {
+		if err := repo.Storer.SetReference(ref); err != nil {
+			return nil, fmt.Errorf("set ref: %w", err)
+		}
+	}
+	return repo, nil
+}
+
+// fetchObjects 在会话 s 上取回 wants 及其可达、但不能从 haves 到达的对象（filter 为空表示不过滤）并写入 st
+func fetchObjects(ctx context.Context, s transport.UploadPackSession, adv *capability.List, wants, haves []plumbing.Hash, filter packp.Filter, st storer.Storer) error {
+	req := packp.NewUploadPackRequestFromCapabilities(adv)
+	if filter != "" {
+		req.Capabilities.Set(capability.Filter)
+		req.Filter = filter
+	}
+	req.Wants = wants
+	req.Haves = haves
+	resp, err := s.UploadPack(ctx, req)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Close()
+
+	var pack io.Reader = resp
+	if t, ok := sidebandType(req.Capabilities); ok {
+		pack = sideband.NewDemuxer(t, resp)
+	}
+	if err := packfile.UpdateObjectStorage(st, pack); err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	return nil
+}
+
+func sidebandType(caps *capability.List) (sideband.Type, bool) {
+	switch {
+	case caps.Supports(capability.Sideband64k):
+		return sideband.Sideband64k, true
+	case caps.Supports(capability.Sideband):
+		return sideband.Sideband, true
+	}
+	return 0, false
+}
@@ -0,0 +1,500 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// poller 缓存轮询一个远端所需的对象（解析后的 endpoint 和认证），
+// 使无变化时的轮询只剩一次引用协商，不再重复解析私钥、创建仓库或下载对象
+type poller struct {
+	mu       sync.Mutex
+	endpoint *transport.Endpoint
+	keyHash  [sha256.Size]byte
+	auth     transport.AuthMethod
+
+	// head 是上次读到的远端 head，headStr 是它的字符串形式；head 不变时 PollHead 直接返回 headStr，不再分配
+	head    plumbing.Hash
+	headStr string
+	// index 保存增量取回的 commit 和 tree（不含文件内容），indexHead 是其中最新的 head。
+	// head 前进时只取回 indexHead 之后的新对象
+	index     *git.Repository
+	indexHead plumbing.Hash
+	// noIndex 表示远端不支持 filter，之后直接克隆元数据，不再尝试增量取回
+	noIndex bool
+	// samples 是读取分配统计时复用的缓冲，只在设置了 PollProfiler 时使用
+	samples []metrics.Sample
+}
+
+var (
+	pollersMu sync.Mutex
+	pollers   = map[string]*poller{}
+)
+
+// PollHead 返回远端 HEAD 当前指向的 commit 哈希，空仓库返回空字符串。
+// 只进行引用协商，不下载任何对象，适合高频轮询。
+func PollHead(repoURL, sshKeyPEM string) (string, error) {
+	_, head, err := pollRemoteHead(repoURL, sshKeyPEM)
+	return head, err
+}
+
+// HasNewCommits 判断远端 HEAD 是否已经不是 knownHead
+func HasNewCommits(repoURL, sshKeyPEM string, knownHead string) (bool, error) {
+	head, _, err := pollRemoteHead(repoURL, sshKeyPEM)
+	if err != nil {
+		return false, err
+	}
+	if head.IsZero() {
+		return false, nil
+	}
+	return head != plumbing.NewHash(knownHead), nil
+}
+
+// pollRemoteHead 返回远端 head 及其字符串形式，空仓库返回零值和空字符串
+func pollRemoteHead(repoURL, sshKeyPEM string) (plumbing.Hash, string, error) {
+	p, err := lockPoller(repoURL, sshKeyPEM)
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	defer p.mu.Unlock()
+
+	profile := p.startProfile(repoURL, PollStageNegotiate)
+	head, err := p.remoteHead(repoURL)
+	changed := head != p.head
+	if err == nil && changed {
+		p.head, p.headStr = head, ""
+		if !head.IsZero() {
+			p.headStr = head.String()
+		}
+	}
+	profile.finish(p, changed, 0, err)
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	return p.head, p.headStr, nil
+}
+
+// lockPoller 返回 repoURL 的 poller 并加锁，调用方负责解锁
+func lockPoller(repoURL, sshKeyPEM string) (*poller, error) {
+	p, err := getPoller(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if err := p.setKey(sshKeyPEM); err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	return p, nil
+}
+
+func getPoller(repoURL string) (*poller, error) {
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+	if p, ok := pollers[repoURL]; ok {
+		return p, nil
+	}
+
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if _, err := client.NewClient(ep); err != nil {
+		return nil, fmt.Errorf("transport: %w", err)
+	}
+	p := &poller{endpoint: ep}
+	pollers[repoURL] = p
+	return p, nil
+}
+
+// setKey 仅在私钥变化时重新解析认证信息
+func (p *poller) setKey(sshKeyPEM string) error {
+	sum := sha256.Sum256([]byte(sshKeyPEM))
+	if p.auth != nil && sum == p.keyHash {
+		return nil
+	}
+	auth, err := utils.NewSSHAuthForURL(p.endpoint.String(), sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	p.auth, p.keyHash = auth, sum
+	return nil
+}
+
+// remoteHead 读取远端通告的 HEAD（或为该仓库指定的分支），空仓库或分支不存在时返回零值
+func (p *poller) remoteHead(repoURL string) (plumbing.Hash, error) {
+	ar, err := p.advertisedRefs(transport.UploadPackServiceName)
+	if err != nil || ar == nil {
+		return plumbing.ZeroHash, err
+	}
+	head := selectedHead(repoURL, ar.References, ar.Head)
+	if head == nil {
+		return plumbing.ZeroHash, nil
+	}
+	return *head, nil
+}
+
+// advertisedRefs 打开指定服务的会话并读取远端通告的引用和能力，不下载任何对象。
+// 空仓库返回 nil（upload-pack）或只包含能力的结果（receive-pack）。
+func (p *poller) advertisedRefs(service string) (ar *packp.AdvRefs, err error) {
+	err = withRetry(p.endpoint.String(), func(ctx context.Context) error {
+		ar, err = p.readAdvertisedRefs(ctx, service)
+		return err
+	})
+	return ar, err
+}
+
+func (p *poller) readAdvertisedRefs(ctx context.Context, service string) (ar *packp.AdvRefs, err error) {
+	p.endpoint.Proxy = utils.SSHProxyOptions(p.endpoint.String())
+	// 每次重新查找 transport，使 EnableConnectionPool 等替换的协议实现立即生效
+	c, err := client.NewClient(p.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("transport: %w", err)
+	}
+	var s transport.Session
+	if service == transport.ReceivePackServiceName {
+		s, err = c.NewReceivePackSession(p.endpoint, p.auth)
+	} else {
+		s, err = c.NewUploadPackSession(p.endpoint, p.auth)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s session: %w", service, err)
+	}
+	defer func() {
+		if cerr := s.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	ar, err = s.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return ar, nil
+		}
+		return nil, fmt.Errorf("advertised refs: %w", err)
+	}
+	return ar, nil
+}
+
+// errNoIndex 表示远端不支持 filter，无法只增量取回 commit 和 tree
+var errNoIndex = errors.New("remote does not support filtered fetch")
+
+// commitsSince 返回 known 之后的新 commit（从旧到新）以及远端 head；
+// known 不在最近 max 条 commit 中时只返回最近的 max 条，truncated 为 true。
+// 远端支持 filter 时在轮询的 poller 中增量维护 commit 索引，每次只取回新 commit 和 tree；否则克隆元数据
+func commitsSince(repoURL, sshKeyPEM string, known string, max int) (commits []SimpleCommit, head string, truncated bool, err error) {
+	p, err := lockPoller(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer p.mu.Unlock()
+
+	if p.noIndex {
+		return cloneCommitsSince(repoURL, sshKeyPEM, known, max)
+	}
+	profile := p.startProfile(repoURL, PollStageFetch)
+	repo, ref, objects, err := p.updateIndex(repoURL)
+	if errors.Is(err, errNoIndex) {
+		p.noIndex = true
+		return cloneCommitsSince(repoURL, sshKeyPEM, known, max)
+	}
+	profile.finish(p, objects > 0, objects, err)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if ref == nil {
+		return nil, "", false, nil
+	}
+	observeHead(repo, repoURL, ref)
+
+	found := false
+	_, err = logCommits(repo, ref.Hash(), max, func(c SimpleCommit) error {
+		if c.Hash == known {
+			found = true
+			return io.EOF
+		}
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return nil, "", false, err
+	}
+	if !found && known != "" {
+		// 历史被改写或间隔太久，索引中留下的旧对象不再需要，下次重新建立
+		p.index, p.indexHead = nil, plumbing.ZeroHash
+	}
+	reverseCommits(commits)
+	return commits, ref.Hash().String(), !found && max > 0 && len(commits) >= max, nil
+}
+
+// updateIndex 把远端 head 之前的 commit 和 tree 增量取回 p.index，返回索引、head 引用（空仓库为 nil）和本次取回的对象数。
+// 远端不支持 filter 时返回 errNoIndex
+func (p *poller) updateIndex(repoURL string) (repo *git.Repository, ref *plumbing.Reference, objects int, err error) {
+	err = withRetry(repoURL, func(ctx context.Context) error {
+		return uploadPack(ctx, repoURL, p.auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+			if ar == nil {
+				ref = nil
+				return nil
+			}
+			if !ar.Capabilities.Supports(capability.Filter) {
+				return errNoIndex
+			}
+			refs, err := ar.AllReferences()
+			if err != nil {
+				return fmt.Errorf("advertised refs: %w", err)
+			}
+			list := make([]*plumbing.Reference, 0, len(refs))
+			for _, r := range refs {
+				list = append(list, r)
+			}
+			name, err := cloneTarget(list, RepoBranch(repoURL))
+			if err != nil {
+				return err
+			}
+			ref = plumbing.NewHashReference(name, refHash(list, name))
+			if p.index != nil && ref.Hash() == p.indexHead {
+				return nil
+			}
+			if p.index == nil {
+				if p.index, err = utils.OpenRemote(repoURL); err != nil {
+					return err
+				}
+				p.indexHead = plumbing.ZeroHash
+			}
+			var haves []plumbing.Hash
+			if !p.indexHead.IsZero() {
+				haves = []plumbing.Hash{p.indexHead}
+			}
+			before := countObjects(p.index)
+			if err := fetchObjects(ctx, s, ar.Capabilities, []plumbing.Hash{ref.Hash()}, haves, packp.FilterBlobNone(), p.index.Storer); err != nil {
+				return err
+			}
+			objects = countObjects(p.index) - before
+			p.indexHead = ref.Hash()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return p.index, ref, objects, nil
+}
+
+// countObjects 返回内存仓库中的对象数，不是内存存储时返回 0
+func countObjects(repo *git.Repository) int {
+	if st, ok := repo.Storer.(*memory.Storage); ok {
+		return len(st.Objects)
+	}
+	return 0
+}
+
+// PollStageNegotiate、PollStageFetch 是 PollProfile 的阶段
+const (
+	// PollStageNegotiate 是读取远端 head 的引用协商
+	PollStageNegotiate = "negotiate"
+	// PollStageFetch 是 head 前进后增量取回新 commit
+	PollStageFetch = "fetch"
+)
+
+// PollProfile 是轮询中一个阶段的耗时和分配统计，用于分析后台轮询的电量开销
+type PollProfile struct {
+	RepoURL string `json:"repoUrl"`
+	Stage   string `json:"stage"`
+	// Changed 表示 negotiate 阶段读到的 head 与上一轮不同，或 fetch 阶段取回了新对象
+	Changed        bool  `json:"changed"`
+	DurationMicros int64 `json:"durationMicros"`
+	// AllocBytes、AllocObjects 是这段时间内整个进程的堆分配量，同时进行的其他操作也会计入
+	AllocBytes   int64 `json:"allocBytes"`
+	AllocObjects int64 `json:"allocObjects"`
+	// Objects 是 fetch 阶段取回的对象数
+	Objects int    `json:"objects,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PollProfiler 接收轮询各阶段的 PollProfile
+type PollProfiler interface {
+	// OnPollProfile 的参数为 PollProfile 的 JSON，在轮询的 goroutine 中同步调用
+	OnPollProfile(profileJSON string)
+}
+
+var (
+	pollProfilerMu sync.Mutex
+	pollProfiler   PollProfiler
+)
+
+// SetPollProfiler 设置轮询统计的接收者，传 nil 取消。未设置时轮询不读取任何统计
+func SetPollProfiler(pp PollProfiler) {
+	pollProfilerMu.Lock()
+	defer pollProfilerMu.Unlock()
+	pollProfiler = pp
+}
+
+func currentPollProfiler() PollProfiler {
+	pollProfilerMu.Lock()
+	defer pollProfilerMu.Unlock()
+	return pollProfiler
+}
+
+// pollProfile 是一个正在统计的阶段，没有设置 PollProfiler 时为 nil
+type pollProfile struct {
+	sink   PollProfiler
+	result PollProfile
+	start  time.Time
+	bytes  uint64
+	count  uint64
+}
+
+// startProfile 开始统计一个阶段，调用方须持有 p.mu
+func (p *poller) startProfile(repoURL, stage string) *pollProfile {
+	sink := currentPollProfiler()
+	if sink == nil {
+		return nil
+	}
+	pp := &pollProfile{sink: sink, result: PollProfile{RepoURL: repoURL, Stage: stage}}
+	pp.bytes, pp.count = p.readAllocs()
+	pp.start = time.Now()
+	return pp
+}
+
+func (pp *pollProfile) finish(p *poller, changed bool, objects int, err error) {
+	if pp == nil {
+		return
+	}
+	pp.result.DurationMicros = time.Since(pp.start).Microseconds()
+	bytes, count := p.readAllocs()
+	pp.result.AllocBytes, pp.result.AllocObjects = int64(bytes-pp.bytes), int64(count-pp.count)
+	pp.result.Changed, pp.result.Objects = changed, objects
+	if err != nil {
+		pp.result.Error = err.Error()
+	}
+	data, jerr := json.Marshal(&pp.result)
+	if jerr != nil {
+		return
+	}
+	pp.sink.OnPollProfile(string(data))
+}
+
+// readAllocs 读取进程累计的堆分配字节数和对象数，复用 p.samples
+func (p *poller) readAllocs() (bytes, count uint64) {
+	if p.samples == nil {
+		p.samples = []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}, {Name: "/gc/heap/allocs:objects"}}
+	}
+	metrics.Read(p.samples)
+	if p.samples[0].Value.Kind() == metrics.KindUint64 {
+		bytes = p.samples[0].Value.Uint64()
+	}
+	if p.samples[1].Value.Kind() == metrics.KindUint64 {
+		count = p.samples[1].Value.Uint64()
+	}
+	return bytes, count
+}
+
+// headLoop 是后台轮询远端 head 的循环，StartTail 和 WatchRepo 共用
+type headLoop struct {
+	stop chan struct{}
+	// wake 用于立即触发一轮检查，不必等到下一个间隔
+	wake chan struct{}
+}
+
+// headLoops 按仓库登记正在运行的 headLoop，同一仓库只保留最后启动的一个
+type headLoops struct {
+	mu    sync.Mutex
+	loops map[string]*headLoop
+}
+
+// start 为 repoURL 登记一个新的循环并停止之前的循环
+func (r *headLoops) start(repoURL string) *headLoop {
+	l := &headLoop{stop: make(chan struct{}), wake: make(chan struct{}, 1)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.loops[repoURL]; ok {
+		close(old.stop)
+	}
+	if r.loops == nil {
+		r.loops = map[string]*headLoop{}
+	}
+	r.loops[repoURL] = l
+	return l
+}
+
+// stopLoop 停止 repoURL 的循环
+func (r *headLoops) stopLoop(repoURL string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.loops[repoURL]; ok {
+		close(l.stop)
+		delete(r.loops, repoURL)
+	}
+}
+
+// trigger 让 repoURL 的循环立即检查一次，没有该仓库的循环时返回 false
+func (r *headLoops) trigger(repoURL string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.loops[repoURL]
+	if !ok {
+		return false
+	}
+	select {
+	case l.wake <- struct{}{}:
+	default: // 已有一次待处理的触发
+	}
+	return true
+}
+
+// urls 返回正在轮询的仓库
+func (r *headLoops) urls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	urls := make([]string, 0, len(r.loops))
+	for url := range r.loops {
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// run 每隔 interval 以 PollHead 检查一次远端 head，直到循环被停止。known 是已经处理过的 head，
+// 为空时以第一次读到的 head 为起点。head 与 known 不同时（包括远端被清空，head 为空字符串）调用 changed，
+// 它返回新的 known；changed 失败时 known 不变，下一轮重试。轮询和 changed 的错误交给 onError
+func (l *headLoop) run(repoURL, sshKeyPEM string, interval time.Duration, known string, changed func(known, head string) (string, error), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	started := known != ""
+	for {
+		head, err := PollHead(repoURL, sshKeyPEM)
+		switch {
+		case err != nil:
+			onError(err)
+		case !started:
+			known, started = head, true
+		case head != known:
+			if next, err := changed(known, head); err != nil {
+				onError(err)
+			} else {
+				known = next
+			}
+		}
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		case <-l.wake:
+		}
+	}
+}
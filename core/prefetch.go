@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// Attachment 是 commit 中的一个附件，即该 commit 新增或修改的文件
+type Attachment struct {
+	Commit string `json:"commit"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// LocalPath 是附件内容在本地缓存中的路径，相同内容只缓存一份
+	LocalPath string `json:"localPath"`
+}
+
+// PrefetchAttachments 在一次克隆中取回 commitHashesJSON（commit 哈希的 JSON 数组，例如一页消息）
+// 引用的全部附件并写入本地缓存（SetDataDir 下的 attachments 目录），转存的大文件会被还原。
+// 返回 Attachment 数组的 JSON，顺序与传入的 commit 一致
+func PrefetchAttachments(repoURL, sshKeyPEM string, commitHashesJSON string) (string, error) {
+	var hashes []string
+	if err := json.Unmarshal([]byte(commitHashesJSON), &hashes); err != nil {
+		return "", fmt.Errorf("parse commit hashes: %w", err)
+	}
+	dir, err := dataPath("attachments")
+	if err != nil {
+		return "", err
+	}
+
+	result := []Attachment{}
+	if len(hashes) > 0 {
+		h, _, err := openBranch(repoURL, sshKeyPEM)
+		if err != nil {
+			return "", err
+		}
+		for _, hash := range hashes {
+			c, err := h.repo.CommitObject(plumbing.NewHash(hash))
+			if err != nil {
+				return "", fmt.Errorf("commit %s: %w", hash, err)
+			}
+			attachments, err := commitAttachments(h.repo, c)
+			if err != nil {
+				return "", err
+			}
+			for _, a := range attachments {
+				cached, err := cacheAttachment(dir, a.content)
+				if err != nil {
+					return "", err
+				}
+				cached.Commit, cached.Path = c.Hash.String(), a.name
+				result = append(result, *cached)
+			}
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// cacheAttachment 以内容的 sha256 为文件名写入缓存目录，已存在时不重复写入
+func cacheAttachment(dir string, content []byte) (*Attachment, error) {
+	sum := sha256.Sum256(content)
+	name := hex.EncodeToString(sum[:])
+	path := filepath.Join(dir, name)
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(content)) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, content, 0o600); err != nil {
+			return nil, fmt.Errorf("write attachment: %w", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return nil, fmt.Errorf("write attachment: %w", err)
+		}
+	}
+	return &Attachment{Size: int64(len(content)), SHA256: name, LocalPath: path}, nil
+}
+
+type mailAttachment struct {
+	name    string
+	content []byte
+}
+
+// commitAttachments 返回 commit 相对于第一个父 commit 新增或修改的文件（还原转存的大文件，不含转存目录本身）
+func commitAttachments(repo *git.Repository, c *object.Commit) ([]mailAttachment, error) {
+	to, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	var from *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("parent: %w", err)
+		}
+		if from, err = parent.Tree(); err != nil {
+			return nil, fmt.Errorf("parent tree: %w", err)
+		}
+	}
+	changes, err := object.DiffTreeWithOptions(context.Background(), from, to, nil)
+	if err != nil {
+		return nil, fmt.Errorf("diff tree: %w", err)
+	}
+
+	var result []mailAttachment
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return nil, err
+		}
+		name := change.To.Name
+		if action == merkletrie.Delete || strings.HasPrefix(name, overflowDir+"/") {
+			continue
+		}
+		content, err := readStoredFile(repo, c.Hash, name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mailAttachment{name: name, content: content})
+	}
+	return result, nil
+}
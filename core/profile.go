@@ -0,0 +1,77 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// AuthorProfile 是用于界面展示的作者资料
+type AuthorProfile struct {
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+}
+
+// ProfileResolver 把作者标识（邮箱或密钥指纹）解析为展示资料，未知作者返回 nil
+type ProfileResolver interface {
+	ResolveProfile(id string) (*AuthorProfile, error)
+}
+
+type cachedProfile struct {
+	profile *AuthorProfile
+	expires time.Time
+}
+
+var (
+	profileMu       sync.Mutex
+	profileResolver ProfileResolver
+	profileTTL      time.Duration
+	profileCache    = map[string]cachedProfile{}
+)
+
+// SetProfileResolver 设置作者资料解析器，ttlSeconds 为缓存时间（<= 0 表示一直缓存），传 nil 取消。
+// 设置后 FetchCommits 等接口返回的 commit 会附带作者资料。
+func SetProfileResolver(r ProfileResolver, ttlSeconds int64) {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	profileResolver = r
+	profileTTL = time.Duration(ttlSeconds) * time.Second
+	profileCache = map[string]cachedProfile{}
+}
+
+// ClearProfileCache 清空作者资料缓存，例如在 App 得知某个用户修改了资料之后
+func ClearProfileCache() {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	profileCache = map[string]cachedProfile{}
+}
+
+// resolveProfile 查询（并缓存）作者资料，解析失败时返回 nil，不影响调用方
+func resolveProfile(id string) *AuthorProfile {
+	profileMu.Lock()
+	resolver := profileResolver
+	entry, ok := profileCache[id]
+	profileMu.Unlock()
+
+	if resolver == nil || id == "" {
+		return nil
+	}
+	if ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return entry.profile
+	}
+
+	profile, err := resolver.ResolveProfile(id)
+	if err != nil {
+		return nil // 错误不缓存，下次重试
+	}
+
+	entry = cachedProfile{profile: profile}
+	profileMu.Lock()
+	if profileTTL > 0 {
+		entry.expires = time.Now().Add(profileTTL)
+	}
+	if profileResolver == resolver {
+		profileCache[id] = entry
+	}
+	profileMu.Unlock()
+	return profile
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"strings"
+)
+
+var (
+	// DeviceID 和 AppVersion 会以 trailer 的形式写入本库生成的每个 commit，留空则不写入
+	DeviceID   = ""
+	AppVersion = ""
+)
+
+const (
+	deviceTrailer  = "MixGram-Device"
+	versionTrailer = "MixGram-Version"
+)
+
+// withProvenance 在提交信息末尾追加设备与版本 trailer
+func withProvenance(msg string) string {
+	var trailers []string
+	if DeviceID != "" {
+		trailers = append(trailers, deviceTrailer+": "+DeviceID)
+	}
+	if AppVersion != "" {
+		trailers = append(trailers, versionTrailer+": "+AppVersion)
+	}
+	if len(trailers) == 0 {
+		return msg
+	}
+	return strings.TrimRight(msg, "\n") + "\n\n" + strings.Join(trailers, "\n") + "\n"
+}
+
+// parseProvenance 从提交信息中拆出设备与版本 trailer，返回去掉 trailer 后的信息
+func parseProvenance(msg string) (body, deviceID, appVersion string) {
+	trimmed := strings.TrimRight(msg, "\n")
+	idx := strings.LastIndex(trimmed, "\n\n")
+	if idx < 0 {
+		return msg, "", ""
+	}
+
+	for _, line := range strings.Split(trimmed[idx+2:], "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		switch {
+		case ok && key == deviceTrailer:
+			deviceID = value
+		case ok && key == versionTrailer:
+			appVersion = value
+		default:
+			// 最后一段不全是本库的 trailer，视为普通正文
+			return msg, "", ""
+		}
+	}
+	return trimmed[:idx] + "\n", deviceID, appVersion
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// RemovePathFromHistory 重写全部历史，从每个 commit 中删除 path（文件或整个目录）并强制推送，
+// 用于清除误提交的密钥或超大附件。
+// 此操作会重写历史记录。
+func RemovePathFromHistory(repoURL, sshKeyPEM string, path string) error {
+	return RemovePathFromHistoryWithOptions(repoURL, sshKeyPEM, path, nil)
+}
+
+// RemovePathFromHistoryWithOptions 同 RemovePathFromHistory，可通过 opts 控制重写细节
+func RemovePathFromHistoryWithOptions(repoURL, sshKeyPEM string, path string, opts *RewriteOptions) error {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return errors.New("path is empty")
+	}
+
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+	var editErr error
+	newHead, err := h.relink(plumbing.ZeroHash, rootToHead(h.commits), opts, func(old, c *object.Commit) bool {
+		if editErr != nil {
+			return false
+		}
+		treeHash, err := editTree(h.repo.Storer, old.TreeHash, map[string][]byte{path: nil})
+		if err != nil {
+			editErr = err
+			return false
+		}
+		if treeHash == old.TreeHash {
+			return false
+		}
+		c.TreeHash = treeHash
+		changed++
+		return true
+	})
+	if editErr != nil {
+		return fmt.Errorf("edit tree: %w", editErr)
+	}
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		fmt.Printf("历史中没有 %s，无需重写\n", path)
+		return nil
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return err
+	}
+
+	fmt.Printf("成功从 %d 个 commit 中删除 %s，并重写历史\n", changed, path)
+	return nil
+}
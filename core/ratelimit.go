@@ -0,0 +1,27 @@
+package core
+
+import (
+	"encoding/json"
+	"mixgram-core/internel/ratelimit"
+)
+
+// RateLimitError 表示远端主机仍在限流退避期内，本次操作没有访问远端；RetryAfter 之后再试
+type RateLimitError = ratelimit.Error
+
+// RateLimitStatus 是一个主机的限流状态，见 RateLimitStatsJSON
+type RateLimitStatus = ratelimit.Status
+
+// RateLimitStatsJSON 返回所有访问过的托管平台主机的限流状态（RateLimitStatus 数组的 JSON）：
+// 是否在退避期、被限流的次数，以及 provider API 通告的剩余配额
+func RateLimitStatsJSON() (string, error) {
+	data, err := json.Marshal(ratelimit.Stats())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ResetRateLimits 清除所有主机的限流状态，立即结束退避（例如用户更换了网络或账号）
+func ResetRateLimits() {
+	ratelimit.Reset()
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// reactionsDir 下每个回应一个空文件：<消息 ID>/<hex(表情)>/<hex(发送者)>。
+// 两台设备同时回应时修改的是不同的路径，推送被拒绝后在新的远端头上重新应用即可合并，不会互相覆盖
+const reactionsDir = "reactions"
+
+// Reaction 是一条消息上某个表情的全部回应
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	// Senders 按字典序排列
+	Senders []string `json:"senders"`
+	Count   int      `json:"count"`
+}
+
+// AddReaction 以 sender 的身份对消息 messageID 添加表情 emoji 并提交推送，已经添加过时不做修改。
+// sender 为空时使用该仓库身份（见 SetRepoIdentity）的邮箱；消息不存在时返回 ErrMessageNotFound
+func AddReaction(repoURL, sshKeyPEM string, messageID, emoji, sender string) error {
+	return react(repoURL, sshKeyPEM, "", messageID, emoji, sender, true)
+}
+
+// RemoveReaction 撤销 sender 对消息 messageID 的表情 emoji，没有该回应时不做修改
+func RemoveReaction(repoURL, sshKeyPEM string, messageID, emoji, sender string) error {
+	return react(repoURL, sshKeyPEM, "", messageID, emoji, sender, false)
+}
+
+// ListReactions 返回消息 messageID 的全部回应，按表情排序
+func ListReactions(repoURL, sshKeyPEM string, messageID string) ([]Reaction, error) {
+	return listReactions(repoURL, sshKeyPEM, "", messageID)
+}
+
+// ListReactionsJSON 同 ListReactions，以 JSON 数组返回
+func ListReactionsJSON(repoURL, sshKeyPEM string, messageID string) (string, error) {
+	return listReactionsJSON(repoURL, sshKeyPEM, "", messageID)
+}
+
+// AddChannelReaction 同 AddReaction，但消息在频道 channel 中
+func AddChannelReaction(repoURL, sshKeyPEM string, channel, messageID, emoji, sender string) error {
+	if err := validateChannelName(channel); err != nil {
+		return err
+	}
+	return react(repoURL, sshKeyPEM, channel, messageID, emoji, sender, true)
+}
+
+// RemoveChannelReaction 同 RemoveReaction，但消息在频道 channel 中
+func RemoveChannelReaction(repoURL, sshKeyPEM string, channel, messageID, emoji, sender string) error {
+	if err := validateChannelName(channel); err != nil {
+		return err
+	}
+	return react(repoURL, sshKeyPEM, channel, messageID, emoji, sender, false)
+}
+
+// ListChannelReactionsJSON 同 ListReactionsJSON，但消息在频道 channel 中
+func ListChannelReactionsJSON(repoURL, sshKeyPEM string, channel, messageID string) (string, error) {
+	if err := validateChannelName(channel); err != nil {
+		return "", err
+	}
+	return listReactionsJSON(repoURL, sshKeyPEM, channel, messageID)
+}
+
+func react(repoURL, sshKeyPEM string, channel, messageID, emoji, sender string, add bool) error {
+	if emoji == "" {
+		return errors.New("emoji is empty")
+	}
+	if sender == "" {
+		sender = identityFor(repoURL).Email
+	}
+	p := reactionPath(channel, messageID, emoji, sender)
+	commitMsg := "react " + emoji + " to " + messageID
+	var content []byte
+	if add {
+		content = []byte{}
+	} else {
+		commitMsg = "unreact " + emoji + " to " + messageID
+	}
+
+	return reactOnce(repoURL, sshKeyPEM, channel, messageID, p, content, commitMsg)
+}
+
+// reactOnce 克隆仓库并在最新的远端头上添加（content 不为 nil）或删除回应文件 p
+func reactOnce(repoURL, sshKeyPEM string, channel, messageID, p string, content []byte, commitMsg string) error {
+	h, head, err := openWritableStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, _, err := findMessage(h, head.Hash(), messageStoreDir(channel), messageID); err != nil {
+		return err
+	}
+	_, err = readRefFile(h.repo, head.Hash(), p)
+	exists := err == nil
+	if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+		return err
+	}
+	if exists == (content != nil) {
+		return nil
+	}
+	_, err = h.commitAndPush(head.Hash(), commitMsg, map[string][]byte{p: content})
+	return err
+}
+
+func listReactionsJSON(repoURL, sshKeyPEM string, channel, messageID string) (string, error) {
+	reactions, err := listReactions(repoURL, sshKeyPEM, channel, messageID)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(reactions)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func listReactions(repoURL, sshKeyPEM string, channel, messageID string) ([]Reaction, error) {
+	result := []Reaction{}
+	h, head, err := openMessageStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := findMessage(h, head.Hash(), messageStoreDir(channel), messageID); err != nil {
+		return nil, err
+	}
+	c, err := h.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	dir := path.Join(reactionStoreDir(channel), messageID)
+	sub, err := tree.Tree(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dir, err)
+	}
+
+	for _, e := range sub.Entries {
+		if e.Mode != filemode.Dir {
+			continue
+		}
+		emoji, err := hex.DecodeString(e.Name)
+		if err != nil {
+			continue
+		}
+		senders, err := sub.Tree(e.Name)
+		if err != nil {
+			return nil, fmt.Errorf("read %s/%s: %w", dir, e.Name, err)
+		}
+		r := Reaction{Emoji: string(emoji), Senders: []string{}}
+		for _, s := range senders.Entries {
+			sender, err := hex.DecodeString(s.Name)
+			if err != nil || !s.Mode.IsFile() {
+				continue
+			}
+			r.Senders = append(r.Senders, string(sender))
+		}
+		if len(r.Senders) == 0 {
+			continue
+		}
+		sort.Strings(r.Senders)
+		r.Count = len(r.Senders)
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Emoji < result[j].Emoji })
+	return result, nil
+}
+
+// reactionPath 返回 sender 对消息 messageID 的表情 emoji 的回应文件路径；
+// 表情和发送者以十六进制编码，避免 "/" 等字符影响路径
+func reactionPath(channel, messageID, emoji, sender string) string {
+	return path.Join(reactionStoreDir(channel), messageID, hex.EncodeToString([]byte(emoji)), hex.EncodeToString([]byte(sender)))
+}
+
+func reactionStoreDir(channel string) string {
+	if channel == "" {
+		return reactionsDir
+	}
+	return path.Join(channelsDir, channel, reactionsDir)
+}
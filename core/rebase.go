@@ -0,0 +1,130 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ggconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// PushRebaseAttempts 是普通推送因远端分支已有新提交被拒绝后，取回远端并把本次修改变基到新的远端头上重新推送的次数，
+// 0 表示不重试、直接返回推送错误
+var PushRebaseAttempts = 3
+
+// rebaseBackoff 是第一次变基前随机等待时间的上限，之后逐次增加
+const rebaseBackoff = 200 * time.Millisecond
+
+// PushConflictError 表示本次修改的文件在远端也被修改过，无法自动变基
+type PushConflictError struct {
+	Paths []string
+}
+
+func (e *PushConflictError) Error() string {
+	return "push conflict: " + strings.Join(e.Paths, ", ")
+}
+
+// rebaseAndPush 在基于 base 的提交被远端拒绝（pushErr）后调用：取回远端分支，把 files 重新应用到新的远端头上，
+// 以 commitMsg 提交并推送，最多重试 PushRebaseAttempts 次，返回最终推送的 commit。
+// overwrite 为 false 时，files 中的路径在 base 之后被远端改成了不同的内容则返回 *PushConflictError
+func (h *history) rebaseAndPush(base plumbing.Hash, commitMsg string, files map[string][]byte, overwrite bool, pushErr error) (plumbing.Hash, error) {
+	err := pushErr
+	for attempt := 0; attempt < PushRebaseAttempts && isPushConflict(err); attempt++ {
+		// 随机等待一小段时间，避免同时推送的设备再次撞车
+		time.Sleep(jittered(time.Duration(attempt+1)*rebaseBackoff, 1))
+		tip, fetchErr := h.fetchTip()
+		if fetchErr != nil {
+			return plumbing.ZeroHash, fetchErr
+		}
+		if !overwrite {
+			conflicts, cerr := h.conflicts(base, tip, files)
+			if cerr != nil {
+				return plumbing.ZeroHash, cerr
+			}
+			if len(conflicts) > 0 {
+				return plumbing.ZeroHash, &PushConflictError{Paths: conflicts}
+			}
+		}
+		newHead, cerr := h.commitFiles(tip, commitMsg, files)
+		if cerr != nil {
+			return plumbing.ZeroHash, cerr
+		}
+		if err = h.push(newHead, false); err == nil {
+			return newHead, nil
+		}
+	}
+	return plumbing.ZeroHash, err
+}
+
+// fetchTip 取回远端分支当前指向的 commit 及其对象
+func (h *history) fetchTip() (plumbing.Hash, error) {
+	tracking := plumbing.NewRemoteReferenceName("origin", h.refName.Short())
+	if err := fetchRefSpec(h.repo, h.auth, ggconfig.RefSpec(fmt.Sprintf("+%s:%s", h.refName, tracking))); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	ref, err := h.repo.Reference(tracking, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, fmt.Errorf("remote branch %s not found", h.refName.Short())
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return ref.Hash(), nil
+}
+
+// conflicts 返回 files 中在 base 之后被 tip 修改、且与本次修改内容不同的路径
+func (h *history) conflicts(base, tip plumbing.Hash, files map[string][]byte) ([]string, error) {
+	baseTree, err := commitTree(h, base)
+	if err != nil {
+		return nil, err
+	}
+	tipTree, err := commitTree(h, tip)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for p, content := range files {
+		before, beforeOK := entryHash(baseTree, p)
+		after, afterOK := entryHash(tipTree, p)
+		if before == after && beforeOK == afterOK {
+			continue // 远端没有修改
+		}
+		if content == nil {
+			if afterOK {
+				paths = append(paths, p)
+			}
+			continue
+		}
+		if !afterOK || after != plumbing.ComputeHash(plumbing.BlobObject, content) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func commitTree(h *history, hash plumbing.Hash) (*object.Tree, error) {
+	c, err := h.repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("load commit %s: %w", hash, err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	return tree, nil
+}
+
+// entryHash 返回树中 path（文件或目录）的对象哈希，不存在时 ok 为 false
+func entryHash(tree *object.Tree, path string) (plumbing.Hash, bool) {
+	e, err := tree.FindEntry(path)
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	return e.Hash, true
+}
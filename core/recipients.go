@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// encModeRecipients 使用随机的文件密钥加密内容，文件密钥分别用每个接收者的 X25519 公钥封装
+	encModeRecipients byte = 2
+
+	x25519KeySize = 32
+	// recipientStanzaSize 是每个接收者的封装数据：接收者公钥 | 临时公钥 | 封装后的文件密钥（含 GCM tag）
+	recipientStanzaSize = x25519KeySize*2 + EncryptionKeySize + 16
+	recipientWrapInfo   = "mixgram-x25519-v1"
+)
+
+// ErrNotRecipient 表示私钥对应的公钥不在文件的接收者列表中
+var ErrNotRecipient = errors.New("not a recipient of this file")
+
+// RecipientKey 是一个 X25519 密钥对，均为 base64 编码
+type RecipientKey struct {
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+}
+
+// GenerateRecipientKey 生成一个用于接收加密文件的 X25519 密钥对，公钥可以公开给发送者
+func GenerateRecipientKey() (*RecipientKey, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &RecipientKey{
+		PublicKey:  base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()),
+		PrivateKey: base64.StdEncoding.EncodeToString(priv.Bytes()),
+	}, nil
+}
+
+// PushCommitForRecipients 同 PushFiles，但文件内容只能被 recipientsJSON（base64 公钥的 JSON 数组）
+// 中的接收者用各自的私钥解密。路径和提交信息不加密
+func PushCommitForRecipients(repoURL, sshKeyPEM string, commitMsg string, files *FileBatch, recipientsJSON string) (string, error) {
+	if files.Len() == 0 {
+		return "", errors.New("no files to push")
+	}
+	recipients, err := parseRecipients(recipientsJSON)
+	if err != nil {
+		return "", err
+	}
+	encrypted := NewFileBatch()
+	for path, content := range files.files {
+		if content == nil {
+			encrypted.Delete(path)
+			continue
+		}
+		sealed, err := sealForRecipients(recipients, path, content)
+		if err != nil {
+			return "", err
+		}
+		encrypted.Put(path, sealed)
+	}
+	return PushFiles(repoURL, sshKeyPEM, commitMsg, encrypted)
+}
+
+// GetFileAtCommitForRecipient 同 GetFileAtCommit，并用接收者的私钥（base64）解密文件内容
+func GetFileAtCommitForRecipient(repoURL, sshKeyPEM string, commitHash, path string, privateKey string) ([]byte, error) {
+	priv, err := parseX25519Private(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	content, err := GetFileAtCommit(repoURL, sshKeyPEM, commitHash, path)
+	if err != nil {
+		return nil, err
+	}
+	return openForRecipient(priv, path, content)
+}
+
+func parseRecipients(recipientsJSON string) ([]*ecdh.PublicKey, error) {
+	var encoded []string
+	if err := json.Unmarshal([]byte(recipientsJSON), &encoded); err != nil {
+		return nil, fmt.Errorf("parse recipients: %w", err)
+	}
+	if len(encoded) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	if len(encoded) > 0xffff {
+		return nil, errors.New("too many recipients")
+	}
+	result := make([]*ecdh.PublicKey, 0, len(encoded))
+	for _, s := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %q: %w", s, err)
+		}
+		pub, err := ecdh.X25519().NewPublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %q: %w", s, err)
+		}
+		result = append(result, pub)
+	}
+	return result, nil
+}
+
+func parseX25519Private(s string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	return priv, nil
+}
+
+// sealForRecipients 加密 content，格式为 magic | 模式 | 接收者数量（uint16） | 每个接收者的封装数据 | nonce | 密文。
+// 头部（含全部封装数据）和路径作为附加数据参与认证
+func sealForRecipients(recipients []*ecdh.PublicKey, path string, content []byte) ([]byte, error) {
+	fileKey, err := GenerateEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	header := append(bytes.Clone(encryptedMagic), encModeRecipients)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(recipients)))
+	for _, pub := range recipients {
+		stanza, err := wrapFileKey(pub, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, stanza...)
+	}
+
+	aead, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(bytes.Clone(header), nonce...)
+	return aead.Seal(out, nonce, content, encryptionAAD(header, path)), nil
+}
+
+func openForRecipient(priv *ecdh.PrivateKey, path string, data []byte) ([]byte, error) {
+	prefix, body, err := splitEncrypted(data)
+	if err != nil {
+		return nil, err
+	}
+	if mode := prefix[len(prefix)-1]; mode != encModeRecipients {
+		return nil, fmt.Errorf("%w: unexpected encryption mode %d", ErrDecryptFailed, mode)
+	}
+	if len(body) < 2 {
+		return nil, ErrDecryptFailed
+	}
+	count := int(binary.BigEndian.Uint16(body))
+	stanzas := body[2:]
+	if len(stanzas) < count*recipientStanzaSize {
+		return nil, ErrDecryptFailed
+	}
+	header := data[:len(prefix)+2+count*recipientStanzaSize]
+	rest := stanzas[count*recipientStanzaSize:]
+
+	self := priv.PublicKey().Bytes()
+	var fileKey []byte
+	for i := 0; i < count; i++ {
+		stanza := stanzas[i*recipientStanzaSize : (i+1)*recipientStanzaSize]
+		if !bytes.Equal(stanza[:x25519KeySize], self) {
+			continue
+		}
+		if fileKey, err = unwrapFileKey(priv, stanza); err != nil {
+			return nil, err
+		}
+		break
+	}
+	if fileKey == nil {
+		return nil, ErrNotRecipient
+	}
+
+	aead, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], encryptionAAD(header, path))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plain, nil
+}
+
+// wrapFileKey 用临时 X25519 密钥与接收者协商出封装密钥，并用它加密文件密钥
+func wrapFileKey(recipient *ecdh.PublicKey, fileKey []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	wrapKey, err := recipientWrapKey(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	stanza := append(bytes.Clone(recipient.Bytes()), ephemeral.PublicKey().Bytes()...)
+	// 每个封装密钥只使用一次，可以使用全零 nonce
+	return aead.Seal(stanza, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func unwrapFileKey(priv *ecdh.PrivateKey, stanza []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(stanza[x25519KeySize : x25519KeySize*2])
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	wrapKey, err := recipientWrapKey(shared, ephemeral, priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), stanza[x25519KeySize*2:], nil)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return fileKey, nil
+}
+
+// recipientWrapKey 由 X25519 共享密钥经 HKDF-SHA256 派生封装密钥，盐为临时公钥和接收者公钥
+func recipientWrapKey(shared []byte, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(bytes.Clone(ephemeral.Bytes()), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, recipientWrapInfo, EncryptionKeySize)
+}
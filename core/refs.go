@@ -0,0 +1,117 @@
+package core
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RemoteRef 是远端的一个分支或标签
+type RemoteRef struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// RemoteRefs 是 ls-remote 的结果
+type RemoteRefs struct {
+	// DefaultBranch 是远端 HEAD 指向的分支（短名称），空仓库为空
+	DefaultBranch string      `json:"defaultBranch,omitempty"`
+	Head          string      `json:"head,omitempty"`
+	Branches      []RemoteRef `json:"branches"`
+	Tags          []RemoteRef `json:"tags"`
+}
+
+// ListRemoteRefs 只通过引用协商列出远端所有分支和标签（不克隆、不下载对象），并识别默认分支
+func ListRemoteRefs(repoURL, sshKeyPEM string) (*RemoteRefs, error) {
+	p, err := getPoller(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.setKey(sshKeyPEM); err != nil {
+		return nil, err
+	}
+	ar, err := p.advertisedRefs(transport.UploadPackServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := &RemoteRefs{Branches: []RemoteRef{}, Tags: []RemoteRef{}}
+	if ar == nil {
+		return refs, nil
+	}
+	for name, hash := range ar.References {
+		ref := plumbing.ReferenceName(name)
+		switch {
+		case ref.IsBranch():
+			refs.Branches = append(refs.Branches, RemoteRef{Name: ref.Short(), Hash: hash.String()})
+		case ref.IsTag():
+			refs.Tags = append(refs.Tags, RemoteRef{Name: ref.Short(), Hash: hash.String()})
+		}
+	}
+	sort.Slice(refs.Branches, func(i, j int) bool { return refs.Branches[i].Name < refs.Branches[j].Name })
+	sort.Slice(refs.Tags, func(i, j int) bool { return refs.Tags[i].Name < refs.Tags[j].Name })
+
+	if ar.Head != nil {
+		refs.Head = ar.Head.String()
+	}
+	if branch := defaultBranch(ar); branch != "" {
+		refs.DefaultBranch = branch.Short()
+	}
+	return refs, nil
+}
+
+// ListRemoteRefsJSON 同 ListRemoteRefs，以 JSON 返回
+func ListRemoteRefsJSON(repoURL, sshKeyPEM string) (string, error) {
+	refs, err := ListRemoteRefs(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// defaultBranch 根据 symref 能力（HEAD:refs/heads/xxx）确定远端默认分支；
+// 服务端未通告 symref 时，退而选择与 HEAD 指向同一 commit 的分支（优先 main、master）
+func defaultBranch(ar *packp.AdvRefs) plumbing.ReferenceName {
+	for _, symref := range ar.Capabilities.Get(capability.SymRef) {
+		if target, ok := strings.CutPrefix(symref, "HEAD:"); ok {
+			return plumbing.ReferenceName(target)
+		}
+	}
+	if ar.Head == nil {
+		return ""
+	}
+
+	var best string
+	for name, hash := range ar.References {
+		if hash != *ar.Head || !plumbing.ReferenceName(name).IsBranch() {
+			continue
+		}
+		if best == "" || branchPreference(name) < branchPreference(best) ||
+			branchPreference(name) == branchPreference(best) && name < best {
+			best = name
+		}
+	}
+	return plumbing.ReferenceName(best)
+}
+
+func branchPreference(name string) int {
+	switch name {
+	case "refs/heads/main":
+		return 0
+	case "refs/heads/master":
+		return 1
+	}
+	return 2
+}
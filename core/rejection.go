@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	ggconfig "github.com/go-git/go-git/v5/config"
+)
+
+// 强制推送被拒绝的原因分类
+const (
+	// RejectProtectedBranch 表示分支受保护，需要在托管平台上允许强制推送（或取消保护）
+	RejectProtectedBranch = "protected-branch"
+	// RejectNonFastForward 表示服务端禁止非快进更新（例如 receive.denyNonFastForwards）
+	RejectNonFastForward = "non-fast-forward"
+	// RejectHook 表示服务端的 pre-receive / update hook 拒绝了更新
+	RejectHook = "hook"
+	// RejectOther 表示其他原因，见 Reason
+	RejectOther = "other"
+)
+
+// ErrForcePushRejected 表示远端拒绝了强制推送；具体原因使用 errors.As 取出 *ForcePushRejectedError
+var ErrForcePushRejected = errors.New("force push rejected by remote")
+
+// RefRejection 是一个 ref 被拒绝的原因
+type RefRejection struct {
+	Ref string `json:"ref"`
+	// Kind 是原因分类（RejectProtectedBranch 等常量），Reason 是服务端状态报告中的原文
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+}
+
+// ForcePushRejectedError 是远端拒绝强制推送时返回的错误，errors.Is(err, ErrForcePushRejected) 为 true
+type ForcePushRejectedError struct {
+	Refs []RefRejection `json:"refs"`
+	// RemoteMessages 是服务端输出的提示，通常说明了如何解除限制
+	RemoteMessages string `json:"remoteMessages,omitempty"`
+}
+
+func (e *ForcePushRejectedError) Error() string {
+	parts := make([]string, 0, len(e.Refs))
+	for _, r := range e.Refs {
+		parts = append(parts, r.Ref+" ("+r.Kind+"): "+r.Reason)
+	}
+	return ErrForcePushRejected.Error() + ": " + strings.Join(parts, "; ")
+}
+
+func (e *ForcePushRejectedError) Is(target error) bool {
+	return target == ErrForcePushRejected
+}
+
+// commandError 匹配 go-git 转换自 receive-pack 状态报告的错误，例如 "command error on refs/heads/main: protected branch hook declined"
+var commandError = regexp.MustCompile(`command error on (\S+): ([^\n]+)`)
+
+// forcePushRejection 在强制推送失败时解析服务端的状态报告，远端明确拒绝了某个 ref 时返回 *ForcePushRejectedError，
+// 否则（网络错误等）返回 nil
+func forcePushRejection(err error, remoteMessages string) *ForcePushRejectedError {
+	if err == nil {
+		return nil
+	}
+	matches := commandError.FindAllStringSubmatch(err.Error(), -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	rejected := &ForcePushRejectedError{RemoteMessages: remoteLines(remoteMessages)}
+	for _, m := range matches {
+		rejected.Refs = append(rejected.Refs, RefRejection{
+			Ref:    m[1],
+			Kind:   rejectionKind(m[2] + "\n" + remoteMessages),
+			Reason: strings.TrimSpace(m[2]),
+		})
+	}
+	return rejected
+}
+
+// isForcePush 判断推送是否包含强制更新
+func isForcePush(force bool, specs []ggconfig.RefSpec) bool {
+	if force {
+		return true
+	}
+	for _, s := range specs {
+		if s.IsForceUpdate() {
+			return true
+		}
+	}
+	return false
+}
+
+func rejectionKind(text string) string {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "protected"):
+		return RejectProtectedBranch
+	case strings.Contains(text, "non-fast-forward") || strings.Contains(text, "non-fast forward"):
+		return RejectNonFastForward
+	case strings.Contains(text, "hook"):
+		return RejectHook
+	}
+	return RejectOther
+}
+
+// remoteLines 返回服务端输出中的非空提示行，去掉 "remote:" 前缀
+func remoteLines(progress string) string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(progress, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "remote:"))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
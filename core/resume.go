@@ -0,0 +1,137 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// defaultResumeBatchSize 是可续传的首次克隆默认每批取回的文件数
+const defaultResumeBatchSize = 64
+
+var resumeBatchSize atomic.Int64
+
+func init() {
+	resumeBatchSize.Store(defaultResumeBatchSize)
+}
+
+// SetResumeBatchSize 设置可续传的首次克隆每批取回的文件数（默认 64），小于 1 时恢复默认值。
+// 克隆中断时已完成的批次保留在缓存中，下一次尝试只取回剩下的文件；批次越小，中断时丢失的进度越少，但请求次数越多
+func SetResumeBatchSize(n int) {
+	if n < 1 {
+		n = defaultResumeBatchSize
+	}
+	resumeBatchSize.Store(int64(n))
+}
+
+// resumeRefPrefix 下的引用记录可续传克隆的进度：指向的 commit 及其 tree 已全部在缓存中，文件内容可能不完整
+const resumeRefPrefix = "refs/mixgram/resume/"
+
+// fetchResumable 把 refName（远端位于 head）分阶段取回缓存：先以 filter=blob:none 取回 commit 和 tree，
+// 再按批取回缺少的文件，全部完成后才更新跟踪引用。用于缓存中还没有该分支或上次克隆中断时，
+// 服务端需要支持 filter 和按哈希取回对象，不支持时返回 false，调用方应改用普通取回
+func fetchResumable(ctx context.Context, cache *git.Repository, repoURL string, auth transport.AuthMethod, refName plumbing.ReferenceName, head plumbing.Hash) (bool, error) {
+	tracking := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, refName.Short())
+	progress := plumbing.ReferenceName(resumeRefPrefix + refName.Short())
+	var haves []plumbing.Hash
+	tracked, err := cache.Storer.Reference(tracking)
+	if err == nil {
+		haves = append(haves, tracked.Hash())
+	}
+	partial, err := cache.Storer.Reference(progress)
+	if err == nil {
+		haves = append(haves, partial.Hash())
+	}
+	if tracked != nil && partial == nil {
+		// 缓存中已有该分支且没有未完成的克隆，普通的增量取回即可
+		return false, nil
+	}
+
+	supported := false
+	err = uploadPack(ctx, repoURL, auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+		if ar == nil || !ar.Capabilities.Supports(capability.Filter) || !ar.Capabilities.Supports(capability.AllowReachableSHA1InWant) {
+			return nil
+		}
+		supported = true
+		for _, have := range haves {
+			if have == head {
+				return nil
+			}
+		}
+		return fetchObjects(ctx, s, ar.Capabilities, []plumbing.Hash{head}, haves, packp.FilterBlobNone(), cache.Storer)
+	})
+	if err != nil || !supported {
+		return supported, err
+	}
+	if err := cache.Storer.SetReference(plumbing.NewHashReference(progress, head)); err != nil {
+		return true, fmt.Errorf("clone: %w", err)
+	}
+
+	hashes, err := revlist.Objects(cache.Storer, []plumbing.Hash{head}, nil)
+	if err != nil {
+		return true, fmt.Errorf("walk cache: %w", err)
+	}
+	var missing []plumbing.Hash
+	for _, hash := range hashes {
+		if cache.Storer.HasEncodedObject(hash) != nil {
+			missing = append(missing, hash)
+		}
+	}
+	batch := int(resumeBatchSize.Load())
+	for len(missing) > 0 {
+		wants := missing[:min(batch, len(missing))]
+		err := uploadPack(ctx, repoURL, auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+			if ar == nil {
+				return transport.ErrEmptyRemoteRepository
+			}
+			return fetchObjects(ctx, s, ar.Capabilities, wants, nil, "", cache.Storer)
+		})
+		if err != nil {
+			return true, err
+		}
+		missing = missing[len(wants):]
+	}
+
+	if err := cache.Storer.SetReference(plumbing.NewHashReference(tracking, head)); err != nil {
+		return true, fmt.Errorf("clone: %w", err)
+	}
+	if err := cache.Storer.RemoveReference(progress); err != nil {
+		return true, fmt.Errorf("clone: %w", err)
+	}
+	return true, nil
+}
+
+// resumeRefs 列出缓存中全部未完成的可续传克隆
+func resumeRefs(cache *git.Repository) []plumbing.ReferenceName {
+	iter, err := cache.Storer.IterReferences()
+	if err != nil {
+		return nil
+	}
+	var names []plumbing.ReferenceName
+	iter.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), resumeRefPrefix) {
+			names = append(names, ref.Name())
+		}
+		return nil
+	})
+	return names
+}
+
+// dropResumeRefs 删除未完成的克隆记录。go-git 取回时把全部本地引用作为 have 发送，
+// 而这些引用可达的文件内容并不完整，普通取回前必须删除
+func dropResumeRefs(cache *git.Repository) error {
+	for _, name := range resumeRefs(cache) {
+		if err := cache.Storer.RemoveReference(name); err != nil {
+			return fmt.Errorf("clone: %w", err)
+		}
+	}
+	return nil
+}
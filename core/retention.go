@@ -0,0 +1,309 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RetentionPolicy 描述历史保留策略，零值字段表示不限制；多个条件同时设置时取最严格的一个
+type RetentionPolicy struct {
+	// KeepCommits 最多保留的 commit 数量
+	KeepCommits int `json:"keepCommits,omitempty"`
+	// MaxAgeDays 只保留最近多少天内的 commit
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
+	// MaxBytes 保留的历史中所有文件内容（去重后）的总字节数上限
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+	// WriteSummary 裁剪时是否在新的根提交中写入被删除历史的摘要
+	WriteSummary bool `json:"writeSummary,omitempty"`
+}
+
+// ChannelRetention 是频道的消息保留策略，保存在频道的 channel.json 中；零值字段表示不限制，
+// 多个条件同时设置时取最严格的一个
+type ChannelRetention struct {
+	// KeepMessages 最多保留的消息数量
+	KeepMessages int `json:"keepMessages,omitempty"`
+	// MaxAgeDays 只保留最近多少天内发送的消息
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
+	// MaxBytes 保留的消息内容的总字节数上限
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// SetRetentionPolicy 把频道 channel 的保留策略（ChannelRetention 的 JSON，空字符串表示取消）写入频道的 channel.json 并推送，
+// 使所有设备执行维护（EnforceRetention、SetAutoTrim）时使用同一策略
+func SetRetentionPolicy(repoURL, sshKeyPEM string, channel, policyJSON string) error {
+	var policy *ChannelRetention
+	if policyJSON != "" {
+		policy = &ChannelRetention{}
+		if err := json.Unmarshal([]byte(policyJSON), policy); err != nil {
+			return fmt.Errorf("decode policy: %w", err)
+		}
+	}
+	if err := validateChannelName(channel); err != nil {
+		return err
+	}
+	h, head, err := openChannel(repoURL, sshKeyPEM, channel)
+	if err != nil {
+		return err
+	}
+	ch, err := readChannel(h, head.Hash(), channel)
+	if err != nil {
+		return err
+	}
+	ch.Retention = policy
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	infoPath := path.Join(channelsDir, channel, channelInfoFile)
+	_, err = h.commitAndPush(head.Hash(), "set retention of "+channel, map[string][]byte{infoPath: data})
+	return err
+}
+
+// GetRetentionPolicyJSON 返回频道 channel 的保留策略（ChannelRetention 的 JSON），未设置时返回空字符串
+func GetRetentionPolicyJSON(repoURL, sshKeyPEM string, channel string) (string, error) {
+	if err := validateChannelName(channel); err != nil {
+		return "", err
+	}
+	h, head, err := openChannel(repoURL, sshKeyPEM, channel)
+	if err != nil {
+		return "", err
+	}
+	ch, err := readChannel(h, head.Hash(), channel)
+	if err != nil || ch.Retention == nil {
+		return "", err
+	}
+	data, err := json.Marshal(ch.Retention)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// EnforceRetention 按各频道 channel.json 中的保留策略，从全部历史中清除过期的消息及其编辑记录、回应、删除标记和话题索引，
+// 返回清除的消息数量；没有消息过期时什么也不做。此操作会重写历史记录。
+func EnforceRetention(repoURL, sshKeyPEM string) (int, error) {
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	head := h.commits[0].Hash
+	channels, err := listChannels(h, head)
+	if err != nil {
+		return 0, err
+	}
+
+	purge := map[string][]byte{}
+	removed := 0
+	for _, ch := range channels {
+		if ch.Retention == nil {
+			continue
+		}
+		n, err := h.expireMessages(head, ch.Name, ch.Retention, purge)
+		if err != nil {
+			return 0, fmt.Errorf("channel %s: %w", ch.Name, err)
+		}
+		removed += n
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	var editErr error
+	newHead, err := h.relink(plumbing.ZeroHash, rootToHead(h.commits), nil, func(old, c *object.Commit) bool {
+		if editErr != nil {
+			return false
+		}
+		treeHash, err := editTree(h.repo.Storer, old.TreeHash, purge)
+		if err != nil {
+			editErr = err
+			return false
+		}
+		c.TreeHash = treeHash
+		return treeHash != old.TreeHash
+	})
+	if editErr != nil {
+		return 0, fmt.Errorf("purge messages: %w", editErr)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// expireMessages 找出频道 channel 中按 policy 已过期的消息，把要删除的路径（值为 nil）加入 purge，返回过期的消息数量
+func (h *history) expireMessages(commit plumbing.Hash, channel string, policy *ChannelRetention, purge map[string][]byte) (int, error) {
+	paths, err := messagePaths(h, commit, channelMessagesDir(channel))
+	if err != nil {
+		return 0, err
+	}
+	keep := len(paths)
+	if policy.KeepMessages > 0 && policy.KeepMessages < keep {
+		keep = policy.KeepMessages
+	}
+	if policy.MaxAgeDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -policy.MaxAgeDays).UnixMilli()
+		for i, p := range paths[:keep] {
+			if ts, ok := messagePathTime(p); ok && ts < cutoff {
+				keep = i
+				break
+			}
+		}
+	}
+
+	// 转存的大文件按其原始大小计算，被保留的消息仍引用的转存文件不删除
+	blobs := map[string]bool{}
+	var total int64
+	for i, p := range paths {
+		content, err := readRefFile(h.repo, commit, p)
+		if err != nil {
+			return 0, err
+		}
+		size := int64(len(content))
+		stub, isStub := parseOverflowStub(content)
+		if isStub {
+			size = stub.Size
+		}
+		if i < keep && policy.MaxBytes > 0 {
+			if total += size; total > policy.MaxBytes {
+				keep = i
+			}
+		}
+		if isStub {
+			blobs[stub.Blob] = blobs[stub.Blob] || i < keep
+		}
+	}
+	for blob, used := range blobs {
+		if !used {
+			purge[blob] = nil
+		}
+	}
+
+	for _, p := range paths[keep:] {
+		msg, err := readMessage(h, commit, p)
+		if err != nil {
+			return 0, err
+		}
+		purge[p] = nil
+		purge[path.Join(editStoreDir(channel), msg.ID)] = nil
+		purge[path.Join(reactionStoreDir(channel), msg.ID)] = nil
+		purge[tombstonePath(channel, msg.ID)] = nil
+		purge[threadIndexPath(channel, msg.threadID(), p)] = nil
+	}
+	return len(paths) - keep, nil
+}
+
+// ApplyRetentionPolicy 按给定策略裁剪历史，返回删除的 commit 数量。
+// 此操作可能会重写历史记录。
+func ApplyRetentionPolicy(repoURL, sshKeyPEM string, policy *RetentionPolicy) (int, error) {
+	if policy == nil {
+		return 0, nil
+	}
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return 0, err
+	}
+
+	keep, err := h.retainedCount(policy)
+	if err != nil {
+		return 0, err
+	}
+	if keep >= len(h.commits) {
+		return 0, nil
+	}
+	removed := len(h.commits) - keep
+	if err := h.trim(keep, &RewriteOptions{TrimSummary: policy.WriteSummary}); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// retainedCount 计算按策略应保留的最新 commit 数量（至少为 1）
+func (h *history) retainedCount(policy *RetentionPolicy) (int, error) {
+	keep := len(h.commits)
+	if policy.KeepCommits > 0 && policy.KeepCommits < keep {
+		keep = policy.KeepCommits
+	}
+
+	if policy.MaxAgeDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -policy.MaxAgeDays)
+		for i, c := range h.commits[:keep] {
+			if c.Author.When.Before(cutoff) {
+				keep = i
+				break
+			}
+		}
+	}
+
+	if policy.MaxBytes > 0 {
+		seen := map[plumbing.Hash]bool{}
+		var total int64
+		for i, c := range h.commits[:keep] {
+			size, err := h.newContentSize(c.TreeHash, seen)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+			if total > policy.MaxBytes {
+				keep = i
+				break
+			}
+		}
+	}
+
+	if keep < 1 {
+		keep = 1
+	}
+	return keep, nil
+}
+
+// newContentSize 统计树中尚未出现过的 blob 的总大小，已统计过的树和 blob 会被跳过
+func (h *history) newContentSize(treeHash plumbing.Hash, seen map[plumbing.Hash]bool) (int64, error) {
+	if seen[treeHash] {
+		return 0, nil
+	}
+	seen[treeHash] = true
+
+	tree, err := object.GetTree(h.repo.Storer, treeHash)
+	if err != nil {
+		return 0, fmt.Errorf("get tree %s: %w", treeHash, err)
+	}
+	var total int64
+	for _, e := range tree.Entries {
+		if seen[e.Hash] {
+			continue
+		}
+		switch e.Mode {
+		case filemode.Dir:
+			size, err := h.newContentSize(e.Hash, seen)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		case filemode.Submodule:
+		default:
+			seen[e.Hash] = true
+			size, err := h.repo.Storer.EncodedObjectSize(e.Hash)
+			if err != nil {
+				return 0, fmt.Errorf("blob size %s: %w", e.Hash, err)
+			}
+			total += size
+		}
+	}
+	return total, nil
+}
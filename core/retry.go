@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"mixgram-core/internel/ratelimit"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RetryPolicy 控制网络操作（克隆、取回、推送、引用协商）失败后的重试
+type RetryPolicy struct {
+	// MaxAttempts 总尝试次数（包含第一次），小于等于 1 表示不重试
+	MaxAttempts int `json:"maxAttempts"`
+	// BaseDelayMs 第一次重试前的等待时间，之后每次翻倍
+	BaseDelayMs int64 `json:"baseDelayMs"`
+	// MaxDelayMs 单次等待时间的上限，0 表示不限制
+	MaxDelayMs int64 `json:"maxDelayMs"`
+	// Jitter 随机抖动比例（0~1），实际等待时间在 delay*(1-Jitter) 到 delay 之间
+	Jitter float64 `json:"jitter"`
+}
+
+// DefaultRetryPolicy 是未调用 SetRetryPolicy 时使用的重试策略
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelayMs: 500, MaxDelayMs: 8000, Jitter: 0.5}
+
+var (
+	retryMu     sync.Mutex
+	retryPolicy = DefaultRetryPolicy
+)
+
+// SetRetryPolicy 设置全局重试策略，传 nil 恢复 DefaultRetryPolicy
+func SetRetryPolicy(policy *RetryPolicy) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	if policy == nil {
+		retryPolicy = DefaultRetryPolicy
+		return
+	}
+	retryPolicy = *policy
+}
+
+// rateLimitMaxWait 是访问被限流的主机前最多等待的时间，退避时间更长时直接返回 *RateLimitError，不再访问远端
+const rateLimitMaxWait = 30 * time.Second
+
+// withRetry 执行访问 repoURL 的网络操作 op，遇到可重试的网络错误时按重试策略退避后重新执行。
+// 每次尝试使用独立的 ctx，受 SetNetworkTimeouts 设置的操作超时限制，并占用一个网络操作名额（见 SetMaxConcurrentNetworkOps）。
+// 远端限流时按该主机的退避时间（服务端提示的等待时间优先）等待后重试，退避时间超过 rateLimitMaxWait 时返回 *RateLimitError。
+// op 必须可以安全地重复执行（例如每次都重新克隆到新的存储中，或推送相同的 refspec）。
+// 远端使用不支持的对象格式时返回 ObjectFormatError
+func withRetry(repoURL string, op func(ctx context.Context) error) error {
+	retryMu.Lock()
+	policy := retryPolicy
+	retryMu.Unlock()
+
+	host := remoteHost(repoURL)
+	delay := time.Duration(policy.BaseDelayMs) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		if wait := ratelimit.Wait(host); wait > rateLimitMaxWait {
+			return &RateLimitError{Host: host, RetryAfter: wait}
+		} else if wait > 0 {
+			time.Sleep(wait)
+		}
+		release := acquireNetwork()
+		trackInFlight(1)
+		ctx, cancel := operationContext()
+		transfer, start := &transferOp{}, time.Now()
+		err := op(withTransferOp(ctx, transfer))
+		cancel()
+		trackInFlight(-1)
+		release()
+		transfer.finish(time.Since(start), ignoreUpToDate(err))
+		if host != "" && isRateLimited(err) {
+			backoff := ratelimit.Hit(host, ratelimit.RetryAfterText(err.Error()))
+			if attempt >= policy.MaxAttempts || backoff > rateLimitMaxWait {
+				return err
+			}
+			continue
+		}
+		if err == nil || !isRetryable(err) {
+			ratelimit.Success(host)
+		}
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryable(err) {
+			return objectFormatError(err)
+		}
+		time.Sleep(jittered(delay, policy.Jitter))
+		delay *= 2
+		if limit := time.Duration(policy.MaxDelayMs) * time.Millisecond; limit > 0 && delay > limit {
+			delay = limit
+		}
+	}
+}
+
+// remoteHost 返回 repoURL 的主机名，本地路径和无法解析的地址返回空字符串
+func remoteHost(repoURL string) string {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return ""
+	}
+	return ep.Host
+}
+
+func jittered(delay time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return delay - time.Duration(rand.Float64()*jitter*float64(delay))
+}
+
+// isRetryable 判断错误是否是连接中断、超时等暂时性的网络错误；
+// 认证失败、仓库不存在、非快进等确定性的错误不重试
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETDOWN) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && (dnsErr.IsTemporary || dnsErr.IsTimeout) {
+		return true
+	}
+	// go-git 在部分路径上只保留了错误文本
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"connection reset", "broken pipe", "connection refused", "i/o timeout",
+		"unexpected eof", "use of closed network connection", "no route to host", "network is unreachable"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOffline 判断错误是否表示当前无法连接远端（网络中断、超时、域名无法解析等），
+// 离线队列据此决定把操作写入 outbox 而不是返回错误
+func isOffline(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if isRetryable(err) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"no such host", "temporary failure in name resolution", "network is down"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRateLimited 判断错误是否由远端限流引起（HTTP 429、托管平台或 SSH 服务端的限流提示）
+func isRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"rate limit", "too many requests", "throttl"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return httpStatus429.MatchString(msg)
+}
+
+// httpStatus429 匹配错误文本中独立的 429 状态码，不匹配哈希等内容中的数字
+var httpStatus429 = regexp.MustCompile(`\b429\b`)
+
+// isPushConflict 判断推送是否因远端分支已被其他设备更新而被拒绝（非快进，或服务端更新引用时发生竞争）。
+// 这类错误不能原样重试，需要取回新的远端头并在其上重新应用修改（见 rebaseAndPush）
+func isPushConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	// go-git 只返回错误文本；服务端拒绝时的文本来自 receive-pack 的状态报告
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"non-fast-forward", "fetch first", "failed to update ref", "cannot lock ref"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
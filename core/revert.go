@@ -0,0 +1,129 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// RevertConflictError 表示要撤销的文件在目标 commit 之后又被修改过，无法自动撤销
+type RevertConflictError struct {
+	Paths []string
+}
+
+func (e *RevertConflictError) Error() string {
+	return "revert conflict: " + strings.Join(e.Paths, ", ")
+}
+
+// RevertCommit 创建一个新的 commit 来撤销 commitHash 引入的修改并正常推送（非强制推送），
+// 不重写历史，适用于禁止强制推送的受保护分支。
+// 若相关文件之后又被修改过，返回 *RevertConflictError。
+func RevertCommit(repoURL, sshKeyPEM string, commitHash string) (string, error) {
+	h, head, err := openBranch(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	targetHash := plumbing.NewHash(commitHash)
+	if !containsCommit(h.repo, head.Hash(), targetHash) {
+		return "", errors.New("commit not found in history")
+	}
+	target, err := h.repo.CommitObject(targetHash)
+	if err != nil {
+		return "", fmt.Errorf("load commit: %w", err)
+	}
+	targetTree, err := target.Tree()
+	if err != nil {
+		return "", fmt.Errorf("get tree for commit %s: %w", commitHash, err)
+	}
+	var parentTree *object.Tree // 根提交的父树视为空树
+	if target.NumParents() > 0 {
+		parent, err := target.Parent(0)
+		if err != nil {
+			return "", fmt.Errorf("load parent: %w", err)
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return "", fmt.Errorf("get parent tree: %w", err)
+		}
+	}
+
+	headCommit, err := h.repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", fmt.Errorf("head commit: %w", err)
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("get head tree: %w", err)
+	}
+
+	changes, err := object.DiffTree(parentTree, targetTree)
+	if err != nil {
+		return "", fmt.Errorf("diff: %w", err)
+	}
+	if len(changes) == 0 {
+		return "", errors.New("commit has no changes to revert")
+	}
+
+	files := map[string][]byte{}
+	var conflicts []string
+	for _, change := range changes {
+		path := change.To.Name
+		if path == "" {
+			path = change.From.Name
+		}
+
+		// HEAD 中该文件必须仍是目标 commit 写入的版本
+		current, err := headTree.FindEntry(path)
+		switch {
+		case err == nil && change.To.Name != "" && current.Hash == change.To.TreeEntry.Hash:
+		case errors.Is(err, object.ErrEntryNotFound) && change.To.Name == "":
+		default:
+			conflicts = append(conflicts, path)
+			continue
+		}
+
+		if change.From.Name == "" {
+			files[path] = nil // 目标 commit 新增的文件，撤销即删除
+			continue
+		}
+		file, err := parentTree.TreeEntryFile(&change.From.TreeEntry)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", path, err)
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", path, err)
+		}
+		files[path] = []byte(content)
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return "", &RevertConflictError{Paths: conflicts}
+	}
+
+	treeHash, err := editTree(h.repo.Storer, headCommit.TreeHash, files)
+	if err != nil {
+		return "", fmt.Errorf("edit tree: %w", err)
+	}
+
+	subject, _, _ := strings.Cut(strings.TrimSpace(target.Message), "\n")
+	author, committer := identityFor(h.repoURL).signatures()
+	newHead, err := h.storeCommit(&object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      withProvenance(fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.", subject, commitHash)),
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{head.Hash()},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := h.push(newHead, false); err != nil {
+		return "", err
+	}
+	return newHead.String(), nil
+}
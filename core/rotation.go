@@ -0,0 +1,235 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// 轮换指针的路径：旧仓库最后一个 commit 中的 successor.json 指向后继仓库，后继仓库根提交中的 predecessor.json 指向旧仓库
+const (
+	successorPath   = ".mixgram/successor.json"
+	predecessorPath = ".mixgram/predecessor.json"
+)
+
+// maxRotationHops 是沿轮换链查找时最多经过的仓库数量，防止指针成环
+const maxRotationHops = 32
+
+// ErrRepoRotated 表示仓库已轮换到后继仓库，不再接收新的消息，见 CurrentRepo
+var ErrRepoRotated = errors.New("repository has been rotated to a successor")
+
+// RotationPointer 是轮换链中指向另一个仓库的记录
+type RotationPointer struct {
+	URL string `json:"url"`
+	// Head 是轮换时旧仓库的 head（写入轮换指针之前）
+	Head      string `json:"head"`
+	RotatedAt int64  `json:"rotatedAt"`
+}
+
+// RotationPolicy 是需要轮换仓库的阈值，零值字段表示不限制；任一条件达到即需要轮换
+type RotationPolicy struct {
+	MaxCommits int `json:"maxCommits,omitempty"`
+	// MaxBytes 是历史中所有文件内容（去重后）的总字节数，见 RepoStatistics.ContentBytes
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// RotationResult 是 RotateRepo 的结果
+type RotationResult struct {
+	OldURL string `json:"oldUrl"`
+	NewURL string `json:"newUrl"`
+	// NewRoot 是后继仓库的根提交，FinalCommit 是旧仓库中写入轮换指针的 commit
+	NewRoot     string `json:"newRoot"`
+	FinalCommit string `json:"finalCommit"`
+}
+
+var (
+	rotationKeysMu sync.Mutex
+	rotationKeys   KeyProvider
+)
+
+// SetRotationKeys 设置沿轮换链读取其他仓库时使用的私钥来源，传 nil 时所有仓库使用调用方传入的同一个私钥
+func SetRotationKeys(keys KeyProvider) {
+	rotationKeysMu.Lock()
+	defer rotationKeysMu.Unlock()
+	rotationKeys = keys
+}
+
+// chainKey 返回轮换链中仓库 repoURL 的私钥，fallback 是调用方为链起点传入的私钥
+func chainKey(repoURL, fallback string) (string, error) {
+	rotationKeysMu.Lock()
+	keys := rotationKeys
+	rotationKeysMu.Unlock()
+	if keys == nil {
+		return fallback, nil
+	}
+	return keys.SSHKey(repoURL)
+}
+
+// RotateRepo 把仓库 oldURL 轮换到 newURL：在空仓库 newURL 上创建指向旧仓库的根提交，然后在旧仓库提交指向新仓库的最后一个 commit。
+// 之后向旧仓库发送消息返回 ErrRepoRotated，FetchMessages 读取新仓库时会在消息读完后继续读取旧仓库。
+// newURL 需事先创建（例如通过 providers），newSSHKeyPEM 为空时使用 sshKeyPEM。
+// 新仓库已由一次中断的轮换初始化过时继续完成轮换
+func RotateRepo(oldURL, sshKeyPEM, newURL, newSSHKeyPEM string) (*RotationResult, error) {
+	if oldURL == newURL {
+		return nil, errors.New("successor is the same repository")
+	}
+	if newSSHKeyPEM == "" {
+		newSSHKeyPEM = sshKeyPEM
+	}
+	h, head, err := openBranch(oldURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if next, err := readRotationPointer(h, head.Hash(), successorPath); err != nil {
+		return nil, err
+	} else if next != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRepoRotated, next.URL)
+	}
+
+	newRoot, err := initSuccessor(oldURL, newURL, newSSHKeyPEM, h.refName.Short(), head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&RotationPointer{URL: newURL, Head: head.Hash().String(), RotatedAt: commitTime().UnixMilli()})
+	if err != nil {
+		return nil, err
+	}
+	final, err := h.commitAndPush(head.Hash(), "Rotate to "+newURL, map[string][]byte{successorPath: data})
+	if err != nil {
+		return nil, err
+	}
+	return &RotationResult{OldURL: oldURL, NewURL: newURL, NewRoot: newRoot, FinalCommit: final.String()}, nil
+}
+
+// RotateRepoJSON 同 RotateRepo，以 JSON 返回 RotationResult
+func RotateRepoJSON(oldURL, sshKeyPEM, newURL, newSSHKeyPEM string) (string, error) {
+	result, err := RotateRepo(oldURL, sshKeyPEM, newURL, newSSHKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// RotateIfNeeded 按 policyJSON（RotationPolicy 的 JSON）检查仓库 oldURL，达到阈值时轮换到 newURL（同 RotateRepo），
+// 返回 RotationResult 的 JSON；未达到阈值时返回空字符串
+func RotateIfNeeded(oldURL, sshKeyPEM, newURL, newSSHKeyPEM string, policyJSON string) (string, error) {
+	var policy RotationPolicy
+	if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+		return "", fmt.Errorf("decode policy: %w", err)
+	}
+	stats, err := RepoStats(oldURL, sshKeyPEM, "")
+	if err != nil {
+		return "", err
+	}
+	if !policy.exceeded(stats) {
+		return "", nil
+	}
+	return RotateRepoJSON(oldURL, sshKeyPEM, newURL, newSSHKeyPEM)
+}
+
+func (p *RotationPolicy) exceeded(stats *RepoStatistics) bool {
+	return p.MaxCommits > 0 && stats.CommitCount >= p.MaxCommits ||
+		p.MaxBytes > 0 && stats.ContentBytes >= p.MaxBytes
+}
+
+// CurrentRepo 沿轮换指针返回仓库 repoURL 当前的后继仓库（未轮换时返回 repoURL 本身），用于切换到新仓库
+func CurrentRepo(repoURL, sshKeyPEM string) (string, error) {
+	url := repoURL
+	for range maxRotationHops {
+		key, err := chainKey(url, sshKeyPEM)
+		if err != nil {
+			return "", err
+		}
+		h, head, err := readBranch(url, key)
+		if err != nil {
+			return "", err
+		}
+		next, err := readRotationPointer(h, head.Hash(), successorPath)
+		if err != nil {
+			return "", err
+		}
+		if next == nil {
+			return url, nil
+		}
+		url = next.URL
+	}
+	return "", errors.New("rotation chain too long")
+}
+
+// initSuccessor 在空仓库 newURL 上创建指向 oldURL 的根提交，返回根提交的哈希
+func initSuccessor(oldURL, newURL, sshKeyPEM, branch string, oldHead plumbing.Hash) (string, error) {
+	auth, err := utils.NewSSHAuthForURL(newURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&RotationPointer{URL: oldURL, Head: oldHead.String(), RotatedAt: commitTime().UnixMilli()})
+	if err != nil {
+		return "", err
+	}
+	root, err := initRemote(newURL, auth, branch, map[string][]byte{predecessorPath: data}, "Continue from "+oldURL)
+	if !errors.Is(err, ErrRemoteNotEmpty) {
+		return root, err
+	}
+
+	// 只接受已经指向同一个旧仓库的后继仓库（上一次轮换在写入旧仓库前中断）
+	h, head, err := openBranch(newURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	prev, err := readRotationPointer(h, head.Hash(), predecessorPath)
+	if err != nil {
+		return "", err
+	}
+	if prev == nil || prev.URL != oldURL {
+		return "", ErrRemoteNotEmpty
+	}
+	return rootCommit(h, head.Hash())
+}
+
+// rootCommit 沿第一父提交返回 head 所在历史的根提交
+func rootCommit(h *history, head plumbing.Hash) (string, error) {
+	c, err := h.repo.CommitObject(head)
+	for err == nil && c.NumParents() > 0 {
+		c, err = c.Parent(0)
+	}
+	if err != nil {
+		return "", fmt.Errorf("walk history: %w", err)
+	}
+	return c.Hash.String(), nil
+}
+
+// readRotationPointer 读取 commit 中的轮换指针，不存在时返回 nil
+func readRotationPointer(h *history, commit plumbing.Hash, path string) (*RotationPointer, error) {
+	data, err := readRefFile(h.repo, commit, path)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p RotationPointer
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// chainCursor 把前驱仓库中的分页游标编码为 "<仓库地址>\n<消息路径>"，消息路径不会包含换行
+func chainCursor(repoURL, cursor string) string {
+	return repoURL + "\n" + cursor
+}
+
+// parseChainCursor 解析 chainCursor 生成的游标，普通游标返回 false
+func parseChainCursor(cursor string) (repoURL, path string, ok bool) {
+	return strings.Cut(cursor, "\n")
+}
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DefaultSecretPatterns 是未指定模式时 ScanForSecrets 和 RedactSecrets 使用的正则表达式：
+// 私钥、AWS access key、GitHub / GitLab / Slack token 和 Google API key。每个模式都匹配密钥的完整内容，
+// 私钥匹配从 BEGIN 到 END 的整块，而不只是首行
+var DefaultSecretPatterns = []string{
+	`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`,
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,
+	`\bglpat-[A-Za-z0-9_\-]{20,}\b`,
+	`\bxox[abposr]-[A-Za-z0-9\-]{10,}\b`,
+	`\bAIza[0-9A-Za-z_\-]{35}\b`,
+}
+
+// DefaultRedaction 是 RedactSecrets 未指定替换文本时使用的内容
+const DefaultRedaction = "[REDACTED]"
+
+// SecretScanMaxBytes 是扫描的单个文件的最大字节数，更大的文件和转存到 .mixgram/blobs/ 的大文件不扫描
+var SecretScanMaxBytes int64 = 1 << 20
+
+// SecretMatch 是历史中的一处疑似密钥，同一内容只在首次出现的 commit 中报告一次
+type SecretMatch struct {
+	Commit string `json:"commit"`
+	// Path 为空表示匹配出现在提交信息中
+	Path    string `json:"path,omitempty"`
+	Blob    string `json:"blob,omitempty"`
+	Pattern string `json:"pattern"`
+	// Line 是匹配所在的行号（从 1 开始）
+	Line int `json:"line"`
+	// Preview 只保留匹配内容的前 4 个字符，其余以 * 代替，结果本身不会泄露密钥
+	Preview string `json:"preview"`
+}
+
+// ScanForSecrets 从旧到新扫描当前分支全部历史中的文件内容和提交信息，返回与 patterns（为空时使用 DefaultSecretPatterns）匹配的位置。
+// 二进制文件、超过 SecretScanMaxBytes 的文件和转存的大文件不扫描
+func ScanForSecrets(repoURL, sshKeyPEM string, patterns []string) ([]SecretMatch, error) {
+	res, err := compileSecretPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []SecretMatch{}
+	seen := map[plumbing.Hash]bool{}
+	for _, c := range rootToHead(h.commits) {
+		for _, m := range findSecrets(res, []byte(c.Message)) {
+			m.Commit = c.Hash.String()
+			matches = append(matches, m)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("get tree %s: %w", c.TreeHash, err)
+		}
+		walker := object.NewTreeWalker(tree, true, seen)
+		for {
+			path, entry, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				walker.Close()
+				return nil, fmt.Errorf("walk tree: %w", err)
+			}
+			if !entry.Mode.IsFile() || entry.Mode == filemode.Symlink || seen[entry.Hash] || strings.HasPrefix(path, overflowDir+"/") {
+				continue
+			}
+			seen[entry.Hash] = true
+			content, ok, err := h.scannableBlob(entry.Hash)
+			if err != nil {
+				walker.Close()
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			for _, m := range findSecrets(res, content) {
+				m.Commit, m.Path, m.Blob = c.Hash.String(), path, entry.Hash.String()
+				matches = append(matches, m)
+			}
+		}
+		walker.Close()
+	}
+	return matches, nil
+}
+
+// ScanForSecretsJSON 同 ScanForSecrets，patternsJSON 为正则表达式的 JSON 数组（空字符串使用默认模式），返回 SecretMatch 的 JSON 数组
+func ScanForSecretsJSON(repoURL, sshKeyPEM string, patternsJSON string) (string, error) {
+	patterns, err := decodeSecretPatterns(patternsJSON)
+	if err != nil {
+		return "", err
+	}
+	matches, err := ScanForSecrets(repoURL, sshKeyPEM, patterns)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(matches)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// RedactSecrets 重写全部历史，把文件内容和提交信息中与 patterns（为空时使用 DefaultSecretPatterns）匹配的部分替换为 replacement
+// （空字符串表示 DefaultRedaction）并强制推送，返回被修改的 commit 数量。扫描范围同 ScanForSecrets。
+// 密钥一旦推送就应视为已泄露，重写历史只能防止继续扩散，仍需吊销该密钥。
+// 此操作会重写历史记录。
+func RedactSecrets(repoURL, sshKeyPEM string, patterns []string, replacement string) (int, error) {
+	res, err := compileSecretPatterns(patterns)
+	if err != nil {
+		return 0, err
+	}
+	if replacement == "" {
+		replacement = DefaultRedaction
+	}
+	repl := []byte(replacement)
+
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return 0, err
+	}
+
+	r := &redactor{h: h, res: res, repl: repl, trees: map[plumbing.Hash]plumbing.Hash{}, blobs: map[plumbing.Hash]plumbing.Hash{}}
+	changed := 0
+	var editErr error
+	newHead, err := h.relink(plumbing.ZeroHash, rootToHead(h.commits), nil, func(old, c *object.Commit) bool {
+		if editErr != nil {
+			return false
+		}
+		treeHash, err := r.tree(old.TreeHash, "")
+		if err != nil {
+			editErr = err
+			return false
+		}
+		message := string(redact(res, []byte(old.Message), repl))
+		if treeHash == old.TreeHash && message == old.Message {
+			return false
+		}
+		c.TreeHash, c.Message = treeHash, message
+		changed++
+		return true
+	})
+	if editErr != nil {
+		return 0, fmt.Errorf("redact: %w", editErr)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if changed == 0 {
+		fmt.Println("历史中没有匹配的内容，无需重写")
+		return 0, nil
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("成功从 %d 个 commit 中移除密钥，并重写历史\n", changed)
+	return changed, nil
+}
+
+// RedactSecretsJSON 同 RedactSecrets，patternsJSON 为正则表达式的 JSON 数组（空字符串使用默认模式）
+func RedactSecretsJSON(repoURL, sshKeyPEM string, patternsJSON string, replacement string) (int, error) {
+	patterns, err := decodeSecretPatterns(patternsJSON)
+	if err != nil {
+		return 0, err
+	}
+	return RedactSecrets(repoURL, sshKeyPEM, patterns, replacement)
+}
+
+// redactor 重写树中匹配的文件，相同的树和 blob 只处理一次
+type redactor struct {
+	h     *history
+	res   []*regexp.Regexp
+	repl  []byte
+	trees map[plumbing.Hash]plumbing.Hash
+	blobs map[plumbing.Hash]plumbing.Hash
+}
+
+// tree 返回替换了匹配内容后的树，dir 是该树的路径（根为空字符串）
+func (r *redactor) tree(hash plumbing.Hash, dir string) (plumbing.Hash, error) {
+	if done, ok := r.trees[hash]; ok {
+		return done, nil
+	}
+	tree, err := object.GetTree(r.h.repo.Storer, hash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("get tree %s: %w", hash, err)
+	}
+	entries := make([]object.TreeEntry, len(tree.Entries))
+	copy(entries, tree.Entries)
+	changed := false
+	for i, e := range entries {
+		p := e.Name
+		if dir != "" {
+			p = dir + "/" + e.Name
+		}
+		var newHash plumbing.Hash
+		switch {
+		case e.Mode == filemode.Dir:
+			if p == overflowDir {
+				continue
+			}
+			newHash, err = r.tree(e.Hash, p)
+		case e.Mode.IsFile() && e.Mode != filemode.Symlink:
+			newHash, err = r.blob(e.Hash)
+		default:
+			continue
+		}
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if newHash != e.Hash {
+			entries[i].Hash = newHash
+			changed = true
+		}
+	}
+	result := hash
+	if changed {
+		if result, err = storeTree(r.h.repo.Storer, entries); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	r.trees[hash] = result
+	return result, nil
+}
+
+func (r *redactor) blob(hash plumbing.Hash) (plumbing.Hash, error) {
+	if done, ok := r.blobs[hash]; ok {
+		return done, nil
+	}
+	result := hash
+	content, ok, err := r.h.scannableBlob(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if ok {
+		if redacted := redact(r.res, content, r.repl); !bytes.Equal(redacted, content) {
+			if result, err = storeBlob(r.h.repo.Storer, redacted); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+	}
+	r.blobs[hash] = result
+	return result, nil
+}
+
+// scannableBlob 读取 blob 的内容，超过 SecretScanMaxBytes 或含有 NUL 字节（二进制）时返回 false
+func (h *history) scannableBlob(hash plumbing.Hash) ([]byte, bool, error) {
+	size, err := h.repo.Storer.EncodedObjectSize(hash)
+	if err != nil {
+		return nil, false, fmt.Errorf("blob size %s: %w", hash, err)
+	}
+	if SecretScanMaxBytes > 0 && size > SecretScanMaxBytes {
+		return nil, false, nil
+	}
+	blob, err := h.repo.BlobObject(hash)
+	if err != nil {
+		return nil, false, fmt.Errorf("read blob %s: %w", hash, err)
+	}
+	rd, err := blob.Reader()
+	if err != nil {
+		return nil, false, fmt.Errorf("read blob %s: %w", hash, err)
+	}
+	defer rd.Close()
+	content, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, false, fmt.Errorf("read blob %s: %w", hash, err)
+	}
+	if bytes.IndexByte(content, 0) >= 0 {
+		return nil, false, nil
+	}
+	return content, true, nil
+}
+
+// findSecrets 返回 content 中的全部匹配
+func findSecrets(res []*regexp.Regexp, content []byte) []SecretMatch {
+	var matches []SecretMatch
+	for _, re := range res {
+		for _, loc := range re.FindAllIndex(content, -1) {
+			matches = append(matches, SecretMatch{
+				Pattern: re.String(),
+				Line:    bytes.Count(content[:loc[0]], []byte("\n")) + 1,
+				Preview: maskSecret(string(content[loc[0]:loc[1]])),
+			})
+		}
+	}
+	return matches
+}
+
+func redact(res []*regexp.Regexp, content, repl []byte) []byte {
+	for _, re := range res {
+		content = re.ReplaceAllLiteral(content, repl)
+	}
+	return content
+}
+
+func maskSecret(s string) string {
+	runes := []rune(s)
+	if len(runes) <= 4 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:4]) + strings.Repeat("*", len(runes)-4)
+}
+
+func compileSecretPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = DefaultSecretPatterns
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			return nil, errors.New("empty secret pattern")
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func decodeSecretPatterns(patternsJSON string) ([]string, error) {
+	if patternsJSON == "" {
+		return nil, nil
+	}
+	var patterns []string
+	if err := json.Unmarshal([]byte(patternsJSON), &patterns); err != nil {
+		return nil, fmt.Errorf("decode patterns: %w", err)
+	}
+	return patterns, nil
+}
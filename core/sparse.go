@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// PathFile 是 FetchPath 取回的一个文件
+type PathFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+
+// FetchPath 读取 HEAD 中 path（文件或目录，空字符串表示整个仓库）下的全部文件，转存的大文件会被透明地还原。
+// 服务端支持 filter 并允许按哈希取回对象时只下载 commit、tree 和 path 下的文件内容；
+// 否则退回浅克隆（不支持浅克隆时完整克隆）。主仓库不可用时改用备用远端（见 SetRemoteGroup）
+func FetchPath(repoURL, sshKeyPEM string, path string) ([]PathFile, error) {
+	path = strings.Trim(path, "/")
+	branch := RepoBranch(repoURL)
+	repo, _, err := readRemotes(repoURL, sshKeyPEM, func(ctx context.Context, url string, auth transport.AuthMethod) (*git.Repository, error) {
+		return clonePath(ctx, url, auth, branch, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("head: %w", err)
+	}
+	tree, err := headTree(repo, head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	entries, err := pathEntries(tree, path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]PathFile, 0, len(entries))
+	for _, e := range entries {
+		// 转存的内容已经在存根所在的路径上返回
+		if strings.HasPrefix(e.name, overflowDir+"/") {
+			continue
+		}
+		content, err := readCommitFile(repoURL, repo, head.Hash(), e.name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, PathFile{Path: e.name, Content: content})
+	}
+	return files, nil
+}
+
+// FetchPathJSON 同 FetchPath，以 JSON 返回 PathFile 数组（内容为 base64）
+func FetchPathJSON(repoURL, sshKeyPEM string, path string) (string, error) {
+	files, err := FetchPath(repoURL, sshKeyPEM, path)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(files)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// clonePath 同 cloneMetadata，并补取 HEAD 中 path 下的文件内容以及其中存根引用的转存文件。
+// 服务端不允许按哈希取回对象时退回深度为 1 的浅克隆
+func clonePath(ctx context.Context, repoURL string, auth transport.AuthMethod, branch, path string) (repo *git.Repository, err error) {
+	depth := 1
+	err = uploadPack(ctx, repoURL, auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+		if ar == nil || !ar.Capabilities.Supports(capability.Filter) || !ar.Capabilities.Supports(capability.AllowReachableSHA1InWant) {
+			if ar == nil || !ar.Capabilities.Supports(capability.Shallow) {
+				depth = 0
+			}
+			return nil
+		}
+		repo, err = fetchWithoutBlobs(ctx, s, ar, repoURL, branch)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		return cloneToMemory(ctx, repoURL, auth, branch, depth)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("head: %w", err)
+	}
+	tree, err := headTree(repo, head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	entries, err := pathEntries(tree, path)
+	if err != nil {
+		return nil, err
+	}
+	// 第一轮取回 path 下的文件，第二轮取回其中存根引用的转存文件
+	wants := missingBlobs(repo, entries)
+	for len(wants) > 0 {
+		err := uploadPack(ctx, repoURL, auth, func(s transport.UploadPackSession, ar *packp.AdvRefs) error {
+			if ar == nil {
+				return transport.ErrEmptyRemoteRepository
+			}
+			return fetchObjects(ctx, s, ar.Capabilities, wants, nil, "", repo.Storer)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if wants, err = overflowBlobs(repo, tree, wants); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+func headTree(repo *git.Repository, head plumbing.Hash) (*object.Tree, error) {
+	c, err := repo.CommitObject(head)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %w", head, err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	return tree, nil
+}
+
+type pathEntry struct {
+	name string
+	hash plumbing.Hash
+}
+
+// pathEntries 列出 tree 中 path 下的全部文件（不含子模块），只需要 tree 对象
+func pathEntries(tree *object.Tree, path string) ([]pathEntry, error) {
+	if path != "" {
+		e, err := tree.FindEntry(path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		if e.Mode != filemode.Dir {
+			return []pathEntry{{name: path, hash: e.Hash}}, nil
+		}
+		if tree, err = tree.Tree(path); err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+
+	var entries []pathEntry
+	w := object.NewTreeWalker(tree, true, nil)
+	defer w.Close()
+	for {
+		name, e, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("walk %s: %w", path, err)
+		}
+		if e.Mode == filemode.Dir || e.Mode == filemode.Submodule {
+			continue
+		}
+		if path != "" {
+			name = path + "/" + name
+		}
+		entries = append(entries, pathEntry{name: name, hash: e.Hash})
+	}
+	return entries, nil
+}
+
+// missingBlobs 返回 entries 中本地还没有的 blob，去掉重复
+func missingBlobs(repo *git.Repository, entries []pathEntry) []plumbing.Hash {
+	var wants []plumbing.Hash
+	seen := map[plumbing.Hash]bool{}
+	for _, e := range entries {
+		if seen[e.hash] || repo.Storer.HasEncodedObject(e.hash) == nil {
+			continue
+		}
+		seen[e.hash] = true
+		wants = append(wants, e.hash)
+	}
+	return wants
+}
+
+// overflowBlobs 返回 blobs 中存根所引用、本地还没有的转存文件
+func overflowBlobs(repo *git.Repository, tree *object.Tree, blobs []plumbing.Hash) ([]plumbing.Hash, error) {
+	var entries []pathEntry
+	for _, hash := range blobs {
+		blob, err := repo.BlobObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("blob %s: %w", hash, err)
+		}
+		// 存根只有几百字节，更大的文件不必读取
+		if blob.Size > 4<<10 {
+			continue
+		}
+		r, err := blob.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("blob %s: %w", hash, err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("blob %s: %w", hash, err)
+		}
+		stub, ok := parseOverflowStub(content)
+		if !ok {
+			continue
+		}
+		e, err := tree.FindEntry(stub.Blob)
+		if err != nil {
+			return nil, fmt.Errorf("overflow blob %s: %w", stub.Blob, err)
+		}
+		entries = append(entries, pathEntry{name: stub.Blob, hash: e.Hash})
+	}
+	return missingBlobs(repo, entries), nil
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/utils"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// SetCloneMemoryBudget 限制每个内存仓库（克隆、OpenRemote、从缓存载入）在内存中保存的对象字节数，budgetBytes <= 0 表示不限制（默认）。
+// 超出后，之后取回的包文件和写入的对象转存到 dir 下的临时目录，读取时按需从磁盘载入；
+// 临时目录在仓库被回收后删除。dir 应为 App 的缓存目录
+func SetCloneMemoryBudget(budgetBytes int64, dir string) error {
+	if budgetBytes <= 0 {
+		utils.SetStorageFactory(nil)
+		return nil
+	}
+	if dir == "" {
+		return errors.New("spill dir is empty")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create spill dir: %w", err)
+	}
+	utils.SetStorageFactory(func() storage.Storer {
+		return &spillStorage{Storage: memory.NewStorage(), budget: budgetBytes, dir: dir}
+	})
+	return nil
+}
+
+// spillStorage 是带内存上限的仓库存储：对象先保存在内存中，超过 budget 后转存到磁盘上的裸仓库。
+// 引用、配置等其他数据总在内存中
+type spillStorage struct {
+	*memory.Storage
+	budget int64
+	dir    string
+
+	mu   sync.Mutex
+	used int64
+	disk *filesystem.Storage
+}
+
+// spilled 返回磁盘上的存储，第一次调用时创建临时目录
+func (s *spillStorage) spilled() (*filesystem.Storage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disk != nil {
+		return s.disk, nil
+	}
+	tmp, err := os.MkdirTemp(s.dir, "spill-")
+	if err != nil {
+		return nil, fmt.Errorf("create spill dir: %w", err)
+	}
+	// 磁盘上对象的解码缓存占预算的四分之一，保留最常读取的对象
+	s.disk = filesystem.NewStorage(osfs.New(tmp), cache.NewObjectLRU(cache.FileSize(s.budget/4)))
+	runtime.AddCleanup(s, func(dir string) { os.RemoveAll(dir) }, tmp)
+	return s.disk, nil
+}
+
+// onDisk 返回已创建的磁盘存储，还没有转存时返回 nil
+func (s *spillStorage) onDisk() *filesystem.Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disk
+}
+
+// reserve 在内存预算内为 n 字节的对象记账，超出预算时返回 false
+func (s *spillStorage) reserve(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disk != nil || s.used+n > s.budget {
+		return false
+	}
+	s.used += n
+	return true
+}
+
+func (s *spillStorage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	if err := s.Storage.HasEncodedObject(obj.Hash()); err == nil {
+		return obj.Hash(), nil
+	}
+	if s.reserve(obj.Size()) {
+		return s.Storage.SetEncodedObject(obj)
+	}
+	disk, err := s.spilled()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return disk.SetEncodedObject(obj)
+}
+
+func (s *spillStorage) HasEncodedObject(h plumbing.Hash) error {
+	err := s.Storage.HasEncodedObject(h)
+	if disk := s.onDisk(); err != nil && disk != nil {
+		return disk.HasEncodedObject(h)
+	}
+	return err
+}
+
+func (s *spillStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.Storage.EncodedObjectSize(h)
+	if disk := s.onDisk(); err != nil && disk != nil {
+		return disk.EncodedObjectSize(h)
+	}
+	return size, err
+}
+
+func (s *spillStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.Storage.EncodedObject(t, h)
+	if disk := s.onDisk(); err != nil && disk != nil {
+		return disk.EncodedObject(t, h)
+	}
+	return obj, err
+}
+
+func (s *spillStorage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	mem, err := s.Storage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+	disk := s.onDisk()
+	if disk == nil {
+		return mem, nil
+	}
+	onDisk, err := disk.IterEncodedObjects(t)
+	if err != nil {
+		mem.Close()
+		return nil, err
+	}
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{mem, onDisk}), nil
+}
+
+func (s *spillStorage) ForEachObjectHash(fn func(plumbing.Hash) error) error {
+	stopped := false
+	err := s.Storage.ForEachObjectHash(func(h plumbing.Hash) error {
+		err := fn(h)
+		stopped = err == storer.ErrStop
+		return err
+	})
+	if err != nil || stopped {
+		return err
+	}
+	if disk := s.onDisk(); disk != nil {
+		return disk.ForEachObjectHash(fn)
+	}
+	return nil
+}
+
+// forget 从内存中删除对象并归还其预算
+func (s *spillStorage) forget(h plumbing.Hash) {
+	obj, ok := s.Objects[h]
+	if !ok {
+		return
+	}
+	delete(s.Objects, h)
+	delete(s.Blobs, h)
+	s.mu.Lock()
+	s.used -= obj.Size()
+	s.mu.Unlock()
+}
+
+// PackfileWriter 接收取回的包文件：在预算内时解析到内存，超出时整个包文件写入磁盘并建立索引
+func (s *spillStorage) PackfileWriter() (io.WriteCloser, error) {
+	return &spillWriter{s: s}, nil
+}
+
+type spillWriter struct {
+	s   *spillStorage
+	buf bytes.Buffer
+	// w 是转存后磁盘存储的包文件写入器
+	w io.WriteCloser
+}
+
+func (w *spillWriter) Write(p []byte) (int, error) {
+	if w.w != nil {
+		return w.w.Write(p)
+	}
+	w.buf.Write(p)
+	w.s.mu.Lock()
+	over := w.s.disk != nil || w.s.used+int64(w.buf.Len()) > w.s.budget
+	w.s.mu.Unlock()
+	if !over {
+		return len(p), nil
+	}
+	disk, err := w.s.spilled()
+	if err != nil {
+		return 0, err
+	}
+	if w.w, err = disk.PackfileWriter(); err != nil {
+		return 0, err
+	}
+	if _, err := w.w.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buf = bytes.Buffer{}
+	return len(p), nil
+}
+
+func (w *spillWriter) Close() error {
+	if w.w != nil {
+		return w.w.Close()
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	// 隐藏 PackfileWriter，让解析出的对象经由 SetEncodedObject 逐个记账
+	return packfile.UpdateObjectStorage(objectsOnly{w.s}, &w.buf)
+}
+
+type objectsOnly struct {
+	storer.Storer
+}
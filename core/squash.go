@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// SquashLastN 把最近的 n 条 commit 合并为一条，内容为最新 commit 的状态，并强制推送。
+// newMessage 为空时使用被合并 commit 的信息拼接而成。
+// 此操作会重写历史记录。
+func SquashLastN(repoURL, sshKeyPEM string, n int, newMessage string) error {
+	return SquashLastNWithOptions(repoURL, sshKeyPEM, n, newMessage, nil)
+}
+
+// SquashLastNWithOptions 同 SquashLastN，可通过 opts 控制重写细节
+func SquashLastNWithOptions(repoURL, sshKeyPEM string, n int, newMessage string, opts *RewriteOptions) error {
+	if n < 2 {
+		return errors.New("need at least 2 commits to squash")
+	}
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+	if n > len(h.commits) {
+		return fmt.Errorf("only %d commits in history", len(h.commits))
+	}
+	return h.squash(n-1, 0, newMessage, opts)
+}
+
+// SquashRange 把 fromHash 到 toHash（闭区间，先后顺序不限）之间的 commit 合并为一条，
+// 内容为区间内最新 commit 的状态，之后的 commit 重新接到合并结果上，然后强制推送。
+// newMessage 为空时使用被合并 commit 的信息拼接而成。
+// 此操作会重写历史记录。
+func SquashRange(repoURL, sshKeyPEM string, fromHash, toHash string, newMessage string) error {
+	return SquashRangeWithOptions(repoURL, sshKeyPEM, fromHash, toHash, newMessage, nil)
+}
+
+// SquashRangeWithOptions 同 SquashRange，可通过 opts 控制重写细节
+func SquashRangeWithOptions(repoURL, sshKeyPEM string, fromHash, toHash string, newMessage string, opts *RewriteOptions) error {
+	defer lockRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	fromIndex := h.indexOf(plumbing.NewHash(fromHash))
+	toIndex := h.indexOf(plumbing.NewHash(toHash))
+	if fromIndex == -1 || toIndex == -1 {
+		return errors.New("commit not found in history")
+	}
+	if fromIndex < toIndex {
+		fromIndex, toIndex = toIndex, fromIndex
+	}
+	if fromIndex == toIndex {
+		return errors.New("need at least 2 commits to squash")
+	}
+	return h.squash(fromIndex, toIndex, newMessage, opts)
+}
+
+// squash 合并 commits[newest..oldest]（索引按 HEAD -> Root 顺序）并强制推送
+func (h *history) squash(oldest, newest int, newMessage string, opts *RewriteOptions) error {
+	group := h.commits[newest : oldest+1]
+	last := group[0]
+
+	if newMessage == "" {
+		var parts []string
+		for i := len(group) - 1; i >= 0; i-- {
+			parts = append(parts, strings.TrimSpace(group[i].Message))
+		}
+		newMessage = strings.Join(parts, "\n\n")
+	}
+
+	parents := []plumbing.Hash{}
+	if oldest+1 < len(h.commits) {
+		parents = []plumbing.Hash{h.commits[oldest+1].Hash}
+	}
+	squashed, err := h.storeCommit(&object.Commit{
+		Author:       last.Author,
+		Committer:    identityFor(h.repoURL).committer(),
+		Message:      withProvenance(newMessage),
+		TreeHash:     last.TreeHash,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return err
+	}
+
+	newHead, err := h.relink(squashed, rootToHead(h.commits[:newest]), opts, nil)
+	if err != nil {
+		return err
+	}
+	if err := h.forcePush(newHead); err != nil {
+		return err
+	}
+
+	fmt.Printf("成功把 %d 个 commit 合并为 1 个，并重写历史\n", len(group))
+	return nil
+}
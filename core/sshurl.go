@@ -0,0 +1,22 @@
+package core
+
+import "mixgram-core/internel/utils"
+
+// NormalizeSSHURL 把 SSH 仓库地址（ssh:// 或 scp 风格）规范化为 ssh://user@host:port/path，
+// user 不为空或 port 大于 0 时覆盖地址中的用户名或端口；没有用户名时使用 "git"
+func NormalizeSSHURL(repoURL, user string, port int) (string, error) {
+	ep, err := utils.ParseSSHURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		ep.User = user
+	}
+	if ep.User == "" {
+		ep.User = utils.DefaultSSHUser
+	}
+	if port > 0 {
+		ep.Port = port
+	}
+	return ep.String(), nil
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// largestBlobCount 是 RepoStats 返回的最大文件数量
+const largestBlobCount = 10
+
+// BlobStat 是历史中的一个文件内容
+type BlobStat struct {
+	Hash string `json:"hash"`
+	// Path 和 Commit 是该内容在最新的包含它的 commit 中的路径
+	Path   string `json:"path"`
+	Commit string `json:"commit"`
+	Size   int64  `json:"size"`
+}
+
+// RepoStatistics 是仓库当前分支的统计信息
+type RepoStatistics struct {
+	Branch      string `json:"branch"`
+	Head        string `json:"head"`
+	CommitCount int    `json:"commitCount"`
+	BranchCount int    `json:"branchCount"`
+	TagCount    int    `json:"tagCount"`
+	ObjectCount int    `json:"objectCount"`
+	// ContentBytes 是历史中所有文件内容（去重后）的总字节数，与 RetentionPolicy.MaxBytes 的计算方式相同
+	ContentBytes int64 `json:"contentBytes"`
+	// ApproxPackBytes 是所有对象逐个压缩后的总大小，不计增量压缩，通常略大于远端实际的包大小
+	ApproxPackBytes int64      `json:"approxPackBytes"`
+	LargestBlobs    []BlobStat `json:"largestBlobs"`
+	// 以下字段仅在指定 sinceHash 时有值：该 commit 之后新增的 commit 数量和文件内容字节数
+	Since        string `json:"since,omitempty"`
+	CommitsSince int    `json:"commitsSince,omitempty"`
+	BytesSince   int64  `json:"bytesSince,omitempty"`
+}
+
+// RepoStats 统计仓库当前分支的 commit 数量、分支和标签数量、内容和近似包大小以及最大的文件，
+// 用于决定保留策略（RetentionPolicy.MaxBytes、KeepCommits）的取值。
+// sinceHash 不为空时另外统计该 commit 之后的增长，该 commit 不在当前分支历史中时返回错误
+func RepoStats(repoURL, sshKeyPEM string, sinceHash string) (*RepoStatistics, error) {
+	defer shareRepo(repoURL)()
+	h, err := loadHistory(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := ListRemoteRefs(repoURL, sshKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	stats := &RepoStatistics{
+		Branch:       h.refName.Short(),
+		CommitCount:  len(h.commits),
+		BranchCount:  len(refs.Branches),
+		TagCount:     len(refs.Tags),
+		LargestBlobs: []BlobStat{},
+	}
+	if len(h.commits) > 0 {
+		stats.Head = h.commits[0].Hash.String()
+	}
+
+	if sinceHash != "" {
+		since := plumbing.NewHash(sinceHash)
+		idx := -1
+		for i, c := range h.commits {
+			if c.Hash == since {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, errors.New("commit not found in history")
+		}
+		// 先标记该 commit 及更早的内容，再统计之后新增的内容
+		seen := map[plumbing.Hash]bool{}
+		for _, c := range h.commits[idx:] {
+			if _, err := h.newContentSize(c.TreeHash, seen); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range h.commits[:idx] {
+			size, err := h.newContentSize(c.TreeHash, seen)
+			if err != nil {
+				return nil, err
+			}
+			stats.BytesSince += size
+		}
+		stats.Since = since.String()
+		stats.CommitsSince = idx
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	for _, c := range h.commits {
+		size, err := h.newContentSize(c.TreeHash, seen)
+		if err != nil {
+			return nil, err
+		}
+		stats.ContentBytes += size
+	}
+	if stats.LargestBlobs, err = h.largestBlobs(largestBlobCount); err != nil {
+		return nil, err
+	}
+	if stats.ObjectCount, stats.ApproxPackBytes, err = h.packEstimate(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// RepoStatsJSON 同 RepoStats，以 JSON 返回
+func RepoStatsJSON(repoURL, sshKeyPEM string, sinceHash string) (string, error) {
+	stats, err := RepoStats(repoURL, sshKeyPEM, sinceHash)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// largestBlobs 从新到旧遍历历史，返回最大的 n 个文件内容
+func (h *history) largestBlobs(n int) ([]BlobStat, error) {
+	var blobs []BlobStat
+	seen := map[plumbing.Hash]bool{}
+	for _, c := range h.commits {
+		tree, err := object.GetTree(h.repo.Storer, c.TreeHash)
+		if err != nil {
+			return nil, fmt.Errorf("get tree %s: %w", c.TreeHash, err)
+		}
+		walker := object.NewTreeWalker(tree, true, seen)
+		for {
+			path, entry, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				walker.Close()
+				return nil, fmt.Errorf("walk tree: %w", err)
+			}
+			if !entry.Mode.IsFile() || seen[entry.Hash] {
+				continue
+			}
+			seen[entry.Hash] = true
+			size, err := h.repo.Storer.EncodedObjectSize(entry.Hash)
+			if err != nil {
+				walker.Close()
+				return nil, fmt.Errorf("blob size %s: %w", entry.Hash, err)
+			}
+			blobs = append(blobs, BlobStat{Hash: entry.Hash.String(), Path: path, Commit: c.Hash.String(), Size: size})
+		}
+		walker.Close()
+	}
+	sort.SliceStable(blobs, func(i, j int) bool { return blobs[i].Size > blobs[j].Size })
+	if len(blobs) > n {
+		blobs = blobs[:n]
+	}
+	if blobs == nil {
+		blobs = []BlobStat{}
+	}
+	return blobs, nil
+}
+
+// packEstimate 统计克隆中的对象数量，以及逐个 zlib 压缩后的总大小
+func (h *history) packEstimate() (int, int64, error) {
+	iter, err := h.repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer iter.Close()
+	var count int
+	var cw countingWriter
+	zw := zlib.NewWriter(&cw)
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		r, err := obj.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		zw.Reset(&cw)
+		if _, err := io.Copy(zw, r); err != nil {
+			return err
+		}
+		count++
+		return zw.Close()
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("estimate pack size: %w", err)
+	}
+	return count, cw.n, nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// StorageBackend 提供本地仓库缓存（见 SetCacheDir）所在的文件系统。
+// 除本地目录外，服务端部署可以接入加密文件系统、App 沙盒存储或基于对象存储的 billy.Filesystem
+type StorageBackend interface {
+	// Filesystem 返回仓库 repoURL 的缓存裸仓库所在的文件系统，同一仓库每次调用应指向同一位置
+	Filesystem(repoURL string) (billy.Filesystem, error)
+}
+
+// fsStorage 把每个仓库放在 fs 中以仓库地址哈希命名的子目录里
+type fsStorage struct {
+	fs billy.Filesystem
+}
+
+// NewFilesystemStorage 返回把各仓库的缓存存放在 fs 中的 StorageBackend，例如 memfs.New() 可以在进程内保留缓存
+func NewFilesystemStorage(fs billy.Filesystem) StorageBackend {
+	return fsStorage{fs: fs}
+}
+
+// NewDirStorage 返回把各仓库的缓存存放在本地目录 dir 中的 StorageBackend，即 SetCacheDir 使用的存储
+func NewDirStorage(dir string) (StorageBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	return fsStorage{fs: osfs.New(dir)}, nil
+}
+
+func (s fsStorage) Filesystem(repoURL string) (billy.Filesystem, error) {
+	sum := sha256.Sum256([]byte(repoURL))
+	return chroot.New(s.fs, hex.EncodeToString(sum[:16])+".git"), nil
+}
+
+// SetStorageBackend 设置本地仓库缓存的存储，传 nil 关闭缓存。SetCacheDir(dir) 等价于使用 NewDirStorage(dir)
+func SetStorageBackend(b StorageBackend) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cacheBackend = b
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WriteCommitsNDJSON 以 NDJSON（每行一个 SimpleCommit）的形式把最近的 max 条 commit 逐条写入 w，
+// 不在内存中构建完整的结果数组
+func WriteCommitsNDJSON(repoURL, sshKeyPEM string, max int, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	_, _, err := walkCommits(repoURL, sshKeyPEM, "", max, func(c SimpleCommit) error {
+		if err := enc.Encode(c); err != nil {
+			return fmt.Errorf("encode commit: %w", err)
+		}
+		return nil
+	})
+	return err
+}
+
+// NDJSONChunkHandler 接收分块的 NDJSON 数据，返回错误会中止遍历
+type NDJSONChunkHandler interface {
+	OnChunk(chunk string) error
+}
+
+// FetchCommitsNDJSON 同 WriteCommitsNDJSON，但每凑满 chunkSize 条 commit 就回调一次 handler，
+// 便于通过 gomobile 分批把大量 commit 交给 App
+func FetchCommitsNDJSON(repoURL, sshKeyPEM string, max, chunkSize int, handler NDJSONChunkHandler) error {
+	if chunkSize <= 0 {
+		chunkSize = 100
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	pending := 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		chunk := buf.String()
+		buf.Reset()
+		pending = 0
+		return handler.OnChunk(chunk)
+	}
+
+	_, _, err := walkCommits(repoURL, sshKeyPEM, "", max, func(c SimpleCommit) error {
+		if err := enc.Encode(c); err != nil {
+			return fmt.Errorf("encode commit: %w", err)
+		}
+		pending++
+		if pending >= chunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// 同步任务的优先级，数值越大越先执行
+const (
+	SyncPriorityBackground = 0
+	SyncPriorityNormal     = 1
+	// SyncPriorityVisible 用于用户当前正在查看的仓库
+	SyncPriorityVisible = 2
+)
+
+var (
+	// SyncAgingSeconds 任务每等待这么多秒，有效优先级加 1，避免低优先级仓库被一直饿死
+	SyncAgingSeconds = 30
+	// MaxPendingSyncs 等待中的任务上限；队列满时新任务只能挤掉有效优先级更低的任务，否则被拒绝
+	MaxPendingSyncs = 256
+)
+
+// SyncHandler 执行一个仓库的同步（取回、推送 outbox 等），由 App 实现
+type SyncHandler interface {
+	SyncRepo(repoURL string) error
+}
+
+type syncTask struct {
+	repoURL  string
+	priority int
+	enqueued time.Time
+}
+
+// effectivePriority 是加上等待时间补偿后的优先级
+func (t *syncTask) effectivePriority(now time.Time) int {
+	if SyncAgingSeconds <= 0 {
+		return t.priority
+	}
+	return t.priority + int(now.Sub(t.enqueued)/(time.Duration(SyncAgingSeconds)*time.Second))
+}
+
+// syncQueue 按优先级调度同步任务：同一仓库同时最多执行一个任务，总并发数不超过 maxConcurrent
+type syncQueue struct {
+	mu            sync.Mutex
+	handler       SyncHandler
+	maxConcurrent int
+	active        int
+	stopped       bool
+	pending       map[string]*syncTask
+	running       map[string]bool
+	// rerun 记录执行期间再次入队的仓库，执行结束后重新排队
+	rerun map[string]*syncTask
+}
+
+var (
+	syncQueueMu  sync.Mutex
+	currentQueue *syncQueue
+)
+
+// StartSyncQueue 启动同步队列，最多同时执行 maxConcurrent 个仓库的同步（小于 1 时为 2）。
+// 重复调用会停止之前的队列，已在执行的任务会继续执行完
+func StartSyncQueue(handler SyncHandler, maxConcurrent int) {
+	if maxConcurrent < 1 {
+		maxConcurrent = 2
+	}
+	q := &syncQueue{
+		handler:       handler,
+		maxConcurrent: maxConcurrent,
+		pending:       map[string]*syncTask{},
+		running:       map[string]bool{},
+		rerun:         map[string]*syncTask{},
+	}
+
+	syncQueueMu.Lock()
+	old := currentQueue
+	currentQueue = q
+	syncQueueMu.Unlock()
+	if old != nil {
+		old.stop()
+	}
+}
+
+// StopSyncQueue 停止同步队列并丢弃等待中的任务
+func StopSyncQueue() {
+	syncQueueMu.Lock()
+	q := currentQueue
+	currentQueue = nil
+	syncQueueMu.Unlock()
+	if q != nil {
+		q.stop()
+	}
+}
+
+// EnqueueSync 把仓库加入同步队列。仓库已在队列中时提升为较高的优先级，正在同步时在结束后再同步一次。
+// 队列未启动或已满且无法挤掉更低优先级的任务时返回 false
+func EnqueueSync(repoURL string, priority int) bool {
+	syncQueueMu.Lock()
+	q := currentQueue
+	syncQueueMu.Unlock()
+	if q == nil {
+		return false
+	}
+	return q.enqueue(repoURL, priority)
+}
+
+// PendingSyncCount 返回等待中（未开始执行）的任务数量
+func PendingSyncCount() int {
+	syncQueueMu.Lock()
+	q := currentQueue
+	syncQueueMu.Unlock()
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) + len(q.rerun)
+}
+
+func (q *syncQueue) enqueue(repoURL string, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return false
+	}
+
+	if q.running[repoURL] {
+		if t, ok := q.rerun[repoURL]; ok {
+			t.priority = max(t.priority, priority)
+		} else {
+			q.rerun[repoURL] = &syncTask{repoURL: repoURL, priority: priority, enqueued: time.Now()}
+		}
+		return true
+	}
+	if t, ok := q.pending[repoURL]; ok {
+		t.priority = max(t.priority, priority)
+		q.dispatch()
+		return true
+	}
+
+	task := &syncTask{repoURL: repoURL, priority: priority, enqueued: time.Now()}
+	if MaxPendingSyncs > 0 && len(q.pending) >= MaxPendingSyncs {
+		lowest := q.pick(false)
+		if lowest == nil || lowest.effectivePriority(task.enqueued) >= priority {
+			return false
+		}
+		delete(q.pending, lowest.repoURL)
+	}
+	q.pending[repoURL] = task
+	q.dispatch()
+	return true
+}
+
+// pick 返回有效优先级最高（highest 为 false 时最低）的等待任务，相同时先入队的优先；调用方需持有 mu
+func (q *syncQueue) pick(highest bool) *syncTask {
+	now := time.Now()
+	var best *syncTask
+	var bestPriority int
+	for _, t := range q.pending {
+		p := t.effectivePriority(now)
+		if best == nil {
+			best, bestPriority = t, p
+			continue
+		}
+		better := p > bestPriority
+		if !highest {
+			better = p < bestPriority
+		}
+		if better || p == bestPriority && t.enqueued.Before(best.enqueued) == highest {
+			best, bestPriority = t, p
+		}
+	}
+	return best
+}
+
+// dispatch 在有空闲并发时启动优先级最高的任务；调用方需持有 mu
+func (q *syncQueue) dispatch() {
+	for !q.stopped && q.active < q.maxConcurrent {
+		task := q.pick(true)
+		if task == nil {
+			return
+		}
+		delete(q.pending, task.repoURL)
+		q.running[task.repoURL] = true
+		q.active++
+		go q.run(task)
+	}
+}
+
+func (q *syncQueue) run(task *syncTask) {
+	_ = q.handler.SyncRepo(task.repoURL)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	delete(q.running, task.repoURL)
+	if again, ok := q.rerun[task.repoURL]; ok {
+		delete(q.rerun, task.repoURL)
+		if !q.stopped {
+			q.pending[task.repoURL] = again
+		}
+	}
+	q.dispatch()
+}
+
+func (q *syncQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+	q.pending = map[string]*syncTask{}
+	q.rerun = map[string]*syncTask{}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TailMaxBatch 是 tail 一轮最多推送的 commit 数量；上次的 head 不在最近这么多条 commit 中时
+// （间隔太久或历史被改写），只推送最近的 TailMaxBatch 条
+var TailMaxBatch = 200
+
+// TailHandler 接收 tail 跟随到的新 commit
+type TailHandler interface {
+	// OnCommits 的参数为新 commit 的 NDJSON（每行一个 SimpleCommit，从旧到新）。
+	// 返回错误时这批 commit 会在下一轮重新推送
+	OnCommits(ndjson string) error
+	// OnError 在一轮轮询失败时调用，tail 不会因此停止
+	OnError(message string)
+}
+
+// tailLoops 是 StartTail 启动的 tail
+var tailLoops headLoops
+
+// StartTail 跟随仓库当前分支，每隔 intervalSeconds 秒轮询一次远端 head，有新 commit 时交给 handler，
+// 适合机器人和服务端监控。sinceHash 为已经处理过的最后一个 commit，空字符串表示从远端当前 head 之后开始。
+// 同一仓库重复调用会替换之前的 tail
+func StartTail(repoURL, sshKeyPEM string, sinceHash string, intervalSeconds int, handler TailHandler) {
+	if intervalSeconds <= 0 {
+		intervalSeconds = 5
+	}
+	l := tailLoops.start(repoURL)
+	go l.run(repoURL, sshKeyPEM, time.Duration(intervalSeconds)*time.Second, sinceHash, func(known, head string) (string, error) {
+		if head == "" {
+			// 远端被清空时保留原来的位置
+			return known, nil
+		}
+		if err := tailSince(repoURL, sshKeyPEM, known, handler); err != nil {
+			return "", err
+		}
+		return head, nil
+	}, func(err error) {
+		handler.OnError(err.Error())
+	})
+}
+
+// StopTail 停止对该仓库的 tail
+func StopTail(repoURL string) {
+	tailLoops.stopLoop(repoURL)
+}
+
+// tailSince 取回 known 之后的新 commit，按从旧到新的顺序交给 handler
+func tailSince(repoURL, sshKeyPEM string, known string, handler TailHandler) error {
+	commits, _, _, err := commitsSince(repoURL, sshKeyPEM, known, TailMaxBatch)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range commits {
+		if err := enc.Encode(c); err != nil {
+			return fmt.Errorf("encode commit: %w", err)
+		}
+	}
+	return handler.OnCommits(buf.String())
+}
+
+// cloneCommitsSince 同 commitsSince，但每次克隆远端的 commit 元数据，用于远端不支持 filter 时
+func cloneCommitsSince(repoURL, sshKeyPEM string, known string, max int) (commits []SimpleCommit, head string, truncated bool, err error) {
+	found := false
+	head, _, err = walkCommits(repoURL, sshKeyPEM, "", max, func(c SimpleCommit) error {
+		if c.Hash == known {
+			found = true
+			return io.EOF
+		}
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return nil, "", false, err
+	}
+	reverseCommits(commits)
+	return commits, head, !found && max > 0 && len(commits) >= max, nil
+}
+
+// reverseCommits 原地反转 commits，把从新到旧的顺序改为从旧到新
+func reverseCommits(commits []SimpleCommit) {
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mixgram-core/internel/utils"
+	"path"
+	"sort"
+	"sync"
+)
+
+// RepoTemplate 描述新仓库的初始布局
+type RepoTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Branch 为空时使用 DefaultBranchName
+	Branch string `json:"branch,omitempty"`
+	// Files 是根提交中的文件（路径 -> 内容），例如说明文档、schema 文件
+	Files map[string]string `json:"files,omitempty"`
+	// Meta 是写入 refs/mixgram/<name> 的元数据（名称 -> 内容）
+	Meta map[string]string `json:"meta,omitempty"`
+	// Channels 是根提交中创建的频道，可以带各自的保留策略
+	Channels []Channel `json:"channels,omitempty"`
+}
+
+// ErrTemplateNotFound 表示没有该名称的模板
+var ErrTemplateNotFound = errors.New("template not found")
+
+var (
+	templatesMu sync.Mutex
+	templates   = map[string]*RepoTemplate{
+		"basic": {
+			Name:        "basic",
+			Description: "只包含说明文档的空仓库",
+			Files:       map[string]string{"README.md": "# MixGram\n"},
+		},
+		"ephemeral": {
+			Name:        "ephemeral",
+			Description: "general 频道只保留最近 500 条消息",
+			Files:       map[string]string{"README.md": "# MixGram\n\nThis repository keeps only recent messages.\n"},
+			Channels:    []Channel{{Name: "general", Retention: &ChannelRetention{KeepMessages: 500}}},
+		},
+	}
+)
+
+// RegisterTemplate 注册（或替换）一个用户模板，参数为 RepoTemplate 的 JSON
+func RegisterTemplate(templateJSON string) error {
+	var t RepoTemplate
+	if err := json.Unmarshal([]byte(templateJSON), &t); err != nil {
+		return fmt.Errorf("decode template: %w", err)
+	}
+	if t.Name == "" {
+		return errors.New("template name is empty")
+	}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	templates[t.Name] = &t
+	return nil
+}
+
+// ListTemplatesJSON 以 JSON 数组返回所有内置和已注册的模板（按名称排序）
+func ListTemplatesJSON() (string, error) {
+	templatesMu.Lock()
+	list := make([]*RepoTemplate, 0, len(templates))
+	for _, t := range templates {
+		list = append(list, t)
+	}
+	templatesMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// CreateFromTemplate 用名为 templateName 的模板初始化一个空的远端仓库，返回根提交的哈希。
+// 文件、频道和元数据在同一次推送中写入；远端已有内容时返回 ErrRemoteNotEmpty。
+func CreateFromTemplate(repoURL, sshKeyPEM string, templateName string) (string, error) {
+	templatesMu.Lock()
+	t, ok := templates[templateName]
+	templatesMu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, templateName)
+	}
+	return createFromTemplate(repoURL, sshKeyPEM, t)
+}
+
+// CreateFromTemplateJSON 同 CreateFromTemplate，直接使用 RepoTemplate 的 JSON 而不必先注册
+func CreateFromTemplateJSON(repoURL, sshKeyPEM string, templateJSON string) (string, error) {
+	var t RepoTemplate
+	if err := json.Unmarshal([]byte(templateJSON), &t); err != nil {
+		return "", fmt.Errorf("decode template: %w", err)
+	}
+	return createFromTemplate(repoURL, sshKeyPEM, &t)
+}
+
+func createFromTemplate(repoURL, sshKeyPEM string, t *RepoTemplate) (string, error) {
+	auth, err := utils.NewSSHAuthForURL(repoURL, sshKeyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	files := map[string][]byte{}
+	for name, content := range t.Files {
+		files[name] = []byte(content)
+	}
+	meta := map[string][]byte{}
+	for name, content := range t.Meta {
+		meta[name] = []byte(content)
+	}
+	for _, ch := range t.Channels {
+		if err := validateChannelName(ch.Name); err != nil {
+			return "", err
+		}
+		ch.CreatedBy = identityFor(repoURL).Email
+		ch.CreatedAt = commitTime().UnixMilli()
+		data, err := json.Marshal(ch)
+		if err != nil {
+			return "", err
+		}
+		files[path.Join(channelsDir, ch.Name, channelInfoFile)] = data
+	}
+
+	branch := t.Branch
+	if branch == "" {
+		branch = DefaultBranchName
+	}
+	message := "Initial commit"
+	if t.Name != "" {
+		message = fmt.Sprintf("Initial commit from template %s", t.Name)
+	}
+	return initRemoteWithMeta(repoURL, auth, branch, files, message, meta)
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// threadsDir 是话题索引目录，与消息目录同级：<话题根消息 ID>/<消息文件名（不含 .json）> 的内容为消息路径。
+// 读取话题只需列出索引目录，不必读取全部消息
+const threadsDir = "threads"
+
+// ErrMessageNotFound 表示消息不存在
+var ErrMessageNotFound = errors.New("message not found")
+
+// SendReply 同 SendMessage，但消息是对 replyTo 的回复，会加入其所在的话题；replyTo 不存在时返回 ErrMessageNotFound
+func SendReply(repoURL, sshKeyPEM string, replyTo string, sender, msgType, body string) (*Message, error) {
+	if replyTo == "" {
+		return nil, errors.New("replyTo is empty")
+	}
+	return sendMessage(repoURL, sshKeyPEM, "", replyTo, sender, msgType, body)
+}
+
+// SendReplyJSON 同 SendReply，以 JSON 返回写入的消息
+func SendReplyJSON(repoURL, sshKeyPEM string, replyTo string, sender, msgType, body string) (string, error) {
+	msg, err := SendReply(repoURL, sshKeyPEM, replyTo, sender, msgType, body)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SendChannelReply 同 SendReply，但消息写入频道 channel
+func SendChannelReply(repoURL, sshKeyPEM string, channel, replyTo string, sender, msgType, body string) (*Message, error) {
+	if err := validateChannelName(channel); err != nil {
+		return nil, err
+	}
+	if replyTo == "" {
+		return nil, errors.New("replyTo is empty")
+	}
+	return sendMessage(repoURL, sshKeyPEM, channel, replyTo, sender, msgType, body)
+}
+
+// FetchThread 返回 messageID 所在话题的全部消息（包括根消息），按消息时钟从旧到新排列（见 SortMessages）；
+// 没有回复的消息只返回其本身，已软删除的消息不会返回
+func FetchThread(repoURL, sshKeyPEM string, messageID string) ([]Message, error) {
+	return fetchThread(repoURL, sshKeyPEM, "", messageID)
+}
+
+// FetchThreadJSON 同 FetchThread，以 JSON 数组返回
+func FetchThreadJSON(repoURL, sshKeyPEM string, messageID string) (string, error) {
+	return fetchThreadJSON(repoURL, sshKeyPEM, "", messageID)
+}
+
+// FetchChannelThread 同 FetchThread，但在频道 channel 中查找
+func FetchChannelThread(repoURL, sshKeyPEM string, channel, messageID string) ([]Message, error) {
+	if err := validateChannelName(channel); err != nil {
+		return nil, err
+	}
+	return fetchThread(repoURL, sshKeyPEM, channel, messageID)
+}
+
+// FetchChannelThreadJSON 同 FetchThreadJSON，但在频道 channel 中查找
+func FetchChannelThreadJSON(repoURL, sshKeyPEM string, channel, messageID string) (string, error) {
+	if err := validateChannelName(channel); err != nil {
+		return "", err
+	}
+	return fetchThreadJSON(repoURL, sshKeyPEM, channel, messageID)
+}
+
+func fetchThreadJSON(repoURL, sshKeyPEM string, channel, messageID string) (string, error) {
+	thread, err := fetchThread(repoURL, sshKeyPEM, channel, messageID)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(thread)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func fetchThread(repoURL, sshKeyPEM string, channel, messageID string) ([]Message, error) {
+	h, head, err := readMessageStore(repoURL, sshKeyPEM, channel)
+	if err != nil {
+		return nil, err
+	}
+	msg, _, err := findMessage(h, head.Hash(), messageStoreDir(channel), messageID)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := tombstoned(h, head.Hash(), channel)
+	if err != nil {
+		return nil, err
+	}
+	if deleted[msg.ID] {
+		return nil, ErrMessageNotFound
+	}
+
+	paths, err := threadPaths(h, head.Hash(), channel, msg.threadID())
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		if err := applyLatestEdit(h, head.Hash(), channel, msg); err != nil {
+			return nil, err
+		}
+		return []Message{*msg}, nil
+	}
+	thread := make([]Message, 0, len(paths))
+	for _, p := range paths {
+		m, err := readMessage(h, head.Hash(), p)
+		if errors.Is(err, object.ErrFileNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if deleted[m.ID] {
+			continue
+		}
+		if err := applyLatestEdit(h, head.Hash(), channel, m); err != nil {
+			return nil, err
+		}
+		thread = append(thread, *m)
+	}
+	SortMessages(thread)
+	return thread, nil
+}
+
+// threadID 返回消息所在话题的根消息 ID
+func (m *Message) threadID() string {
+	if m.ThreadID != "" {
+		return m.ThreadID
+	}
+	return m.ID
+}
+
+// findMessage 在消息目录 dir 中按文件名查找消息 id，不读取其他消息的内容
+func findMessage(h *history, commit plumbing.Hash, dir string, id string) (*Message, string, error) {
+	if id == "" {
+		return nil, "", ErrMessageNotFound
+	}
+	paths, err := messagePaths(h, commit, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	suffix := "-" + id + ".json"
+	for _, p := range paths {
+		if strings.HasSuffix(p, suffix) {
+			m, err := readMessage(h, commit, p)
+			if err != nil {
+				return nil, "", err
+			}
+			return m, p, nil
+		}
+	}
+	return nil, "", ErrMessageNotFound
+}
+
+func readMessage(h *history, commit plumbing.Hash, msgPath string) (*Message, error) {
+	content, err := readCommitFile(h.repoURL, h.repo, commit, msgPath)
+	if err != nil {
+		return nil, err
+	}
+	var m Message
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("parse message %s: %w", msgPath, err)
+	}
+	return &m, nil
+}
+
+// threadPaths 返回话题索引中的消息路径，按时间从旧到新排列
+func threadPaths(h *history, commit plumbing.Hash, channel, threadID string) ([]string, error) {
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	dir := path.Join(threadStoreDir(channel), threadID)
+	sub, err := tree.Tree(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dir, err)
+	}
+
+	names := make([]string, 0, len(sub.Entries))
+	for _, e := range sub.Entries {
+		if e.Mode.IsFile() {
+			names = append(names, e.Name)
+		}
+	}
+	sort.Strings(names)
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		content, err := readRefFile(h.repo, commit, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, strings.TrimSpace(string(content)))
+	}
+	return paths, nil
+}
+
+// threadIndexPath 返回消息 msgPath 在话题 threadID 索引中的路径
+func threadIndexPath(channel, threadID, msgPath string) string {
+	return path.Join(threadStoreDir(channel), threadID, strings.TrimSuffix(path.Base(msgPath), ".json"))
+}
+
+func threadStoreDir(channel string) string {
+	if channel == "" {
+		return threadsDir
+	}
+	return path.Join(channelsDir, channel, threadsDir)
+}
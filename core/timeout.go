@@ -0,0 +1,26 @@
+package core
+
+import (
+	"context"
+	"mixgram-core/internel/utils"
+	"sync/atomic"
+	"time"
+)
+
+var operationTimeout atomic.Int64
+
+// SetNetworkTimeouts 设置网络超时（秒），0 表示不限制：
+// dialSeconds 限制建立 SSH / HTTP 连接的时间；
+// operationSeconds 限制单次克隆、取回、推送或引用协商的总时间，超时后按重试策略重试
+func SetNetworkTimeouts(dialSeconds, operationSeconds int) {
+	utils.SetDialTimeout(time.Duration(dialSeconds) * time.Second)
+	operationTimeout.Store(int64(time.Duration(operationSeconds) * time.Second))
+}
+
+// operationContext 返回一次网络操作使用的 ctx，带有 SetNetworkTimeouts 设置的操作超时
+func operationContext() (context.Context, context.CancelFunc) {
+	if d := time.Duration(operationTimeout.Load()); d > 0 {
+		return context.WithTimeout(context.Background(), d)
+	}
+	return context.WithCancel(context.Background())
+}
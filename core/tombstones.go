@@ -0,0 +1,108 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// tombstonesDir 下每个被软删除的消息一个标记文件：<消息 ID>，内容为 Tombstone 的 JSON。
+// 原消息仍保留在仓库中，读取消息时过滤掉带标记的消息
+const tombstonesDir = "tombstones"
+
+// Tombstone 是一条消息的删除标记
+type Tombstone struct {
+	MessageID string `json:"messageId"`
+	DeletedBy string `json:"deletedBy"`
+	// DeletedAt 是删除时间（Unix 毫秒）
+	DeletedAt int64 `json:"deletedAt"`
+}
+
+// DeleteMessageSoft 为消息 messageID 写入删除标记并提交推送，不重写历史，
+// 其他设备的缓存和克隆不受影响；之后 FetchMessages / FetchThread 不再返回该消息。
+// 消息不存在时返回 ErrMessageNotFound，已删除时不做修改。
+// 需要从历史中彻底清除内容时使用 EditMessage 的硬编辑或 DeleteCommit
+func DeleteMessageSoft(repoURL, sshKeyPEM string, messageID string) error {
+	return deleteMessageSoft(repoURL, sshKeyPEM, "", messageID)
+}
+
+// DeleteChannelMessageSoft 同 DeleteMessageSoft，但消息在频道 channel 中
+func DeleteChannelMessageSoft(repoURL, sshKeyPEM string, channel, messageID string) error {
+	if err := validateChannelName(channel); err != nil {
+		return err
+	}
+	return deleteMessageSoft(repoURL, sshKeyPEM, channel, messageID)
+}
+
+func deleteMessageSoft(repoURL, sshKeyPEM string, channel, messageID string) error {
+	h, head, err := openWritableStore(repoURL, sshKeyPEM, channel)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, _, err := findMessage(h, head.Hash(), messageStoreDir(channel), messageID); err != nil {
+		return err
+	}
+	p := tombstonePath(channel, messageID)
+	if _, err := readRefFile(h.repo, head.Hash(), p); err == nil {
+		return nil
+	} else if !errors.Is(err, object.ErrFileNotFound) {
+		return err
+	}
+
+	data, err := json.Marshal(&Tombstone{
+		MessageID: messageID,
+		DeletedBy: identityFor(repoURL).Email,
+		DeletedAt: commitTime().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.commitAndPush(head.Hash(), "delete message "+messageID, map[string][]byte{p: data})
+	return err
+}
+
+// tombstoned 返回频道 channel 中已被软删除的消息 ID
+func tombstoned(h *history, commit plumbing.Hash, channel string) (map[string]bool, error) {
+	c, err := h.repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("head commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree: %w", err)
+	}
+	deleted := map[string]bool{}
+	dir := tombstoneStoreDir(channel)
+	sub, err := tree.Tree(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return deleted, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dir, err)
+	}
+	for _, e := range sub.Entries {
+		if e.Mode.IsFile() {
+			deleted[e.Name] = true
+		}
+	}
+	return deleted, nil
+}
+
+func tombstonePath(channel, messageID string) string {
+	return path.Join(tombstoneStoreDir(channel), messageID)
+}
+
+func tombstoneStoreDir(channel string) string {
+	if channel == "" {
+		return tombstonesDir
+	}
+	return path.Join(channelsDir, channel, tombstonesDir)
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"mixgram-core/internel/utils"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// TransferStats 中操作的类别
+const (
+	// TransferPoll 只读取了远端引用（轮询、探测能力、已是最新的取回）
+	TransferPoll = "poll"
+	// TransferFetch 从远端取回了对象（克隆、取回）
+	TransferFetch = "fetch"
+	// TransferPush 向远端推送
+	TransferPush = "push"
+)
+
+// TransferTotals 是一组网络操作的累计传输量。字节数按 git 协议的数据计算，不含 SSH/TLS 的加密开销
+type TransferTotals struct {
+	// Count 是操作次数，每次重试单独计数
+	Count           int64 `json:"count"`
+	BytesSent       int64 `json:"bytesSent"`
+	BytesReceived   int64 `json:"bytesReceived"`
+	ObjectsSent     int64 `json:"objectsSent"`
+	ObjectsReceived int64 `json:"objectsReceived"`
+	DurationMs      int64 `json:"durationMs"`
+}
+
+func (t *TransferTotals) add(o TransferTotals) {
+	t.Count += o.Count
+	t.BytesSent += o.BytesSent
+	t.BytesReceived += o.BytesReceived
+	t.ObjectsSent += o.ObjectsSent
+	t.ObjectsReceived += o.ObjectsReceived
+	t.DurationMs += o.DurationMs
+}
+
+// TransferStats 是 GetTransferStats 的结果
+type TransferStats struct {
+	// Since 是开始统计（进程启动或上次 ResetTransferStats）的时间，毫秒时间戳
+	Since int64 `json:"since"`
+	TransferTotals
+	// Operations 按类别（TransferPoll 等）分别统计
+	Operations map[string]*TransferTotals `json:"operations"`
+}
+
+var (
+	transferMu    sync.Mutex
+	transferSince = time.Now()
+	transferStats = map[string]*TransferTotals{}
+)
+
+func init() {
+	utils.SetTransferSink(recordSession)
+}
+
+// GetTransferStats 返回自进程启动或上次 ResetTransferStats 以来全部网络操作的传输量，
+// 供 App 显示流量使用情况，或让按流量计费的用户决定同步策略
+func GetTransferStats() *TransferStats {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+	stats := &TransferStats{Since: transferSince.UnixMilli(), Operations: map[string]*TransferTotals{}}
+	for kind, t := range transferStats {
+		copied := *t
+		stats.Operations[kind] = &copied
+		stats.TransferTotals.add(copied)
+	}
+	return stats
+}
+
+// GetTransferStatsJSON 同 GetTransferStats，以 JSON 返回
+func GetTransferStatsJSON() (string, error) {
+	data, err := json.Marshal(GetTransferStats())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ResetTransferStats 清空统计并重新开始计时，例如在计费周期开始时
+func ResetTransferStats() {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+	transferSince = time.Now()
+	transferStats = map[string]*TransferTotals{}
+}
+
+// transferOp 累计一次网络操作（withRetry 的一次尝试）中各个会话的传输量
+type transferOp struct {
+	mu                      sync.Mutex
+	totals                  TransferTotals
+	sessions                int
+	cloned, fetched, pushed bool
+}
+
+type transferOpKey struct{}
+
+func withTransferOp(ctx context.Context, op *transferOp) context.Context {
+	return context.WithValue(ctx, transferOpKey{}, op)
+}
+
+func (op *transferOp) add(t utils.Transfer) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.sessions++
+	op.totals.add(TransferTotals{
+		BytesSent:       t.BytesSent,
+		BytesReceived:   t.BytesReceived,
+		ObjectsSent:     t.ObjectsSent,
+		ObjectsReceived: t.ObjectsReceived,
+	})
+	if t.Requested {
+		switch {
+		case t.Service == transport.ReceivePackServiceName:
+			op.pushed = true
+		case t.Incremental:
+			op.fetched = true
+		default:
+			op.cloned = true
+		}
+	}
+}
+
+// metricOp 返回操作在指标中的类别
+func (op *transferOp) metricOp() string {
+	switch {
+	case op.sessions == 0:
+		return MetricOpConnect
+	case op.pushed:
+		return MetricOpPush
+	case op.cloned:
+		return MetricOpClone
+	case op.fetched:
+		return MetricOpFetch
+	}
+	return MetricOpPoll
+}
+
+// finish 把操作计入统计并上报指标，err 是操作的结果。没有建立任何会话（例如连接失败）时不计入统计
+func (op *transferOp) finish(d time.Duration, err error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	kind := op.metricOp()
+	observeOperation(kind, d, err)
+	if op.sessions == 0 {
+		return
+	}
+	totals := op.totals
+	observeTransfer(kind, totals)
+	totals.Count = 1
+	totals.DurationMs = d.Milliseconds()
+	if kind == MetricOpClone {
+		kind = TransferFetch
+	}
+
+	transferMu.Lock()
+	defer transferMu.Unlock()
+	t := transferStats[kind]
+	if t == nil {
+		t = &TransferTotals{}
+		transferStats[kind] = t
+	}
+	t.add(totals)
+}
+
+// recordSession 接收 transport 会话的传输量：经由 withRetry 的会话计入所在的操作，其他会话单独计为一次操作
+func recordSession(ctx context.Context, t utils.Transfer) {
+	if op, ok := ctx.Value(transferOpKey{}).(*transferOp); ok {
+		op.add(t)
+		return
+	}
+	op := &transferOp{}
+	op.add(t)
+	op.finish(t.Duration, nil)
+}
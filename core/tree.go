@@ -0,0 +1,175 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// editTree 在 root 树的基础上写入/删除若干文件，返回新树的哈希。
+// files 的 key 为以 "/" 分隔的路径，value 为 nil 表示删除该路径（文件或整个目录）。
+// 覆盖已有的文件时沿用其模式（可执行、符号链接），新文件为 filemode.Regular。
+// root 为零值时视为空树。
+func editTree(s storer.EncodedObjectStorer, root plumbing.Hash, files map[string][]byte) (plumbing.Hash, error) {
+	return editTreeModes(s, root, files, nil)
+}
+
+// editTreeModes 同 editTree，modes 为 files 中部分路径指定写入的模式
+func editTreeModes(s storer.EncodedObjectStorer, root plumbing.Hash, files map[string][]byte, modes map[string]filemode.FileMode) (plumbing.Hash, error) {
+	edits := make(map[string]treeEdit, len(files))
+	for path, content := range files {
+		edits[path] = treeEdit{content: content, mode: modes[path]}
+	}
+	return applyTreeEdits(s, root, edits)
+}
+
+// treeEdit 是对一个路径的修改，mode 为零值时沿用原有的模式
+type treeEdit struct {
+	content []byte
+	mode    filemode.FileMode
+}
+
+func applyTreeEdits(s storer.EncodedObjectStorer, root plumbing.Hash, edits map[string]treeEdit) (plumbing.Hash, error) {
+	var entries []object.TreeEntry
+	if !root.IsZero() {
+		tree, err := object.GetTree(s, root)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("get tree %s: %w", root, err)
+		}
+		entries = append(entries, tree.Entries...)
+	}
+
+	// 按第一级路径分组：leaf 为直接作用于本层的修改，nested 为子目录内的修改
+	leaf := map[string]treeEdit{}
+	nested := map[string]map[string]treeEdit{}
+	for path, edit := range edits {
+		path = strings.Trim(path, "/")
+		if path == "" {
+			continue
+		}
+		name, rest, found := strings.Cut(path, "/")
+		if !found {
+			leaf[name] = edit
+			continue
+		}
+		if nested[name] == nil {
+			nested[name] = map[string]treeEdit{}
+		}
+		nested[name][rest] = edit
+	}
+
+	index := map[string]int{}
+	for i, e := range entries {
+		index[e.Name] = i
+	}
+	set := func(e object.TreeEntry) {
+		if i, ok := index[e.Name]; ok {
+			entries[i] = e
+			return
+		}
+		index[e.Name] = len(entries)
+		entries = append(entries, e)
+	}
+	removed := map[string]bool{}
+
+	for name, edit := range leaf {
+		if edit.content == nil {
+			removed[name] = true
+			continue
+		}
+		blobHash, err := storeBlob(s, edit.content)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		mode := edit.mode
+		if mode == 0 {
+			mode = filemode.Regular
+			if i, ok := index[name]; ok && entries[i].Mode.IsFile() {
+				mode = entries[i].Mode
+			}
+		}
+		set(object.TreeEntry{Name: name, Mode: mode, Hash: blobHash})
+	}
+
+	for name, changes := range nested {
+		if _, ok := leaf[name]; ok {
+			continue // 同一路径既作为文件又作为目录修改时，以文件为准
+		}
+		var child plumbing.Hash
+		if i, ok := index[name]; ok && entries[i].Mode == filemode.Dir {
+			child = entries[i].Hash
+		}
+		newChild, err := applyTreeEdits(s, child, changes)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if newChild == emptyTreeHash {
+			removed[name] = true
+			continue
+		}
+		set(object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: newChild})
+	}
+
+	var result []object.TreeEntry
+	for _, e := range entries {
+		if !removed[e.Name] {
+			result = append(result, e)
+		}
+	}
+	return storeTree(s, result)
+}
+
+// emptyTreeHash 是 git 中空树对象的固定哈希
+var emptyTreeHash = plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+
+// storeTree 按 git 规定的顺序排序条目并写入树对象
+func storeTree(s storer.EncodedObjectStorer, entries []object.TreeEntry) (plumbing.Hash, error) {
+	// git 排序时把目录名视为以 "/" 结尾
+	sortKey := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortKey(entries[i]) < sortKey(entries[j])
+	})
+
+	tree := &object.Tree{Entries: entries}
+	obj := s.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("encode tree: %w", err)
+	}
+	hash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store tree: %w", err)
+	}
+	return hash, nil
+}
+
+// storeBlob 把内容写入一个 blob 对象
+func storeBlob(s storer.EncodedObjectStorer, content []byte) (plumbing.Hash, error) {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("blob writer: %w", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		_ = w.Close()
+		return plumbing.ZeroHash, fmt.Errorf("write blob: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("close blob: %w", err)
+	}
+	hash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store blob: %w", err)
+	}
+	return hash, nil
+}
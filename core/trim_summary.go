@@ -0,0 +1,62 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// trimSummaryPath 是裁剪摘要在新根提交中的路径
+const trimSummaryPath = ".mixgram/trim-summary.md"
+
+// trimSummary 生成被裁剪 commit（HEAD -> Root 顺序）的摘要。
+// 如果被裁剪的根提交中已有上一次裁剪的摘要，则附在末尾，使多次裁剪的记录得以累积。
+func trimSummary(h *history, removed []*object.Commit) []byte {
+	type authorStat struct {
+		name, email string
+		count       int
+	}
+	stats := map[string]*authorStat{}
+	oldest, newest := removed[len(removed)-1].Author.When, removed[0].Author.When
+	for _, c := range removed {
+		key := c.Author.Name + "\x00" + c.Author.Email
+		if stats[key] == nil {
+			stats[key] = &authorStat{name: c.Author.Name, email: c.Author.Email}
+		}
+		stats[key].count++
+		if c.Author.When.Before(oldest) {
+			oldest = c.Author.When
+		}
+		if c.Author.When.After(newest) {
+			newest = c.Author.When
+		}
+	}
+	authors := make([]*authorStat, 0, len(stats))
+	for _, a := range stats {
+		authors = append(authors, a)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].count != authors[j].count {
+			return authors[i].count > authors[j].count
+		}
+		return authors[i].email < authors[j].email
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Trimmed %s\n\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- removed commits: %d\n", len(removed))
+	fmt.Fprintf(&b, "- date range: %s ~ %s\n", oldest.UTC().Format(time.RFC3339), newest.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- authors:\n")
+	for _, a := range authors {
+		fmt.Fprintf(&b, "  - %s <%s>: %d\n", a.name, a.email, a.count)
+	}
+
+	if previous, err := readRefFile(h.repo, removed[len(removed)-1].Hash, trimSummaryPath); err == nil {
+		b.WriteString("\n")
+		b.Write(previous)
+	}
+	return []byte(b.String())
+}
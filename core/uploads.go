@@ -0,0 +1,299 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUploadSourceChanged 表示续传时源文件与开始上传时不一致，只能取消后重新上传
+	ErrUploadSourceChanged = errors.New("upload source file changed")
+	// ErrUploadNotFound 表示本地没有该上传记录
+	ErrUploadNotFound = errors.New("pending upload not found")
+)
+
+// PendingUpload 是一个可断点续传的附件上传，进度保存在本地存储（SetDataDir）的 uploads 目录下
+type PendingUpload struct {
+	// ID 即上传完成后的附件 ID；仓库中已有相同内容的附件时，ResumeAttachmentUpload 返回已有附件的清单
+	ID       string `json:"id"`
+	RepoURL  string `json:"repoUrl"`
+	FilePath string `json:"filePath"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType,omitempty"`
+	// FileSize / ModTime（Unix 毫秒）用于检测源文件在两次续传之间是否被修改
+	FileSize  int64 `json:"fileSize"`
+	ModTime   int64 `json:"modTime"`
+	ChunkSize int64 `json:"chunkSize"`
+	CreatedAt int64 `json:"createdAt"`
+	// Chunks 是已推送的分块，Uploaded 是已推送的字节数
+	Chunks    []string `json:"chunks,omitempty"`
+	Uploaded  int64    `json:"uploaded"`
+	Attempts  int      `json:"attempts"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+var (
+	uploadsMu     sync.Mutex
+	activeUploads = map[string]bool{}
+)
+
+// StartAttachmentUpload 为本地文件 filePath 创建一个可断点续传的上传记录并返回其 ID，不进行网络操作。
+// 之后调用 ResumeAttachmentUpload 开始或继续上传；App 重启后可通过 ListPendingUploadsJSON 找回未完成的上传
+func StartAttachmentUpload(repoURL string, filePath, name, mimeType string) (string, error) {
+	if AttachmentChunkSize <= 0 {
+		return "", errors.New("attachment chunk size must be positive")
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	m := newAttachmentManifest(name, mimeType)
+	entry := &PendingUpload{
+		ID:        m.ID,
+		RepoURL:   repoURL,
+		FilePath:  filePath,
+		Name:      name,
+		MimeType:  mimeType,
+		FileSize:  info.Size(),
+		ModTime:   info.ModTime().UnixMilli(),
+		ChunkSize: m.ChunkSize,
+		CreatedAt: m.CreatedAt,
+		Chunks:    []string{},
+	}
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	if err := writePendingUpload(entry); err != nil {
+		return "", err
+	}
+	return entry.ID, nil
+}
+
+// ResumeAttachmentUpload 开始或继续上传 uploadID，已推送且仍在仓库中的分块会被跳过。
+// 完成后删除上传记录并返回附件清单；失败时保留进度，可以稍后再次调用
+func ResumeAttachmentUpload(repoURL, sshKeyPEM string, uploadID string) (*AttachmentManifest, error) {
+	uploadsMu.Lock()
+	entry, err := readPendingUpload(uploadID)
+	if err == nil && entry.RepoURL != repoURL {
+		err = fmt.Errorf("upload %s belongs to %s", uploadID, entry.RepoURL)
+	}
+	if err == nil && activeUploads[uploadID] {
+		err = fmt.Errorf("upload %s is already running", uploadID)
+	}
+	if err != nil {
+		uploadsMu.Unlock()
+		return nil, err
+	}
+	activeUploads[uploadID] = true
+	uploadsMu.Unlock()
+	defer func() {
+		uploadsMu.Lock()
+		delete(activeUploads, uploadID)
+		uploadsMu.Unlock()
+	}()
+
+	m, err := resumeUpload(sshKeyPEM, entry)
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	if err != nil {
+		entry.Attempts++
+		entry.LastError = err.Error()
+		_ = writePendingUpload(entry)
+		return nil, err
+	}
+	path, err := pendingUploadPath(entry.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove upload record: %w", err)
+	}
+	return m, nil
+}
+
+// ResumePendingUploads 依次续传本地保存的全部未完成上传，返回完成的数量和遇到的第一个错误
+func ResumePendingUploads(keys KeyProvider) (int, error) {
+	uploadsMu.Lock()
+	entries, err := readPendingUploads()
+	uploadsMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	done := 0
+	var firstErr error
+	for _, e := range entries {
+		key, err := keys.SSHKey(e.RepoURL)
+		if err == nil {
+			_, err = ResumeAttachmentUpload(e.RepoURL, key, e.ID)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		done++
+	}
+	return done, firstErr
+}
+
+// CancelAttachmentUpload 删除上传记录，已推送的分块留在仓库中
+func CancelAttachmentUpload(uploadID string) error {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	if _, err := readPendingUpload(uploadID); err != nil {
+		return err
+	}
+	if activeUploads[uploadID] {
+		return fmt.Errorf("upload %s is running", uploadID)
+	}
+	path, err := pendingUploadPath(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ListPendingUploadsJSON 以 JSON 数组返回未完成的上传（按创建时间排序，不含分块列表）
+func ListPendingUploadsJSON() (string, error) {
+	uploadsMu.Lock()
+	entries, err := readPendingUploads()
+	uploadsMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	list := make([]PendingUpload, 0, len(entries))
+	for _, e := range entries {
+		summary := *e
+		summary.Chunks = nil
+		list = append(list, summary)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// resumeUpload 校验源文件后从头读取，已推送的分块只做校验不重复推送，每批推送后保存进度
+func resumeUpload(sshKeyPEM string, entry *PendingUpload) (*AttachmentManifest, error) {
+	f, err := os.Open(entry.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != entry.FileSize || info.ModTime().UnixMilli() != entry.ModTime {
+		return nil, ErrUploadSourceChanged
+	}
+
+	m := &AttachmentManifest{
+		Version:   attachmentManifestV1,
+		ID:        entry.ID,
+		Name:      entry.Name,
+		MimeType:  entry.MimeType,
+		ChunkSize: entry.ChunkSize,
+		CreatedAt: entry.CreatedAt,
+	}
+	err = uploadAttachment(entry.RepoURL, sshKeyPEM, f, m, entry.Chunks, func(chunks []string) error {
+		if len(chunks) <= len(entry.Chunks) {
+			return nil
+		}
+		entry.Chunks = append([]string(nil), chunks...)
+		entry.Uploaded = min(int64(len(chunks))*entry.ChunkSize, entry.FileSize)
+		uploadsMu.Lock()
+		defer uploadsMu.Unlock()
+		return writePendingUpload(entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func pendingUploadPath(id string) (string, error) {
+	dir, err := dataPath("uploads")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// readPendingUpload 读取上传记录，调用方需持有 uploadsMu
+func readPendingUpload(id string) (*PendingUpload, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, ErrUploadNotFound
+	}
+	path, err := pendingUploadPath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read upload record: %w", err)
+	}
+	var entry PendingUpload
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("decode upload record %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// readPendingUploads 按创建时间读取全部上传记录，调用方需持有 uploadsMu
+func readPendingUploads() ([]*PendingUpload, error) {
+	dir, err := dataPath("uploads")
+	if err != nil {
+		return nil, err
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read uploads: %w", err)
+	}
+	var result []*PendingUpload
+	for _, n := range names {
+		if n.IsDir() || !strings.HasSuffix(n.Name(), ".json") {
+			continue
+		}
+		entry, err := readPendingUpload(strings.TrimSuffix(n.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt < result[j].CreatedAt })
+	return result, nil
+}
+
+// writePendingUpload 先写临时文件再重命名，调用方需持有 uploadsMu
+func writePendingUpload(entry *PendingUpload) error {
+	path, err := pendingUploadPath(entry.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write upload record: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write upload record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 校验的数据类型
+const (
+	ValidationKindCommit  = "commit"
+	ValidationKindFiles   = "files"
+	ValidationKindMessage = "message"
+)
+
+// 内置校验的限制，0 表示不限制
+var (
+	// MaxCommitMessageBytes 是提交信息的最大字节数
+	MaxCommitMessageBytes = 64 << 10
+	// MaxMessageBodyBytes 是消息正文的最大字节数
+	MaxMessageBodyBytes = 1 << 20
+	// MaxFileBytes 是 PushFiles 中单个文件的最大字节数，超过 InlineSizeLimit 的文件仍会转存
+	MaxFileBytes int64 = 0
+	// RequireUTF8 为 true 时提交信息、文件路径和消息的文本字段必须是合法的 UTF-8
+	RequireUTF8 = true
+)
+
+// ErrValidation 表示待提交的数据没有通过校验，没有写入仓库也没有进入 outbox；
+// 具体原因使用 errors.As 取出 *ValidationError
+var ErrValidation = errors.New("validation failed")
+
+// ValidationError 是校验失败时返回的错误，errors.Is(err, ErrValidation) 为 true
+type ValidationError struct {
+	Kind string `json:"kind"`
+	// Validator 是拒绝该数据的校验器名称，内置校验为空
+	Validator string `json:"validator,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Validator != "" {
+		return fmt.Sprintf("%s: %s rejected by %s: %s", ErrValidation, e.Kind, e.Validator, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrValidation, e.Kind, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Validator 是应用自定义的校验规则，在内置校验之后、提交和进入 outbox 之前调用
+type Validator interface {
+	// Validate 的参数为 ValidationInput 的 JSON，返回错误表示拒绝
+	Validate(inputJSON string) error
+}
+
+// ValidationInput 是交给 Validator 的待提交数据
+type ValidationInput struct {
+	// Kind 为 ValidationKindCommit、ValidationKindFiles 或 ValidationKindMessage
+	Kind    string `json:"kind"`
+	RepoURL string `json:"repoUrl"`
+	// CommitMessage 是 PushCommit / PushFiles 的提交信息
+	CommitMessage string `json:"commitMessage,omitempty"`
+	// Files 是 PushFiles 修改的文件，按路径排序
+	Files []ValidationFile `json:"files,omitempty"`
+	// Channel 和 Message 是要发送的消息，Channel 为空表示仓库默认的 messages/
+	Channel string   `json:"channel,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// ValidationFile 是 PushFiles 中的一个文件修改
+type ValidationFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+var validators registry[Validator]
+
+// AddValidator 添加名为 name 的校验器，按添加的顺序调用；同名的校验器会被替换
+func AddValidator(name string, v Validator) error {
+	if name == "" {
+		return errors.New("validator name is empty")
+	}
+	if v == nil {
+		return errors.New("validator is nil")
+	}
+	validators.add(name, v)
+	return nil
+}
+
+// RemoveValidator 移除名为 name 的校验器
+func RemoveValidator(name string) {
+	validators.remove(name)
+}
+
+// ClearValidators 移除全部校验器，内置校验不受影响
+func ClearValidators() {
+	validators.clear()
+}
+
+// validateCommit 校验 PushCommit 的提交信息
+func validateCommit(repoURL, commitMsg string) error {
+	if err := checkCommitMessage(commitMsg); err != nil {
+		return &ValidationError{Kind: ValidationKindCommit, Reason: err.Error()}
+	}
+	return runValidators(&ValidationInput{Kind: ValidationKindCommit, RepoURL: repoURL, CommitMessage: commitMsg})
+}
+
+// validateFiles 校验 PushFiles 的提交信息和文件
+func validateFiles(repoURL, commitMsg string, files map[string][]byte) error {
+	input := &ValidationInput{Kind: ValidationKindFiles, RepoURL: repoURL, CommitMessage: commitMsg}
+	reject := func(reason string) error {
+		return &ValidationError{Kind: ValidationKindFiles, Reason: reason}
+	}
+	if err := checkCommitMessage(commitMsg); err != nil {
+		return reject(err.Error())
+	}
+	for path, content := range files {
+		if RequireUTF8 && !utf8.ValidString(path) {
+			return reject(fmt.Sprintf("path %q is not valid UTF-8", path))
+		}
+		if MaxFileBytes > 0 && int64(len(content)) > MaxFileBytes {
+			return reject(fmt.Sprintf("%s is %d bytes, limit is %d", path, len(content), MaxFileBytes))
+		}
+		input.Files = append(input.Files, ValidationFile{Path: path, Size: int64(len(content)), Delete: content == nil})
+	}
+	sort.Slice(input.Files, func(i, j int) bool { return input.Files[i].Path < input.Files[j].Path })
+	return runValidators(input)
+}
+
+// validateMessage 校验要发送到频道 channel 的消息
+func validateMessage(repoURL, channel string, msg *Message) error {
+	reject := func(reason string) error {
+		return &ValidationError{Kind: ValidationKindMessage, Reason: reason}
+	}
+	if MaxMessageBodyBytes > 0 && len(msg.Body) > MaxMessageBodyBytes {
+		return reject(fmt.Sprintf("body is %d bytes, limit is %d", len(msg.Body), MaxMessageBodyBytes))
+	}
+	if RequireUTF8 {
+		for _, field := range []struct{ name, value string }{
+			{"sender", msg.Sender}, {"type", msg.Type}, {"body", msg.Body},
+		} {
+			if !utf8.ValidString(field.value) {
+				return reject(field.name + " is not valid UTF-8")
+			}
+		}
+	}
+	return runValidators(&ValidationInput{Kind: ValidationKindMessage, RepoURL: repoURL, Channel: channel, Message: msg})
+}
+
+func checkCommitMessage(msg string) error {
+	if MaxCommitMessageBytes > 0 && len(msg) > MaxCommitMessageBytes {
+		return fmt.Errorf("commit message is %d bytes, limit is %d", len(msg), MaxCommitMessageBytes)
+	}
+	if RequireUTF8 && !utf8.ValidString(msg) {
+		return errors.New("commit message is not valid UTF-8")
+	}
+	// git 的 commit 对象以 NUL 结尾的字段解析，提交信息中的 NUL 会被其他客户端截断
+	if strings.IndexByte(msg, 0) >= 0 {
+		return errors.New("commit message contains NUL byte")
+	}
+	return nil
+}
+
+// runValidators 依次调用已添加的校验器，第一个拒绝的校验器的错误包装为 *ValidationError
+func runValidators(input *ValidationInput) error {
+	names, list := validators.snapshot()
+	if len(list) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	for i, v := range list {
+		if err := v.Validate(string(data)); err != nil {
+			return &ValidationError{Kind: input.Kind, Validator: names[i], Reason: err.Error()}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrRewriteVerification 表示重写后的历史与原历史内容不一致，强制推送已取消；
+// 具体的不一致使用 errors.As 取出 *RewriteVerificationError
+var ErrRewriteVerification = errors.New("rewritten history changed content")
+
+// TreeMismatch 是一个重写后内容不一致的 commit
+type TreeMismatch struct {
+	OldCommit string `json:"oldCommit"`
+	NewCommit string `json:"newCommit"`
+	OldTree   string `json:"oldTree"`
+	// NewTree 在新 commit 无法读取时为空
+	NewTree string `json:"newTree,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// RewriteVerificationError 是强制推送前校验失败时返回的错误，errors.Is(err, ErrRewriteVerification) 为 true
+type RewriteVerificationError struct {
+	// Checked 是校验过的 commit 数量
+	Checked    int            `json:"checked"`
+	Mismatches []TreeMismatch `json:"mismatches"`
+}
+
+func (e *RewriteVerificationError) Error() string {
+	parts := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		parts = append(parts, fmt.Sprintf("%s -> %s: %s", m.OldCommit, m.NewCommit, m.Reason))
+	}
+	return fmt.Sprintf("%s (%d of %d commits): %s", ErrRewriteVerification, len(e.Mismatches), e.Checked, strings.Join(parts, "; "))
+}
+
+func (e *RewriteVerificationError) Is(target error) bool {
+	return target == ErrRewriteVerification
+}
+
+// verifyRewrite 在强制推送前确认每个被重写的 commit 与原 commit 的树相同（本次重写有意修改的除外），
+// 且新 commit 和它的树都已写入仓库，不一致时返回 *RewriteVerificationError
+func (h *history) verifyRewrite() error {
+	result := &RewriteVerificationError{}
+	for old, rewritten := range h.rewritten {
+		if h.retreed[old] {
+			continue
+		}
+		result.Checked++
+		mismatch := TreeMismatch{OldCommit: old.String(), NewCommit: rewritten.String()}
+		oldCommit, err := h.repo.CommitObject(old)
+		if err != nil {
+			return fmt.Errorf("original commit %s: %w", old, err)
+		}
+		mismatch.OldTree = oldCommit.TreeHash.String()
+
+		newCommit, err := h.repo.CommitObject(rewritten)
+		switch {
+		case err != nil:
+			mismatch.Reason = "rewritten commit is missing: " + err.Error()
+		case newCommit.TreeHash != oldCommit.TreeHash:
+			mismatch.NewTree = newCommit.TreeHash.String()
+			mismatch.Reason = "tree changed"
+		default:
+			if _, err := object.GetTree(h.repo.Storer, newCommit.TreeHash); err != nil {
+				mismatch.NewTree = newCommit.TreeHash.String()
+				mismatch.Reason = "tree is missing: " + err.Error()
+			}
+		}
+		if mismatch.Reason != "" {
+			result.Mismatches = append(result.Mismatches, mismatch)
+		}
+	}
+	if len(result.Mismatches) == 0 {
+		return nil
+	}
+	sort.Slice(result.Mismatches, func(i, j int) bool { return result.Mismatches[i].OldCommit < result.Mismatches[j].OldCommit })
+	return result
+}
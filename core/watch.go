@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WatchIntervalSeconds 是 WatchRepo 轮询远端 head 的间隔
+var WatchIntervalSeconds = 15
+
+// RepoWatcher 接收 WatchRepo 发现的新 commit
+type RepoWatcher interface {
+	// OnNewCommits 的参数为 NewCommitsEvent 的 JSON
+	OnNewCommits(eventJSON string)
+	// OnError 在一轮轮询失败时调用，监听不会因此停止
+	OnError(message string)
+}
+
+// NewCommitsEvent 描述远端 head 的一次前进
+type NewCommitsEvent struct {
+	RepoURL string `json:"repoUrl"`
+	OldHead string `json:"oldHead"`
+	NewHead string `json:"newHead"`
+	// Commits 是 OldHead 之后的新 commit，从旧到新
+	Commits []SimpleCommit `json:"commits"`
+	// Truncated 表示 OldHead 不在最近 TailMaxBatch 条 commit 中（间隔太久或历史被改写），Commits 只包含最近的部分
+	Truncated bool `json:"truncated"`
+}
+
+// watchLoops 是 WatchRepo 启动的监听
+var watchLoops headLoops
+
+// WatchRepo 在后台监听仓库当前分支，远端 head 前进时调用 listener.OnNewCommits。
+// 每隔 WatchIntervalSeconds 秒只通过引用协商检查一次远端 head，head 变化时才克隆取回新 commit。
+// 从远端当前 head 开始监听，已有的 commit 不会通知；同一仓库重复调用会替换之前的监听
+func WatchRepo(repoURL, sshKeyPEM string, listener RepoWatcher) {
+	interval := WatchIntervalSeconds
+	if interval <= 0 {
+		interval = 15
+	}
+	l := watchLoops.start(repoURL)
+	go l.run(repoURL, sshKeyPEM, time.Duration(interval)*time.Second, "", func(known, head string) (string, error) {
+		if head == "" {
+			// 远端被清空，之后的 commit 都是新的
+			return "", nil
+		}
+		return notifyNewCommits(repoURL, sshKeyPEM, known, listener)
+	}, func(err error) {
+		listener.OnError(err.Error())
+	})
+}
+
+// StopWatch 停止对该仓库的监听
+func StopWatch(repoURL string) {
+	watchLoops.stopLoop(repoURL)
+}
+
+// TriggerWatch 让该仓库的监听立即检查一次远端 head，不必等到下一个轮询间隔（例如收到推送通知时）；
+// 没有监听该仓库时返回 false
+func TriggerWatch(repoURL string) bool {
+	return watchLoops.trigger(repoURL)
+}
+
+// notifyNewCommits 取回 known 之后的新 commit 并通知 listener，返回新的 head
+func notifyNewCommits(repoURL, sshKeyPEM string, known string, listener RepoWatcher) (string, error) {
+	commits, head, truncated, err := commitsSince(repoURL, sshKeyPEM, known, TailMaxBatch)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return head, nil
+	}
+	data, err := json.Marshal(&NewCommitsEvent{
+		RepoURL:   repoURL,
+		OldHead:   known,
+		NewHead:   head,
+		Commits:   commits,
+		Truncated: truncated,
+	})
+	if err != nil {
+		return "", err
+	}
+	listener.OnNewCommits(string(data))
+	return head, nil
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// maxWebhookBody 是 webhook 请求体的最大长度
+const maxWebhookBody = 5 << 20
+
+var (
+	webhookMu     sync.Mutex
+	webhookServer *http.Server
+)
+
+// StartWebhookServer 在 addr（例如 ":8080"）上启动接收推送通知的 HTTP 服务，用于不想依赖轮询的桌面端或服务端。
+// 支持 GitHub（X-Hub-Signature-256）和 Gitea/Forgejo（X-Gitea-Signature）的签名校验，secret 为平台上配置的 webhook 密钥。
+// 收到 push 事件时，若推送的仓库和分支正被 WatchRepo 监听，立即触发一次检查（见 TriggerWatch）。
+// 服务已在运行时先停止旧的服务
+func StartWebhookServer(addr, secret string) error {
+	if secret == "" {
+		return errors.New("webhook secret is empty")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: &webhookHandler{secret: []byte(secret)}}
+
+	webhookMu.Lock()
+	old := webhookServer
+	webhookServer = srv
+	webhookMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	go srv.Serve(ln)
+	return nil
+}
+
+// StopWebhookServer 停止 StartWebhookServer 启动的服务，没有运行时什么也不做
+func StopWebhookServer() {
+	webhookMu.Lock()
+	srv := webhookServer
+	webhookServer = nil
+	webhookMu.Unlock()
+	if srv != nil {
+		srv.Close()
+	}
+}
+
+type webhookHandler struct {
+	secret []byte
+}
+
+// pushEvent 是 GitHub 和 Gitea push 事件中用到的字段，两者格式相同
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		CloneURL      string `json:"clone_url"`
+		SSHURL        string `json:"ssh_url"`
+		HTMLURL       string `json:"html_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+func (wh *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "read body", http.StatusBadRequest)
+		return
+	}
+	if !wh.verify(r.Header, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	// Gitea 同时发送 X-GitHub-Event，这里按 Gitea、Forgejo、GitHub 的顺序取事件类型
+	event := r.Header.Get("X-Gitea-Event")
+	if event == "" {
+		event = r.Header.Get("X-Forgejo-Event")
+	}
+	if event == "" {
+		event = r.Header.Get("X-GitHub-Event")
+	}
+	if event != "push" {
+		// ping 等其他事件只确认收到
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var push pushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	triggerPush(&push)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verify 校验请求签名：GitHub 为 "sha256=<hex>"，Gitea/Forgejo 为不带前缀的 hex，都是请求体的 HMAC-SHA256
+func (wh *webhookHandler) verify(header http.Header, body []byte) bool {
+	sig := header.Get("X-Hub-Signature-256")
+	if sig != "" {
+		var ok bool
+		if sig, ok = strings.CutPrefix(sig, "sha256="); !ok {
+			return false
+		}
+	} else if sig = header.Get("X-Gitea-Signature"); sig == "" {
+		sig = header.Get("X-Forgejo-Signature")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, wh.secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// triggerPush 触发所有监听 push 所推送的仓库和分支的 WatchRepo
+func triggerPush(push *pushEvent) {
+	pushed := map[string]bool{}
+	for _, u := range []string{push.Repository.SSHURL, push.Repository.CloneURL, push.Repository.HTMLURL} {
+		if key := canonicalRepo(u); key != "" {
+			pushed[key] = true
+		}
+	}
+	branch, isBranch := strings.CutPrefix(push.Ref, "refs/heads/")
+	if !isBranch {
+		return
+	}
+
+	for _, url := range watchLoops.urls() {
+		if !pushed[canonicalRepo(url)] {
+			continue
+		}
+		watched := RepoBranch(url)
+		if watched == "" {
+			watched = push.Repository.DefaultBranch
+		}
+		if watched != "" && watched != branch {
+			continue
+		}
+		TriggerWatch(url)
+	}
+}
+
+// canonicalRepo 把同一仓库的 SSH、HTTPS 等不同形式的地址归一为 "host/path"，忽略大小写和 .git 后缀
+func canonicalRepo(repoURL string) string {
+	if repoURL == "" {
+		return ""
+	}
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimSuffix(strings.Trim(ep.Path, "/"), ".git")
+	return strings.ToLower(ep.Host + "/" + path)
+}
@@ -0,0 +1,211 @@
+// Package ratelimit 按主机记录托管平台的限流状态：被限流后在退避期内不再访问该主机，
+// 并保存 HTTP API 通告的剩余配额。git 传输（core）和各 provider 的 REST 客户端共用同一份状态。
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxBackoff 是连续被限流时退避时间的上限
+const MaxBackoff = 15 * time.Minute
+
+// defaultBackoff 是没有 Retry-After 时第一次被限流的退避时间，按主机后缀匹配，其余主机使用 fallbackBackoff。
+// GitHub 的 secondary rate limit 和 GitLab 的限流窗口都以分钟计
+var defaultBackoff = map[string]time.Duration{
+	"github.com": time.Minute,
+	"gitlab.com": time.Minute,
+}
+
+const fallbackBackoff = 30 * time.Second
+
+// Status 是一个主机的限流状态
+type Status struct {
+	Host string `json:"host"`
+	// BackoffUntil 是退避结束的时间（Unix 毫秒），不在退避期时为 0
+	BackoffUntil int64 `json:"backoffUntil,omitempty"`
+	// Hits 是累计被限流的次数，ConsecutiveHits 是最近一次成功访问之后被限流的次数
+	Hits            int `json:"hits"`
+	ConsecutiveHits int `json:"consecutiveHits"`
+	// Limit、Remaining、ResetAt（Unix 毫秒）来自 HTTP API 的配额响应头，未知时 Remaining 为 -1
+	Limit     int   `json:"limit,omitempty"`
+	Remaining int   `json:"remaining"`
+	ResetAt   int64 `json:"resetAt,omitempty"`
+}
+
+var (
+	mu    sync.Mutex
+	hosts = map[string]*Status{}
+)
+
+func get(host string) *Status {
+	s := hosts[host]
+	if s == nil {
+		s = &Status{Host: host, Remaining: -1}
+		hosts[host] = s
+	}
+	return s
+}
+
+// Wait 返回访问 host 前还需等待的时间，不在退避期时为 0
+func Wait(host string) time.Duration {
+	if host == "" {
+		return 0
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	s := hosts[host]
+	if s == nil || s.BackoffUntil == 0 {
+		return 0
+	}
+	return time.Until(time.UnixMilli(s.BackoffUntil))
+}
+
+// Hit 记录 host 被限流一次，开始退避并返回退避时间。retryAfter 大于 0 时（服务端给出的 Retry-After）直接使用，
+// 否则从该主机的默认退避时间开始，连续被限流时每次翻倍，不超过 MaxBackoff
+func Hit(host string, retryAfter time.Duration) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	s := get(host)
+	s.Hits++
+	s.ConsecutiveHits++
+	backoff := retryAfter
+	if backoff <= 0 {
+		backoff = hostBackoff(host) << min(s.ConsecutiveHits-1, 10)
+	}
+	backoff = min(backoff, MaxBackoff)
+	s.BackoffUntil = time.Now().Add(backoff).UnixMilli()
+	return backoff
+}
+
+// Success 记录一次成功访问 host，结束退避
+func Success(host string) {
+	if host == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if s := hosts[host]; s != nil {
+		s.ConsecutiveHits = 0
+		s.BackoffUntil = 0
+	}
+}
+
+// ObserveHeaders 从 HTTP 响应头中记录 host 的配额（GitHub 的 X-RateLimit-*、GitLab 的 RateLimit-*），
+// 配额耗尽时按重置时间开始退避
+func ObserveHeaders(host string, h http.Header) {
+	limit, okLimit := headerInt(h, "X-RateLimit-Limit", "RateLimit-Limit")
+	remaining, okRemaining := headerInt(h, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	reset, okReset := headerInt(h, "X-RateLimit-Reset", "RateLimit-Reset")
+	if !okLimit && !okRemaining && !okReset {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	s := get(host)
+	if okLimit {
+		s.Limit = limit
+	}
+	if okRemaining {
+		s.Remaining = remaining
+	}
+	if okReset {
+		s.ResetAt = int64(reset) * 1000
+		if s.Remaining == 0 && s.ResetAt > time.Now().UnixMilli() {
+			s.BackoffUntil = max(s.BackoffUntil, s.ResetAt)
+		}
+	}
+}
+
+// RetryAfter 解析 Retry-After 响应头（秒数或 HTTP 日期），没有或无法解析时返回 0
+func RetryAfter(h http.Header) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+var retryAfterText = regexp.MustCompile(`(?i)(?:retry|try again)[^0-9]{0,20}(\d+)\s*(s|sec|second|seconds|m|min|minute|minutes)?\b`)
+
+// RetryAfterText 从错误文本（例如 SSH 服务端的提示）中解析 "retry after 30 seconds" 一类的等待时间，没有时返回 0
+func RetryAfterText(msg string) time.Duration {
+	m := retryAfterText.FindStringSubmatch(msg)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	if strings.HasPrefix(strings.ToLower(m[2]), "m") {
+		return time.Duration(n) * time.Minute
+	}
+	return time.Duration(n) * time.Second
+}
+
+// Stats 返回所有记录过的主机的限流状态，按主机名排序；已过期的退避时间记为 0
+func Stats() []Status {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now().UnixMilli()
+	stats := make([]Status, 0, len(hosts))
+	for _, s := range hosts {
+		st := *s
+		if st.BackoffUntil <= now {
+			st.BackoffUntil = 0
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
+
+// Reset 清除所有主机的限流状态
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	hosts = map[string]*Status{}
+}
+
+// Error 表示主机仍在退避期内，本次操作没有访问远端
+type Error struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s is rate limited, retry after %s", e.Host, e.RetryAfter.Round(time.Second))
+}
+
+func hostBackoff(host string) time.Duration {
+	for suffix, d := range defaultBackoff {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return d
+		}
+	}
+	return fallbackBackoff
+}
+
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,162 @@
+// Package restapi 是各托管平台 provider（GitHub、Gitea 等）共用的 JSON REST 客户端。
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mixgram-core/internel/ratelimit"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout 是单个请求的默认超时时间
+const DefaultTimeout = 30 * time.Second
+
+// MaxRateLimitWait 是被限流后最多等待的时间，需要等待更久时直接返回错误
+const MaxRateLimitWait = 30 * time.Second
+
+// rateLimitAttempts 是被限流时的总尝试次数（包含第一次）
+const rateLimitAttempts = 3
+
+// maxErrorBody 是错误信息中保留的响应内容的最大长度
+const maxErrorBody = 512
+
+// Client 向 BaseURL 发送 JSON 请求，每个请求都带上 Header
+type Client struct {
+	BaseURL string
+	Header  http.Header
+	HTTP    *http.Client
+}
+
+// New 创建一个请求 baseURL 的客户端
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Header:  http.Header{"Accept": {"application/json"}},
+		HTTP:    &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// Error 是服务端返回的非 2xx 响应
+type Error struct {
+	Method  string
+	URL     string
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.Status, e.Message)
+}
+
+// IsStatus 判断 err 是否是状态码为 status 的 *Error
+func IsStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == status
+}
+
+// Do 发送请求：in 不为 nil 时编码为 JSON 请求体，响应为 2xx 且 out 不为 nil 时把响应体解码到 out。
+// 被限流（429，或带 Retry-After / 配额耗尽的 403）时按 ratelimit 的退避时间等待后重试，
+// 退避时间超过 MaxRateLimitWait 或已重试 rateLimitAttempts 次时返回错误；主机仍在退避期时不发送请求，直接返回 *ratelimit.Error
+func (c *Client) Do(ctx context.Context, method, path string, in, out any) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	url := c.BaseURL + path
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		host := req.URL.Hostname()
+		if wait := ratelimit.Wait(host); wait > MaxRateLimitWait {
+			return &ratelimit.Error{Host: host, RetryAfter: wait}
+		} else if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for k, v := range c.Header {
+			req.Header[k] = v
+		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s %s: read response: %w", method, url, err)
+		}
+		ratelimit.ObserveHeaders(host, resp.Header)
+		if rateLimited(resp) {
+			backoff := ratelimit.Hit(host, ratelimit.RetryAfter(resp.Header))
+			if attempt < rateLimitAttempts && backoff <= MaxRateLimitWait {
+				continue
+			}
+		} else {
+			ratelimit.Success(host)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &Error{Method: method, URL: url, Status: resp.StatusCode, Message: errorMessage(data)}
+		}
+		if out == nil || len(data) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%s %s: parse response: %w", method, url, err)
+		}
+		return nil
+	}
+}
+
+// rateLimited 判断响应是否表示被限流：429，或 GitHub 等平台带 Retry-After 或配额耗尽的 403
+func rateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// errorMessage 从错误响应中取出可读的信息：优先使用 JSON 中的 message / error 字段，否则使用截断后的原文
+func errorMessage(data []byte) string {
+	var v struct {
+		Message json.RawMessage `json:"message"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &v) == nil {
+		for _, raw := range []json.RawMessage{v.Message, v.Error} {
+			if len(raw) == 0 {
+				continue
+			}
+			var s string
+			if json.Unmarshal(raw, &s) == nil {
+				return s
+			}
+			return string(raw)
+		}
+	}
+	msg := strings.TrimSpace(string(data))
+	if len(msg) > maxErrorBody {
+		msg = msg[:maxErrorBody]
+	}
+	return msg
+}
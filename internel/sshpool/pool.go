@@ -0,0 +1,206 @@
+// Package sshpool 提供一个复用 SSH 连接的 git transport：同一主机、用户和私钥的多次
+// fetch / push 共用一个 SSH 连接，每次操作只新开一个 session，省去 TCP 和 SSH 握手。
+package sshpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	ggssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// Identity 由认证方法实现，返回能区分不同私钥的标识（例如公钥指纹）。
+// 没有实现该接口的认证方法不复用连接
+type Identity interface {
+	Identity() string
+}
+
+// DialFunc 建立到 SSH 服务器的 TCP 连接
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Pool 是 SSH 连接池，实现 transport.Transport
+type Pool struct {
+	dial        DialFunc
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*conn
+}
+
+type conn struct {
+	client   *ssh.Client
+	sessions int
+	lastUsed time.Time
+	broken   bool
+}
+
+// New 创建连接池，idleTimeout 为连接空闲多久后关闭（0 表示不自动关闭）
+func New(dial DialFunc, idleTimeout time.Duration) *Pool {
+	return &Pool{dial: dial, idleTimeout: idleTimeout, conns: map[string]*conn{}}
+}
+
+func (p *Pool) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	return p.newSession(transport.UploadPackServiceName, ep, auth)
+}
+
+func (p *Pool) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	return p.newSession(transport.ReceivePackServiceName, ep, auth)
+}
+
+func (p *Pool) newSession(service string, ep *transport.Endpoint, auth transport.AuthMethod) (*session, error) {
+	a, ok := auth.(ggssh.AuthMethod)
+	if !ok {
+		return nil, transport.ErrInvalidAuthMethod
+	}
+	config, err := a.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.HostKeyCallback == nil {
+		return nil, errors.New("ssh pool: auth method has no host key callback")
+	}
+
+	port := ep.Port
+	if port <= 0 {
+		port = ggssh.DefaultPort
+	}
+	addr := net.JoinHostPort(ep.Host, strconv.Itoa(port))
+	key := ""
+	if id, ok := auth.(Identity); ok {
+		key = config.User + "@" + addr + "#" + id.Identity()
+	}
+
+	c, err := p.acquire(key, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := c.client.NewSession()
+	if err != nil {
+		// 复用的连接可能已被服务端关闭，丢弃后重新建立一次
+		p.release(key, c, true)
+		if c, err = p.acquire(key, addr, config); err != nil {
+			return nil, err
+		}
+		if sess, err = c.client.NewSession(); err != nil {
+			p.release(key, c, true)
+			return nil, err
+		}
+	}
+
+	s, err := newSession(sess, func(broken bool) { p.release(key, c, broken) }, service, ep.Path)
+	if err != nil {
+		_ = sess.Close()
+		p.release(key, c, true)
+		return nil, err
+	}
+	return s, nil
+}
+
+// acquire 返回可用的连接，key 为空时总是新建且不放入连接池
+func (p *Pool) acquire(key, addr string, config *ssh.ClientConfig) (*conn, error) {
+	if key != "" {
+		p.mu.Lock()
+		if c, ok := p.conns[key]; ok && !c.broken {
+			c.sessions++
+			p.mu.Unlock()
+			return c, nil
+		}
+		p.mu.Unlock()
+	}
+
+	client, err := p.connect(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{client: client, sessions: 1}
+	if key != "" {
+		p.mu.Lock()
+		if old, ok := p.conns[key]; ok && !old.broken {
+			// 并发建立了另一个连接，使用先放入连接池的那个
+			old.sessions++
+			p.mu.Unlock()
+			_ = client.Close()
+			return old, nil
+		}
+		p.conns[key] = c
+		p.mu.Unlock()
+	}
+	return c, nil
+}
+
+func (p *Pool) connect(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	nc, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, chans, reqs, err := ssh.NewClientConn(nc, addr, config)
+	if err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	return ssh.NewClient(cc, chans, reqs), nil
+}
+
+// release 在 session 结束时调用；broken 的连接从连接池移除，没有 session 时关闭
+func (p *Pool) release(key string, c *conn, broken bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.sessions--
+	c.lastUsed = time.Now()
+	if broken {
+		c.broken = true
+		if key != "" && p.conns[key] == c {
+			delete(p.conns, key)
+		}
+	}
+	if key == "" || c.broken {
+		if c.sessions == 0 {
+			_ = c.client.Close()
+		}
+		return
+	}
+	if p.idleTimeout > 0 {
+		time.AfterFunc(p.idleTimeout, func() { p.closeIdle(key, c) })
+	}
+}
+
+func (p *Pool) closeIdle(key string, c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.sessions > 0 || time.Since(c.lastUsed) < p.idleTimeout || p.conns[key] != c {
+		return
+	}
+	delete(p.conns, key)
+	_ = c.client.Close()
+}
+
+// CloseIdle 关闭所有当前没有 session 的连接
+func (p *Pool) CloseIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, c := range p.conns {
+		if c.sessions == 0 {
+			delete(p.conns, key)
+			_ = c.client.Close()
+		}
+	}
+}
+
+// Len 返回连接池中的连接数量
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
@@ -0,0 +1,322 @@
+package sshpool
+
+// 本文件的会话实现移植自 go-git plumbing/transport/internal/common（Apache License 2.0），
+// 区别在于命令运行在连接池中复用的 SSH 连接上，关闭会话时不关闭连接。
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// stdErrSkipPattern 匹配 stderr 中可以忽略的行
+var stdErrSkipPattern = regexp.MustCompile("^remote:( =*){0,1}$")
+
+// session 是在一个 ssh.Session 上运行的 git-upload-pack / git-receive-pack
+type session struct {
+	ssh     *ssh.Session
+	release func(broken bool)
+	stdin   io.WriteCloser
+	stdout  io.Reader
+
+	isReceivePack bool
+	advRefs       *packp.AdvRefs
+	packRun       bool
+	finished      bool
+	closed        bool
+	broken        bool
+	firstErrLine  chan string
+}
+
+func newSession(s *ssh.Session, release func(broken bool), service, path string) (*session, error) {
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := s.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Start(fmt.Sprintf("%s '%s'", service, path)); err != nil {
+		return nil, err
+	}
+	return &session{
+		ssh:           s,
+		release:       release,
+		stdin:         stdin,
+		stdout:        stdout,
+		firstErrLine:  listenFirstError(stderr),
+		isReceivePack: service == transport.ReceivePackServiceName,
+	}, nil
+}
+
+func listenFirstError(r io.Reader) chan string {
+	errLine := make(chan string, 1)
+	go func() {
+		s := bufio.NewScanner(r)
+		for {
+			if s.Scan() {
+				line := s.Text()
+				if !stdErrSkipPattern.MatchString(line) {
+					errLine <- line
+					break
+				}
+			} else {
+				close(errLine)
+				break
+			}
+		}
+		_, _ = io.Copy(io.Discard, r)
+	}()
+	return errLine
+}
+
+func (s *session) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.TODO())
+}
+
+func (s *session) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	if s.advRefs != nil {
+		return s.advRefs, nil
+	}
+
+	ar := packp.NewAdvRefs()
+	if err := ar.Decode(s.stdoutContext(ctx)); err != nil {
+		if err := s.handleAdvRefDecodeError(err); err != nil {
+			return nil, err
+		}
+	}
+	if !s.isReceivePack && ar.IsEmpty() {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	transport.FilterUnsupportedCapabilities(ar.Capabilities)
+	s.advRefs = ar
+	return ar, nil
+}
+
+func (s *session) handleAdvRefDecodeError(err error) error {
+	var errLine *pktline.ErrorLine
+	if errors.As(err, &errLine) {
+		if isRepoNotFoundError(errLine.Text) {
+			return transport.ErrRepositoryNotFound
+		}
+		return errLine
+	}
+
+	// 仓库不存在时 stdout 为空，错误信息写在 stderr
+	if errors.Is(err, packp.ErrEmptyInput) {
+		s.finished = true
+		if err := s.checkNotFoundError(); err != nil {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	}
+
+	// 空仓库返回只有 flush 的引用通告，对 receive-pack 是合法的
+	if err == packp.ErrEmptyAdvRefs {
+		if s.isReceivePack {
+			return nil
+		}
+		if err := s.finish(); err != nil {
+			return err
+		}
+		return transport.ErrEmptyRemoteRepository
+	}
+
+	if uerr, ok := err.(*packp.ErrUnexpectedData); ok {
+		if isRepoNotFoundError(string(uerr.Data)) {
+			return transport.ErrRepositoryNotFound
+		}
+	}
+	return err
+}
+
+func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	if req.IsEmpty() {
+		if err := s.finish(); err != nil {
+			return nil, err
+		}
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := s.AdvertisedReferencesContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.packRun = true
+	in := s.stdinContext(ctx)
+	out := s.stdoutContext(ctx)
+	if err := uploadPack(in, req); err != nil {
+		return nil, err
+	}
+
+	r, err := ioutil.NonEmptyReader(out)
+	if err == ioutil.ErrEmptyReader {
+		_ = s.Close()
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := packp.NewUploadPackResponse(req)
+	if err := res.Decode(ioutil.NewReadCloser(r, s)); err != nil {
+		return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+	}
+	return res, nil
+}
+
+func (s *session) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	if _, err := s.AdvertisedReferences(); err != nil {
+		return nil, err
+	}
+
+	s.packRun = true
+	w := s.stdinContext(ctx)
+	if err := req.Encode(w); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if !req.Capabilities.Supports(capability.ReportStatus) {
+		return nil, s.Close()
+	}
+
+	r := s.stdoutContext(ctx)
+	var d *sideband.Demuxer
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		d = sideband.NewDemuxer(sideband.Sideband64k, r)
+	} else if req.Capabilities.Supports(capability.Sideband) {
+		d = sideband.NewDemuxer(sideband.Sideband, r)
+	}
+	if d != nil {
+		d.Progress = req.Progress
+		r = d
+	}
+
+	report := packp.NewReportStatus()
+	if err := report.Decode(r); err != nil {
+		return nil, err
+	}
+	if err := report.Error(); err != nil {
+		defer s.Close()
+		return report, err
+	}
+	return report, s.Close()
+}
+
+func (s *session) stdinContext(ctx context.Context) io.WriteCloser {
+	return ioutil.NewWriteCloserOnError(ioutil.NewContextWriteCloser(ctx, s.stdin), s.onError)
+}
+
+func (s *session) stdoutContext(ctx context.Context) io.Reader {
+	return ioutil.NewReaderOnError(ioutil.NewContextReader(ctx, s.stdout), s.onError)
+}
+
+// onError 在读写出错（包括 ctx 取消）时关闭会话；连接可能已处于异常状态，不再放回连接池
+func (s *session) onError(error) {
+	s.broken = true
+	_ = s.Close()
+}
+
+func (s *session) finish() error {
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+
+	// 没有执行 upload/receive-pack 时发送 flush 让服务端正常退出
+	if !s.packRun {
+		_, err := s.stdin.Write(pktline.FlushPkt)
+		return err
+	}
+	return nil
+}
+
+func (s *session) Close() error {
+	if s.closed {
+		return nil
+	}
+	err := s.finish()
+	s.closed = true
+
+	if cerr := s.ssh.Close(); err == nil && cerr != nil && !errors.Is(cerr, io.EOF) {
+		err = cerr
+	}
+	s.release(s.broken)
+	return err
+}
+
+func (s *session) checkNotFoundError() error {
+	t := time.NewTimer(10 * time.Second)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return errors.New("timeout exceeded")
+	case line, ok := <-s.firstErrLine:
+		if !ok || len(line) == 0 {
+			return nil
+		}
+		if isRepoNotFoundError(line) {
+			return transport.ErrRepositoryNotFound
+		}
+		return fmt.Errorf("unknown error: %s", line)
+	}
+}
+
+func isRepoNotFoundError(s string) bool {
+	for _, msg := range []string{
+		"Repository not found.",
+		"repository does not exist.",
+		"does not appear to be a git repository",
+		"no such repository",
+		"access denied",
+		"Repository does not exist or you do not have access",
+		"The project you were looking for could not be found",
+	} {
+		if strings.Contains(s, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadPack 发送 upload-pack 请求（wants、haves 和 done）
+func uploadPack(w io.WriteCloser, req *packp.UploadPackRequest) error {
+	if err := req.UploadRequest.Encode(w); err != nil {
+		return fmt.Errorf("sending upload-req message: %s", err)
+	}
+	if err := req.UploadHaves.Encode(w, true); err != nil {
+		return fmt.Errorf("sending haves message: %s", err)
+	}
+	if err := pktline.NewEncoder(w).Encodef("done\n"); err != nil {
+		return fmt.Errorf("sending done message: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("closing input: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/proxy"
+)
+
+// sshDialerScheme 是注册给 x/net/proxy 的伪代理协议。go-git 的 SSH transport 没有注入 dialer 的接口，
+// 但会按 ProxyOptions 通过 proxy.FromURL 建立连接，借此把连接交给自定义的 dialer
+const sshDialerScheme = "mixgram-dialer"
+
+// Dialer 建立网络连接，需同时支持带 ctx 和不带 ctx 的拨号
+type Dialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
+}
+
+type dialerHolder struct {
+	dialer Dialer
+}
+
+var sshDialer atomic.Pointer[dialerHolder]
+
+func init() {
+	proxy.RegisterDialerType(sshDialerScheme, func(u *url.URL, _ proxy.Dialer) (proxy.Dialer, error) {
+		var d Dialer = proxy.Direct
+		if h := sshDialer.Load(); h != nil {
+			d = h.dialer
+		}
+		if limit := parseRateLimit(u); limit.enabled() {
+			d = &throttledDialer{Dialer: d, limit: limit}
+		}
+		return d, nil
+	})
+}
+
+// throttledDialer 对建立的连接按 limit 限速
+type throttledDialer struct {
+	Dialer
+	limit RateLimit
+}
+
+func (d *throttledDialer) Dial(network, addr string) (net.Conn, error) {
+	c, err := d.Dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return throttle(c, d.limit), nil
+}
+
+func (d *throttledDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := d.Dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return throttle(c, d.limit), nil
+}
+
+// SetSSHDialer 设置建立 SSH 连接使用的 dialer（例如经由 VPN、Tor 或其他隧道），传 nil 恢复直连
+func SetSSHDialer(d Dialer) {
+	if d == nil {
+		sshDialer.Store(nil)
+		return
+	}
+	sshDialer.Store(&dialerHolder{dialer: d})
+}
+
+// DialSSH 建立到 SSH 服务器的 TCP 连接：设置了 SSH dialer 时经由它，否则直连。
+// 连接按全局限速包装（连接可能被多个仓库共用，不使用单个仓库的限速）
+func DialSSH(ctx context.Context, network, addr string) (net.Conn, error) {
+	var (
+		c   net.Conn
+		err error
+	)
+	if h := sshDialer.Load(); h != nil {
+		c, err = h.dialer.DialContext(ctx, network, addr)
+	} else {
+		var d net.Dialer
+		c, err = d.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l := globalRateLimit.Load(); l != nil {
+		c = throttle(c, *l)
+	}
+	return c, nil
+}
+
+// SSHProxyOptions 返回访问 repoURL 时应使用的 ProxyOptions：
+// repoURL 是 SSH 地址且设置了 SSH dialer 或限速时指向自定义 dialer（限速编码在 URL 参数中），否则为空（直连）
+func SSHProxyOptions(repoURL string) transport.ProxyOptions {
+	if !IsSSHURL(repoURL) {
+		return transport.ProxyOptions{}
+	}
+	limit := EffectiveRateLimit(repoURL)
+	if sshDialer.Load() == nil && !limit.enabled() {
+		return transport.ProxyOptions{}
+	}
+	u := url.URL{Scheme: sshDialerScheme, Host: "dialer", RawQuery: rateLimitQuery(limit)}
+	return transport.ProxyOptions{URL: u.String()}
+}
+
+// IsSSHURL 判断 repoURL 是否使用 SSH 协议（ssh:// 或 scp 风格的 user@host:path）
+func IsSSHURL(repoURL string) bool {
+	ep, err := transport.NewEndpoint(repoURL)
+	return err == nil && ep.Protocol == "ssh"
+}
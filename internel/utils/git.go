@@ -1,45 +1,153 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	ggssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"golang.org/x/crypto/ssh"
 	"io"
 )
 
-// NewSSHAuth 创建一个基于 PEM 私钥字符串的 SSH 认证方法
-func NewSSHAuth(sshKeyPEM string) (*ggssh.PublicKeys, error) {
-	auth, err := ggssh.NewPublicKeys("git", []byte(sshKeyPEM), "")
+var dialTimeout atomic.Int64
+
+// storageFactory 创建克隆和 OpenRemote 使用的对象存储，为 nil 时使用内存存储
+var storageFactory atomic.Pointer[func() storage.Storer]
+
+// SetStorageFactory 设置创建内存仓库存储的函数（例如带内存上限的存储），传 nil 恢复纯内存存储
+func SetStorageFactory(f func() storage.Storer) {
+	if f == nil {
+		storageFactory.Store(nil)
+		return
+	}
+	storageFactory.Store(&f)
+}
+
+// NewStorage 创建一个新的仓库存储，见 SetStorageFactory
+func NewStorage() storage.Storer {
+	if f := storageFactory.Load(); f != nil {
+		return (*f)()
+	}
+	return memory.NewStorage()
+}
+
+// SetDialTimeout 设置建立 SSH / HTTP 连接的超时时间，0 表示不限制
+func SetDialTimeout(d time.Duration) {
+	dialTimeout.Store(int64(d))
+	dialer := &net.Dialer{Timeout: d, KeepAlive: 30 * time.Second}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialer.DialContext
+	httpClient := githttp.NewClient(&http.Client{Transport: tr})
+	InstallProtocol("http", httpClient)
+	InstallProtocol("https", httpClient)
+}
+
+// DefaultSSHUser 是仓库地址中没有指定用户时使用的 SSH 用户名
+const DefaultSSHUser = "git"
+
+// NewSSHAuth 创建一个基于 PEM 私钥字符串的 SSH 认证方法，用户名为 DefaultSSHUser
+func NewSSHAuth(sshKeyPEM string) (ggssh.AuthMethod, error) {
+	return NewSSHAuthWithUser(DefaultSSHUser, sshKeyPEM)
+}
+
+// NewSSHAuthForURL 同 NewSSHAuth，但使用 repoURL 中的用户名（如 ssh://gitea@host:2222/org/repo.git），
+// 地址中没有用户名时使用 DefaultSSHUser
+func NewSSHAuthForURL(repoURL, sshKeyPEM string) (ggssh.AuthMethod, error) {
+	user := DefaultSSHUser
+	if ep, err := ParseSSHURL(repoURL); err == nil && ep.User != "" {
+		user = ep.User
+	}
+	return NewSSHAuthWithUser(user, sshKeyPEM)
+}
+
+// NewSSHAuthWithUser 创建一个使用指定用户名和 PEM 私钥字符串的 SSH 认证方法
+func NewSSHAuthWithUser(user, sshKeyPEM string) (ggssh.AuthMethod, error) {
+	auth, err := ggssh.NewPublicKeys(user, []byte(sshKeyPEM), "")
 	if err != nil {
 		return nil, fmt.Errorf("create public keys: %w", err)
 	}
 	// WARNING: 不校验 host key（开发/测试用）。生产请替换为合适的 HostKeyCallback。
 	auth.HostKeyCallbackHelper.HostKeyCallback = ssh.InsecureIgnoreHostKey()
-	return auth, nil
+	return &timeoutAuth{auth}, nil
 }
 
-// CloneToMemory 克隆一个仓库到内存中
-// depth: 克隆深度，0 表示完整克隆
+// timeoutAuth 在 SSH 客户端配置中带上 SetDialTimeout 设置的连接超时
+type timeoutAuth struct {
+	*ggssh.PublicKeys
+}
+
+// Identity 返回私钥对应公钥的指纹，连接池据此区分不同的私钥
+func (a *timeoutAuth) Identity() string {
+	return ssh.FingerprintSHA256(a.Signer.PublicKey())
+}
+
+func (a *timeoutAuth) ClientConfig() (*ssh.ClientConfig, error) {
+	config, err := a.PublicKeys.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = time.Duration(dialTimeout.Load())
+	return config, nil
+}
+
+// CloneToMemory 克隆一个仓库到内存中，检出远端默认分支（HEAD 指向的分支）
 // 修正：返回 billy.Filesystem 接口，而不是 *memfs.Memory
 func CloneToMemory(repoURL string, auth transport.AuthMethod) (*git.Repository, billy.Filesystem, error) {
-	storer := memory.NewStorage()
+	return CloneBranchToMemory(context.Background(), repoURL, auth, "", 0)
+}
+
+// CloneBranchToMemory 克隆一个仓库到内存中并检出指定分支（短名称），
+// branch 为空时检出远端默认分支
+// depth: 克隆深度，0 表示完整克隆；ctx 取消或超时时中止克隆
+func CloneBranchToMemory(ctx context.Context, repoURL string, auth transport.AuthMethod, branch string, depth int) (*git.Repository, billy.Filesystem, error) {
+	storer := NewStorage()
 	fs := memfs.New() // fs 是 *memfs.Memory
 
 	cloneOpts := &git.CloneOptions{
-		URL:      repoURL,
-		Auth:     auth,
-		Progress: io.Discard,
+		URL:          repoURL,
+		Auth:         auth,
+		Depth:        depth,
+		Progress:     io.Discard,
+		ProxyOptions: SSHProxyOptions(repoURL),
+	}
+	if branch != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(branch)
 	}
 
-	repo, err := git.Clone(storer, fs, cloneOpts)
+	repo, err := git.CloneContext(ctx, storer, fs, cloneOpts)
 	if err != nil {
 		return nil, nil, fmt.Errorf("clone: %w", err)
 	}
 	// 修正：返回 fs (*memfs.Memory) 作为 billy.Filesystem 接口
 	return repo, fs, nil
 }
+
+// OpenRemote 创建一个空的内存仓库并添加名为 origin 的远端，
+// 用于只需要取回/推送个别 ref、不需要完整克隆的轻量操作
+func OpenRemote(repoURL string) (*git.Repository, error) {
+	repo, err := git.Init(NewStorage(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("init: %w", err)
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create remote: %w", err)
+	}
+	return repo, nil
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// DefaultSSHPort 是仓库地址中没有指定端口时使用的 SSH 端口
+const DefaultSSHPort = 22
+
+// SSHEndpoint 是从仓库地址中解析出的 SSH 连接信息
+type SSHEndpoint struct {
+	User string
+	Host string
+	Port int
+	// Path 是仓库路径，不带开头的 "/"
+	Path string
+}
+
+// ParseSSHURL 解析 ssh://[user@]host[:port]/path 或 scp 风格的 [user@]host:path 地址，
+// 缺省的用户名为空，缺省的端口为 DefaultSSHPort
+func ParseSSHURL(repoURL string) (*SSHEndpoint, error) {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if ep.Protocol != "ssh" {
+		return nil, fmt.Errorf("not an ssh url: %s", repoURL)
+	}
+	if ep.Host == "" {
+		return nil, fmt.Errorf("missing host: %s", repoURL)
+	}
+	port := ep.Port
+	if port == 0 {
+		port = DefaultSSHPort
+	}
+	return &SSHEndpoint{
+		User: ep.User,
+		Host: ep.Host,
+		Port: port,
+		Path: strings.TrimPrefix(ep.Path, "/"),
+	}, nil
+}
+
+// String 返回 ssh://user@host:port/path 形式的地址，端口为 DefaultSSHPort 时省略。
+// scp 风格地址中的相对路径会被当作从根开始的路径，这与常见的 git 托管服务一致
+func (e *SSHEndpoint) String() string {
+	var b strings.Builder
+	b.WriteString("ssh://")
+	if e.User != "" {
+		b.WriteString(e.User)
+		b.WriteByte('@')
+	}
+	host := e.Host
+	if e.Port != 0 && e.Port != DefaultSSHPort {
+		host = net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	b.WriteString(host)
+	b.WriteByte('/')
+	b.WriteString(e.Path)
+	return b.String()
+}
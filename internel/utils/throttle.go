@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throttleChunk 是限速连接单次读写的最大字节数，避免一次大块读写造成突发流量
+const throttleChunk = 16 * 1024
+
+// RateLimit 是上传和下载的限速（字节/秒），0 表示不限速
+type RateLimit struct {
+	Upload   int64
+	Download int64
+}
+
+func (r RateLimit) enabled() bool {
+	return r.Upload > 0 || r.Download > 0
+}
+
+var (
+	globalRateLimit atomic.Pointer[RateLimit]
+
+	repoRateLimitsMu sync.Mutex
+	repoRateLimits   = map[string]RateLimit{}
+)
+
+// SetRateLimit 设置所有 SSH 传输默认的上传/下载限速（字节/秒），0 表示不限速
+func SetRateLimit(upload, download int64) {
+	globalRateLimit.Store(&RateLimit{Upload: max(upload, 0), Download: max(download, 0)})
+}
+
+// SetRepoRateLimit 为访问 repoURL 的传输单独设置限速，覆盖全局设置；
+// upload 和 download 都小于 0 时取消覆盖，恢复使用全局设置
+func SetRepoRateLimit(repoURL string, upload, download int64) {
+	repoRateLimitsMu.Lock()
+	defer repoRateLimitsMu.Unlock()
+	if upload < 0 && download < 0 {
+		delete(repoRateLimits, repoURL)
+		return
+	}
+	repoRateLimits[repoURL] = RateLimit{Upload: max(upload, 0), Download: max(download, 0)}
+}
+
+// EffectiveRateLimit 返回访问 repoURL 时实际使用的限速
+func EffectiveRateLimit(repoURL string) RateLimit {
+	repoRateLimitsMu.Lock()
+	limit, ok := repoRateLimits[repoURL]
+	repoRateLimitsMu.Unlock()
+	if ok {
+		return limit
+	}
+	if l := globalRateLimit.Load(); l != nil {
+		return *l
+	}
+	return RateLimit{}
+}
+
+// rateLimitQuery 把限速编码进 ProxyOptions 的 URL 参数，拨号时由 dialerFromURL 解析
+func rateLimitQuery(limit RateLimit) string {
+	q := url.Values{}
+	if limit.Upload > 0 {
+		q.Set("up", strconv.FormatInt(limit.Upload, 10))
+	}
+	if limit.Download > 0 {
+		q.Set("down", strconv.FormatInt(limit.Download, 10))
+	}
+	return q.Encode()
+}
+
+func parseRateLimit(u *url.URL) RateLimit {
+	q := u.Query()
+	up, _ := strconv.ParseInt(q.Get("up"), 10, 64)
+	down, _ := strconv.ParseInt(q.Get("down"), 10, 64)
+	return RateLimit{Upload: max(up, 0), Download: max(down, 0)}
+}
+
+// throttle 按 limit 包装连接，不限速时原样返回
+func throttle(c net.Conn, limit RateLimit) net.Conn {
+	if !limit.enabled() {
+		return c
+	}
+	return &throttledConn{Conn: c, up: newPacer(limit.Upload), down: newPacer(limit.Download)}
+}
+
+// throttledConn 对读写分别限速
+type throttledConn struct {
+	net.Conn
+	up, down *pacer
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if c.down != nil && len(b) > throttleChunk {
+		b = b[:throttleChunk]
+	}
+	n, err := c.Conn.Read(b)
+	c.down.wait(n)
+	return n, err
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if c.up != nil && len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		c.up.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// pacer 按固定速率安排字节的发送时间，空闲时不累积额度
+type pacer struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+func newPacer(rate int64) *pacer {
+	if rate <= 0 {
+		return nil
+	}
+	return &pacer{rate: rate}
+}
+
+// wait 为 n 个字节预留发送时间，并等待到这些字节允许发送为止
+func (p *pacer) wait(n int) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(int64(n) * int64(time.Second) / p.rate))
+	delay := p.next.Sub(now)
+	p.mu.Unlock()
+	time.Sleep(delay)
+}
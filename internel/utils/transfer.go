@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+)
+
+// Transfer 是一个 transport 会话的传输量。字节数按 git 协议的数据计算（不含 SSH/TLS 的加密开销），
+// 对象数取自包文件头
+type Transfer struct {
+	// Service 是 transport.UploadPackServiceName 或 transport.ReceivePackServiceName
+	Service         string
+	BytesSent       int64
+	BytesReceived   int64
+	ObjectsSent     int64
+	ObjectsReceived int64
+	Duration        time.Duration
+	// Requested 表示会话发出了取回或推送请求，为 false 时只读取了引用通告
+	Requested bool
+	// Incremental 表示取回请求带有 have，即在本地已有的历史上增量取回；为 false 的取回是克隆
+	Incremental bool
+}
+
+var transferSink atomic.Pointer[func(ctx context.Context, t Transfer)]
+
+// SetTransferSink 设置接收传输量的函数，每个会话关闭时以会话最后一次使用的 ctx 调用一次；传 nil 取消
+func SetTransferSink(f func(ctx context.Context, t Transfer)) {
+	if f == nil {
+		transferSink.Store(nil)
+		return
+	}
+	transferSink.Store(&f)
+}
+
+var (
+	protocolsMu sync.Mutex
+	protocols   = map[string]*meteredTransport{}
+)
+
+func init() {
+	for scheme, t := range client.Protocols {
+		InstallProtocol(scheme, t)
+	}
+}
+
+// InstallProtocol 同 client.InstallProtocol，但经由计量的包装安装，传输量报告给 SetTransferSink 设置的函数。
+// 本库替换协议实现时都应使用它，直接调用 client.InstallProtocol 安装的协议不计量
+func InstallProtocol(scheme string, t transport.Transport) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if m, ok := t.(*meteredTransport); ok {
+		t = m.load()
+	}
+	m := protocols[scheme]
+	if m == nil {
+		m = &meteredTransport{}
+		protocols[scheme] = m
+	}
+	m.inner.Store(&t)
+	client.InstallProtocol(scheme, m)
+}
+
+type meteredTransport struct {
+	inner atomic.Pointer[transport.Transport]
+}
+
+func (m *meteredTransport) load() transport.Transport {
+	return *m.inner.Load()
+}
+
+func (m *meteredTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	s, err := m.load().NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredUploadPack{UploadPackSession: s, meter: newSessionMeter(transport.UploadPackServiceName)}, nil
+}
+
+func (m *meteredTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	s, err := m.load().NewReceivePackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReceivePack{ReceivePackSession: s, meter: newSessionMeter(transport.ReceivePackServiceName)}, nil
+}
+
+// sessionMeter 累计一个会话的传输量，会话关闭时报告
+type sessionMeter struct {
+	service string
+	start   time.Time
+
+	mu  sync.Mutex
+	ctx context.Context
+
+	sent, received, objectsSent, objectsReceived atomic.Int64
+	advertisedOnce, requested, incremental       atomic.Bool
+	reported                                     atomic.Bool
+}
+
+func newSessionMeter(service string) *sessionMeter {
+	return &sessionMeter{service: service, start: time.Now(), ctx: context.Background()}
+}
+
+func (m *sessionMeter) use(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+}
+
+func (m *sessionMeter) report() {
+	if m.reported.Swap(true) {
+		return
+	}
+	f := transferSink.Load()
+	if f == nil {
+		return
+	}
+	m.mu.Lock()
+	ctx := m.ctx
+	m.mu.Unlock()
+	(*f)(ctx, Transfer{
+		Service:         m.service,
+		BytesSent:       m.sent.Load(),
+		BytesReceived:   m.received.Load(),
+		ObjectsSent:     m.objectsSent.Load(),
+		ObjectsReceived: m.objectsReceived.Load(),
+		Duration:        time.Since(m.start),
+		Requested:       m.requested.Load(),
+		Incremental:     m.incremental.Load(),
+	})
+}
+
+// advertised 按编码后的大小计入服务端通告的引用，会话缓存的通告只计一次
+func (m *sessionMeter) advertised(ar *packp.AdvRefs) {
+	if ar != nil && !m.advertisedOnce.Swap(true) {
+		var c byteCounter
+		ar.Encode(&c)
+		m.received.Add(int64(c))
+	}
+}
+
+type meteredUploadPack struct {
+	transport.UploadPackSession
+	meter *sessionMeter
+}
+
+func (s *meteredUploadPack) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.Background())
+}
+
+func (s *meteredUploadPack) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	s.meter.use(ctx)
+	ar, err := s.UploadPackSession.AdvertisedReferencesContext(ctx)
+	s.meter.advertised(ar)
+	return ar, err
+}
+
+func (s *meteredUploadPack) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	s.meter.use(ctx)
+	s.meter.requested.Store(true)
+	if len(req.Haves) > 0 {
+		s.meter.incremental.Store(true)
+	}
+	var c byteCounter
+	req.UploadRequest.Encode(&c)
+	req.UploadHaves.Encode(&c, true)
+	s.meter.sent.Add(int64(c))
+
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	sideband := req.Capabilities.Supports(capability.Sideband64k) || req.Capabilities.Supports(capability.Sideband)
+	counted := packp.NewUploadPackResponseWithPackfile(req, &packCounter{
+		ReadCloser: resp,
+		bytes:      &s.meter.received,
+		objects:    &s.meter.objectsReceived,
+		sideband:   sideband,
+	})
+	counted.ShallowUpdate = resp.ShallowUpdate
+	counted.ServerResponse = resp.ServerResponse
+	return counted, nil
+}
+
+func (s *meteredUploadPack) Close() error {
+	err := s.UploadPackSession.Close()
+	s.meter.report()
+	return err
+}
+
+type meteredReceivePack struct {
+	transport.ReceivePackSession
+	meter *sessionMeter
+}
+
+func (s *meteredReceivePack) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.Background())
+}
+
+func (s *meteredReceivePack) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	s.meter.use(ctx)
+	ar, err := s.ReceivePackSession.AdvertisedReferencesContext(ctx)
+	s.meter.advertised(ar)
+	return ar, err
+}
+
+func (s *meteredReceivePack) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	s.meter.use(ctx)
+	s.meter.requested.Store(true)
+	commands := *req
+	commands.Packfile = nil
+	var c byteCounter
+	commands.Encode(&c)
+	s.meter.sent.Add(int64(c))
+	if req.Packfile != nil {
+		counted := *req
+		counted.Packfile = &packCounter{ReadCloser: req.Packfile, bytes: &s.meter.sent, objects: &s.meter.objectsSent}
+		req = &counted
+	}
+
+	rs, err := s.ReceivePackSession.ReceivePack(ctx, req)
+	if rs != nil {
+		var c byteCounter
+		rs.Encode(&c)
+		s.meter.received.Add(int64(c))
+	}
+	return rs, err
+}
+
+func (s *meteredReceivePack) Close() error {
+	err := s.ReceivePackSession.Close()
+	s.meter.report()
+	return err
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// packCounter 统计经过的字节数，并从包文件头读取对象数。sideband 为 true 时数据按 pkt-line 复用，
+// 包文件位于第 1 通道
+type packCounter struct {
+	io.ReadCloser
+	bytes, objects *atomic.Int64
+	sideband       bool
+
+	// pending 是还未解析完的 pkt-line，header 是已收到的包文件头
+	pending []byte
+	header  []byte
+	done    bool
+}
+
+func (c *packCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.bytes.Add(int64(n))
+	if !c.done && n > 0 {
+		c.sniff(p[:n])
+	}
+	return n, err
+}
+
+// packHeaderSize 是包文件头的长度："PACK"、版本号和对象数各 4 字节
+const packHeaderSize = 12
+
+func (c *packCounter) sniff(p []byte) {
+	if !c.sideband {
+		c.addHeader(p)
+		return
+	}
+	c.pending = append(c.pending, p...)
+	for !c.done && len(c.pending) >= 4 {
+		size, err := strconv.ParseUint(string(c.pending[:4]), 16, 16)
+		if err != nil {
+			c.done = true
+			break
+		}
+		if size < 4 {
+			// flush 等特殊包没有内容
+			c.pending = c.pending[4:]
+			continue
+		}
+		if len(c.pending) < int(size) {
+			break
+		}
+		if size > 4 && c.pending[4] == 1 {
+			c.addHeader(c.pending[5:size])
+		}
+		c.pending = c.pending[size:]
+	}
+	if c.done {
+		c.pending = nil
+	}
+}
+
+func (c *packCounter) addHeader(p []byte) {
+	c.header = append(c.header, p[:min(len(p), packHeaderSize-len(c.header))]...)
+	if len(c.header) < packHeaderSize {
+		return
+	}
+	c.done = true
+	if bytes.HasPrefix(c.header, []byte("PACK")) {
+		c.objects.Add(int64(binary.BigEndian.Uint32(c.header[8:])))
+	}
+}
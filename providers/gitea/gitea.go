@@ -0,0 +1,88 @@
+// Package gitea 通过 Gitea / Forgejo 的 REST API（/api/v1）在自建实例上创建私有仓库、登记 deploy key 和设置默认分支，
+// 用法与 providers/github 相同。需要具有 repository 写权限的 Access Token。
+package gitea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"mixgram-core/internel/restapi"
+	"mixgram-core/providers"
+)
+
+// apiPrefix 是 Gitea / Forgejo API 相对实例地址的路径
+const apiPrefix = "/api/v1"
+
+// Client 是使用一个 Access Token 访问某个 Gitea / Forgejo 实例的客户端
+type Client struct {
+	api *restapi.Client
+}
+
+// NewClient 创建访问实例 baseURL（例如 https://git.example.com，不含 /api/v1）的客户端
+func NewClient(baseURL, token string) *Client {
+	api := restapi.New(strings.TrimRight(baseURL, "/") + apiPrefix)
+	api.Header.Set("Authorization", "token "+token)
+	return &Client{api: api}
+}
+
+// CreateRepository 创建仓库 name，org 为空时创建在 token 所属用户名下，否则创建在组织 org 下；
+// defaultBranch 为空时使用实例的默认设置
+func (c *Client) CreateRepository(org, name, description, defaultBranch string, private bool) (*providers.Repository, error) {
+	if name == "" {
+		return nil, errors.New("repository name is empty")
+	}
+	path := "/user/repos"
+	if org != "" {
+		path = "/orgs/" + url.PathEscape(org) + "/repos"
+	}
+	in := map[string]any{"name": name, "description": description, "private": private}
+	if defaultBranch != "" {
+		in["default_branch"] = defaultBranch
+	}
+	var out providers.APIRepository
+	if err := c.api.Do(context.Background(), http.MethodPost, path, in, &out); err != nil {
+		return nil, fmt.Errorf("create repository: %w", err)
+	}
+	return out.Repository(), nil
+}
+
+// SetDefaultBranch 把仓库 owner/repo 的默认分支设为 branch（分支需已存在）
+func (c *Client) SetDefaultBranch(owner, repo, branch string) (*providers.Repository, error) {
+	if branch == "" {
+		return nil, errors.New("branch is empty")
+	}
+	var out providers.APIRepository
+	in := map[string]any{"default_branch": branch}
+	if err := c.api.Do(context.Background(), http.MethodPatch, providers.RepoPath(owner, repo), in, &out); err != nil {
+		return nil, fmt.Errorf("set default branch: %w", err)
+	}
+	return out.Repository(), nil
+}
+
+// AddDeployKey 在仓库 owner/repo 上登记 deploy key，publicKey 为 authorized_keys 格式；readOnly 为 false 时允许推送
+func (c *Client) AddDeployKey(owner, repo, title, publicKey string, readOnly bool) (*providers.DeployKey, error) {
+	return providers.AddDeployKey(c.api, owner, repo, title, publicKey, readOnly)
+}
+
+// DeleteDeployKey 删除仓库 owner/repo 上的 deploy key
+func (c *Client) DeleteDeployKey(owner, repo string, keyID int64) error {
+	return providers.DeleteDeployKey(c.api, owner, repo, keyID)
+}
+
+// Onboard 创建私有仓库 name（org 为空时在用户名下，默认分支为 defaultBranch，空表示实例默认），
+// 生成新的 SSH 密钥并登记为可写的 deploy key，返回仓库的 SSH 地址和私钥。keyTitle 为空时使用 providers.DefaultKeyTitle。
+// 登记密钥失败时仓库已经创建，错误信息中包含仓库名称，可以重试 AddDeployKey 或手动删除仓库
+func (c *Client) Onboard(org, name, keyTitle, defaultBranch string) (*providers.OnboardResult, error) {
+	return providers.Onboard(c.api, keyTitle, func() (*providers.Repository, error) {
+		return c.CreateRepository(org, name, "", defaultBranch, true)
+	})
+}
+
+// OnboardJSON 同 Onboard，以 JSON 返回 OnboardResult
+func (c *Client) OnboardJSON(org, name, keyTitle, defaultBranch string) (string, error) {
+	return providers.OnboardJSON(c.Onboard(org, name, keyTitle, defaultBranch))
+}
@@ -0,0 +1,78 @@
+// Package github 通过 GitHub REST API 创建私有仓库并登记 deploy key，
+// 把新用户的初始化（建仓库、生成密钥、上传公钥）合并为一次调用。需要具有 repo 权限的 Personal Access Token。
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"mixgram-core/internel/restapi"
+	"mixgram-core/providers"
+)
+
+// DefaultAPIURL 是 github.com 的 API 地址，GitHub Enterprise Server 使用 https://<host>/api/v3
+const DefaultAPIURL = "https://api.github.com"
+
+// Client 是使用一个 Personal Access Token 的 GitHub API 客户端
+type Client struct {
+	api *restapi.Client
+}
+
+// NewClient 创建使用 token 访问 github.com 的客户端
+func NewClient(token string) *Client {
+	api := restapi.New(DefaultAPIURL)
+	api.Header.Set("Accept", "application/vnd.github+json")
+	api.Header.Set("Authorization", "Bearer "+token)
+	api.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	return &Client{api: api}
+}
+
+// SetBaseURL 设置 API 地址，用于 GitHub Enterprise Server
+func (c *Client) SetBaseURL(baseURL string) {
+	c.api.BaseURL = strings.TrimRight(baseURL, "/")
+}
+
+// CreateRepository 创建仓库 name，org 为空时创建在 token 所属用户名下，否则创建在组织 org 下
+func (c *Client) CreateRepository(org, name, description string, private bool) (*providers.Repository, error) {
+	if name == "" {
+		return nil, errors.New("repository name is empty")
+	}
+	path := "/user/repos"
+	if org != "" {
+		path = "/orgs/" + url.PathEscape(org) + "/repos"
+	}
+	in := map[string]any{"name": name, "description": description, "private": private}
+	var out providers.APIRepository
+	if err := c.api.Do(context.Background(), http.MethodPost, path, in, &out); err != nil {
+		return nil, fmt.Errorf("create repository: %w", err)
+	}
+	return out.Repository(), nil
+}
+
+// AddDeployKey 在仓库 owner/repo 上登记 deploy key，publicKey 为 authorized_keys 格式；readOnly 为 false 时允许推送
+func (c *Client) AddDeployKey(owner, repo, title, publicKey string, readOnly bool) (*providers.DeployKey, error) {
+	return providers.AddDeployKey(c.api, owner, repo, title, publicKey, readOnly)
+}
+
+// DeleteDeployKey 删除仓库 owner/repo 上的 deploy key
+func (c *Client) DeleteDeployKey(owner, repo string, keyID int64) error {
+	return providers.DeleteDeployKey(c.api, owner, repo, keyID)
+}
+
+// Onboard 创建私有仓库 name（org 为空时在用户名下），生成新的 SSH 密钥并登记为可写的 deploy key，
+// 返回仓库的 SSH 地址和私钥。keyTitle 为空时使用 providers.DefaultKeyTitle。
+// 登记密钥失败时仓库已经创建，错误信息中包含仓库名称，可以重试 AddDeployKey 或手动删除仓库
+func (c *Client) Onboard(org, name, keyTitle string) (*providers.OnboardResult, error) {
+	return providers.Onboard(c.api, keyTitle, func() (*providers.Repository, error) {
+		return c.CreateRepository(org, name, "", true)
+	})
+}
+
+// OnboardJSON 同 Onboard，以 JSON 返回 OnboardResult
+func (c *Client) OnboardJSON(org, name, keyTitle string) (string, error) {
+	return providers.OnboardJSON(c.Onboard(org, name, keyTitle))
+}
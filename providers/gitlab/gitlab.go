@@ -0,0 +1,236 @@
+// Package gitlab 通过 GitLab REST API（/api/v4，gitlab.com 或自建实例）创建私有项目、登记 deploy key
+// 并配置受保护分支，使 TrimOldCommits 等重写历史的操作可以强制推送。用法与 providers/github 相同，
+// 需要具有 api 权限的 Personal / Project Access Token。
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mixgram-core/internel/restapi"
+	"mixgram-core/internel/utils"
+	"mixgram-core/providers"
+)
+
+// DefaultBaseURL 是 gitlab.com 的地址，自建实例传入自己的地址（不含 /api/v4）
+const DefaultBaseURL = "https://gitlab.com"
+
+// apiPrefix 是 GitLab API 相对实例地址的路径
+const apiPrefix = "/api/v4"
+
+// maintainerAccess 是 GitLab 的 Maintainer 访问级别，新建保护规则时推送和合并都限制为 Maintainer
+const maintainerAccess = 40
+
+// Client 是使用一个 Access Token 访问 GitLab 的客户端
+type Client struct {
+	api *restapi.Client
+}
+
+// Project 是 GitLab 上的一个项目
+type Project struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"pathWithNamespace"`
+	Visibility        string `json:"visibility"`
+	SSHURL            string `json:"sshUrl"`
+	WebURL            string `json:"webUrl"`
+	DefaultBranch     string `json:"defaultBranch,omitempty"`
+}
+
+// DeployKey 是项目上登记的一个 deploy key
+type DeployKey struct {
+	ID      int64  `json:"id"`
+	Title   string `json:"title"`
+	Key     string `json:"key"`
+	CanPush bool   `json:"canPush"`
+}
+
+// ProtectedBranch 是一条受保护分支规则
+type ProtectedBranch struct {
+	Name           string `json:"name"`
+	AllowForcePush bool   `json:"allowForcePush"`
+}
+
+// OnboardResult 是 Onboard 的结果：新项目、可写的 deploy key 及其私钥
+type OnboardResult struct {
+	Project   *Project   `json:"project"`
+	DeployKey *DeployKey `json:"deployKey"`
+	// SSHURL 是项目的 SSH 地址，与 PrivateKeyPEM 一起作为 core 中各 API 的 repoURL 和 sshKeyPEM
+	SSHURL        string `json:"sshUrl"`
+	PrivateKeyPEM string `json:"privateKeyPem"`
+}
+
+// NewClient 创建访问实例 baseURL 的客户端，baseURL 为空时使用 DefaultBaseURL
+func NewClient(baseURL, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	api := restapi.New(strings.TrimRight(baseURL, "/") + apiPrefix)
+	api.Header.Set("PRIVATE-TOKEN", token)
+	return &Client{api: api}
+}
+
+// CreateProject 创建私有或公开项目 name，namespace 为组（或子组）的完整路径，空表示 token 所属用户的个人命名空间；
+// defaultBranch 为空时使用实例的默认设置
+func (c *Client) CreateProject(namespace, name, description, defaultBranch string, private bool) (*Project, error) {
+	if name == "" {
+		return nil, errors.New("project name is empty")
+	}
+	in := map[string]any{"name": name, "description": description, "visibility": "public"}
+	if private {
+		in["visibility"] = "private"
+	}
+	if defaultBranch != "" {
+		in["default_branch"] = defaultBranch
+	}
+	if namespace != "" {
+		var ns struct {
+			ID int64 `json:"id"`
+		}
+		if err := c.api.Do(context.Background(), http.MethodGet, "/namespaces/"+url.PathEscape(namespace), nil, &ns); err != nil {
+			return nil, fmt.Errorf("find namespace %s: %w", namespace, err)
+		}
+		in["namespace_id"] = ns.ID
+	}
+	var out apiProject
+	if err := c.api.Do(context.Background(), http.MethodPost, "/projects", in, &out); err != nil {
+		return nil, fmt.Errorf("create project: %w", err)
+	}
+	return out.toProject(), nil
+}
+
+// AddDeployKey 在项目 project（数字 ID 或 group/name 形式的完整路径）上登记 deploy key，
+// publicKey 为 authorized_keys 格式；canPush 为 true 时允许推送
+func (c *Client) AddDeployKey(project, title, publicKey string, canPush bool) (*DeployKey, error) {
+	in := map[string]any{"title": title, "key": publicKey, "can_push": canPush}
+	var out struct {
+		ID      int64  `json:"id"`
+		Title   string `json:"title"`
+		Key     string `json:"key"`
+		CanPush bool   `json:"can_push"`
+	}
+	if err := c.api.Do(context.Background(), http.MethodPost, projectPath(project)+"/deploy_keys", in, &out); err != nil {
+		return nil, fmt.Errorf("add deploy key: %w", err)
+	}
+	return &DeployKey{ID: out.ID, Title: out.Title, Key: out.Key, CanPush: out.CanPush}, nil
+}
+
+// DeleteDeployKey 删除项目 project 上的 deploy key
+func (c *Client) DeleteDeployKey(project string, keyID int64) error {
+	if err := c.api.Do(context.Background(), http.MethodDelete, fmt.Sprintf("%s/deploy_keys/%d", projectPath(project), keyID), nil, nil); err != nil {
+		return fmt.Errorf("delete deploy key: %w", err)
+	}
+	return nil
+}
+
+// SetForcePushAllowed 设置项目 project 的分支 branch 是否允许强制推送。
+// 分支已受保护时只修改其 allow_force_push；尚未受保护时（包括分支还不存在）新建保护规则，
+// 推送和合并限制为 Maintainer，deployKeyID 大于 0 时同时允许该 deploy key 推送。
+// 预先为默认分支建立规则可以避免 GitLab 在第一次推送时按实例默认设置自动保护并禁止强制推送
+func (c *Client) SetForcePushAllowed(project, branch string, allowed bool, deployKeyID int64) (*ProtectedBranch, error) {
+	if branch == "" {
+		return nil, errors.New("branch is empty")
+	}
+	var out struct {
+		Name           string `json:"name"`
+		AllowForcePush bool   `json:"allow_force_push"`
+	}
+	path := projectPath(project) + "/protected_branches"
+	err := c.api.Do(context.Background(), http.MethodPatch, path+"/"+url.PathEscape(branch),
+		map[string]any{"allow_force_push": allowed}, &out)
+	if restapi.IsStatus(err, http.StatusNotFound) {
+		in := map[string]any{
+			"name":               branch,
+			"allow_force_push":   allowed,
+			"push_access_level":  maintainerAccess,
+			"merge_access_level": maintainerAccess,
+		}
+		if deployKeyID > 0 {
+			in["allowed_to_push"] = []map[string]any{{"deploy_key_id": deployKeyID}}
+		}
+		err = c.api.Do(context.Background(), http.MethodPost, path, in, &out)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("protect branch %s: %w", branch, err)
+	}
+	return &ProtectedBranch{Name: out.Name, AllowForcePush: out.AllowForcePush}, nil
+}
+
+// Onboard 创建私有项目 name（namespace 为空时在个人命名空间下），生成新的 SSH 密钥并登记为可推送的 deploy key，
+// 返回项目的 SSH 地址和私钥。keyTitle 为空时使用 providers.DefaultKeyTitle。
+// allowForcePush 为 true 时为默认分支（defaultBranch，空表示 main）建立允许该 deploy key 强制推送的保护规则。
+// 之后的步骤失败时项目已经创建，错误信息中包含项目路径，可以单独重试或手动删除项目
+func (c *Client) Onboard(namespace, name, keyTitle, defaultBranch string, allowForcePush bool) (*OnboardResult, error) {
+	if keyTitle == "" {
+		keyTitle = providers.DefaultKeyTitle
+	}
+	if defaultBranch == "" {
+		defaultBranch = "main"
+	}
+	privateKey, publicKey, err := utils.GenerateSSHKey(keyTitle)
+	if err != nil {
+		return nil, err
+	}
+	project, err := c.CreateProject(namespace, name, "", defaultBranch, true)
+	if err != nil {
+		return nil, err
+	}
+	id := strconv.FormatInt(project.ID, 10)
+	key, err := c.AddDeployKey(id, keyTitle, publicKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("project %s created: %w", project.PathWithNamespace, err)
+	}
+	if allowForcePush {
+		if _, err := c.SetForcePushAllowed(id, defaultBranch, true, key.ID); err != nil {
+			return nil, fmt.Errorf("project %s created: %w", project.PathWithNamespace, err)
+		}
+	}
+	return &OnboardResult{Project: project, DeployKey: key, SSHURL: project.SSHURL, PrivateKeyPEM: privateKey}, nil
+}
+
+// OnboardJSON 同 Onboard，以 JSON 返回 OnboardResult
+func (c *Client) OnboardJSON(namespace, name, keyTitle, defaultBranch string, allowForcePush bool) (string, error) {
+	result, err := c.Onboard(namespace, name, keyTitle, defaultBranch, allowForcePush)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// apiProject 是 API 返回的项目字段
+type apiProject struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	Visibility        string `json:"visibility"`
+	SSHURL            string `json:"ssh_url_to_repo"`
+	WebURL            string `json:"web_url"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+func (p *apiProject) toProject() *Project {
+	return &Project{
+		ID:                p.ID,
+		Name:              p.Name,
+		PathWithNamespace: p.PathWithNamespace,
+		Visibility:        p.Visibility,
+		SSHURL:            p.SSHURL,
+		WebURL:            p.WebURL,
+		DefaultBranch:     p.DefaultBranch,
+	}
+}
+
+// projectPath 返回项目的 API 路径，group/name 形式的路径需要整体转义
+func projectPath(project string) string {
+	return "/projects/" + url.PathEscape(project)
+}
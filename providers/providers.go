@@ -0,0 +1,136 @@
+// Package providers 是 providers/github、providers/gitea 等共用的类型和初始化流程：
+// GitHub 和 Gitea / Forgejo 的仓库与 deploy key 接口相同，只是地址和认证方式不同。
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"mixgram-core/internel/restapi"
+	"mixgram-core/internel/utils"
+)
+
+// DefaultKeyTitle 是 Onboard 上传 deploy key 时默认使用的标题
+const DefaultKeyTitle = "MixGram"
+
+// Repository 是托管平台上的一个仓库
+type Repository struct {
+	ID            int64  `json:"id"`
+	Owner         string `json:"owner"`
+	Name          string `json:"name"`
+	FullName      string `json:"fullName"`
+	Private       bool   `json:"private"`
+	SSHURL        string `json:"sshUrl"`
+	HTMLURL       string `json:"htmlUrl"`
+	DefaultBranch string `json:"defaultBranch"`
+}
+
+// DeployKey 是仓库上登记的一个 deploy key
+type DeployKey struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Key      string `json:"key"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+// OnboardResult 是 Onboard 的结果：新仓库、可写的 deploy key 及其私钥
+type OnboardResult struct {
+	Repository *Repository `json:"repository"`
+	DeployKey  *DeployKey  `json:"deployKey"`
+	// SSHURL 是仓库的 SSH 地址，与 PrivateKeyPEM 一起作为 core 中各 API 的 repoURL 和 sshKeyPEM
+	SSHURL        string `json:"sshUrl"`
+	PrivateKeyPEM string `json:"privateKeyPem"`
+}
+
+// APIRepository 是 API 返回的仓库字段
+type APIRepository struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+	SSHURL   string `json:"ssh_url"`
+	HTMLURL  string `json:"html_url"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// Repository 转换为 Repository
+func (r *APIRepository) Repository() *Repository {
+	return &Repository{
+		ID:            r.ID,
+		Owner:         r.Owner.Login,
+		Name:          r.Name,
+		FullName:      r.FullName,
+		Private:       r.Private,
+		SSHURL:        r.SSHURL,
+		HTMLURL:       r.HTMLURL,
+		DefaultBranch: r.DefaultBranch,
+	}
+}
+
+// RepoPath 返回仓库 owner/repo 的 API 路径
+func RepoPath(owner, repo string) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+// AddDeployKey 通过 api 在仓库 owner/repo 上登记 deploy key，publicKey 为 authorized_keys 格式；readOnly 为 false 时允许推送
+func AddDeployKey(api *restapi.Client, owner, repo, title, publicKey string, readOnly bool) (*DeployKey, error) {
+	in := map[string]any{"title": title, "key": publicKey, "read_only": readOnly}
+	var out struct {
+		ID       int64  `json:"id"`
+		Title    string `json:"title"`
+		Key      string `json:"key"`
+		ReadOnly bool   `json:"read_only"`
+	}
+	if err := api.Do(context.Background(), http.MethodPost, RepoPath(owner, repo)+"/keys", in, &out); err != nil {
+		return nil, fmt.Errorf("add deploy key: %w", err)
+	}
+	return &DeployKey{ID: out.ID, Title: out.Title, Key: out.Key, ReadOnly: out.ReadOnly}, nil
+}
+
+// DeleteDeployKey 通过 api 删除仓库 owner/repo 上的 deploy key
+func DeleteDeployKey(api *restapi.Client, owner, repo string, keyID int64) error {
+	if err := api.Do(context.Background(), http.MethodDelete, fmt.Sprintf("%s/keys/%d", RepoPath(owner, repo), keyID), nil, nil); err != nil {
+		return fmt.Errorf("delete deploy key: %w", err)
+	}
+	return nil
+}
+
+// Onboard 生成新的 SSH 密钥，用 create 创建私有仓库，再通过 api 把公钥登记为可写的 deploy key，
+// 返回仓库的 SSH 地址和私钥。keyTitle 为空时使用 DefaultKeyTitle。
+// 登记密钥失败时仓库已经创建，错误信息中包含仓库名称，可以重试 AddDeployKey 或手动删除仓库
+func Onboard(api *restapi.Client, keyTitle string, create func() (*Repository, error)) (*OnboardResult, error) {
+	if keyTitle == "" {
+		keyTitle = DefaultKeyTitle
+	}
+	privateKey, publicKey, err := utils.GenerateSSHKey(keyTitle)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := create()
+	if err != nil {
+		return nil, err
+	}
+	key, err := AddDeployKey(api, repo.Owner, repo.Name, keyTitle, publicKey, false)
+	if err != nil {
+		return nil, fmt.Errorf("repository %s created: %w", repo.FullName, err)
+	}
+	return &OnboardResult{Repository: repo, DeployKey: key, SSHURL: repo.SSHURL, PrivateKeyPEM: privateKey}, nil
+}
+
+// OnboardJSON 以 JSON 返回 Onboard 的结果，供各 provider 的 OnboardJSON 使用
+func OnboardJSON(result *OnboardResult, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}